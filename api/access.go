@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/people/v1"
+)
+
+// Access users may grant when connecting, chosen with the `access` parameter of
+// /auth/gmail
+const (
+	// Analyze and clean: everything the enabled features need (the default)
+	accessModify = "modify"
+	// Analyze only: read the mailbox without being able to change it
+	accessReadonly = "readonly"
+	// Only labels and headers, for analysis without granting read access to content
+	accessMetadata = "metadata"
+)
+
+// accessScopes returns the OAuth scopes to request for an access level, or nil for the
+// configured ones
+func accessScopes(access string) ([]string, error) {
+	switch access {
+	case "", accessModify:
+		return nil, nil
+	case accessReadonly:
+		scopes := []string{gmail.GmailReadonlyScope}
+		if config.StorageQuotaEnabled {
+			scopes = append(scopes, drive.DriveFileScope)
+		}
+		if config.ContactsEnabled {
+			scopes = append(scopes, people.ContactsReadonlyScope, people.ContactsOtherReadonlyScope)
+		}
+		return scopes, nil
+	case accessMetadata:
+		return []string{gmail.GmailMetadataScope}, nil
+	}
+	return nil, fmt.Errorf("access must be %s, %s or %s", accessModify, accessReadonly, accessMetadata)
+}
+
+// accessLevel returns the access level a token was granted, or "" if its scopes aren't
+// known
+func accessLevel(token *oauth2.Token) string {
+	switch {
+	case len(tokenScopes(token)) == 0:
+		return ""
+	case canModify(token):
+		return accessModify
+	case metadataOnly(token):
+		return accessMetadata
+	}
+	return accessReadonly
+}
+
+// tokenScopes returns the scopes Google granted a token, or nil if they aren't known,
+// as for tokens API clients send as JSON
+func tokenScopes(token *oauth2.Token) []string {
+	scope, _ := token.Extra("scope").(string)
+	return strings.Fields(scope)
+}
+
+// withScopes returns a copy of token that carries scopes like a token fresh from Google
+func withScopes(token *oauth2.Token, scopes []string) *oauth2.Token {
+	if len(scopes) == 0 {
+		copied := *token
+		return &copied
+	}
+	return token.WithExtra(map[string]interface{}{"scope": strings.Join(scopes, " ")})
+}
+
+// metadataOnly reports whether a token was granted the metadata scope, under which
+// Gmail returns no bodies, snippets or raw messages and accepts no search queries
+func metadataOnly(token *oauth2.Token) bool {
+	return slices.Contains(tokenScopes(token), gmail.GmailMetadataScope)
+}
+
+// canModify reports whether a token may change the mailbox. Tokens whose scopes aren't
+// known are given the benefit of the doubt; Gmail still refuses them if they can't.
+func canModify(token *oauth2.Token) bool {
+	scopes := tokenScopes(token)
+	return len(scopes) == 0 || slices.Contains(scopes, gmail.GmailModifyScope) || slices.Contains(scopes, gmail.MailGoogleComScope)
+}
+
+// errReadonlyAccess is returned for changes to a mailbox the session can only analyze
+var errReadonlyAccess = errors.New("changing the mailbox needs modify access; this session was granted read access only, so sign in again with access=modify")
+
+// requireContentAccess writes a 403 error and returns false if token was only granted
+// metadata access, for endpoints that read message content
+func requireContentAccess(w http.ResponseWriter, token *oauth2.Token) bool {
+	if metadataOnly(token) {
+		writeErrorCode(w, http.StatusForbidden, ErrCodeInsufficientScope, "Reading message content needs full access; this session was granted metadata access only")
+		return false
+	}
+	return true
+}
+
+// requireModifyAccess writes a 403 error and returns false if token can't change the
+// mailbox, for endpoints that trash, archive, label or otherwise change mail
+func requireModifyAccess(w http.ResponseWriter, token *oauth2.Token) bool {
+	if !canModify(token) {
+		writeErrorFrom(w, "", errReadonlyAccess, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// mailboxForChange is mailboxForRequest for endpoints that change the mailbox,
+// refusing sessions that can only analyze it
+func mailboxForChange(w http.ResponseWriter, r *http.Request) *mailbox {
+	mb := mailboxForRequest(w, r)
+	if mb == nil || !requireModifyAccess(w, mb.token) {
+		return nil
+	}
+	return mb
+}
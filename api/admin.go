@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+)
+
+// AccountUsage is the resource footprint of one analyzed account
+type AccountUsage struct {
+	UserID string `json:"userId"`
+	ResourceUsage
+	IsProcessing bool `json:"isProcessing"`
+}
+
+// HandleAdminUsage reports the memory and disk held for every account on the server
+func HandleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	accounts := make([]AccountUsage, 0)
+	var totals ResourceUsage
+	for userID, processor := range Registry.All() {
+		usage := processor.Usage()
+		usage.DiskBytes += Backups.DiskUsage(knownAccount(userID))
+
+		totals.CachedMessages += usage.CachedMessages
+		totals.MemoryBytes += usage.MemoryBytes
+		totals.DiskBytes += usage.DiskBytes
+
+		accounts = append(accounts, AccountUsage{
+			UserID:        userID,
+			ResourceUsage: usage,
+			IsProcessing:  processor.GetProgress()["isProcessing"].(bool),
+		})
+	}
+
+	// Largest footprint first
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].MemoryBytes+accounts[i].DiskBytes > accounts[j].MemoryBytes+accounts[j].DiskBytes
+	})
+
+	writeJSON(w, map[string]interface{}{
+		"accounts": accounts,
+		"totals":   totals,
+	})
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/api/gmail/v1"
+)
+
+// StrippedAttachment describes an attachment removed from a message
+type StrippedAttachment struct {
+	Filename string `json:"filename"`
+	MimeType string `json:"mimeType"`
+	Size     int    `json:"size"`
+}
+
+// HandleStripAttachments replaces a message with a copy that has its attachments removed.
+// The copy is inserted with the original labels and date before the original is trashed,
+// so the email text survives while the attachment storage is reclaimed.
+func HandleStripAttachments(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+	if !requireContentAccess(w, mb.token) {
+		return
+	}
+	if err := mb.quota.Wait(r.Context(), costMessagesGet+costMessagesInsert+costMessagesTrash); err != nil {
+		writeErrorFrom(w, "Request cancelled", err, http.StatusServiceUnavailable)
+		return
+	}
+
+	// Fetch the raw RFC 822 message
+	msg, err := mb.provider.GetMessage(mb.context(), mb.user, messageID, "raw")
+	if err != nil {
+		writeErrorFrom(w, "Failed to fetch email", err, http.StatusNotFound)
+		return
+	}
+	for _, label := range msg.LabelIds {
+		if label == "DRAFT" {
+			writeError(w, "Drafts can't have their attachments stripped", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	raw, err := decodeBase64URL(msg.Raw)
+	if err != nil {
+		writeErrorFrom(w, "Failed to decode email", err, http.StatusInternalServerError)
+		return
+	}
+
+	stripped, removed, err := stripAttachments(raw)
+	if err != nil {
+		writeErrorFrom(w, "Failed to parse email", err, http.StatusUnprocessableEntity)
+		return
+	}
+	if len(removed) == 0 {
+		writeError(w, "Email has no attachments to strip", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Insert the stripped copy, keeping labels and the original Date header as its date
+	inserted, err := mb.provider.InsertMessage(mb.context(), mb.user, &gmail.Message{
+		Raw:      base64.URLEncoding.EncodeToString(stripped),
+		LabelIds: msg.LabelIds,
+		ThreadId: msg.ThreadId,
+	})
+	if err != nil {
+		writeErrorFrom(w, "Failed to insert stripped email", err, http.StatusInternalServerError)
+		return
+	}
+
+	// Only trash the original once the replacement exists
+	err = mb.provider.Trash(mb.context(), mb.user, messageID)
+	recordTrashAudit(mb, newID(), "strip-attachments", messageID, []string{messageID}, err)
+	if err != nil {
+		writeErrorFrom(w, "Stripped copy inserted as "+inserted.Id+" but failed to trash original", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"originalId":  messageID,
+		"newId":       inserted.Id,
+		"removed":     removed,
+		"bytesBefore": len(raw),
+		"bytesAfter":  len(stripped),
+	})
+}
+
+// decodeBase64URL decodes Gmail's URL-safe base64, with or without padding
+func decodeBase64URL(s string) ([]byte, error) {
+	if data, err := base64.URLEncoding.DecodeString(s); err == nil {
+		return data, nil
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// stripAttachments rewrites a raw message with every attachment part replaced by a
+// short text note. The top-level header block is kept byte for byte.
+func stripAttachments(raw []byte) ([]byte, []StrippedAttachment, error) {
+	headerEnd, sep := bytes.Index(raw, []byte("\r\n\r\n")), 4
+	if headerEnd < 0 {
+		headerEnd, sep = bytes.Index(raw, []byte("\n\n")), 2
+	}
+	if headerEnd < 0 {
+		return nil, nil, fmt.Errorf("message has no body")
+	}
+
+	header, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw[:headerEnd+sep]))).ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid message header: %w", err)
+	}
+
+	body, removed, err := stripPart(header, raw[headerEnd+sep:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make([]byte, 0, headerEnd+sep+len(body))
+	out = append(out, raw[:headerEnd+sep]...)
+	out = append(out, body...)
+	return out, removed, nil
+}
+
+// stripPart returns the body of a MIME entity with attachments removed, recursing
+// into multipart containers
+func stripPart(header textproto.MIMEHeader, body []byte) ([]byte, []StrippedAttachment, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		// Leaf part, nothing to recurse into
+		return body, nil, nil
+	}
+
+	var out bytes.Buffer
+	writer := multipart.NewWriter(&out)
+	if err := writer.SetBoundary(params["boundary"]); err != nil {
+		return nil, nil, err
+	}
+
+	removed := make([]StrippedAttachment, 0)
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+
+		partBody, err := io.ReadAll(part)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if filename, ok := attachmentFilename(part.Header); ok {
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			removed = append(removed, StrippedAttachment{Filename: filename, MimeType: partType, Size: len(partBody)})
+
+			// Leave a note where the attachment used to be
+			placeholder := textproto.MIMEHeader{}
+			placeholder.Set("Content-Type", "text/plain; charset=utf-8")
+			pw, err := writer.CreatePart(placeholder)
+			if err != nil {
+				return nil, nil, err
+			}
+			fmt.Fprintf(pw, "[Attachment removed: %s (%s, %d bytes)]\r\n", filename, partType, len(partBody))
+			continue
+		}
+
+		partBody, partRemoved, err := stripPart(part.Header, partBody)
+		if err != nil {
+			return nil, nil, err
+		}
+		removed = append(removed, partRemoved...)
+
+		pw, err := writer.CreatePart(part.Header)
+		if err != nil {
+			return nil, nil, err
+		}
+		pw.Write(partBody)
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+	return out.Bytes(), removed, nil
+}
+
+// attachmentFilename reports whether a MIME part is an attachment, returning its filename.
+// Inline parts referenced by Content-ID (e.g. images in an HTML body) are not attachments.
+func attachmentFilename(header textproto.MIMEHeader) (string, bool) {
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	mediaType, typeParams, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return "", false
+	}
+
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = typeParams["name"]
+	}
+
+	switch {
+	case disposition == "attachment":
+		if filename == "" {
+			filename = "unnamed"
+		}
+		return filename, true
+	case disposition == "inline" || header.Get("Content-ID") != "":
+		return "", false
+	default:
+		return filename, filename != ""
+	}
+}
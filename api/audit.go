@@ -0,0 +1,214 @@
+package api
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditEntry records one destructive action taken on a mailbox
+type AuditEntry struct {
+	ID string `json:"id"`
+	// Signed-in user who took the action
+	Actor string `json:"actor"`
+	// Mailbox the action was taken on; differs from Actor for delegated mailboxes
+	Mailbox string    `json:"mailbox"`
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	// What was selected, such as the search query, sender or message
+	Target     string   `json:"target,omitempty"`
+	Count      int      `json:"count"`
+	MessageIDs []string `json:"messageIds"`
+	Error      string   `json:"error,omitempty"`
+	// Set when the action was a dry run that changed nothing
+	DryRun bool `json:"dryRun,omitempty"`
+	// Set when the action moved the messages to trash, which automatic trash emptying
+	// goes by
+	Trashed bool `json:"trashed,omitempty"`
+}
+
+// auditKeyPrefix is the storage key prefix of a mailbox's audit log. Entry keys start
+// with their timestamp so listing them returns the log in order.
+func auditKeyPrefix(mailbox string) string {
+	return "audit/" + mailbox + "/"
+}
+
+// recordAudit appends an entry to the audit log of mb. The log is append-only: entries
+// are never updated or deleted. Failing to write it doesn't undo the action, so
+// failures are only logged.
+func recordAudit(mb *mailbox, action, target string, ids []string, actionErr error) {
+	appendAudit(mb, action, target, ids, actionErr, false)
+}
+
+// recordTrashAudit is recordAudit for actions that moved the messages to trash, which
+// also puts them on the undo stack as the operation with the given ID if the action
+// succeeded
+func recordTrashAudit(mb *mailbox, id, action, target string, ids []string, actionErr error) {
+	appendAudit(mb, action, target, ids, actionErr, true)
+	if actionErr == nil {
+		recordTrash(mb, id, action, ids)
+	}
+}
+
+// appendAudit appends an entry to the audit log of mb
+func appendAudit(mb *mailbox, action, target string, ids []string, actionErr error, trashed bool) {
+	account, err := mb.account()
+	if err != nil {
+		log.Printf("Failed to audit %s: %s", action, redactError(err))
+		return
+	}
+	actor, err := mb.actor()
+	if err != nil {
+		log.Printf("Failed to audit %s: %s", action, redactError(err))
+		return
+	}
+
+	entry := &AuditEntry{
+		ID:         newID(),
+		Actor:      actor,
+		Mailbox:    account,
+		Time:       time.Now().UTC(),
+		Action:     action,
+		Target:     target,
+		Count:      len(ids),
+		MessageIDs: ids,
+		DryRun:     mb.dryRun(),
+		Trashed:    trashed,
+	}
+	if actionErr != nil {
+		entry.Error = redactError(actionErr)
+	}
+
+	key := auditKeyPrefix(account) + entry.Time.Format("20060102T150405.000000000Z") + "-" + entry.ID
+	if err := Storage.Put(key, entry); err != nil {
+		log.Printf("Failed to audit %s: %s", action, redactError(err))
+	}
+}
+
+// affectedIDs returns the messages an operation acted on: those it actually trashed if
+// it reports them, those it deleted before stopping if it empties a label, otherwise the
+// ones it was given
+func affectedIDs(result interface{}, ids []string) []string {
+	switch res := result.(type) {
+	case trashReporter:
+		return res.trashedIDs()
+	case *EmptyResult:
+		// Deletion goes in order, so the deleted messages are the first ones
+		return ids[:res.Deleted]
+	}
+	return ids
+}
+
+// parseAuditTime reads an optional time filter given as YYYY-MM-DD or RFC 3339
+func parseAuditTime(v string) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+	t, err := time.Parse("2006-01-02", v)
+	return t, err == nil
+}
+
+// HandleGetAuditLog returns the audit log of the mailbox, oldest first. It can be
+// filtered by `action`, `actor`, `since` and `until` (YYYY-MM-DD or RFC 3339), and
+// `format=csv` downloads it as CSV.
+func HandleGetAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	since, ok := parseAuditTime(query.Get("since"))
+	if !ok {
+		writeError(w, "since must be a date (YYYY-MM-DD) or RFC 3339 time", http.StatusBadRequest)
+		return
+	}
+	until, ok := parseAuditTime(query.Get("until"))
+	if !ok {
+		writeError(w, "until must be a date (YYYY-MM-DD) or RFC 3339 time", http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	all, err := loadAuditLog(account)
+	if err != nil {
+		writeErrorFrom(w, "Failed to read audit log", err, http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]AuditEntry, 0, len(all))
+	for _, entry := range all {
+		switch {
+		case query.Get("action") != "" && entry.Action != query.Get("action"):
+		case query.Get("actor") != "" && !strings.EqualFold(entry.Actor, query.Get("actor")):
+		case !since.IsZero() && entry.Time.Before(since):
+		case !until.IsZero() && !entry.Time.Before(until):
+		default:
+			entries = append(entries, entry)
+		}
+	}
+
+	if format != "csv" {
+		writeJSON(w, entries)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	writeAuditCSV(w, entries)
+}
+
+// loadAuditLog returns the audit log of an account, oldest first
+func loadAuditLog(account string) ([]AuditEntry, error) {
+	keys, err := Storage.List(auditKeyPrefix(account))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]AuditEntry, 0, len(keys))
+	for _, key := range keys {
+		var entry AuditEntry
+		if _, err := Storage.Get(key, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// writeAuditCSV writes audit entries as CSV with a header row
+func writeAuditCSV(w io.Writer, entries []AuditEntry) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "time", "actor", "mailbox", "action", "target", "count", "error", "message_ids"})
+	for _, entry := range entries {
+		out.Write([]string{
+			entry.ID,
+			entry.Time.Format(time.RFC3339),
+			entry.Actor,
+			entry.Mailbox,
+			entry.Action,
+			entry.Target,
+			strconv.Itoa(entry.Count),
+			entry.Error,
+			strings.Join(entry.MessageIDs, " "),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
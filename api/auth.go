@@ -2,18 +2,36 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
+	"net/mail"
+	"strings"
 
 	"golang.org/x/oauth2"
 )
 
-// handleGmailAuth initiates the OAuth flow
+// handleGmailAuth initiates the OAuth flow, asking for the access named by the `access`
+// parameter. In demo mode it signs in to a new synthetic mailbox right away instead.
 func HandleGmailAuth(w http.ResponseWriter, r *http.Request) {
-	url := oauthConfig.AuthCodeURL(oauthStateString)
+	if config.DemoMode {
+		writeTokenPage(w, newDemoToken())
+		return
+	}
+
+	scopes, err := accessScopes(r.URL.Query().Get("access"))
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+	options := redirectURL(r)
+	if scopes != nil {
+		options = append(options, oauth2.SetAuthURLParam("scope", strings.Join(scopes, " ")))
+	}
+	url := oauthConfig.AuthCodeURL(oauthStateString, options...)
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
@@ -22,35 +40,39 @@ func HandleGmailCallback(w http.ResponseWriter, r *http.Request) {
 	// Verify state to prevent CSRF
 	state := r.FormValue("state")
 	if state != oauthStateString {
-		http.Error(w, "Invalid OAuth state", http.StatusBadRequest)
+		writeError(w, "Invalid OAuth state", http.StatusBadRequest)
 		return
 	}
 
 	// Exchange auth code for token
 	code := r.FormValue("code")
-	token, err := oauthConfig.Exchange(context.Background(), code)
+	token, err := oauthConfig.Exchange(context.Background(), code, redirectURL(r)...)
 	if err != nil {
-		http.Error(w, "Failed to exchange token: "+err.Error(), http.StatusInternalServerError)
+		writeErrorFrom(w, "Failed to exchange token", err, http.StatusInternalServerError)
 		return
 	}
 
-	// Convert token to a map for easier JSON handling
-	tokenMap := map[string]interface{}{
-		"access_token":  token.AccessToken,
-		"token_type":    token.TokenType,
-		"refresh_token": token.RefreshToken,
-		"expiry":        token.Expiry.Format(time.RFC3339),
+	writeTokenPage(w, token)
+}
+
+// writeTokenPage writes the page that hands the window that opened the sign-in popup a
+// session token for the OAuth token, which stays on the server
+func writeTokenPage(w http.ResponseWriter, token *oauth2.Token) {
+	session, err := newSession(token)
+	if err != nil {
+		writeErrorFrom(w, "Failed to start session", err, http.StatusInternalServerError)
+		return
 	}
 
 	// Convert to JSON
-	tokenJSON, err := json.Marshal(tokenMap)
+	sessionJSON, err := json.Marshal(session)
 	if err != nil {
-		http.Error(w, "Failed to marshal token: "+err.Error(), http.StatusInternalServerError)
+		writeErrorFrom(w, "Failed to marshal session", err, http.StatusInternalServerError)
 		return
 	}
 
-	// Create a base64 encoded version of the token JSON to avoid any escaping issues
-	tokenBase64 := base64.StdEncoding.EncodeToString(tokenJSON)
+	// Create a base64 encoded version of the session JSON to avoid any escaping issues
+	sessionBase64 := base64.StdEncoding.EncodeToString(sessionJSON)
 
 	// Set content type and write the HTML response
 	w.Header().Set("Content-Type", "text/html")
@@ -65,14 +87,14 @@ func HandleGmailCallback(w http.ResponseWriter, r *http.Request) {
     <p>You can close this window now.</p>
     <script>
         try {
-            // Decode the base64 encoded token
-            const tokenBase64 = "%s";
-            const tokenJSON = atob(tokenBase64);
-            const token = JSON.parse(tokenJSON);
+            // Decode the base64 encoded session
+            const sessionBase64 = "%s";
+            const sessionJSON = atob(sessionBase64);
+            const session = JSON.parse(sessionJSON);
             
             if (window.opener) {
-                window.opener.postMessage({token: token}, "*");
-                console.log("Token sent to main window");
+                window.opener.postMessage({session: session}, "*");
+                console.log("Session sent to main window");
                 setTimeout(function() {
                     window.close();
                 }, 1000);
@@ -85,29 +107,114 @@ func HandleGmailCallback(w http.ResponseWriter, r *http.Request) {
         }
     </script>
 </body>
-</html>`, tokenBase64)
+</html>`, sessionBase64)
 
 	w.Write([]byte(html))
 }
 
-// ParseToken extracts and validates the OAuth token from the Authorization header
+// redirectURL returns the OAuth callback URL for the host the client reached, unless
+// REDIRECT_URL fixes it, so the app works behind a proxy without configuring it. The
+// callback must be one registered with Google either way.
+func redirectURL(r *http.Request) []oauth2.AuthCodeOption {
+	if config.RedirectURL != "" {
+		return nil
+	}
+	return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("redirect_uri", requestBaseURL(r)+"/auth/gmail/callback")}
+}
+
+// ParseToken extracts and validates the OAuth token from the Authorization header, or
+// the one its session JWT stands for. An admin request naming a user in the
+// X-Impersonate header gets a delegated token acting as them instead.
 func ParseToken(r *http.Request) (*oauth2.Token, error) {
-	// Get token from Authorization header
-	tokenStr := r.Header.Get("Authorization")
-	if tokenStr == "" {
-		return nil, fmt.Errorf("authorization header not provided")
+	if token, err := impersonatedToken(r); token != nil || err != nil {
+		return token, err
+	}
+
+	// The Sessions middleware has loaded the token of a session JWT
+	token := sessionToken(r.Context())
+	if token == nil {
+		// Get token from Authorization header
+		tokenStr := r.Header.Get("Authorization")
+		if tokenStr == "" {
+			return nil, fmt.Errorf("authorization header not provided")
+		}
+
+		// Remove "Bearer " prefix if present
+		if len(tokenStr) > 7 && tokenStr[:7] == "Bearer " {
+			tokenStr = tokenStr[7:]
+		}
+
+		// Parse token
+		token = &oauth2.Token{}
+		if err := json.Unmarshal([]byte(tokenStr), token); err != nil {
+			return nil, fmt.Errorf("invalid token format: %w", err)
+		}
+	}
+	if isIMAPToken(token) && imapSessionFor(token) == nil {
+		return nil, errIMAPSessionEnded
+	}
+	if isDemoToken(token) != config.DemoMode {
+		return nil, fmt.Errorf("demo mode is not in use on this server")
+	}
+
+	return token, nil
+}
+
+// userIDFromToken derives the key used to track a user's server-side state
+func userIDFromToken(token *oauth2.Token) string {
+	if isIMAPToken(token) {
+		return imapUserID(token)
+	}
+	if isDemoToken(token) {
+		return token.AccessToken
+	}
+
+	// Delegated tokens change hourly, but the user they act as doesn't
+	if subject := delegatedSubject(token); subject != "" {
+		return delegatedUserPrefix + subject
 	}
 
-	// Remove "Bearer " prefix if present
-	if len(tokenStr) > 7 && tokenStr[:7] == "Bearer " {
-		tokenStr = tokenStr[7:]
+	// Hash the whole token: Google's access tokens share a long prefix, so any part of
+	// one would be the same for different users
+	sum := sha256.Sum256([]byte(token.AccessToken))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Header naming a delegated mailbox to act on instead of the signed-in user's own
+const mailboxHeader = "X-Mailbox"
+
+// requestMailbox returns the mailbox a request acts on: "me" for the signed-in user, or
+// the address from the X-Mailbox header for a mailbox delegated to them. The server acts
+// on another mailbox through domain-wide delegation, so the header is refused without
+// it; newMailbox checks that the user is a delegate of the mailbox.
+func requestMailbox(r *http.Request) (string, error) {
+	address := strings.ToLower(strings.TrimSpace(r.Header.Get(mailboxHeader)))
+	if address == "" || address == "me" {
+		return "me", nil
+	}
+	if delegation == nil {
+		return "", fmt.Errorf("the %s header needs domain-wide delegation, which is not configured (SERVICE_ACCOUNT_FILE not set)", mailboxHeader)
+	}
+	if _, err := mail.ParseAddress(address); err != nil || strings.ContainsAny(address, "<> ") {
+		return "", fmt.Errorf("invalid %s header: %q is not an email address", mailboxHeader, address)
 	}
+	return address, nil
+}
 
-	// Parse token
-	var token oauth2.Token
-	if err := json.Unmarshal([]byte(tokenStr), &token); err != nil {
-		return nil, fmt.Errorf("invalid token format: %w", err)
+// mailboxUserID derives the key of a mailbox's server-side state. A delegated mailbox
+// gets its own key, so its scans and stats never mix with the user's own.
+func mailboxUserID(token *oauth2.Token, user string) string {
+	if user == "me" {
+		return userIDFromToken(token)
 	}
+	return userIDFromToken(token) + "/" + user
+}
 
-	return &token, nil
+// requestUserID returns the key of the server-side state of the mailbox a request acts on
+func requestUserID(r *http.Request, token *oauth2.Token) (string, error) {
+	user, err := requestMailbox(r)
+	if err != nil {
+		return "", err
+	}
+	return mailboxUserID(token, user), nil
 }
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// How often trash is emptied of old trashes
+	autoEmptyRunInterval = 24 * time.Hour
+	// Days messages stay in trash unless the user picks otherwise
+	defaultAutoEmptyDays = 7
+)
+
+// Gmail deletes trashed messages itself after trashRetention, so emptying them sooner
+// than that is the only point of the schedule
+var maxAutoEmptyDays = int(trashRetention/(24*time.Hour)) - 1
+
+// Serializes read-modify-write updates of auto-empty settings
+var autoEmptyMu sync.Mutex
+
+// AutoEmptySettings has the scheduler permanently delete the messages this tool trashed
+// a number of days ago, reclaiming their storage before Gmail would. Until then they
+// can still be restored.
+type AutoEmptySettings struct {
+	Enabled bool `json:"enabled"`
+	// Messages are deleted this many days after they were trashed
+	AfterDays int        `json:"afterDays"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	// When trash was last emptied, by the scheduler or on request
+	LastRunAt *time.Time    `json:"lastRunAt,omitempty"`
+	LastRun   *AutoEmptyRun `json:"lastRun,omitempty"`
+}
+
+// autoEmptyRequest is the body of HandleUpdateAutoEmpty
+type autoEmptyRequest struct {
+	Enabled bool `json:"enabled"`
+	// Left as is if left out
+	AfterDays int `json:"afterDays"`
+}
+
+// AutoEmptyRun reports what one emptying of trash did
+type AutoEmptyRun struct {
+	// scheduled, or manual for runs started with HandleRunAutoEmpty
+	Trigger    string    `json:"trigger"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// Messages trashed before this were due
+	Cutoff time.Time `json:"cutoff"`
+	// Messages the audit log shows were trashed before the cutoff and not restored
+	Due int `json:"due"`
+	// Due messages still in trash, and those deleted
+	Matched int    `json:"matched"`
+	Deleted int    `json:"deleted"`
+	DryRun  bool   `json:"dryRun,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// autoEmptyKey is the storage key of an account's auto-empty settings
+func autoEmptyKey(account string) string {
+	return "auto-empty/" + account
+}
+
+// loadAutoEmpty returns an account's auto-empty settings, disabled if it has none
+func loadAutoEmpty(account string) (*AutoEmptySettings, error) {
+	settings := &AutoEmptySettings{AfterDays: defaultAutoEmptyDays}
+	if _, err := Storage.Get(autoEmptyKey(account), settings); err != nil {
+		return nil, fmt.Errorf("failed to load trash schedule: %w", err)
+	}
+	return settings, nil
+}
+
+// recordAutoEmptyRun keeps the report of the latest run with the settings
+func recordAutoEmptyRun(account string, run *AutoEmptyRun) {
+	autoEmptyMu.Lock()
+	defer autoEmptyMu.Unlock()
+
+	settings, err := loadAutoEmpty(account)
+	if err != nil {
+		log.Printf("Failed to record trash emptying: %s", redactError(err))
+		return
+	}
+	settings.LastRun = run
+	if err := Storage.Put(autoEmptyKey(account), settings); err != nil {
+		log.Printf("Failed to record trash emptying: %s", redactError(err))
+	}
+}
+
+// trashedBefore returns the messages the audit log shows this tool trashed before
+// cutoff and didn't restore since. Dry runs changed nothing, so they are left out.
+func trashedBefore(account string, cutoff time.Time) (map[string]bool, error) {
+	entries, err := loadAuditLog(account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	// The log is in order, so a later trash or restore of a message wins
+	trashedAt := make(map[string]time.Time)
+	for _, entry := range entries {
+		switch {
+		case entry.DryRun:
+		case entry.Trashed:
+			for _, id := range entry.MessageIDs {
+				trashedAt[id] = entry.Time
+			}
+		case entry.Action == "undo" || entry.Action == "batch-untrash":
+			for _, id := range entry.MessageIDs {
+				delete(trashedAt, id)
+			}
+		}
+	}
+
+	due := make(map[string]bool)
+	for id, at := range trashedAt {
+		if at.Before(cutoff) {
+			due[id] = true
+		}
+	}
+	return due, nil
+}
+
+// emptyTrash queues a job permanently deleting the messages trashed more than afterDays
+// ago, which records the run's report. Only messages still in trash are deleted, so
+// ones the user restored in Gmail are left alone. Trash records only know when the
+// server trashed a message, so one restored and trashed again in Gmail since counts
+// from the first time.
+func emptyTrash(mb *mailbox, account string, afterDays int, trigger string, priority JobPriority) *Job {
+	return Jobs.Enqueue("auto-empty-trash", mb.userID, priority, func(job *Job) (interface{}, error) {
+		run := &AutoEmptyRun{Trigger: trigger, StartedAt: time.Now().UTC(), DryRun: mb.dryRun()}
+		run.Cutoff = run.StartedAt.AddDate(0, 0, -afterDays)
+
+		err := run.apply(mb.forJob(job), job, account)
+		if err != nil {
+			run.Error = redactError(err)
+			notify(account, notification{
+				Title:   "Emptying trash failed",
+				Message: fmt.Sprintf("Emptying trash failed: %s. %d of %d messages deleted.", run.Error, run.Deleted, run.Matched),
+				Failed:  true,
+			})
+		} else if run.Deleted > 0 {
+			notify(account, notification{
+				Title:   "Trash emptied",
+				Message: fmt.Sprintf("%d messages trashed over %d days ago were deleted for good.", run.Deleted, afterDays),
+			})
+		}
+		run.FinishedAt = time.Now().UTC()
+		recordAutoEmptyRun(account, run)
+		return run, err
+	})
+}
+
+// apply deletes the messages trashed before the run's cutoff that are still in trash,
+// filling in the run's report
+func (run *AutoEmptyRun) apply(mb *mailbox, job *Job, account string) error {
+	due, err := trashedBefore(account, run.Cutoff)
+	if err != nil {
+		return err
+	}
+	run.Due = len(due)
+	if len(due) == 0 {
+		return nil
+	}
+
+	inTrash, err := listLabelMessageIDs(mb, "TRASH")
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(due))
+	for _, id := range inTrash {
+		if due[id] {
+			ids = append(ids, id)
+		}
+	}
+	run.Matched = len(ids)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	run.Deleted, err = batchDelete(mb, ids, func(deleted int) {
+		Jobs.SetProgress(job, map[string]int{"deleted": deleted, "total": len(ids)})
+	})
+	target := "trashed before " + run.Cutoff.Format("2006-01-02")
+	recordAudit(mb, "auto-empty-trash", target, ids[:run.Deleted], err)
+	return err
+}
+
+// emptyDueTrash starts emptying an account's trash if it is enabled and wasn't emptied
+// within autoEmptyRunInterval of now
+func emptyDueTrash(account string, now time.Time) {
+	if !Features().PermanentDelete {
+		return
+	}
+	autoEmptyMu.Lock()
+	defer autoEmptyMu.Unlock()
+
+	settings, err := loadAutoEmpty(account)
+	if err != nil {
+		log.Printf("Not emptying trash: %s", redactError(err))
+		return
+	}
+	if !settings.Enabled || (settings.LastRunAt != nil && now.Sub(*settings.LastRunAt) < autoEmptyRunInterval) {
+		return
+	}
+
+	mb, err := scheduledMailbox(account)
+	if err != nil {
+		log.Printf("Not emptying trash: %s", redactError(err))
+		return
+	}
+	// Marked as run when started, so a slow run isn't started again by the next check
+	settings.LastRunAt = &now
+	if err := Storage.Put(autoEmptyKey(account), settings); err != nil {
+		log.Printf("Not emptying trash: %s", redactError(err))
+		return
+	}
+	emptyTrash(mb, account, settings.AfterDays, retentionTriggerScheduled, PriorityBackground)
+}
+
+// requestAutoEmptyAccount returns the mailbox and account of a request that changes or
+// runs automatic trash emptying, writing an error response and returning nil if that
+// fails
+func requestAutoEmptyAccount(w http.ResponseWriter, r *http.Request) (*mailbox, string) {
+	if !requireFeature(w, Features().PermanentDelete, "permanent delete") {
+		return nil, ""
+	}
+	return requestRetentionAccount(w, r)
+}
+
+// HandleGetAutoEmpty returns the user's automatic trash emptying settings and the
+// report of the latest run
+func HandleGetAutoEmpty(w http.ResponseWriter, r *http.Request) {
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	settings, err := loadAutoEmpty(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, settings)
+}
+
+// HandleUpdateAutoEmpty turns automatic trash emptying on or off. While it is on, the
+// scheduler permanently deletes what this tool trashed afterDays ago once a day, with
+// the sign-in of the request.
+func HandleUpdateAutoEmpty(w http.ResponseWriter, r *http.Request) {
+	var req autoEmptyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if req.AfterDays < 0 || req.AfterDays > maxAutoEmptyDays {
+		writeError(w, fmt.Sprintf("afterDays must be between 1 and %d; Gmail deletes trash after %d days itself", maxAutoEmptyDays, maxAutoEmptyDays+1), http.StatusBadRequest)
+		return
+	}
+
+	mb, account := requestAutoEmptyAccount(w, r)
+	if mb == nil {
+		return
+	}
+
+	autoEmptyMu.Lock()
+	defer autoEmptyMu.Unlock()
+
+	settings, err := loadAutoEmpty(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	settings.Enabled, settings.UpdatedAt = req.Enabled, &now
+	if req.AfterDays > 0 {
+		settings.AfterDays = req.AfterDays
+	}
+
+	if settings.Enabled {
+		if err := saveScheduleToken(mb, account); err != nil {
+			writeErrorFrom(w, "", err, http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := Storage.Put(autoEmptyKey(account), settings); err != nil {
+		writeErrorFrom(w, "Failed to save trash schedule", err, http.StatusInternalServerError)
+		return
+	}
+	if !settings.Enabled {
+		releaseScheduleToken(account)
+	}
+
+	writeJSON(w, settings)
+}
+
+// HandleRunAutoEmpty empties trash of old trashes right away, whether or not the
+// schedule is on, and returns the job doing it. Its result is the run report.
+func HandleRunAutoEmpty(w http.ResponseWriter, r *http.Request) {
+	mb, account := requestAutoEmptyAccount(w, r)
+	if mb == nil {
+		return
+	}
+
+	autoEmptyMu.Lock()
+	defer autoEmptyMu.Unlock()
+
+	settings, err := loadAutoEmpty(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	settings.LastRunAt = &now
+	if err := Storage.Put(autoEmptyKey(account), settings); err != nil {
+		writeErrorFrom(w, "Failed to save trash schedule", err, http.StatusInternalServerError)
+		return
+	}
+
+	job := emptyTrash(mb, account, settings.AfterDays, retentionTriggerManual, PriorityUser)
+	snapshot, _ := Jobs.Get(job.ID)
+	writeJSON(w, snapshot)
+}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Supported backup archive formats
+const (
+	BackupFormatMbox = "mbox"
+	BackupFormatZip  = "zip" // one .eml file per message
+)
+
+// Backup is a downloadable archive of raw messages taken before a bulk delete
+type Backup struct {
+	ID        string    `json:"id"`
+	Format    string    `json:"format"`
+	Count     int       `json:"count"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Account the backed up messages belong to, the only one that may download it
+	owner string
+	path  string
+}
+
+// BackupRegistry tracks the backups written to disk
+type BackupRegistry struct {
+	backups map[string]*Backup
+	mu      sync.RWMutex
+}
+
+var (
+	// Global registry for backups
+	Backups = &BackupRegistry{
+		backups: make(map[string]*Backup),
+	}
+)
+
+// Get retrieves a backup by ID
+func (r *BackupRegistry) Get(id string) (*Backup, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	backup, ok := r.backups[id]
+	return backup, ok
+}
+
+// DiskUsage returns the total size of the backups owned by an account
+func (r *BackupRegistry) DiskUsage(owner string) int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var total int64
+	for _, backup := range r.backups {
+		if backup.owner == owner {
+			total += backup.Size
+		}
+	}
+	return total
+}
+
+// ownedBy returns copies of the backups owned by an account
+func (r *BackupRegistry) ownedBy(owner string) []Backup {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	owned := make([]Backup, 0)
+	for _, backup := range r.backups {
+		if backup.owner == owner {
+			owned = append(owned, *backup)
+		}
+	}
+	return owned
+}
+
+func (r *BackupRegistry) add(backup *Backup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backups[backup.ID] = backup
+}
+
+// removeOwner deletes the backups owned by an account from disk and the registry, and
+// returns how many there were. Backups whose file couldn't be deleted stay registered.
+func (r *BackupRegistry) removeOwner(owner string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	removed := 0
+	for id, backup := range r.backups {
+		if backup.owner != owner {
+			continue
+		}
+		if err := os.Remove(backup.path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to delete backup: %w", err)
+		}
+		delete(r.backups, id)
+		removed++
+	}
+	return removed, nil
+}
+
+// createBackup downloads the raw form of every message and writes them to a single
+// archive. Any failure aborts the backup, since an incomplete safety net is worse than
+// a clear error before anything is deleted.
+func createBackup(mb *mailbox, ids []string, format string) (*Backup, error) {
+	if format != BackupFormatMbox && format != BackupFormatZip {
+		return nil, fmt.Errorf("unsupported backup format %q", format)
+	}
+	account, err := mb.account()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(config.DataDir, "backups")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	backup := &Backup{
+		ID:        newID(),
+		Format:    format,
+		CreatedAt: time.Now(),
+		owner:     account,
+	}
+	backup.path = filepath.Join(dir, backup.ID+"."+format)
+
+	file, err := os.OpenFile(backup.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup file: %w", err)
+	}
+
+	err = writeBackup(file, mb, ids, format)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(backup.path)
+		return nil, err
+	}
+
+	info, err := os.Stat(backup.path)
+	if err != nil {
+		return nil, err
+	}
+	backup.Count = len(ids)
+	backup.Size = info.Size()
+
+	Backups.add(backup)
+	return backup, nil
+}
+
+// writeBackup streams each raw message into the archive as it is downloaded
+func writeBackup(out io.Writer, mb *mailbox, ids []string, format string) error {
+	buffered := bufio.NewWriter(out)
+
+	var archive *zip.Writer
+	if format == BackupFormatZip {
+		archive = zip.NewWriter(buffered)
+	}
+
+	for _, id := range ids {
+		if err := mb.quota.Wait(context.Background(), costMessagesGet); err != nil {
+			return err
+		}
+		msg, err := mb.provider.GetMessage(mb.context(), mb.user, id, "raw")
+		if err != nil {
+			return fmt.Errorf("failed to download message %s: %s", id, redactError(err))
+		}
+		raw, err := decodeBase64URL(msg.Raw)
+		if err != nil {
+			return fmt.Errorf("failed to decode message %s: %w", id, err)
+		}
+
+		if archive != nil {
+			entry, err := archive.Create(id + ".eml")
+			if err != nil {
+				return err
+			}
+			if _, err := entry.Write(raw); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := writeMboxMessage(buffered, raw, time.UnixMilli(msg.InternalDate)); err != nil {
+			return err
+		}
+	}
+
+	if archive != nil {
+		if err := archive.Close(); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
+
+// writeMboxMessage appends one message in mboxrd format: a "From " separator line,
+// the message with LF line endings and ">"-quoted "From " lines, then a blank line
+func writeMboxMessage(w io.Writer, raw []byte, date time.Time) error {
+	if _, err := fmt.Fprintf(w, "From MAILER-DAEMON %s\n", date.UTC().Format(time.ANSIC)); err != nil {
+		return err
+	}
+
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	for _, line := range bytes.SplitAfter(raw, []byte("\n")) {
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			if _, err := w.Write([]byte(">")); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+
+	if !bytes.HasSuffix(raw, []byte("\n")) {
+		if _, err := w.Write([]byte("\n")); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte("\n"))
+	return err
+}
+
+// HandleDownloadBackup serves a backup archive to the account it was taken of. Other
+// users are told it doesn't exist.
+func HandleDownloadBackup(w http.ResponseWriter, r *http.Request) {
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	backup, exists := Backups.Get(mux.Vars(r)["id"])
+	if !exists || backup.owner != account {
+		writeError(w, "Backup not found", http.StatusNotFound)
+		return
+	}
+
+	contentType := "application/mbox"
+	if backup.Format == BackupFormatZip {
+		contentType = "application/zip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="backup-%s.%s"`, backup.ID, backup.Format))
+	http.ServeFile(w, r, backup.path)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDownloadBackupOnlyByOwner(t *testing.T) {
+	owner := newTestUser(t, senderMessages("deal", "deals@shop.example", 2, 0)...)
+	other := newTestUser(t)
+
+	backup, err := createBackup(owner.mailbox(t), []string{"deala", "dealb"}, BackupFormatMbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.Count != 2 {
+		t.Errorf("backup count = %d, want 2", backup.Count)
+	}
+
+	r := other.request(t, http.MethodGet, "/api/v1/backups/"+backup.ID, nil, map[string]string{"id": backup.ID})
+	decodeResponse(t, serve(HandleDownloadBackup, r), http.StatusNotFound, nil)
+
+	r = owner.request(t, http.MethodGet, "/api/v1/backups/"+backup.ID, nil, map[string]string{"id": backup.ID})
+	w := serve(HandleDownloadBackup, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if got := strings.Count(w.Body.String(), "\nFrom "); got != 1 || !strings.HasPrefix(w.Body.String(), "From ") {
+		t.Errorf("backup doesn't hold the two messages:\n%s", w.Body)
+	}
+
+	r = owner.request(t, http.MethodGet, "/api/v1/backups/missing", nil, map[string]string{"id": "missing"})
+	decodeResponse(t, serve(HandleDownloadBackup, r), http.StatusNotFound, nil)
+}
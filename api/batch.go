@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// cleaner returns the bulk actions of a mailbox, paced against its quota
+func (mb *mailbox) cleaner() *deepclean.Cleaner {
+	return &deepclean.Cleaner{
+		Provider: mb.provider,
+		User:     mb.user,
+		BeforeCall: func(ctx context.Context, method string) error {
+			return mb.quota.Wait(ctx, methodCosts[method])
+		},
+		AfterCall: func(method string, err error) {
+			mb.quota.throttle.Observe(err)
+		},
+	}
+}
+
+// listMessageIDs returns the IDs of every message matching a Gmail search query
+func listMessageIDs(mb *mailbox, query string) ([]string, error) {
+	return mb.cleaner().List(mb.context(), query)
+}
+
+// batchModify adds and removes labels on the given messages, splitting the IDs into
+// as many BatchModify calls as needed
+func batchModify(mb *mailbox, ids, addLabelIDs, removeLabelIDs []string) error {
+	return mb.cleaner().Modify(mb.context(), ids, addLabelIDs, removeLabelIDs)
+}
+
+// modifyEach is batchModify going on past the messages it fails on
+func modifyEach(mb *mailbox, ids, addLabelIDs, removeLabelIDs []string) *BatchResult {
+	return newBatchResult(ids, mb.cleaner().ModifyEach(mb.context(), ids, addLabelIDs, removeLabelIDs))
+}
+
+// BatchResult reports which messages a batch action succeeded and failed on, so one bad
+// message, such as one deleted in the meantime, doesn't fail the rest
+type BatchResult struct {
+	Succeeded []string       `json:"succeeded"`
+	Failed    []BatchFailure `json:"failed"`
+	// Set when some of the failed messages are worth trying again
+	Retry *RetryGuidance `json:"retry,omitempty"`
+}
+
+// BatchFailure is a message a batch action failed on
+type BatchFailure struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+	// Code like those of error responses, e.g. not_found for messages already deleted
+	Code      string `json:"code"`
+	Retryable bool   `json:"retryable"`
+}
+
+// RetryGuidance tells clients which failed messages to send again, and when
+type RetryGuidance struct {
+	IDs []string `json:"ids"`
+	// Seconds to wait before retrying
+	AfterSeconds int    `json:"afterSeconds"`
+	Advice       string `json:"advice"`
+}
+
+// Seconds to wait before retrying messages Gmail rate limited, unless it says otherwise
+const rateLimitRetrySeconds = 60
+
+// newBatchResult sorts ids into those that succeeded and those in failed
+func newBatchResult(ids []string, failed map[string]error) *BatchResult {
+	result := &BatchResult{
+		Succeeded: make([]string, 0, len(ids)-len(failed)),
+		Failed:    make([]BatchFailure, 0, len(failed)),
+		Retry:     retryGuidance(failed),
+	}
+	for _, id := range ids {
+		err, ok := failed[id]
+		if !ok {
+			result.Succeeded = append(result.Succeeded, id)
+			continue
+		}
+		code, retryable := classifyFailure(err)
+		result.Failed = append(result.Failed, BatchFailure{ID: id, Reason: redactError(err), Code: code, Retryable: retryable})
+	}
+	return result
+}
+
+// classifyFailure returns the error code of a message's failure and whether trying it
+// again may succeed. Messages that are gone or that Gmail refuses won't.
+func classifyFailure(err error) (string, bool) {
+	var rerr *oauth2.RetrieveError
+	if errors.As(err, &rerr) {
+		return ErrCodeTokenExpired, true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrCodeUnavailable, true
+	}
+	if isScopeError(err) {
+		return ErrCodeInsufficientScope, false
+	}
+
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return ErrCodeInternal, true
+	}
+	switch {
+	case gerr.Code == http.StatusUnauthorized:
+		return ErrCodeTokenExpired, true
+	case gerr.Code == http.StatusTooManyRequests || isRateLimitReason(gerr):
+		return ErrCodeRateLimited, true
+	case gerr.Code >= http.StatusInternalServerError:
+		return ErrCodeUpstream, true
+	}
+	if code, ok := statusErrorCodes[gerr.Code]; ok {
+		return code, false
+	}
+	return ErrCodeInvalidRequest, false
+}
+
+// retryGuidance returns which of the failed messages to retry and when, or nil if none
+// of them are worth it
+func retryGuidance(failed map[string]error) *RetryGuidance {
+	guidance := &RetryGuidance{IDs: make([]string, 0)}
+	codes := make(map[string]bool)
+	for id, err := range failed {
+		code, retryable := classifyFailure(err)
+		if !retryable {
+			continue
+		}
+		guidance.IDs = append(guidance.IDs, id)
+		codes[code] = true
+
+		if code == ErrCodeRateLimited {
+			guidance.AfterSeconds = max(guidance.AfterSeconds, retryAfterSeconds(err))
+		}
+	}
+	if len(guidance.IDs) == 0 {
+		return nil
+	}
+	sort.Strings(guidance.IDs)
+
+	switch {
+	case codes[ErrCodeTokenExpired]:
+		guidance.Advice = "Sign in again, then send these messages again"
+	case codes[ErrCodeRateLimited]:
+		guidance.Advice = "Gmail is rate limiting this account; send these messages again after waiting"
+	default:
+		guidance.Advice = "Send these messages again; the failures look temporary"
+	}
+	return guidance
+}
+
+// retryAfterSeconds returns how long Gmail asked to wait after rate limiting a call
+func retryAfterSeconds(err error) int {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		if seconds, err := strconv.Atoi(gerr.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			return seconds
+		}
+	}
+	return rateLimitRetrySeconds
+}
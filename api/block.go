@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Most senders one batch block request may name
+const maxBlockSenders = 100
+
+// BlockSenderResult reports how blocking one sender went
+type BlockSenderResult struct {
+	Sender   string `json:"sender"`
+	FilterID string `json:"filterId,omitempty"`
+	// Number of existing messages reported as spam
+	ReportedSpam int          `json:"reportedSpam"`
+	Trash        *TrashResult `json:"trash,omitempty"`
+	Errors       []string     `json:"errors,omitempty"`
+}
+
+// BlockResult reports the outcome of blocking one or more senders
+type BlockResult struct {
+	Senders []BlockSenderResult `json:"senders"`
+	// Set when the block was confirmed with verify=true
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// trashedIDs returns the messages the block moved to trash
+func (r *BlockResult) trashedIDs() []string {
+	ids := make([]string, 0)
+	for _, sender := range r.Senders {
+		if sender.Trash != nil {
+			ids = append(ids, sender.Trash.Trashed...)
+		}
+	}
+	return ids
+}
+
+// blockRequest holds the options of the block endpoints
+type blockRequest struct {
+	// Senders to block; only used by the batch endpoint
+	Senders []string `json:"senders"`
+	// Report existing messages as spam before trashing them
+	ReportSpam bool `json:"reportSpam"`
+}
+
+// HandleBlockSender previews blocking a sender: a filter deleting their future mail,
+// trashing everything they already sent and, with reportSpam, reporting it as spam
+func HandleBlockSender(w http.ResponseWriter, r *http.Request) {
+	var req blockRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	handleBlock(w, r, []string{mux.Vars(r)["email"]}, req.ReportSpam)
+}
+
+// HandleBatchBlockSenders previews blocking several senders at once
+func HandleBatchBlockSenders(w http.ResponseWriter, r *http.Request) {
+	var req blockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if len(req.Senders) == 0 {
+		writeError(w, "At least one sender is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Senders) > maxBlockSenders {
+		writeError(w, "Too many senders in one request", http.StatusBadRequest)
+		return
+	}
+
+	handleBlock(w, r, req.Senders, req.ReportSpam)
+}
+
+// handleBlock previews blocking senders as one operation
+func handleBlock(w http.ResponseWriter, r *http.Request, senders []string, reportSpam bool) {
+	if !requireFeature(w, Features().FilterCreation, "filter creation") {
+		return
+	}
+
+	// Deduplicate and order the senders so results are stable
+	unique := make(map[string]struct{}, len(senders))
+	for _, sender := range senders {
+		sender = strings.ToLower(strings.TrimSpace(sender))
+		if sender == "" || !strings.Contains(sender, "@") {
+			writeError(w, "Invalid sender address: "+sender, http.StatusBadRequest)
+			return
+		}
+		unique[sender] = struct{}{}
+	}
+	names := make([]string, 0, len(unique))
+	for sender := range unique {
+		names = append(names, sender)
+	}
+	sort.Strings(names)
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+	// Blocking creates a filter deleting the senders' future mail
+	if !refuseProtectedSenders(w, mb, names) {
+		return
+	}
+
+	// Remember which previewed message belongs to which sender
+	senderIDs := make(map[string][]string, len(names))
+	resolve := func() ([]string, error) {
+		all := make([]string, 0)
+		for _, sender := range names {
+			ids, err := listMessageIDs(mb, SenderQuery(sender))
+			if err != nil {
+				return nil, err
+			}
+			senderIDs[sender] = ids
+			all = append(all, ids...)
+		}
+		return all, nil
+	}
+
+	handleOperationPreview(w, r, mb, "block", strings.Join(names, ","), resolve, func(mb *mailbox, job *Job, ids []string) (interface{}, error) {
+		result := &BlockResult{Senders: make([]BlockSenderResult, 0, len(names))}
+		for _, sender := range names {
+			result.Senders = append(result.Senders, blockSender(mb, sender, senderIDs[sender], reportSpam))
+		}
+		return result, nil
+	})
+}
+
+// blockSender filters, reports and trashes one sender's mail. A failed step is recorded
+// and the remaining steps still run.
+func blockSender(mb *mailbox, sender string, ids []string, report bool) BlockSenderResult {
+	result := BlockSenderResult{Sender: sender}
+
+	filter, err := createSenderFilter(mb, sender, deleteFilterAction())
+	if err != nil {
+		result.Errors = append(result.Errors, "filter: "+redactError(err))
+	} else {
+		result.FilterID = filter.Id
+	}
+
+	if report {
+		// Report the messages first so Gmail learns from them, then trash them
+		spam, err := reportSpam(mb, ids)
+		if err != nil {
+			result.Errors = append(result.Errors, "report spam: "+redactError(err))
+		} else {
+			result.ReportedSpam = len(spam.Reported)
+		}
+	}
+
+	result.Trash = trashMessages(mb, ids)
+	return result
+}
@@ -0,0 +1,357 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// What a bulk endpoint does to the messages it selects
+const (
+	bulkActionTrash    = "trash"
+	bulkActionArchive  = "archive"
+	bulkActionMarkRead = "mark-read"
+	// Only offered for explicit ID lists, never for whole queries
+	bulkActionReportSpam = "report-spam"
+)
+
+// batchTrashRequest is the body of HandleBatchTrash
+type batchTrashRequest struct {
+	IDs []string `json:"ids"`
+	// Archive format ("mbox" or "zip") to back the messages up in first, empty for none
+	Backup string `json:"backup"`
+	// Only trash the messages classified into this category
+	Category string `json:"category"`
+}
+
+// TrashResult reports the outcome of a bulk trash
+type TrashResult struct {
+	Trashed []string          `json:"trashed"`
+	Failed  map[string]string `json:"failed"`
+	// Messages skipped because the user's protection rules cover them
+	Protected   []string `json:"protected"`
+	Aborted     bool     `json:"aborted"`
+	AbortReason string   `json:"abortReason,omitempty"`
+	// Set when some of the failed messages are worth trying again
+	Retry  *RetryGuidance `json:"retry,omitempty"`
+	Backup *Backup        `json:"backup,omitempty"`
+	// Set when the operation was confirmed with verify=true
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// ArchiveResult reports the outcome of archiving messages
+type ArchiveResult struct {
+	Archived int `json:"archived"`
+	*BatchResult
+}
+
+// MarkReadResult reports the outcome of marking messages read
+type MarkReadResult struct {
+	MarkedRead int `json:"markedRead"`
+	*BatchResult
+}
+
+// HandleBatchTrash previews moving a list of messages to trash, optionally writing a
+// downloadable backup of them first once confirmed
+func HandleBatchTrash(w http.ResponseWriter, r *http.Request) {
+	var req batchTrashRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, "No message IDs provided", http.StatusBadRequest)
+		return
+	}
+	if req.Backup != "" && req.Backup != BackupFormatMbox && req.Backup != BackupFormatZip {
+		writeError(w, "Unsupported backup format "+req.Backup, http.StatusBadRequest)
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+	// Backups are of the raw messages
+	if req.Backup != "" && !requireContentAccess(w, mb.token) {
+		return
+	}
+
+	if req.Category != "" {
+		if err := validateCategory(req.Category); err != nil {
+			writeErrorFrom(w, "", err, http.StatusBadRequest)
+			return
+		}
+		var err error
+		req.IDs, err = filterIDsByCategory(mb.userID, req.IDs, req.Category)
+		if err != nil {
+			writeErrorFrom(w, "", err, http.StatusConflict)
+			return
+		}
+	}
+
+	handleOperationPreview(w, r, mb, "batch-trash", req.Category, func() ([]string, error) {
+		return req.IDs, nil
+	}, func(mb *mailbox, job *Job, ids []string) (interface{}, error) {
+		// Take the backup before touching anything
+		var backup *Backup
+		if req.Backup != "" {
+			var err error
+			backup, err = createBackup(mb, ids, req.Backup)
+			if err != nil {
+				return nil, fmt.Errorf("backup failed, nothing was deleted: %w", err)
+			}
+		}
+
+		result := trashMessages(mb, ids)
+		result.Backup = backup
+		return result, nil
+	})
+}
+
+// HandleBatchArchive removes a list of messages from the inbox without deleting them,
+// optionally only those the classifier put in `category`
+func HandleBatchArchive(w http.ResponseWriter, r *http.Request) {
+	handleBatchAction(w, r, "batch-archive", bulkActionArchive)
+}
+
+// HandleBatchMarkRead marks a list of messages read, optionally only those the
+// classifier put in `category`
+func HandleBatchMarkRead(w http.ResponseWriter, r *http.Request) {
+	handleBatchAction(w, r, "batch-mark-read", bulkActionMarkRead)
+}
+
+// batchActionRequest is the body of the non-destructive batch endpoints
+type batchActionRequest struct {
+	IDs []string `json:"ids"`
+	// Only act on the messages classified into this category
+	Category string `json:"category"`
+}
+
+// handleBatchAction applies a non-destructive bulk action to the messages listed in
+// the request body as a user-priority job and writes the result
+func handleBatchAction(w http.ResponseWriter, r *http.Request, kind, action string) {
+	var req batchActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, "No message IDs provided", http.StatusBadRequest)
+		return
+	}
+	if err := validateCategory(req.Category); err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	ids, err := filterIDsByCategory(mb.userID, req.IDs, req.Category)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusConflict)
+		return
+	}
+
+	result, err := Jobs.Run(r.Context(), kind, mb.userID, PriorityUser, func(job *Job) (interface{}, error) {
+		return applyBulkAction(mb, ids, action)
+	})
+	if err != nil {
+		writeErrorFrom(w, "Failed to "+action+" emails", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, result)
+}
+
+// HandleArchiveByQuery archives every inbox message matching a Gmail search query
+func HandleArchiveByQuery(w http.ResponseWriter, r *http.Request) {
+	var req queryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if req.Query == "" {
+		writeError(w, "A search query is required", http.StatusBadRequest)
+		return
+	}
+
+	handleQueryAction(w, r, "archive-by-query", "("+req.Query+") in:inbox", bulkActionArchive)
+}
+
+// HandleMarkReadByQuery marks every unread message matching a Gmail search query read
+func HandleMarkReadByQuery(w http.ResponseWriter, r *http.Request) {
+	var req queryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if req.Query == "" {
+		writeError(w, "A search query is required", http.StatusBadRequest)
+		return
+	}
+
+	handleQueryAction(w, r, "mark-read-by-query", "("+req.Query+") is:unread", bulkActionMarkRead)
+}
+
+// trashMessages moves messages to trash in quota-sized batches, giving up if the error
+// budget is exceeded rather than ploughing on through a failing cleanup. Messages covered
+// by the user's protection rules are always skipped; if they can't be determined,
+// nothing is trashed.
+func trashMessages(mb *mailbox, ids []string) *TrashResult {
+	result := &TrashResult{
+		Trashed:   make([]string, 0, len(ids)),
+		Failed:    make(map[string]string),
+		Protected: make([]string, 0),
+	}
+
+	protected, err := protectedIDs(mb)
+	if err != nil {
+		result.Aborted = true
+		result.AbortReason = redactError(err)
+		return result
+	}
+	allowed := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := protected[id]; ok {
+			result.Protected = append(result.Protected, id)
+		} else {
+			allowed = append(allowed, id)
+		}
+	}
+	ids = allowed
+
+	budget := NewErrorBudget()
+	failed := make(map[string]error)
+
+	trash := func(id string) error {
+		return mb.provider.Trash(mb.context(), mb.user, id)
+	}
+	err = runPlanned(mb.context(), mb.quota, ids, costMessagesTrash, trash, func(results map[string]error) bool {
+		for id, err := range results {
+			budget.Record(err)
+			if err != nil {
+				failed[id] = err
+				result.Failed[id] = redactError(err)
+			} else {
+				result.Trashed = append(result.Trashed, id)
+			}
+		}
+
+		if err := budget.Exceeded(); err != nil {
+			log.Printf("Aborting bulk trash: %s", redactError(err))
+			result.Aborted = true
+			result.AbortReason = redactError(err)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		result.Aborted = true
+		result.AbortReason = redactError(err)
+	}
+	result.Retry = retryGuidance(failed)
+
+	return result
+}
+
+// trashOperation is the operationFunc of plain bulk trashes
+func trashOperation(mb *mailbox, job *Job, ids []string) (interface{}, error) {
+	return trashMessages(mb, ids), nil
+}
+
+// handleQueryAction trashes, archives or marks read every message matching a Gmail
+// query and writes the result. Archiving and marking read run right away, while trashing
+// only previews an operation to confirm. A `category` parameter limits the action to
+// messages the classifier put in that category.
+func handleQueryAction(w http.ResponseWriter, r *http.Request, kind, query, action string) {
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	category, err := parseCategoryFilter(r)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+
+	resolve := func() ([]string, error) {
+		ids, err := listMessageIDs(mb, query)
+		if err != nil {
+			return nil, err
+		}
+		return filterIDsByCategory(mb.userID, ids, category)
+	}
+	handleResolvedAction(w, r, mb, kind, query, resolve, action)
+}
+
+// handleResolvedAction trashes, archives or marks read the messages resolve selects and
+// writes the result, previewing trashes like handleQueryAction
+func handleResolvedAction(w http.ResponseWriter, r *http.Request, mb *mailbox, kind, target string, resolve func() ([]string, error), action string) {
+	if action == bulkActionTrash {
+		handleOperationPreview(w, r, mb, kind, target, resolve, trashOperation)
+		return
+	}
+
+	result, err := Jobs.Run(r.Context(), kind, mb.userID, PriorityUser, func(job *Job) (interface{}, error) {
+		ids, err := resolve()
+		if err != nil {
+			return nil, err
+		}
+		return applyBulkAction(mb, ids, action)
+	})
+	if err != nil {
+		writeErrorFrom(w, "Failed to "+action+" emails", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, result)
+}
+
+// handleIDsAction trashes, archives or marks read a known set of messages and writes
+// the result, previewing trashes like handleQueryAction
+func handleIDsAction(w http.ResponseWriter, r *http.Request, kind, target string, ids []string, action string) {
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	if action == bulkActionTrash {
+		handleOperationPreview(w, r, mb, kind, target, func() ([]string, error) {
+			return ids, nil
+		}, trashOperation)
+		return
+	}
+
+	result, err := Jobs.Run(r.Context(), kind, mb.userID, PriorityUser, func(job *Job) (interface{}, error) {
+		return applyBulkAction(mb, ids, action)
+	})
+	if err != nil {
+		writeErrorFrom(w, "Failed to "+action+" emails", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, result)
+}
+
+// applyBulkAction trashes, archives, marks read or reports as spam the given messages.
+// Messages it fails on are reported in the result rather than failing the others.
+func applyBulkAction(mb *mailbox, ids []string, action string) (interface{}, error) {
+	switch action {
+	case bulkActionReportSpam:
+		return reportSpam(mb, ids)
+	case bulkActionMarkRead:
+		result := modifyEach(mb, ids, nil, []string{"UNREAD"})
+		return &MarkReadResult{MarkedRead: len(result.Succeeded), BatchResult: result}, nil
+	case bulkActionArchive:
+		result := modifyEach(mb, ids, nil, []string{"INBOX"})
+		return &ArchiveResult{Archived: len(result.Succeeded), BatchResult: result}, nil
+	default:
+		return trashMessages(mb, ids), nil
+	}
+}
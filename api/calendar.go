@@ -0,0 +1,146 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+)
+
+// defaultCalendarAgeDays is how long ago an event must have ended for its invites and
+// notifications to be cleaned up
+const defaultCalendarAgeDays = 30
+
+// Senders of nothing but event notifications
+var calendarNotificationSenders = map[string]bool{
+	"calendar-notification@google.com": true,
+	"calendar-server@google.com":       true,
+}
+
+// Subject prefixes of event notifications and reminders from calendar apps
+var calendarNotificationPrefixes = []string{"notification:", "reminder:", "canceled event:", "cancelled event:"}
+
+// CalendarMail counts the calendar invites and event notifications collected by the
+// last scan, and those whose event has passed
+type CalendarMail struct {
+	OlderThanDays int `json:"olderThanDays"`
+	// Events that ended before this are stale
+	Cutoff        time.Time `json:"cutoff"`
+	Invites       int       `json:"invites"`
+	Notifications int       `json:"notifications"`
+	Size          int64     `json:"size"`
+	// Invites and notifications of events that ended before the cutoff
+	StaleCount int   `json:"staleCount"`
+	StaleSize  int64 `json:"staleSize"`
+	// Invites whose event end isn't known: recurring events, and invites whose calendar
+	// data the scan didn't fetch. They are never cleaned up.
+	UnknownEnd int `json:"unknownEnd"`
+
+	staleIDs []string
+}
+
+// isEventNotification reports whether a message notifies of or reminds about an event,
+// rather than inviting to it. Subjects only count on mail the classifier didn't take
+// for personal.
+func isEventNotification(e *EmailMetadata) bool {
+	if calendarNotificationSenders[strings.ToLower(e.From)] {
+		return true
+	}
+	if e.Category == deepclean.CategoryPersonal {
+		return false
+	}
+	subject := strings.ToLower(e.Subject)
+	for _, prefix := range calendarNotificationPrefixes {
+		if strings.HasPrefix(subject, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CalendarMail finds the collected calendar invites and event notifications, treating
+// those of events that ended before cutoff as stale. Notifications are sent around
+// their event, so their date stands in for its end.
+func (p *InboxProcessor) CalendarMail(cutoff time.Time) *CalendarMail {
+	calendar := &CalendarMail{Cutoff: cutoff, staleIDs: make([]string, 0)}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	p.emails.each(func(_ int, email *EmailMetadata) bool {
+		var end time.Time
+		switch {
+		case email.CalendarInvite:
+			calendar.Invites++
+			if email.EventEnd == nil {
+				calendar.UnknownEnd++
+			} else {
+				end = *email.EventEnd
+			}
+		case isEventNotification(email):
+			calendar.Notifications++
+			end = email.Date
+		default:
+			return true
+		}
+
+		calendar.Size += email.Size()
+		if !end.IsZero() && end.Before(cutoff) {
+			calendar.StaleCount++
+			calendar.StaleSize += email.Size()
+			calendar.staleIDs = append(calendar.staleIDs, email.ID)
+		}
+		return true
+	})
+	return calendar
+}
+
+// requestCalendarMail reads the `olderThanDays` parameter (default 30) and finds the
+// calendar mail of the request's scan, writing an error and returning nil on failure
+func requestCalendarMail(w http.ResponseWriter, r *http.Request) *CalendarMail {
+	olderThanDays, ok := parseOlderThanDays(r, defaultCalendarAgeDays)
+	if !ok {
+		writeError(w, "olderThanDays must be a non-negative integer", http.StatusBadRequest)
+		return nil
+	}
+
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return nil
+	}
+
+	calendar := processor.CalendarMail(time.Now().UTC().AddDate(0, 0, -olderThanDays))
+	calendar.OlderThanDays = olderThanDays
+	return calendar
+}
+
+// HandleGetCalendarMail reports how many calendar invites and event notifications the
+// last scan found, and how many of them are for events that ended more than
+// `olderThanDays` days ago (default 30). Invites are recognized by their text/calendar
+// part, so only scans that fetched full messages find them.
+func HandleGetCalendarMail(w http.ResponseWriter, r *http.Request) {
+	calendar := requestCalendarMail(w, r)
+	if calendar == nil {
+		return
+	}
+
+	writeJSON(w, calendar)
+}
+
+// HandleTrashStaleCalendarMail previews trashing the invites and notifications of events
+// that ended more than `olderThanDays` days ago (default 30), as found by the last scan
+func HandleTrashStaleCalendarMail(w http.ResponseWriter, r *http.Request) {
+	calendar := requestCalendarMail(w, r)
+	if calendar == nil {
+		return
+	}
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	handleOperationPreview(w, r, mb, "calendar-cleanup", fmt.Sprintf("events ended over %d days ago", calendar.OlderThanDays), func() ([]string, error) {
+		return calendar.staleIDs, nil
+	}, trashOperation)
+}
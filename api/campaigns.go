@@ -0,0 +1,276 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+	"google.golang.org/api/gmail/v1"
+)
+
+// How unsubscribing from one sender went
+const (
+	unsubscribeOneClick    = "one-click"
+	unsubscribeMailto      = "mailto"
+	unsubscribeManual      = "manual"      // only a link to open in a browser is offered
+	unsubscribeUnavailable = "unavailable" // the sender advertises no way to unsubscribe
+	unsubscribeDisabled    = "disabled"
+	unsubscribeFailed      = "failed"
+)
+
+// Client for one-click unsubscribe requests to senders' servers
+var unsubscribeClient = &http.Client{Timeout: 15 * time.Second}
+
+// campaignRequest is the body of HandleUnsubscribeCampaign
+type campaignRequest struct {
+	// Domain whose newsletter senders to purge; subdomains are included
+	Domain string `json:"domain"`
+	// What to do with the senders' existing mail: "trash" (default), "archive" or "none"
+	Cleanup string `json:"cleanup"`
+}
+
+// CampaignSenderResult reports what an unsubscribe campaign did for one sender
+type CampaignSenderResult struct {
+	Sender      string `json:"sender"`
+	Unsubscribe string `json:"unsubscribe"`
+	// Link the user has to open themselves when Unsubscribe is "manual"
+	UnsubscribeURL string       `json:"unsubscribeUrl,omitempty"`
+	FilterID       string       `json:"filterId,omitempty"`
+	Archived       int          `json:"archived,omitempty"`
+	Trash          *TrashResult `json:"trash,omitempty"`
+	Errors         []string     `json:"errors,omitempty"`
+}
+
+// CampaignResult reports what an unsubscribe campaign did
+type CampaignResult struct {
+	Domain  string                 `json:"domain"`
+	Senders []CampaignSenderResult `json:"senders"`
+	// Set when the campaign was confirmed with verify=true
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// trashedIDs returns the messages the campaign moved to trash
+func (r *CampaignResult) trashedIDs() []string {
+	ids := make([]string, 0)
+	for _, sender := range r.Senders {
+		if sender.Trash != nil {
+			ids = append(ids, sender.Trash.Trashed...)
+		}
+	}
+	return ids
+}
+
+// NewsletterSenders returns the unsubscribe targets of every newsletter sender whose
+// address is in domain or one of its subdomains. Senders without targets map to nil.
+func (p *InboxProcessor) NewsletterSenders(domain string) map[string]*UnsubscribeInfo {
+	p.stats.RLock()
+	defer p.stats.RUnlock()
+
+	senders := make(map[string]*UnsubscribeInfo)
+	for sender := range p.stats.FromCount {
+		if !inDomain(sender, domain) {
+			continue
+		}
+		info := p.stats.Unsubscribe[sender]
+		if info != nil || p.stats.FromCategory[sender] == deepclean.CategoryNewsletter {
+			senders[sender] = info
+		}
+	}
+	return senders
+}
+
+// inDomain reports whether an address belongs to domain or one of its subdomains
+func inDomain(address, domain string) bool {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return false
+	}
+	host := strings.ToLower(address[at+1:])
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// HandleUnsubscribeCampaign previews purging every newsletter sender under a domain,
+// e.g. a former employer's SaaS tools. Once confirmed, each sender in turn is
+// unsubscribed from, filtered out of the inbox and has their existing mail cleaned up.
+func HandleUnsubscribeCampaign(w http.ResponseWriter, r *http.Request) {
+	var req campaignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	req.Domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(req.Domain), "@"))
+	if req.Domain == "" || !strings.Contains(req.Domain, ".") {
+		writeError(w, "A domain such as example.com is required", http.StatusBadRequest)
+		return
+	}
+	switch req.Cleanup {
+	case "":
+		req.Cleanup = bulkActionTrash
+	case bulkActionTrash, bulkActionArchive, "none":
+	default:
+		writeError(w, "cleanup must be trash, archive or none", http.StatusBadRequest)
+		return
+	}
+
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return
+	}
+	senders := processor.NewsletterSenders(req.Domain)
+	if len(senders) == 0 {
+		writeError(w, "No newsletter senders found under "+req.Domain, http.StatusNotFound)
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	// Remember which previewed message belongs to which sender
+	senderIDs := make(map[string][]string, len(senders))
+	resolve := func() ([]string, error) {
+		all := make([]string, 0)
+		for sender := range senders {
+			ids, err := listMessageIDs(mb, SenderQuery(sender))
+			if err != nil {
+				return nil, err
+			}
+			senderIDs[sender] = ids
+			all = append(all, ids...)
+		}
+		return all, nil
+	}
+
+	handleOperationPreview(w, r, mb, "unsubscribe-campaign", req.Domain, resolve, func(mb *mailbox, job *Job, ids []string) (interface{}, error) {
+		names := make([]string, 0, len(senders))
+		for sender := range senders {
+			names = append(names, sender)
+		}
+		sort.Strings(names)
+
+		results := make([]CampaignSenderResult, 0, len(names))
+		for _, sender := range names {
+			results = append(results, runCampaignSender(mb, sender, senders[sender], senderIDs[sender], req.Cleanup))
+		}
+		return &CampaignResult{Domain: req.Domain, Senders: results}, nil
+	})
+}
+
+// runCampaignSender unsubscribes from, filters and cleans up one sender. A failed step
+// is recorded and the remaining steps still run.
+func runCampaignSender(mb *mailbox, sender string, info *UnsubscribeInfo, ids []string, cleanup string) CampaignSenderResult {
+	result := CampaignSenderResult{Sender: sender}
+
+	if Features().Unsubscribe {
+		var err error
+		result.Unsubscribe, result.UnsubscribeURL, err = unsubscribe(mb, info)
+		if err != nil {
+			result.Unsubscribe = unsubscribeFailed
+			result.Errors = append(result.Errors, "unsubscribe: "+redactError(err))
+		}
+	} else {
+		result.Unsubscribe = unsubscribeDisabled
+	}
+
+	if Features().FilterCreation {
+		err := mb.quota.Wait(context.Background(), costFiltersCreate)
+		if err == nil {
+			var filter *gmail.Filter
+			if filter, err = createSkipInboxFilter(mb, &gmail.FilterCriteria{From: sender}); err == nil {
+				result.FilterID = filter.Id
+			}
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, "filter: "+redactError(err))
+		}
+	}
+
+	switch cleanup {
+	case bulkActionTrash:
+		result.Trash = trashMessages(mb, ids)
+	case bulkActionArchive:
+		if err := batchModify(mb, ids, nil, []string{"INBOX"}); err != nil {
+			result.Errors = append(result.Errors, "archive: "+redactError(err))
+		} else {
+			result.Archived = len(ids)
+		}
+	}
+	return result
+}
+
+// unsubscribe uses the best target a sender advertises: an RFC 8058 one-click POST,
+// then an unsubscribe email. A plain link can only be handed back to the user.
+func unsubscribe(mb *mailbox, info *UnsubscribeInfo) (method, manualURL string, err error) {
+	if info == nil {
+		return unsubscribeUnavailable, "", nil
+	}
+
+	if info.OneClick {
+		for _, target := range info.URLs {
+			if strings.HasPrefix(strings.ToLower(target), "https://") {
+				if mb.dryRun() {
+					log.Printf("Dry run: skipped one-click unsubscribe")
+					return unsubscribeOneClick, "", nil
+				}
+				return unsubscribeOneClick, "", oneClickUnsubscribe(target)
+			}
+		}
+	}
+	if len(info.Mailto) > 0 {
+		return unsubscribeMailto, "", mailtoUnsubscribe(mb, info.Mailto[0])
+	}
+	if len(info.URLs) > 0 {
+		return unsubscribeManual, info.URLs[0], nil
+	}
+	return unsubscribeUnavailable, "", nil
+}
+
+// oneClickUnsubscribe performs an RFC 8058 one-click unsubscribe
+func oneClickUnsubscribe(target string) error {
+	resp, err := unsubscribeClient.Post(target, "application/x-www-form-urlencoded", strings.NewReader("List-Unsubscribe=One-Click"))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unsubscribe endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// mailtoUnsubscribe sends the unsubscribe email described by a mailto: URL
+func mailtoUnsubscribe(mb *mailbox, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid mailto target: %w", err)
+	}
+	to := u.Opaque
+	if to == "" {
+		return fmt.Errorf("mailto target has no address")
+	}
+	subject := u.Query().Get("subject")
+	if subject == "" {
+		subject = "unsubscribe"
+	}
+
+	// Keep header values from the sender on a single line
+	oneLine := strings.NewReplacer("\r", " ", "\n", " ")
+	raw := fmt.Sprintf("To: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		oneLine.Replace(to), oneLine.Replace(subject), u.Query().Get("body"))
+
+	if err := mb.quota.Wait(context.Background(), costMessagesSend); err != nil {
+		return err
+	}
+	return mb.provider.SendMessage(mb.context(), mb.user, &gmail.Message{
+		Raw: base64.URLEncoding.EncodeToString([]byte(raw)),
+	})
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+)
+
+// classifyAll re-runs the classifier over every collected message now that each sender's
+// unread ratio is known, applies the user's corrections and rebuilds the category
+// aggregates
+func (p *InboxProcessor) classifyAll() {
+	feedback := p.feedback()
+
+	var err error
+	p.mu.Lock()
+	p.stats.Reclassify(func(yield func(*EmailMetadata) bool) {
+		err = p.emails.updateAll(func(email *EmailMetadata) { yield(email) })
+	}, feedback.apply)
+	p.mu.Unlock()
+	if err != nil {
+		log.Printf("Failed to reclassify cached messages: %s", redactError(err))
+	}
+}
+
+// IDsInCategory returns the IDs of collected messages with the given category
+func (p *InboxProcessor) IDsInCategory(category string) map[string]struct{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	ids := make(map[string]struct{})
+	p.emails.each(func(_ int, email *EmailMetadata) bool {
+		if email.Category == category {
+			ids[email.ID] = struct{}{}
+		}
+		return true
+	})
+	return ids
+}
+
+// parseCategoryFilter reads and validates the optional `category` query parameter
+func parseCategoryFilter(r *http.Request) (string, error) {
+	category := r.URL.Query().Get("category")
+	return category, validateCategory(category)
+}
+
+// validateCategory checks that category is empty or one the classifier assigns
+func validateCategory(category string) error {
+	if category == "" {
+		return nil
+	}
+	for _, c := range deepclean.Categories {
+		if c == category {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown category %q, expected one of %s", category, strings.Join(deepclean.Categories, ", "))
+}
+
+// filterIDsByCategory keeps only the IDs the user's processor has classified as category.
+// It returns ids unchanged when no category filter is given.
+func filterIDsByCategory(userID string, ids []string, category string) ([]string, error) {
+	if category == "" {
+		return ids, nil
+	}
+
+	processor, exists := Registry.Get(userID)
+	if !exists {
+		return nil, fmt.Errorf("category filters need a completed inbox scan")
+	}
+
+	inCategory := processor.IDsInCategory(category)
+	filtered := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := inCategory[id]; ok {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/oauth2"
+)
+
+// LoginLocal signs a command-line user in: it prints the consent URL to out, receives
+// Google's redirect on a loopback port and exchanges the code for a token. Google only
+// accepts any loopback port for Desktop OAuth clients.
+func LoginLocal(ctx context.Context, out io.Writer) (*oauth2.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the OAuth redirect: %w", err)
+	}
+	redirect := oauth2.SetAuthURLParam("redirect_uri", "http://"+listener.Addr().String()+"/")
+	state := randomSecret()
+
+	codes := make(chan string, 1)
+	denied := make(chan error, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("state") != state {
+			writeError(w, "Invalid OAuth state", http.StatusBadRequest)
+			return
+		}
+		if reason := r.FormValue("error"); reason != "" {
+			writeError(w, "Sign-in failed: "+reason, http.StatusForbidden)
+			select {
+			case denied <- fmt.Errorf("sign-in failed: %s", reason):
+			default:
+			}
+			return
+		}
+		fmt.Fprintln(w, "Signed in. You can close this window and return to the terminal.")
+		select {
+		case codes <- r.FormValue("code"):
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	// Ask for a refresh token every time, since it is what the cached token lives on
+	url := oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce, redirect)
+	fmt.Fprintf(out, "Open this URL in your browser to sign in:\n\n  %s\n\n", url)
+
+	select {
+	case code := <-codes:
+		token, err := oauthConfig.Exchange(ctx, code, redirect)
+		if err != nil {
+			return nil, fmt.Errorf("failed to exchange token: %w", err)
+		}
+		return token, nil
+	case err := <-denied:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// LoginDevice signs a user in with the OAuth device flow, for machines without a
+// browser: it prints a code to out for the user to enter on another device, then waits
+// for them to approve. It needs an OAuth client of the "TVs and Limited Input devices"
+// type, and Google only grants some scopes this way.
+func LoginDevice(ctx context.Context, out io.Writer) (*oauth2.Token, error) {
+	auth, err := oauthConfig.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device sign-in: %w", err)
+	}
+	fmt.Fprintf(out, "On any device, open %s and enter the code %s\n\n", auth.VerificationURI, auth.UserCode)
+
+	// Polls at the interval Google asks for until approved, denied or expired
+	token, err := oauthConfig.DeviceAccessToken(ctx, auth)
+	if err != nil {
+		return nil, fmt.Errorf("device sign-in failed: %w", err)
+	}
+	return token, nil
+}
+
+// LoadToken reads a token saved by SaveToken
+func LoadToken(path string) (*oauth2.Token, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("not signed in, run the login command first")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var token oauth2.Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("invalid token file %s: %w", path, err)
+	}
+	return &token, nil
+}
+
+// SaveToken writes a token to path, readable only by the current user. The file is
+// replaced rather than rewritten, so an older copy with looser permissions doesn't keep
+// them.
+func SaveToken(path string, token *oauth2.Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".token-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Scan downloads and processes the mailbox in the calling goroutine rather than as a job,
+// returning an error if the scan stopped early. The processor is registered like a
+// server scan's, so later previews know the sizes of the messages it saw.
+func (p *InboxProcessor) Scan(ctx context.Context) error {
+	p.mu.Lock()
+	if p.isProcessing {
+		p.mu.Unlock()
+		return fmt.Errorf("processing already in progress")
+	}
+	p.isProcessing = true
+	p.mu.Unlock()
+
+	Registry.Register(p.userID, p)
+	p.processInbox(ctx)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.abortReason != "" {
+		return fmt.Errorf("scan stopped early: %s", p.abortReason)
+	}
+	return nil
+}
+
+// TrashPlan is the command-line counterpart of a previewed trash operation: the
+// messages matching a query, resolved once so that executing the plan trashes exactly
+// the messages that were summarized
+type TrashPlan struct {
+	Query   string
+	Summary OperationSummary
+
+	mb  *mailbox
+	ids []string
+}
+
+// PlanTrash resolves and summarizes the messages matching a Gmail search query in the
+// token's own mailbox, without changing anything
+func PlanTrash(ctx context.Context, token *oauth2.Token, query string) (*TrashPlan, error) {
+	mb, err := newMailbox(token, "me")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gmail service: %w", err)
+	}
+	mb.ctx = ctx
+
+	ids, err := listMessageIDs(mb, query)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := summarizeMessages(mb, ids)
+	if err != nil {
+		return nil, err
+	}
+	summary.Storage = projectStorage(mb, summary.EstimatedSize)
+
+	return &TrashPlan{Query: query, Summary: *summary, mb: mb, ids: ids}, nil
+}
+
+// Execute trashes the planned messages, skipping those the user's protection rules
+// cover. Like a confirmed operation it is audited and goes on the undo stack, so the
+// web app can restore what it trashed.
+func (p *TrashPlan) Execute() (*TrashResult, error) {
+	// Without the account what is trashed couldn't be undone, so don't start
+	if _, err := p.mb.account(); err != nil {
+		return nil, err
+	}
+
+	result := trashMessages(p.mb, p.ids)
+	recordTrashAudit(p.mb, newID(), "delete-by-query", p.Query, affectedIDs(result, p.ids), nil)
+	return result, nil
+}
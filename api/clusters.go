@@ -0,0 +1,171 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultClusterMinSize = 3
+	clusterSampleSize     = 3
+)
+
+var (
+	// Reply and forward prefixes, possibly repeated ("Re: Fwd: ...")
+	replyPrefixPattern = regexp.MustCompile(`(?i)^((re|fwd?|aw|wg)\s*(\[\d+\])?\s*:\s*)+`)
+	// Month names and abbreviations, which vary between issues of a series
+	monthPattern = regexp.MustCompile(`(?i)\b(jan(uary)?|feb(ruary)?|mar(ch)?|apr(il)?|may|june?|july?|aug(ust)?|sep(t(ember)?)?|oct(ober)?|nov(ember)?|dec(ember)?)\b`)
+	// Weekday names and abbreviations
+	weekdayPattern = regexp.MustCompile(`(?i)\b(mon|tue|wed|thu|fri|sat|sun)(day|sday|nesday|rsday|urday)?\b`)
+	// Runs of digits (dates, counts, order numbers), with separators between them
+	numberPattern = regexp.MustCompile(`\d+([.,:/-]\d+)*`)
+	spacePattern  = regexp.MustCompile(`\s+`)
+)
+
+// SubjectCluster is a group of messages from one sender with near-identical subjects,
+// such as every issue of "Your weekly digest — <date>"
+type SubjectCluster struct {
+	ID      string    `json:"id"`
+	Sender  string    `json:"sender"`
+	Pattern string    `json:"pattern"`
+	Count   int       `json:"count"`
+	Size    int64     `json:"size"`
+	Samples []string  `json:"samples"`
+	Oldest  time.Time `json:"oldest"`
+	Newest  time.Time `json:"newest"`
+
+	messageIDs []string
+}
+
+// normalizeSubject reduces a subject to a template by dropping reply prefixes and
+// replacing the parts that change between messages of a series with placeholders
+func normalizeSubject(subject string) string {
+	s := strings.ToLower(strings.TrimSpace(subject))
+	s = replyPrefixPattern.ReplaceAllString(s, "")
+	s = monthPattern.ReplaceAllString(s, "<month>")
+	s = weekdayPattern.ReplaceAllString(s, "<day>")
+	s = numberPattern.ReplaceAllString(s, "#")
+	s = spacePattern.ReplaceAllString(s, " ")
+	return strings.TrimSpace(s)
+}
+
+// clusterID derives a stable identifier for a sender/pattern pair
+func clusterID(sender, pattern string) string {
+	sum := sha1.Sum([]byte(sender + "\x00" + pattern))
+	return hex.EncodeToString(sum[:6])
+}
+
+// GetSubjectClusters groups collected messages by sender and subject template, returning
+// clusters of at least minSize messages, largest first. An empty sender means all senders.
+func (p *InboxProcessor) GetSubjectClusters(sender string, minSize int) []*SubjectCluster {
+	p.mu.RLock()
+	byID := make(map[string]*SubjectCluster)
+	p.emails.each(func(_ int, email *EmailMetadata) bool {
+		if sender != "" && email.From != sender {
+			return true
+		}
+
+		pattern := normalizeSubject(email.Subject)
+		id := clusterID(email.From, pattern)
+		cluster, ok := byID[id]
+		if !ok {
+			cluster = &SubjectCluster{ID: id, Sender: email.From, Pattern: pattern}
+			byID[id] = cluster
+		}
+
+		cluster.Count++
+		cluster.Size += email.Size()
+		cluster.messageIDs = append(cluster.messageIDs, email.ID)
+		if len(cluster.Samples) < clusterSampleSize {
+			cluster.Samples = append(cluster.Samples, email.Subject)
+		}
+		if !email.Date.IsZero() {
+			if cluster.Oldest.IsZero() || email.Date.Before(cluster.Oldest) {
+				cluster.Oldest = email.Date
+			}
+			if email.Date.After(cluster.Newest) {
+				cluster.Newest = email.Date
+			}
+		}
+		return true
+	})
+	p.mu.RUnlock()
+
+	clusters := make([]*SubjectCluster, 0)
+	for _, cluster := range byID {
+		if cluster.Count >= minSize {
+			clusters = append(clusters, cluster)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Count != clusters[j].Count {
+			return clusters[i].Count > clusters[j].Count
+		}
+		return clusters[i].ID < clusters[j].ID
+	})
+	return clusters
+}
+
+// findCluster returns the cluster with the given ID
+func (p *InboxProcessor) findCluster(id string) (*SubjectCluster, bool) {
+	for _, cluster := range p.GetSubjectClusters("", 1) {
+		if cluster.ID == id {
+			return cluster, true
+		}
+	}
+	return nil, false
+}
+
+// HandleGetSubjectClusters lists recurring subject series, optionally for one `sender`
+// and with at least `minSize` messages (default 3)
+func HandleGetSubjectClusters(w http.ResponseWriter, r *http.Request) {
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return
+	}
+
+	minSize := defaultClusterMinSize
+	if v := r.URL.Query().Get("minSize"); v != "" {
+		var err error
+		if minSize, err = strconv.Atoi(v); err != nil || minSize < 1 {
+			writeError(w, "minSize must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	writeJSON(w, processor.GetSubjectClusters(r.URL.Query().Get("sender"), minSize))
+}
+
+// HandleTrashSubjectCluster previews moving every message in a subject cluster to trash
+func HandleTrashSubjectCluster(w http.ResponseWriter, r *http.Request) {
+	handleClusterAction(w, r, "cluster-trash", bulkActionTrash)
+}
+
+// HandleArchiveSubjectCluster archives every message in a subject cluster
+func HandleArchiveSubjectCluster(w http.ResponseWriter, r *http.Request) {
+	handleClusterAction(w, r, "cluster-archive", bulkActionArchive)
+}
+
+// handleClusterAction applies a bulk action to the messages of the cluster in the URL
+func handleClusterAction(w http.ResponseWriter, r *http.Request, kind, action string) {
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return
+	}
+
+	cluster, ok := processor.findCluster(mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, "Cluster not found", http.StatusNotFound)
+		return
+	}
+
+	handleIDsAction(w, r, kind, "cluster:"+cluster.ID, cluster.messageIDs, action)
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Responses shorter than this are sent as is, since compressing them saves less than
+// the encoding costs
+const compressMinSize = 1024
+
+// Content types worth compressing. Images, archives, attachments and the like are
+// usually compressed already.
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"application/mbox":       true,
+	"image/svg+xml":          true,
+}
+
+// Pools of compressors per encoding, created at the configured level
+var (
+	gzipWriters sync.Pool
+	zlibWriters sync.Pool
+)
+
+// compressor is a gzip or zlib writer
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Compress gzips or deflates responses for clients that accept it, such as the stats
+// of large mailboxes and the JSON Lines export, which shrink manyfold. Only text-like
+// content types over compressMinSize are compressed, and streamed responses still
+// flush as they go. Compression level 0 turns it off.
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.CompressionLevel == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header, preferring
+// gzip, or returns "" if the client accepts neither. HTTP's deflate is the zlib format.
+func acceptedEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// isCompressible reports whether a response is worth compressing from its headers
+func isCompressible(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" || strings.HasPrefix(header.Get("Content-Range"), "bytes") {
+		return false
+	}
+	if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil && length < compressMinSize {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// compressWriter compresses what a handler writes once the response turns out to be
+// worth it. Up to compressMinSize bytes are held back to find out, unless the handler
+// flushes first.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	// Set once the response is known to be compressed or not
+	decided    bool
+	compressor compressor
+	// Start of the body, held back while undecided
+	buffer []byte
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+	// Informational responses go out right away and don't count as the response
+	if status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		cw.status = 0
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.decided {
+		if cw.compressor != nil {
+			return cw.compressor.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buffer = append(cw.buffer, p...)
+	if len(cw.buffer) >= compressMinSize {
+		if err := cw.decide(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide starts the response, compressed if the headers allow and the body held back
+// was big enough or streaming has begun, and writes out the held back body
+func (cw *compressWriter) decide(streaming bool) error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.buffer) > 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buffer))
+	}
+
+	compress := cw.status != http.StatusNoContent && cw.status != http.StatusNotModified &&
+		(streaming || len(cw.buffer) >= compressMinSize) && isCompressible(header)
+	if compress {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", cw.encoding)
+		cw.compressor = newCompressor(cw.encoding, cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buffered := cw.buffer
+	cw.buffer = nil
+	if len(buffered) == 0 {
+		return nil
+	}
+	var err error
+	if cw.compressor != nil {
+		_, err = cw.compressor.Write(buffered)
+	} else {
+		_, err = cw.ResponseWriter.Write(buffered)
+	}
+	return err
+}
+
+// Flush sends what was written so far. A response flushed before reaching
+// compressMinSize is a stream, which is compressed however small its first part.
+func (cw *compressWriter) Flush() {
+	if !cw.decided && cw.decide(true) != nil {
+		return
+	}
+	if cw.compressor != nil {
+		cw.compressor.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// close finishes the response once the handler returns
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 {
+			// Nothing was written; let the server send its default response
+			return
+		}
+		cw.decide(false)
+	}
+	if cw.compressor != nil {
+		cw.compressor.Close()
+		releaseCompressor(cw.compressor)
+		cw.compressor = nil
+	}
+}
+
+// Unwrap gives http.ResponseController the underlying writer, e.g. for write deadlines
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// newCompressor takes a compressor for encoding from its pool, writing to w
+func newCompressor(encoding string, w io.Writer) compressor {
+	if encoding == "gzip" {
+		if gz, ok := gzipWriters.Get().(*gzip.Writer); ok {
+			gz.Reset(w)
+			return gz
+		}
+		gz, _ := gzip.NewWriterLevel(w, config.CompressionLevel)
+		return gz
+	}
+	if zw, ok := zlibWriters.Get().(*zlib.Writer); ok {
+		zw.Reset(w)
+		return zw
+	}
+	zw, _ := zlib.NewWriterLevel(w, config.CompressionLevel)
+	return zw
+}
+
+// releaseCompressor returns a closed compressor to its pool
+func releaseCompressor(c compressor) {
+	switch c := c.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(c)
+	case *zlib.Writer:
+		zlibWriters.Put(c)
+	}
+}
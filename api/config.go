@@ -1,18 +1,140 @@
 package api
 
 import (
+	"log"
+	"net"
 	"os"
+	"path/filepath"
+	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/people/v1"
 )
 
-// Configuration struct for OAuth
+// Config is the server's configuration. LoadConfig reads it from the config file, the
+// environment and the command line.
 type Config struct {
+	// Port to serve on, 8080 by default or 443 when serving HTTPS
+	Port string
+	// Port of the plain HTTP listener redirecting to HTTPS, or "off"
+	HTTPRedirectPort string
+	// Port of the gRPC API, empty to not serve it
+	GRPCPort string
+
 	ClientID     string
 	ClientSecret string
 	RedirectURL  string
+	// OAuth scopes to request on top of those the enabled features need
+	ExtraScopes []string
+
+	// Fraction of failed messages that aborts a scan or cleanup (0 disables the check)
+	ErrorBudget float64
+	// Messages that must be attempted before the error budget is enforced
+	ErrorBudgetMinSample int
+
+	// Skip every call that would change a mailbox, logging and counting it instead
+	DryRun bool
+
+	// Request the Drive scope so attachments can be saved to Drive before deletion
+	DriveEnabled bool
+	// Default Drive folder that saved attachments are uploaded to
+	DriveFolderID string
+	// Request Drive access to read the account's storage quota
+	StorageQuotaEnabled bool
+	// Request read access to contacts to show senders' names and photos
+	ContactsEnabled bool
+
+	// Service account key with domain-wide delegation, letting admins act as any user of
+	// the Workspace domain
+	ServiceAccountFile string
+	// Workspace admin the service account acts as to look up the domain's users
+	WorkspaceAdmin string
+
+	// IMAP servers users may sign in to instead of Gmail, by host or host:port, or "*" for
+	// any (empty disables IMAP sign-in)
+	IMAPServers []string
+
+	// Serve a synthetic mailbox instead of connecting to Google, for trying the app
+	DemoMode bool
+	// Size of the synthetic mailbox, how many senders it has and how unevenly mail is
+	// spread among them (the exponent of a Zipf distribution, above 1), and the fraction
+	// of messages with an attachment
+	DemoMessages        int
+	DemoSenders         int
+	DemoSenderSkew      float64
+	DemoAttachmentRatio float64
+
+	// Feature flags the server starts with; operators can change them at runtime
+	Features FeatureFlags
+
+	// Number of jobs that run at once, besides the worker reserved for interactive jobs
+	JobWorkers int
+	// Minutes between checks for scheduled cleanups that are due (0 disables the
+	// scheduler)
+	SchedulerMinutes int
+
+	// Messages a scan lists per Gmail API call
+	ScanPageSize int
+	// Messages per scan kept in memory before the rest spill to disk (0 keeps all)
+	MaxCachedMessages int
+	// Pages between saved checkpoints of a running scan (0 disables them)
+	ScanCheckpointPages int
+
+	// Where server-side state is kept; only "file" is supported
+	StorageBackend string
+	// Directory for server-side files such as backups
+	DataDir string
+	// Largest mbox file a user may upload for import, and the most disk space the
+	// unfinished imports of one account may reserve, in MB
+	MaxImportMB     int
+	ImportStorageMB int
+
+	// Key for signing share links (random per process if unset, so links die on restart)
+	ShareSecret string
+	// Key for signing session tokens (random per process if unset, so users sign in again
+	// after a restart), how many minutes a session token is valid before the frontend
+	// gets a new one, and how many days the server holds a session's OAuth token
+	SessionSecret  string
+	SessionMinutes int
+	SessionMaxDays int
+
+	// Shared secret for the operator-only /api/admin endpoints (empty disables them)
+	AdminToken string
+
+	// Public URL of the app, linked from cleanup report emails
+	AppURL string
+
+	// Directory to serve the frontend from instead of the embedded files
+	FrontendDir string
+
+	// Certificate and key to serve HTTPS with
+	TLSCertFile string
+	TLSKeyFile  string
+	// Hostnames to get Let's Encrypt certificates for instead, and the contact address
+	// for expiry notices
+	AutocertHosts []string
+	AutocertEmail string
+
+	// Origins allowed to call the API from the browser, or "*" for any (empty allows
+	// only the same origin)
+	CORSAllowedOrigins []string
+	// Proxies whose X-Forwarded-* headers are trusted
+	TrustedProxies []*net.IPNet
+	// Loopback, private and link-local networks webhooks and notifiers may deliver to
+	// anyway, for receivers on the operator's own network
+	WebhookAllowedNetworks []*net.IPNet
+	// Gzip/deflate level of compressed responses, 1 (fastest) to 9 (smallest), or 0 to
+	// not compress them
+	CompressionLevel int
+
+	// Log addresses, subjects and snippets instead of redacting them
+	UnredactedLogs bool
+
+	// Print the configuration and exit instead of serving
+	PrintConfig bool
 }
 
 var (
@@ -21,14 +143,73 @@ var (
 	oauthStateString = "random-state-string" // Replace with a secure random string in production
 )
 
-// Init initializes the API configuration
-func Init() {
-	// Initialize configuration
-	config = Config{
-		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-		RedirectURL:  os.Getenv("REDIRECT_URL"),
+// defaultConfig returns the configuration used where nothing else is set
+func defaultConfig() Config {
+	return Config{
+		HTTPRedirectPort: "80",
+
+		ErrorBudget:          0.05,
+		ErrorBudgetMinSample: 100,
+
+		DemoMessages:        2000,
+		DemoSenders:         200,
+		DemoSenderSkew:      1.3,
+		DemoAttachmentRatio: 0.08,
+
+		Features: FeatureFlags{
+			FilterCreation:   true,
+			Unsubscribe:      true,
+			DeepBodyScan:     true,
+			Webhooks:         true,
+			Notifications:    true,
+			ScheduledCleanup: true,
+		},
+
+		JobWorkers:          4,
+		SchedulerMinutes:    60,
+		CompressionLevel:    6,
+		ScanPageSize:        100,
+		MaxCachedMessages:   50000,
+		ScanCheckpointPages: 10,
+		StorageBackend:      "file",
+		DataDir:             "data",
+		MaxImportMB:         5 << 10,
+		ImportStorageMB:     10 << 10,
+
+		SessionMinutes: 15,
+		SessionMaxDays: 30,
+	}
+}
+
+// Init initializes the API with the given configuration
+func Init(cfg Config) {
+	config = cfg
+	if config.ShareSecret == "" {
+		config.ShareSecret = randomSecret()
+	}
+	if config.SessionSecret == "" {
+		config.SessionSecret = randomSecret()
+	}
+	initFeatures()
+
+	store, err := NewFileStore(filepath.Join(config.DataDir, "store"))
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	Storage = store
+
+	// Spill files belong to scans of a previous run, which are gone
+	if err := os.RemoveAll(spillDir()); err != nil {
+		log.Printf("Failed to clear spill directory: %s", redactError(err))
 	}
+	Jobs = NewJobQueue(config.JobWorkers)
+	go Usage.flushEvery(time.Minute)
+	if config.SchedulerMinutes > 0 {
+		go runScheduler(time.Duration(config.SchedulerMinutes) * time.Minute)
+	}
+
+	// Addresses, subjects and snippets stay out of the logs unless debugging
+	SetRedactionEnabled(!config.UnredactedLogs)
 
 	// Set up OAuth2 configuration
 	oauthConfig = &oauth2.Config{
@@ -36,9 +217,33 @@ func Init() {
 		ClientSecret: config.ClientSecret,
 		RedirectURL:  config.RedirectURL,
 		Scopes: []string{
-			gmail.GmailReadonlyScope, // For reading emails
-			gmail.GmailModifyScope,   // For modifying/deleting emails
+			gmail.GmailReadonlyScope,      // For reading emails
+			gmail.GmailModifyScope,        // For modifying/deleting emails
+			gmail.GmailSettingsBasicScope, // For creating filters
 		},
 		Endpoint: google.Endpoint,
 	}
+
+	if Features().PermanentDelete {
+		// Permanent deletion is the one thing gmail.modify can't do
+		oauthConfig.Scopes = append(oauthConfig.Scopes, gmail.MailGoogleComScope)
+	}
+
+	if config.DriveEnabled || config.StorageQuotaEnabled {
+		// Only files created by this app, never the rest of the user's Drive. This
+		// is enough to read the account's storage quota too.
+		oauthConfig.Scopes = append(oauthConfig.Scopes, drive.DriveFileScope)
+	}
+
+	if config.ContactsEnabled {
+		// Saved contacts plus the people Gmail remembers the user emailing
+		oauthConfig.Scopes = append(oauthConfig.Scopes, people.ContactsReadonlyScope, people.ContactsOtherReadonlyScope)
+	}
+
+	oauthConfig.Scopes = append(oauthConfig.Scopes, config.ExtraScopes...)
+
+	// The service account is granted the same scopes as the OAuth client
+	if err := initDelegation(); err != nil {
+		log.Fatalf("Failed to load service account: %v", err)
+	}
 }
@@ -0,0 +1,352 @@
+package api
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting is one configuration option. It is read from the config file by its key, from
+// the environment by env and from the command line as --key.
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	value  flag.Value
+	secret bool
+}
+
+// settings binds every option to its field of c
+func (c *Config) settings() []setting {
+	return []setting{
+		{"port", "PORT", "Port to serve on (default 8080, or 443 when serving HTTPS)", (*stringValue)(&c.Port), false},
+		{"http-redirect-port", "HTTP_REDIRECT_PORT", `Port redirecting plain HTTP to HTTPS, or "off"`, (*stringValue)(&c.HTTPRedirectPort), false},
+		{"grpc-port", "GRPC_PORT", "Port to serve the gRPC API on (default off)", (*stringValue)(&c.GRPCPort), false},
+
+		{"google-client-id", "GOOGLE_CLIENT_ID", "OAuth client ID", (*stringValue)(&c.ClientID), false},
+		{"google-client-secret", "GOOGLE_CLIENT_SECRET", "OAuth client secret", (*stringValue)(&c.ClientSecret), true},
+		{"redirect-url", "REDIRECT_URL", "OAuth callback URL (default derived from the request)", (*stringValue)(&c.RedirectURL), false},
+		{"extra-scopes", "OAUTH_EXTRA_SCOPES", "OAuth scopes to request besides those features need, comma-separated", (*listValue)(&c.ExtraScopes), false},
+		{"service-account-file", "SERVICE_ACCOUNT_FILE", "Service account key with domain-wide delegation, to act as any user of the domain", (*stringValue)(&c.ServiceAccountFile), false},
+		{"imap-servers", "IMAP_SERVERS", `IMAP servers users may sign in to, comma-separated, or "*" for any`, (*listValue)(&c.IMAPServers), false},
+		{"workspace-admin", "WORKSPACE_ADMIN", "Workspace admin to act as when listing the domain's users", (*stringValue)(&c.WorkspaceAdmin), false},
+
+		{"demo-mode", "DEMO_MODE", "Serve a synthetic mailbox instead of connecting to Google", (*boolValue)(&c.DemoMode), false},
+		{"demo-messages", "DEMO_MESSAGES", "Messages in the demo mailbox", (*intValue)(&c.DemoMessages), false},
+		{"demo-senders", "DEMO_SENDERS", "Senders in the demo mailbox", (*intValue)(&c.DemoSenders), false},
+		{"demo-sender-skew", "DEMO_SENDER_SKEW", "How much the demo mailbox's top senders dominate (above 1)", (*floatValue)(&c.DemoSenderSkew), false},
+		{"demo-attachment-ratio", "DEMO_ATTACHMENT_RATIO", "Fraction of demo messages with an attachment (0-1)", (*floatValue)(&c.DemoAttachmentRatio), false},
+
+		{"dry-run", "DRY_RUN", "Log and count calls that would change a mailbox instead of making them", (*boolValue)(&c.DryRun), false},
+		{"scan-error-budget", "SCAN_ERROR_BUDGET", "Fraction of failed messages that aborts a scan or cleanup (0 disables)", (*floatValue)(&c.ErrorBudget), false},
+		{"scan-error-min-sample", "SCAN_ERROR_MIN_SAMPLE", "Messages attempted before the error budget applies", (*intValue)(&c.ErrorBudgetMinSample), false},
+
+		{"enable-drive", "ENABLE_DRIVE", "Save attachments to Google Drive", (*boolValue)(&c.DriveEnabled), false},
+		{"drive-folder-id", "DRIVE_FOLDER_ID", "Default Drive folder for saved attachments", (*stringValue)(&c.DriveFolderID), false},
+		{"enable-storage-quota", "ENABLE_STORAGE_QUOTA", "Read the account's storage quota from Drive", (*boolValue)(&c.StorageQuotaEnabled), false},
+		{"enable-contacts", "ENABLE_CONTACTS", "Show senders' names and photos from contacts", (*boolValue)(&c.ContactsEnabled), false},
+
+		{"feature-permanent-delete", "FEATURE_PERMANENT_DELETE", "Allow deleting messages for good", (*boolValue)(&c.Features.PermanentDelete), false},
+		{"feature-filter-creation", "FEATURE_FILTER_CREATION", "Allow creating Gmail filters", (*boolValue)(&c.Features.FilterCreation), false},
+		{"feature-unsubscribe", "FEATURE_UNSUBSCRIBE", "Allow following unsubscribe links", (*boolValue)(&c.Features.Unsubscribe), false},
+		{"feature-deep-body-scan", "FEATURE_DEEP_BODY_SCAN", "Allow scanning full message bodies", (*boolValue)(&c.Features.DeepBodyScan), false},
+		{"feature-webhooks", "FEATURE_WEBHOOKS", "Allow users to register webhooks", (*boolValue)(&c.Features.Webhooks), false},
+		{"webhook-allowed-networks", "WEBHOOK_ALLOWED_NETWORKS", "Private networks webhooks and notifiers may deliver to, such as a LAN's Home Assistant, as IPs or CIDR ranges", (*networksValue)(&c.WebhookAllowedNetworks), false},
+		{"feature-notifications", "FEATURE_NOTIFICATIONS", "Allow users to set up Slack, ntfy and Pushover notifications", (*boolValue)(&c.Features.Notifications), false},
+		{"feature-scheduled-cleanup", "FEATURE_SCHEDULED_CLEANUP", "Allow users to set up cleanups the server runs on a schedule", (*boolValue)(&c.Features.ScheduledCleanup), false},
+
+		{"job-workers", "JOB_WORKERS", "Number of jobs that run at once, besides one reserved for interactive jobs", (*intValue)(&c.JobWorkers), false},
+		{"scheduler-minutes", "SCHEDULER_MINUTES", "Minutes between checks for due scheduled cleanups (0 disables them)", (*intValue)(&c.SchedulerMinutes), false},
+		{"scan-page-size", "SCAN_PAGE_SIZE", "Messages a scan lists per Gmail API call (1-500)", (*intValue)(&c.ScanPageSize), false},
+		{"max-cached-messages", "MAX_CACHED_MESSAGES", "Messages per scan kept in memory before spilling to disk (0 keeps all)", (*intValue)(&c.MaxCachedMessages), false},
+		{"scan-checkpoint-pages", "SCAN_CHECKPOINT_PAGES", "Pages between scan checkpoints (0 disables them)", (*intValue)(&c.ScanCheckpointPages), false},
+
+		{"storage-backend", "STORAGE_BACKEND", `Where server-side state is kept ("file")`, (*stringValue)(&c.StorageBackend), false},
+		{"data-dir", "DATA_DIR", "Directory for server-side files", (*stringValue)(&c.DataDir), false},
+		{"max-import-mb", "MAX_IMPORT_MB", "Largest mbox file a user may upload for import, in MB", (*intValue)(&c.MaxImportMB), false},
+		{"import-storage-mb", "IMPORT_STORAGE_MB", "Disk space the unfinished imports of one account may take up, in MB", (*intValue)(&c.ImportStorageMB), false},
+		{"share-secret", "SHARE_SECRET", "Key for signing share links (random per process if unset)", (*stringValue)(&c.ShareSecret), true},
+		{"session-secret", "SESSION_SECRET", "Key for signing session tokens (random per process if unset)", (*stringValue)(&c.SessionSecret), true},
+		{"session-minutes", "SESSION_MINUTES", "Minutes a session token is valid before the frontend renews it", (*intValue)(&c.SessionMinutes), false},
+		{"session-max-days", "SESSION_MAX_DAYS", "Days the server keeps a signed-in user's OAuth token", (*intValue)(&c.SessionMaxDays), false},
+		{"admin-token", "ADMIN_TOKEN", "Secret for the /api/admin endpoints (empty disables them)", (*stringValue)(&c.AdminToken), true},
+		{"app-url", "APP_URL", "Public URL of the app, linked from report emails", (*stringValue)(&c.AppURL), false},
+		{"frontend-dir", "FRONTEND_DIR", "Serve the frontend from this directory instead of the embedded files", (*stringValue)(&c.FrontendDir), false},
+
+		{"tls-cert-file", "TLS_CERT_FILE", "Certificate to serve HTTPS with", (*stringValue)(&c.TLSCertFile), false},
+		{"tls-key-file", "TLS_KEY_FILE", "Key of the certificate", (*stringValue)(&c.TLSKeyFile), false},
+		{"autocert-hosts", "AUTOCERT_HOSTS", "Hostnames to get Let's Encrypt certificates for, comma-separated", (*listValue)(&c.AutocertHosts), false},
+		{"autocert-email", "AUTOCERT_EMAIL", "Contact address for Let's Encrypt", (*stringValue)(&c.AutocertEmail), false},
+
+		{"cors-allowed-origins", "CORS_ALLOWED_ORIGINS", `Origins allowed to call the API from the browser, or "*"`, (*listValue)(&c.CORSAllowedOrigins), false},
+		{"trusted-proxies", "TRUSTED_PROXIES", "Proxies whose X-Forwarded-* headers are trusted, as IPs or CIDR ranges", (*networksValue)(&c.TrustedProxies), false},
+		{"compression-level", "COMPRESSION_LEVEL", "Gzip/deflate level of responses, 1 (fastest) to 9 (smallest), 0 to not compress", (*intValue)(&c.CompressionLevel), false},
+
+		{"debug-unredacted-logs", "DEBUG_UNREDACTED_LOGS", "Log addresses, subjects and snippets", (*boolValue)(&c.UnredactedLogs), false},
+	}
+}
+
+// LoadConfig reads the configuration. Each source overrides the ones before it: the
+// defaults, the YAML config file named by --config or CONFIG_FILE, the environment, and
+// the command-line flags. args are the command-line arguments without the program name.
+func LoadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+	settings := cfg.settings()
+
+	flags := flag.NewFlagSet("gmail-deepclean", flag.ContinueOnError)
+	for _, s := range settings {
+		flags.Var(s.value, s.key, s.usage+" ($"+s.env+")")
+	}
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML config file ($CONFIG_FILE)")
+	flags.BoolVar(&cfg.PrintConfig, "print-config", false, "Print the configuration and exit")
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+	if flags.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	// The flags are already applied, so the file and environment only fill in the rest
+	fromFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		fromFlags[f.Name] = true
+	})
+
+	if *configFile != "" {
+		values, err := readConfigFile(*configFile)
+		if err != nil {
+			return cfg, err
+		}
+		for _, s := range settings {
+			value, ok := values[s.key]
+			delete(values, s.key)
+			if !ok || fromFlags[s.key] {
+				continue
+			}
+			if err := s.value.Set(value); err != nil {
+				return cfg, fmt.Errorf("%s: invalid value for %s: %w", *configFile, s.key, err)
+			}
+		}
+		for key := range values {
+			return cfg, fmt.Errorf("%s: unknown setting %s", *configFile, key)
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := os.LookupEnv(s.env)
+		if !ok || fromFlags[s.key] {
+			continue
+		}
+		if err := s.value.Set(value); err != nil {
+			return cfg, fmt.Errorf("invalid value for %s: %w", s.env, err)
+		}
+	}
+
+	return cfg, cfg.validate()
+}
+
+// readConfigFile reads a YAML file of settings by key. Lists may be YAML sequences or
+// comma-separated strings.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch value := value.(type) {
+		case nil:
+			values[key] = ""
+		case []interface{}:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+// validate reports every invalid setting at once
+func (c *Config) validate() error {
+	var errs []error
+	if c.Port != "" && !validPort(c.Port) {
+		errs = append(errs, fmt.Errorf("port must be a port number, got %q", c.Port))
+	}
+	if c.HTTPRedirectPort != "off" && !validPort(c.HTTPRedirectPort) {
+		errs = append(errs, fmt.Errorf(`http-redirect-port must be a port number or "off", got %q`, c.HTTPRedirectPort))
+	}
+	if c.GRPCPort != "" && (!validPort(c.GRPCPort) || c.GRPCPort == c.Port) {
+		errs = append(errs, fmt.Errorf("grpc-port must be a port number other than port, got %q", c.GRPCPort))
+	}
+	if c.ErrorBudget < 0 || c.ErrorBudget > 1 {
+		errs = append(errs, fmt.Errorf("scan-error-budget must be between 0 and 1, got %v", c.ErrorBudget))
+	}
+	if c.ErrorBudgetMinSample < 0 {
+		errs = append(errs, errors.New("scan-error-min-sample can't be negative"))
+	}
+	if c.CompressionLevel < 0 || c.CompressionLevel > 9 {
+		errs = append(errs, fmt.Errorf("compression-level must be between 0 and 9, got %d", c.CompressionLevel))
+	}
+	if c.SessionMinutes < 1 {
+		errs = append(errs, errors.New("session-minutes must be at least 1"))
+	}
+	if c.SessionMaxDays < 1 {
+		errs = append(errs, errors.New("session-max-days must be at least 1"))
+	}
+	if c.JobWorkers < 1 {
+		errs = append(errs, errors.New("job-workers must be at least 1"))
+	}
+	if c.SchedulerMinutes < 0 {
+		errs = append(errs, errors.New("scheduler-minutes can't be negative"))
+	}
+	if c.ScanPageSize < 1 || c.ScanPageSize > maxEmailsPageSize {
+		errs = append(errs, fmt.Errorf("scan-page-size must be between 1 and %d", maxEmailsPageSize))
+	}
+	if c.MaxCachedMessages < 0 || c.ScanCheckpointPages < 0 {
+		errs = append(errs, errors.New("max-cached-messages and scan-checkpoint-pages can't be negative"))
+	}
+	if c.StorageBackend != "file" {
+		errs = append(errs, fmt.Errorf(`storage-backend %q is not supported; only "file" is`, c.StorageBackend))
+	}
+	if c.DataDir == "" {
+		errs = append(errs, errors.New("data-dir is required"))
+	}
+	if c.MaxImportMB < 1 || c.ImportStorageMB < c.MaxImportMB {
+		errs = append(errs, errors.New("max-import-mb must be at least 1 and import-storage-mb at least max-import-mb"))
+	}
+	if c.WorkspaceAdmin != "" && c.ServiceAccountFile == "" {
+		errs = append(errs, errors.New("workspace-admin needs service-account-file"))
+	}
+	if c.DemoMode {
+		if c.DemoMessages < 1 || c.DemoSenders < 2 {
+			errs = append(errs, errors.New("demo-messages must be at least 1 and demo-senders at least 2"))
+		}
+		if c.DemoSenderSkew <= 1 {
+			errs = append(errs, fmt.Errorf("demo-sender-skew must be above 1, got %v", c.DemoSenderSkew))
+		}
+		if c.DemoAttachmentRatio < 0 || c.DemoAttachmentRatio > 1 {
+			errs = append(errs, fmt.Errorf("demo-attachment-ratio must be between 0 and 1, got %v", c.DemoAttachmentRatio))
+		}
+	}
+	if c.TLSCertFile != "" && len(c.AutocertHosts) > 0 {
+		errs = append(errs, errors.New("set either tls-cert-file or autocert-hosts, not both"))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls-cert-file and tls-key-file must be set together"))
+	}
+	return errors.Join(errs...)
+}
+
+// validPort reports whether port is a TCP port number
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
+
+// Print writes the configuration as a YAML config file, with secrets masked
+func (c *Config) Print(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, s := range c.settings() {
+		value := s.value.String()
+		if s.secret && value != "" {
+			value = "********"
+		}
+		doc.Content = append(doc.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: s.key},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: value, Style: yaml.DoubleQuotedStyle},
+		)
+	}
+	return yaml.NewEncoder(w).Encode(doc)
+}
+
+// flag.Value implementations binding settings to Config fields
+
+type stringValue string
+
+func (v *stringValue) String() string     { return string(*v) }
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+
+type intValue int
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return errors.New("not an integer")
+	}
+	*v = intValue(n)
+	return nil
+}
+
+type floatValue float64
+
+func (v *floatValue) String() string { return strconv.FormatFloat(float64(*v), 'g', -1, 64) }
+func (v *floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return errors.New("not a number")
+	}
+	*v = floatValue(f)
+	return nil
+}
+
+type boolValue bool
+
+func (v *boolValue) String() string   { return strconv.FormatBool(bool(*v)) }
+func (v *boolValue) IsBoolFlag() bool { return true }
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return errors.New("not true or false")
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+// listValue is a comma-separated list; empty entries are dropped
+type listValue []string
+
+func (v *listValue) String() string { return strings.Join(*v, ",") }
+func (v *listValue) Set(s string) error {
+	*v = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v = append(*v, item)
+		}
+	}
+	return nil
+}
+
+type networksValue []*net.IPNet
+
+func (v *networksValue) String() string {
+	list := make([]string, len(*v))
+	for i, ipNet := range *v {
+		list[i] = ipNet.String()
+	}
+	return strings.Join(list, ",")
+}
+func (v *networksValue) Set(s string) error {
+	nets, err := parseNetworks(s)
+	if err != nil {
+		return err
+	}
+	*v = nets
+	return nil
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+	"google.golang.org/api/people/v1"
+)
+
+// How long a user's contacts are reused before being fetched again
+const contactsTTL = time.Hour
+
+// Contact is how the user knows a sender
+type Contact struct {
+	Name  string `json:"name,omitempty"`
+	Photo string `json:"photo,omitempty"`
+}
+
+// contactDirectory is a user's contacts by lowercase email address
+type contactDirectory struct {
+	byEmail   map[string]Contact
+	fetchedAt time.Time
+}
+
+var (
+	contactDirectories   = make(map[string]*contactDirectory)
+	contactDirectoriesMu sync.Mutex
+)
+
+// lookupContacts returns the user's contacts, fetching them from the People API at most
+// once per contactsTTL
+func lookupContacts(ctx context.Context, token *oauth2.Token, userID string) (map[string]Contact, error) {
+	contactDirectoriesMu.Lock()
+	dir, ok := contactDirectories[userID]
+	contactDirectoriesMu.Unlock()
+	if ok && time.Since(dir.fetchedAt) < contactsTTL {
+		return dir.byEmail, nil
+	}
+
+	byEmail, err := fetchContacts(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	contactDirectoriesMu.Lock()
+	contactDirectories[userID] = &contactDirectory{byEmail: byEmail, fetchedAt: time.Now()}
+	contactDirectoriesMu.Unlock()
+	return byEmail, nil
+}
+
+// fetchContacts reads the user's saved contacts and the "other contacts" Gmail collects
+// from people they've emailed. Saved contacts win when an address is in both.
+func fetchContacts(ctx context.Context, token *oauth2.Token) (map[string]Contact, error) {
+	client := googleClient(ctx, token)
+	service, err := people.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create People service: %w", err)
+	}
+
+	byEmail := make(map[string]Contact)
+
+	pageToken := ""
+	for {
+		req := service.People.Connections.List("people/me").PersonFields("names,emailAddresses,photos").PageSize(1000).Context(ctx)
+		if pageToken != "" {
+			req = req.PageToken(pageToken)
+		}
+		resp, err := req.Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list contacts: %w", err)
+		}
+		for _, person := range resp.Connections {
+			addContact(byEmail, person)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+
+	pageToken = ""
+	for {
+		req := service.OtherContacts.List().ReadMask("names,emailAddresses,photos").PageSize(1000).Context(ctx)
+		if pageToken != "" {
+			req = req.PageToken(pageToken)
+		}
+		resp, err := req.Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list other contacts: %w", err)
+		}
+		for _, person := range resp.OtherContacts {
+			addContact(byEmail, person)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+
+	return byEmail, nil
+}
+
+// addContact records a person under each of their addresses not already known
+func addContact(byEmail map[string]Contact, person *people.Person) {
+	var contact Contact
+	for _, name := range person.Names {
+		if name.DisplayName != "" {
+			contact.Name = name.DisplayName
+			break
+		}
+	}
+	for _, photo := range person.Photos {
+		// Default photos are generated initials, no more useful than the name
+		if photo.Url != "" && !photo.Default {
+			contact.Photo = photo.Url
+			break
+		}
+	}
+	if contact.Name == "" && contact.Photo == "" {
+		return
+	}
+
+	for _, address := range person.EmailAddresses {
+		email := strings.ToLower(strings.TrimSpace(address.Value))
+		if _, ok := byEmail[email]; email != "" && !ok {
+			byEmail[email] = contact
+		}
+	}
+}
+
+// enrichSenders adds the contact name and photo of each sender the user knows. Senders
+// are left as they are if contacts can't be read, since the list is useful without them.
+func enrichSenders(ctx context.Context, token *oauth2.Token, userID string, senders []map[string]interface{}) {
+	contacts, err := lookupContacts(ctx, token, userID)
+	if err != nil {
+		log.Printf("Failed to look up contacts: %s", redactError(err))
+		return
+	}
+	for _, sender := range senders {
+		email, _ := sender["email"].(string)
+		contact, ok := contacts[strings.ToLower(email)]
+		if !ok {
+			continue
+		}
+		if contact.Name != "" {
+			sender["name"] = contact.Name
+		}
+		if contact.Photo != "" {
+			sender["photo"] = contact.Photo
+		}
+	}
+}
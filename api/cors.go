@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Request headers the frontend sends, including the trace context of its spans
+const corsAllowedHeaders = "Authorization, Content-Type, Range, X-Admin-Token, X-CSRF-Token, X-Impersonate, X-Mailbox, traceparent, tracestate"
+
+// Response headers the frontend may read besides the CORS-safelisted ones
+const corsExposedHeaders = "Content-Disposition, Content-Range, Accept-Ranges, X-Total-Records"
+
+// corsAllowedOrigin returns the value of Access-Control-Allow-Origin for origin, or ""
+// if it isn't allowed
+func corsAllowedOrigin(origin string) string {
+	for _, allowed := range config.CORSAllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// CORS lets the configured origins, such as the frontend's dev server on another port,
+// call the API from the browser. It answers preflight requests itself, since the router
+// wouldn't match OPTIONS for most routes. Without configured origins it does nothing.
+func CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(config.CORSAllowedOrigins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := corsAllowedOrigin(origin)
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		}
+
+		// Preflight
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed == "" {
+				writeError(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+const (
+	// Cookie holding the CSRF token, readable by the frontend so it can echo it back
+	csrfCookie = "deepclean_csrf"
+	// Header the CSRF token is sent back in
+	csrfHeader = "X-CSRF-Token"
+)
+
+// CSRF rejects POST, PUT, PATCH and DELETE requests that don't send the CSRF token of
+// their cookie back in the X-CSRF-Token header (double submit), so other sites can't
+// make a signed-in browser change anything. Requests carrying an Authorization or
+// X-Admin-Token header, as the frontend, the CLI and API clients send, are exempt: a
+// browser only sends those when the app's own scripts set them, and other origins'
+// scripts can't without passing CORS.
+func CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isMutating(r.Method) || csrfExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(csrfCookie)
+		sent := r.Header.Get(csrfHeader)
+		if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(sent)) != 1 {
+			writeError(w, "Missing or invalid CSRF token: get one from GET /auth/csrf and send it in the "+csrfHeader+" header", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isMutating reports whether requests with method may change something
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// csrfExempt reports whether a request authenticates with a header rather than with
+// anything a browser sends by itself
+func csrfExempt(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("X-Admin-Token") != ""
+}
+
+// HandleGetCSRFToken returns the CSRF token to send in the X-CSRF-Token header, setting
+// its cookie if the browser doesn't have one yet
+func HandleGetCSRFToken(w http.ResponseWriter, r *http.Request) {
+	token := ""
+	if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+		token = cookie.Value
+	} else {
+		token = randomSecret()
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrfCookie,
+			Value:    token,
+			Path:     "/",
+			Secure:   strings.HasPrefix(requestBaseURL(r), "https://"),
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]string{"token": token, "header": csrfHeader})
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DataExportManifest is manifest.json of a data export, listing its files
+type DataExportManifest struct {
+	Account    string    `json:"account"`
+	ExportedAt time.Time `json:"exportedAt"`
+	// What each file of the archive holds, by name
+	Files map[string]string `json:"files"`
+}
+
+// dataFile is one file of a data export
+type dataFile struct {
+	name        string
+	description string
+	write       func(w io.Writer) error
+}
+
+// jsonDataFile is a data export file holding v as indented JSON
+func jsonDataFile(name, description string, v interface{}) dataFile {
+	return dataFile{name, description, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}}
+}
+
+// HandleExportMyData downloads a zip of everything the server holds about the mailbox,
+// the counterpart of HandlePurgeMyData: the scan's cached metadata, stats and progress,
+// rules, protection rules, saved searches, webhooks, notifiers, retention policies and
+// their runs, trash emptying settings, classification feedback, the audit log, undo
+// records, jobs, imports, backups and quota usage, plus a manifest.json describing each
+// file. Everything but the cached metadata is read before the download starts, so
+// failing to read it is reported as an error response rather than a truncated archive.
+func HandleExportMyData(w http.ResponseWriter, r *http.Request) {
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	files, err := collectUserData(mb, account)
+	if err != nil {
+		writeErrorFrom(w, "Failed to read stored data", err, http.StatusInternalServerError)
+		return
+	}
+
+	manifest := DataExportManifest{Account: account, ExportedAt: time.Now().UTC(), Files: make(map[string]string)}
+	for _, file := range files {
+		manifest.Files[file.name] = file.description
+	}
+	files = append(files, jsonDataFile("manifest.json", "This list of files", manifest))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="deepclean-data-%s.zip"`, manifest.ExportedAt.Format("2006-01-02")))
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		out, err := archive.Create(file.name)
+		if err == nil {
+			err = file.write(out)
+		}
+		if err != nil {
+			log.Printf("Data export aborted at %s: %s", file.name, redactError(err))
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Data export aborted: %s", redactError(err))
+	}
+}
+
+// collectUserData reads what the server holds about a mailbox into the files of its
+// data export
+func collectUserData(mb *mailbox, account string) ([]dataFile, error) {
+	files := make([]dataFile, 0)
+
+	if processor, ok := Registry.Get(mb.userID); ok {
+		total := processor.EmailCount()
+		files = append(files,
+			dataFile{"emails.jsonl", "Metadata of the scanned messages, one JSON object per line", func(w io.Writer) error {
+				enc := json.NewEncoder(w)
+				for pos := 0; pos < total; pos += exportChunkSize {
+					chunk := processor.EmailsRange(pos, min(exportChunkSize, total-pos))
+					for i := range chunk {
+						if err := enc.Encode(&chunk[i]); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			}},
+			jsonDataFile("stats.json", "Statistics of the scanned messages", processor.GetStats()),
+			jsonDataFile("scan.json", "Progress of the last scan", processor.GetProgress()),
+		)
+	}
+
+	rules, err := loadRules(account)
+	if err != nil {
+		return nil, err
+	}
+	protection, err := loadProtectionRules(account)
+	if err != nil {
+		return nil, err
+	}
+	searches, err := loadSavedSearches(account)
+	if err != nil {
+		return nil, err
+	}
+	feedback, err := loadFeedback(account)
+	if err != nil {
+		return nil, err
+	}
+	audit, err := loadAuditLog(account)
+	if err != nil {
+		return nil, err
+	}
+	trashRecords, err := loadTrashRecords(account)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := Jobs.History(account)
+	if err != nil {
+		return nil, err
+	}
+	imports, err := importSessionsOf(account)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := Usage.userHistory(account)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := loadWebhooks(account)
+	if err != nil {
+		return nil, err
+	}
+	notifiers, err := loadNotifiers(account)
+	if err != nil {
+		return nil, err
+	}
+	retention, err := loadRetentionPolicies(account)
+	if err != nil {
+		return nil, err
+	}
+	retentionRuns, err := loadRetentionRuns(account)
+	if err != nil {
+		return nil, err
+	}
+	autoEmpty, err := loadAutoEmpty(account)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(files,
+		jsonDataFile("rules.json", "Cleanup rules", rules),
+		jsonDataFile("protection.json", "Protection rules", protection),
+		jsonDataFile("saved-searches.json", "Saved searches", searches),
+		jsonDataFile("webhooks.json", "Webhooks, without their signing secrets", withoutSecrets(webhooks)),
+		jsonDataFile("notifiers.json", "Notifiers, without their tokens", maskNotifiers(notifiers)),
+		jsonDataFile("retention.json", "Retention policies", retention),
+		jsonDataFile("retention-runs.json", "Reports of retention policy runs, newest first", retentionRuns),
+		jsonDataFile("auto-empty.json", "Automatic trash emptying settings and the latest run", autoEmpty),
+		jsonDataFile("feedback.json", "Classification feedback", feedback),
+		dataFile{"audit.csv", "Audit log of the changes made to the mailbox", func(w io.Writer) error {
+			return writeAuditCSV(w, audit)
+		}},
+		jsonDataFile("trash-records.json", "Undo records of trashed messages, most recent first", trashRecords),
+		jsonDataFile("jobs.json", "Jobs run for the mailbox, newest first", jobs),
+		jsonDataFile("imports.json", "Mbox imports", imports),
+		jsonDataFile("backups.json", "Backups taken before bulk deletes", Backups.ownedBy(account)),
+		dataFile{"usage.csv", "Gmail API quota units used per day", func(w io.Writer) error {
+			out := csv.NewWriter(w)
+			out.Write([]string{"date", "quota_units"})
+			for _, day := range usage {
+				out.Write([]string{day.Date, strconv.FormatInt(day.QuotaUnits, 10)})
+			}
+			out.Flush()
+			return out.Error()
+		}},
+	), nil
+}
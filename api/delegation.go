@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// Header naming the Workspace user an admin request acts as, through domain-wide
+// delegation
+const impersonateHeader = "X-Impersonate"
+
+// Token extra marking a token issued through delegation, holding the user it acts as
+const delegatedSubjectKey = "deepclean_subject"
+
+// Prefix of the user IDs of delegated tokens, followed by the user they act as
+const delegatedUserPrefix = "delegated:"
+
+// Delegation is the service account acting on behalf of the domain's users
+type Delegation struct {
+	ServiceAccount string `json:"serviceAccount"`
+	// Client ID to authorize in the Workspace admin console, with Scopes
+	ClientID string   `json:"clientId"`
+	Scopes   []string `json:"scopes"`
+
+	jwt     *jwt.Config
+	sources map[string]oauth2.TokenSource
+	mu      sync.Mutex
+}
+
+// Service account of domain-wide delegation mode, nil unless configured
+var delegation *Delegation
+
+// How long a user confirmed as a delegate of a mailbox may act on it before it is
+// checked again, so a revoked delegation stops working soon after
+const mailboxDelegateTTL = 5 * time.Minute
+
+var (
+	// Maps a user ID and a mailbox delegated to them to when the delegation was confirmed
+	mailboxDelegates   = make(map[string]time.Time)
+	mailboxDelegatesMu sync.Mutex
+)
+
+var errNotDelegate = errors.New("you are not a delegate of this mailbox")
+
+// initDelegation loads the service account key named by the config, requesting the same
+// scopes as the OAuth client, plus read access to the directory when a Workspace admin
+// is configured to look up the domain's users as
+func initDelegation() error {
+	if config.ServiceAccountFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(config.ServiceAccountFile)
+	if err != nil {
+		return err
+	}
+	scopes := append([]string{}, oauthConfig.Scopes...)
+	if config.WorkspaceAdmin != "" {
+		scopes = append(scopes, admin.AdminDirectoryUserReadonlyScope)
+	}
+	jwtConfig, err := google.JWTConfigFromJSON(data, scopes...)
+	if err != nil {
+		return fmt.Errorf("invalid service account key: %w", err)
+	}
+	var key struct {
+		ClientID string `json:"client_id"`
+	}
+	json.Unmarshal(data, &key)
+
+	delegation = &Delegation{
+		ServiceAccount: jwtConfig.Email,
+		ClientID:       key.ClientID,
+		Scopes:         jwtConfig.Scopes,
+		jwt:            jwtConfig,
+		sources:        make(map[string]oauth2.TokenSource),
+	}
+	return nil
+}
+
+// tokenSource returns the cached, self-refreshing token source acting as subject
+func (d *Delegation) tokenSource(subject string) oauth2.TokenSource {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if source, ok := d.sources[subject]; ok {
+		return source
+	}
+	impersonating := *d.jwt
+	impersonating.Subject = subject
+	source := impersonating.TokenSource(context.Background())
+	d.sources[subject] = source
+	return source
+}
+
+// DelegatedToken returns a token acting as a user of the domain through domain-wide
+// delegation. Fetching it checks that the service account may impersonate them. API
+// calls made with it keep working past its expiry, since they get fresh tokens from the
+// service account rather than refreshing this one.
+func DelegatedToken(subject string) (*oauth2.Token, error) {
+	if delegation == nil {
+		return nil, fmt.Errorf("domain-wide delegation is not configured (SERVICE_ACCOUNT_FILE not set)")
+	}
+	subject = strings.ToLower(strings.TrimSpace(subject))
+	if _, err := mail.ParseAddress(subject); err != nil || strings.ContainsAny(subject, "<> ") {
+		return nil, fmt.Errorf("%q is not an email address", subject)
+	}
+
+	token, err := delegation.tokenSource(subject).Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to impersonate %s: %w", subject, err)
+	}
+	return token.WithExtra(map[string]interface{}{delegatedSubjectKey: subject}), nil
+}
+
+// delegatedSubject returns the user a delegated token acts as, or "" for a user's own
+// OAuth token
+func delegatedSubject(token *oauth2.Token) string {
+	subject, _ := token.Extra(delegatedSubjectKey).(string)
+	return subject
+}
+
+// mailboxDelegateToken returns a delegated token acting as the mailbox mb names, after
+// checking in the mailbox's settings that the signed-in user is an accepted delegate of
+// it. It fails with errNotDelegate if they aren't.
+func mailboxDelegateToken(mb *mailbox) (*oauth2.Token, error) {
+	if isIMAPToken(mb.token) || isDemoToken(mb.token) {
+		return nil, fmt.Errorf("%w: acting on another user's mailbox", ErrNotSupported)
+	}
+	token, err := DelegatedToken(mb.user)
+	if err != nil {
+		return nil, err
+	}
+
+	key := mb.actorID + "/" + mb.user
+	mailboxDelegatesMu.Lock()
+	confirmed, ok := mailboxDelegates[key]
+	mailboxDelegatesMu.Unlock()
+	if ok && time.Since(confirmed) < mailboxDelegateTTL {
+		return token, nil
+	}
+
+	actor, err := mb.actor()
+	if err != nil {
+		return nil, err
+	}
+	if err := mb.quota.Wait(mb.context(), costDelegatesGet); err != nil {
+		return nil, err
+	}
+	service, err := gmail.NewService(mb.context(), option.WithHTTPClient(googleClient(mb.context(), token)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gmail service: %w", err)
+	}
+	delegate, err := service.Users.Settings.Delegates.Get(mb.user, actor).Context(mb.context()).Do()
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+		return nil, errNotDelegate
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check mailbox delegation: %w", err)
+	}
+	if delegate.VerificationStatus != "accepted" {
+		return nil, errNotDelegate
+	}
+
+	mailboxDelegatesMu.Lock()
+	mailboxDelegates[key] = time.Now()
+	mailboxDelegatesMu.Unlock()
+	return token, nil
+}
+
+// impersonatedToken returns the delegated token of the user named by an admin request's
+// X-Impersonate header, or nil if the request has none
+func impersonatedToken(r *http.Request) (*oauth2.Token, error) {
+	subject := r.Header.Get(impersonateHeader)
+	if subject == "" {
+		return nil, nil
+	}
+	if !isAdminRequest(r) {
+		return nil, fmt.Errorf("the %s header needs a valid X-Admin-Token", impersonateHeader)
+	}
+	return DelegatedToken(subject)
+}
+
+// HandleGetDelegation describes the service account of domain-wide delegation mode,
+// with the client ID and scopes to authorize for it in the Workspace admin console
+func HandleGetDelegation(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if delegation == nil {
+		writeError(w, "Domain-wide delegation is not configured (SERVICE_ACCOUNT_FILE not set)", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, delegation)
+}
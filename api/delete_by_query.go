@@ -0,0 +1,35 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// queryRequest is the body of the endpoints acting on a Gmail search query
+type queryRequest struct {
+	// Gmail search query, e.g. "from:foo older_than:2y has:attachment"
+	Query string `json:"query"`
+}
+
+// HandleDeleteByQuery previews trashing every message matching a Gmail search query.
+// Confirming the returned operation trashes exactly the messages that were previewed.
+func HandleDeleteByQuery(w http.ResponseWriter, r *http.Request) {
+	var req queryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if req.Query == "" {
+		writeError(w, "A search query is required", http.StatusBadRequest)
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	handleOperationPreview(w, r, mb, "delete-by-query", req.Query, func() ([]string, error) {
+		return listMessageIDs(mb, req.Query)
+	}, trashOperation)
+}
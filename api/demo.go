@@ -0,0 +1,672 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+	"github.com/emersion/go-imap"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+)
+
+// Token type of the tokens handed out in demo mode. Each one gets its own copy of the
+// synthetic mailbox, so visitors can clean it up without affecting each other.
+const demoTokenType = "demo"
+
+// Labels every demo mailbox has, besides the user labels created in it
+var demoSystemLabels = []string{
+	"INBOX", "SENT", "DRAFT", "SPAM", "TRASH", "UNREAD", "STARRED", "IMPORTANT",
+	"CATEGORY_PERSONAL", "CATEGORY_SOCIAL", "CATEGORY_PROMOTIONS", "CATEGORY_UPDATES", "CATEGORY_FORUMS",
+}
+
+var (
+	// Maps the access tokens of demo tokens to their mailboxes. Mailboxes are created on
+	// first use, so a token keeps working across restarts with a fresh mailbox.
+	demoMailboxes   = make(map[string]*DemoProvider)
+	demoMailboxesMu sync.Mutex
+)
+
+// isDemoToken reports whether a token was handed out in demo mode
+func isDemoToken(token *oauth2.Token) bool {
+	return token.TokenType == demoTokenType
+}
+
+// demoAddress returns the address of a demo token's account. Every visitor gets their
+// own, since the address keys everything the server keeps for an account; it is derived
+// from a hash so the token never appears where the address is shown.
+func demoAddress(token *oauth2.Token) string {
+	sum := sha256.Sum256([]byte(token.AccessToken))
+	return "demo-" + hex.EncodeToString(sum[:8]) + "@demo.example"
+}
+
+// newDemoToken returns a token for a new demo mailbox
+func newDemoToken() *oauth2.Token {
+	return &oauth2.Token{AccessToken: "demo-" + newID(), TokenType: demoTokenType}
+}
+
+// DemoProvider is a MailProvider serving a synthetic mailbox from memory, for trying
+// the app without a Google account
+type DemoProvider struct {
+	// Address of the demo account
+	address string
+	// Messages, newest first
+	messages []*demoMessage
+	byID     map[string]*demoMessage
+	// User labels by ID, and filters created
+	labels  map[string]*gmail.Label
+	filters []*gmail.Filter
+	nextID  int
+	mu      sync.Mutex
+}
+
+// demoProvider returns the mailbox of a demo token, generating it on first use
+func demoProvider(token *oauth2.Token) (*DemoProvider, error) {
+	if !config.DemoMode {
+		return nil, fmt.Errorf("demo mode is off")
+	}
+	demoMailboxesMu.Lock()
+	defer demoMailboxesMu.Unlock()
+	if provider, ok := demoMailboxes[token.AccessToken]; ok {
+		return provider, nil
+	}
+
+	address := demoAddress(token)
+	provider := &DemoProvider{
+		address:  address,
+		messages: generateDemoMessages(time.Now(), address),
+		byID:     make(map[string]*demoMessage),
+		labels:   make(map[string]*gmail.Label),
+	}
+	for _, msg := range provider.messages {
+		provider.byID[msg.id] = msg
+	}
+	demoMailboxes[token.AccessToken] = provider
+	return provider, nil
+}
+
+// checkUser checks a call acts on the demo account's own mailbox
+func (d *DemoProvider) checkUser(user string) error {
+	if user != "me" && user != d.address {
+		return fmt.Errorf("%w: acting on another user's mailbox", ErrNotSupported)
+	}
+	return nil
+}
+
+// newIDLocked returns an ID for a new message, label or filter
+func (d *DemoProvider) newIDLocked(prefix string) string {
+	d.nextID++
+	return prefix + strconv.Itoa(d.nextID)
+}
+
+// message returns a message by ID, or a Gmail-like not found error
+func (d *DemoProvider) message(id string) (*demoMessage, error) {
+	msg, ok := d.byID[id]
+	if !ok {
+		return nil, imapNotFound("message", id)
+	}
+	return msg, nil
+}
+
+// sizeEstimate approximates a message's raw size like Gmail's estimate
+func (m *demoMessage) sizeEstimate() int64 {
+	size := len(m.text) + m.htmlSize + 500
+	for _, h := range m.headers {
+		size += len(h.Name) + len(h.Value) + 4
+	}
+	if m.attachment != nil {
+		size += m.attachment.size * 4 / 3
+	}
+	return int64(size)
+}
+
+// html returns the HTML alternative of a message, padded to its size
+func (m *demoMessage) html() []byte {
+	var html bytes.Buffer
+	html.WriteString("<html><body><p>" + m.text + "</p>")
+	for html.Len() < m.htmlSize-len("</body></html>") {
+		html.WriteString("<div class=\"spacer\"></div>\n")
+	}
+	html.WriteString("</body></html>")
+	return html.Bytes()
+}
+
+// attachmentData returns the content of a message's attachment, the same on every call
+func (m *demoMessage) attachmentData() []byte {
+	seed, _ := strconv.ParseInt(m.id, 16, 64)
+	data := make([]byte, m.attachment.size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// payload builds a message's MIME structure in Gmail's format. Bodies are inlined,
+// except for the attachment, which GetAttachment serves.
+func (m *demoMessage) payload() *gmail.MessagePart {
+	text := &gmail.MessagePart{MimeType: "text/plain", Headers: []*gmail.MessagePartHeader{{Name: "Content-Type", Value: "text/plain; charset=UTF-8"}},
+		Body: &gmail.MessagePartBody{Size: int64(len(m.text)), Data: base64.URLEncoding.EncodeToString([]byte(m.text))}}
+	body := text
+	if m.htmlSize > 0 {
+		html := m.html()
+		body = &gmail.MessagePart{MimeType: "multipart/alternative", Body: &gmail.MessagePartBody{}, Parts: []*gmail.MessagePart{
+			text,
+			{MimeType: "text/html", Headers: []*gmail.MessagePartHeader{{Name: "Content-Type", Value: "text/html; charset=UTF-8"}},
+				Body: &gmail.MessagePartBody{Size: int64(len(html)), Data: base64.URLEncoding.EncodeToString(html)}},
+		}}
+	}
+	if m.attachment == nil {
+		body.Headers = append(slices.Clone(m.headers), body.Headers...)
+		return body
+	}
+
+	return &gmail.MessagePart{MimeType: "multipart/mixed", Headers: m.headers, Body: &gmail.MessagePartBody{}, Parts: []*gmail.MessagePart{
+		body,
+		{
+			MimeType: m.attachment.mimeType,
+			Filename: m.attachment.filename,
+			Headers: []*gmail.MessagePartHeader{
+				{Name: "Content-Type", Value: m.attachment.mimeType + "; name=\"" + m.attachment.filename + "\""},
+				{Name: "Content-Disposition", Value: "attachment; filename=\"" + m.attachment.filename + "\""},
+				{Name: "Content-Transfer-Encoding", Value: "base64"},
+			},
+			Body: &gmail.MessagePartBody{AttachmentId: "att-" + m.id, Size: int64(m.attachment.size)},
+		},
+	}}
+}
+
+// raw renders a message as RFC 822
+func (m *demoMessage) raw() []byte {
+	var raw bytes.Buffer
+	for _, h := range m.headers {
+		raw.WriteString(h.Name + ": " + h.Value + "\r\n")
+	}
+	if m.htmlSize == 0 && m.attachment == nil {
+		raw.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n" + m.text + "\r\n")
+		return raw.Bytes()
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if m.htmlSize > 0 {
+		var alternative bytes.Buffer
+		inner := multipart.NewWriter(&alternative)
+		part, _ := inner.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+		part.Write([]byte(m.text))
+		part, _ = inner.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/html; charset=UTF-8"}})
+		part.Write(m.html())
+		inner.Close()
+		if m.attachment == nil {
+			raw.WriteString("Content-Type: multipart/alternative; boundary=" + inner.Boundary() + "\r\n\r\n")
+			raw.Write(alternative.Bytes())
+			return raw.Bytes()
+		}
+		part, _ = writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + inner.Boundary()}})
+		part.Write(alternative.Bytes())
+	} else {
+		part, _ := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+		part.Write([]byte(m.text))
+	}
+	part, _ := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {m.attachment.mimeType + "; name=\"" + m.attachment.filename + "\""},
+		"Content-Disposition":       {"attachment; filename=\"" + m.attachment.filename + "\""},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	encoded := base64.StdEncoding.EncodeToString(m.attachmentData())
+	for len(encoded) > 76 {
+		part.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	part.Write([]byte(encoded + "\r\n"))
+	writer.Close()
+
+	raw.WriteString("Content-Type: multipart/mixed; boundary=" + writer.Boundary() + "\r\n\r\n")
+	raw.Write(body.Bytes())
+	return raw.Bytes()
+}
+
+// gmailMessage returns a message in a Gmail format, only with the wanted headers if the
+// format is metadata
+func (m *demoMessage) gmailMessage(format string, wanted ...string) *gmail.Message {
+	msg := &gmail.Message{
+		Id:           m.id,
+		ThreadId:     m.threadID,
+		LabelIds:     slices.Clone(m.labels),
+		SizeEstimate: m.sizeEstimate(),
+		InternalDate: m.date.UnixMilli(),
+		Snippet:      m.text[:min(len(m.text), snippetLength)],
+	}
+	switch format {
+	case "metadata":
+		headers := m.headers
+		if len(wanted) > 0 {
+			headers = slices.DeleteFunc(slices.Clone(headers), func(h *gmail.MessagePartHeader) bool {
+				return !slices.ContainsFunc(wanted, func(name string) bool { return strings.EqualFold(name, h.Name) })
+			})
+		}
+		msg.Payload = &gmail.MessagePart{Headers: headers}
+	case "full":
+		msg.Payload = m.payload()
+	case "raw":
+		msg.Raw = base64.URLEncoding.EncodeToString(m.raw())
+	}
+	return msg
+}
+
+// matches reports whether a message matches an IMAP search, with flags standing for the
+// labels they map onto
+func (m *demoMessage) matches(c *imap.SearchCriteria) bool {
+	for name, values := range c.Header {
+		for _, value := range values {
+			if !strings.Contains(strings.ToLower(headerValue(m.headers, name)), strings.ToLower(value)) {
+				return false
+			}
+		}
+	}
+	for _, text := range c.Text {
+		text = strings.ToLower(text)
+		found := strings.Contains(strings.ToLower(m.text), text)
+		for _, h := range m.headers {
+			found = found || strings.Contains(strings.ToLower(h.Value), text)
+		}
+		if !found {
+			return false
+		}
+	}
+	if (!c.Since.IsZero() && m.date.Before(c.Since)) || (!c.Before.IsZero() && !m.date.Before(c.Before)) {
+		return false
+	}
+	if (c.Larger > 0 && m.sizeEstimate() <= int64(c.Larger)) || (c.Smaller > 0 && m.sizeEstimate() >= int64(c.Smaller)) {
+		return false
+	}
+	hasFlag := func(flag string) bool {
+		switch flag {
+		case imap.SeenFlag:
+			return !slices.Contains(m.labels, "UNREAD")
+		case imap.FlaggedFlag:
+			return slices.Contains(m.labels, "STARRED")
+		case imap.DraftFlag:
+			return slices.Contains(m.labels, "DRAFT")
+		}
+		return false
+	}
+	for _, flag := range c.WithFlags {
+		if !hasFlag(flag) {
+			return false
+		}
+	}
+	for _, flag := range c.WithoutFlags {
+		if hasFlag(flag) {
+			return false
+		}
+	}
+	for _, not := range c.Not {
+		if m.matches(not) {
+			return false
+		}
+	}
+	for _, or := range c.Or {
+		if !m.matches(or[0]) && !m.matches(or[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// labelID resolves a label given by ID or by name, as in: and label: terms give it
+func (d *DemoProvider) labelID(label string) string {
+	for id, l := range d.labels {
+		if strings.EqualFold(l.Name, label) {
+			return id
+		}
+	}
+	if upper := strings.ToUpper(label); slices.Contains(demoSystemLabels, upper) {
+		return upper
+	}
+	return label
+}
+
+func (d *DemoProvider) Profile(ctx context.Context, user string) (*gmail.Profile, error) {
+	if err := d.checkUser(user); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	threads := make(map[string]struct{})
+	for _, msg := range d.messages {
+		threads[msg.threadID] = struct{}{}
+	}
+	return &gmail.Profile{EmailAddress: d.address, MessagesTotal: int64(len(d.messages)), ThreadsTotal: int64(len(threads))}, nil
+}
+
+// ListMessages lists messages newest first. The query supports what IMAP searches do,
+// plus category: and has:attachment terms.
+func (d *DemoProvider) ListMessages(ctx context.Context, user string, query MessageQuery) (*gmail.ListMessagesResponse, error) {
+	if err := d.checkUser(user); err != nil {
+		return nil, err
+	}
+	terms := make([]string, 0)
+	labels := slices.Clone(query.LabelIDs)
+	hasAttachment := false
+	for _, term := range searchTerms(query.Query) {
+		if strings.EqualFold(term, "has:attachment") {
+			hasAttachment = true
+			continue
+		}
+		if category, ok := strings.CutPrefix(strings.ToLower(term), "category:"); ok {
+			labels = append(labels, "CATEGORY_"+strings.ToUpper(category))
+			continue
+		}
+		terms = append(terms, term)
+	}
+	search, err := parseIMAPSearch(strings.Join(terms, " "))
+	if err != nil {
+		return nil, err
+	}
+	offset := 0
+	if query.PageToken != "" {
+		if offset, err = strconv.Atoi(query.PageToken); err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid pageToken")
+		}
+	}
+	pageSize := int(query.MaxResults)
+	if pageSize <= 0 || pageSize > maxEmailsPageSize {
+		pageSize = 100
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, label := range search.labelIDs {
+		labels = append(labels, d.labelID(label))
+	}
+	resp := &gmail.ListMessagesResponse{Messages: make([]*gmail.Message, 0)}
+	matched := 0
+	for _, msg := range d.messages {
+		if !query.IncludeSpamTrash && deepclean.JunkLabel(msg.labels) != "" && deepclean.JunkLabel(labels) == "" {
+			continue
+		}
+		if slices.ContainsFunc(labels, func(label string) bool { return !slices.Contains(msg.labels, label) }) || !msg.matches(search.criteria) ||
+			(hasAttachment && msg.attachment == nil) {
+			continue
+		}
+		matched++
+		if matched <= offset {
+			continue
+		}
+		if len(resp.Messages) == pageSize {
+			resp.NextPageToken = strconv.Itoa(offset + pageSize)
+			break
+		}
+		resp.Messages = append(resp.Messages, &gmail.Message{Id: msg.id, ThreadId: msg.threadID})
+	}
+	resp.ResultSizeEstimate = int64(len(resp.Messages))
+	return resp, nil
+}
+
+func (d *DemoProvider) GetMessage(ctx context.Context, user, id, format string, headers ...string) (*gmail.Message, error) {
+	if err := d.checkUser(user); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	msg, err := d.message(id)
+	if err != nil {
+		return nil, err
+	}
+	return msg.gmailMessage(format, headers...), nil
+}
+
+func (d *DemoProvider) GetAttachment(ctx context.Context, user, messageID, attachmentID string) (*gmail.MessagePartBody, error) {
+	if err := d.checkUser(user); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	msg, err := d.message(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.attachment == nil || attachmentID != "att-"+msg.id {
+		return nil, imapNotFound("attachment", attachmentID)
+	}
+	data := msg.attachmentData()
+	return &gmail.MessagePartBody{AttachmentId: attachmentID, Size: int64(len(data)), Data: base64.URLEncoding.EncodeToString(data)}, nil
+}
+
+// addRawLocked adds a raw message with the given labels, dated by its Date header
+func (d *DemoProvider) addRawLocked(raw []byte, labels []string) *gmail.Message {
+	headers, body := splitMessage(raw)
+	msg := &demoMessage{
+		id:      d.newIDLocked("demo"),
+		labels:  slices.Clone(labels),
+		date:    time.Now().Truncate(time.Second),
+		headers: headers,
+		text:    strings.Join(strings.Fields(string(body)), " "),
+	}
+	msg.threadID = msg.id
+	if date, err := parseDemoDate(headerValue(headers, "Date")); err == nil {
+		msg.date = date
+	}
+
+	i, _ := slices.BinarySearchFunc(d.messages, msg, func(a, b *demoMessage) int { return b.date.Compare(a.date) })
+	d.messages = slices.Insert(d.messages, i, msg)
+	d.byID[msg.id] = msg
+	return msg.gmailMessage("minimal")
+}
+
+// parseDemoDate parses a Date header
+func parseDemoDate(value string) (time.Time, error) {
+	return time.Parse(time.RFC1123Z, value)
+}
+
+func (d *DemoProvider) InsertMessage(ctx context.Context, user string, msg *gmail.Message) (*gmail.Message, error) {
+	if err := d.checkUser(user); err != nil {
+		return nil, err
+	}
+	raw, err := decodeBase64URL(msg.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw message: %w", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.addRawLocked(raw, msg.LabelIds), nil
+}
+
+func (d *DemoProvider) ImportMessage(ctx context.Context, user string, raw io.Reader) (*gmail.Message, error) {
+	if err := d.checkUser(user); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(raw)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.addRawLocked(data, []string{"INBOX", "UNREAD"}), nil
+}
+
+// SendMessage files the message under Sent without delivering it anywhere
+func (d *DemoProvider) SendMessage(ctx context.Context, user string, msg *gmail.Message) error {
+	if err := d.checkUser(user); err != nil {
+		return err
+	}
+	raw, err := decodeBase64URL(msg.Raw)
+	if err != nil {
+		return fmt.Errorf("invalid raw message: %w", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.addRawLocked(raw, []string{"SENT"})
+	return nil
+}
+
+// modifyLocked changes the labels of a message
+func (d *DemoProvider) modifyLocked(msg *demoMessage, addLabelIDs, removeLabelIDs []string) error {
+	for _, label := range addLabelIDs {
+		if !slices.Contains(demoSystemLabels, label) && d.labels[label] == nil {
+			return imapNotFound("label", label)
+		}
+	}
+	msg.labels = slices.DeleteFunc(msg.labels, func(label string) bool { return slices.Contains(removeLabelIDs, label) })
+	for _, label := range addLabelIDs {
+		if !slices.Contains(msg.labels, label) {
+			msg.labels = append(msg.labels, label)
+		}
+	}
+	return nil
+}
+
+func (d *DemoProvider) ModifyMessage(ctx context.Context, user, id string, addLabelIDs, removeLabelIDs []string) error {
+	return d.BatchModify(ctx, user, []string{id}, addLabelIDs, removeLabelIDs)
+}
+
+func (d *DemoProvider) BatchModify(ctx context.Context, user string, ids, addLabelIDs, removeLabelIDs []string) error {
+	if err := d.checkUser(user); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range ids {
+		msg, err := d.message(id)
+		if err != nil {
+			return err
+		}
+		if err := d.modifyLocked(msg, addLabelIDs, removeLabelIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DemoProvider) Trash(ctx context.Context, user, id string) error {
+	return d.ModifyMessage(ctx, user, id, []string{"TRASH"}, nil)
+}
+
+func (d *DemoProvider) Untrash(ctx context.Context, user, id string) error {
+	return d.ModifyMessage(ctx, user, id, nil, []string{"TRASH"})
+}
+
+func (d *DemoProvider) BatchDelete(ctx context.Context, user string, ids []string) error {
+	if err := d.checkUser(user); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range ids {
+		delete(d.byID, id)
+	}
+	d.messages = slices.DeleteFunc(d.messages, func(msg *demoMessage) bool { return d.byID[msg.id] == nil })
+	return nil
+}
+
+// threadLocked returns the messages of a thread, oldest first
+func (d *DemoProvider) threadLocked(id string) ([]*demoMessage, error) {
+	messages := make([]*demoMessage, 0)
+	for i := len(d.messages) - 1; i >= 0; i-- {
+		if d.messages[i].threadID == id {
+			messages = append(messages, d.messages[i])
+		}
+	}
+	if len(messages) == 0 {
+		return nil, imapNotFound("thread", id)
+	}
+	return messages, nil
+}
+
+func (d *DemoProvider) GetThread(ctx context.Context, user, id, format string, headers ...string) (*gmail.Thread, error) {
+	if err := d.checkUser(user); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	messages, err := d.threadLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	thread := &gmail.Thread{Id: id}
+	for _, msg := range messages {
+		thread.Messages = append(thread.Messages, msg.gmailMessage(format, headers...))
+	}
+	return thread, nil
+}
+
+func (d *DemoProvider) ModifyThread(ctx context.Context, user, id string, addLabelIDs, removeLabelIDs []string) error {
+	if err := d.checkUser(user); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	messages, err := d.threadLocked(id)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if err := d.modifyLocked(msg, addLabelIDs, removeLabelIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DemoProvider) Labels(ctx context.Context, user string) ([]*gmail.Label, error) {
+	if err := d.checkUser(user); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	labels := make([]*gmail.Label, 0, len(demoSystemLabels)+len(d.labels))
+	for _, id := range demoSystemLabels {
+		labels = append(labels, &gmail.Label{Id: id, Name: id, Type: "system"})
+	}
+	for _, label := range d.labels {
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+func (d *DemoProvider) CreateLabel(ctx context.Context, user string, label *gmail.Label) (*gmail.Label, error) {
+	if err := d.checkUser(user); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	created := &gmail.Label{Id: d.newIDLocked("Label_"), Name: label.Name, Type: "user"}
+	d.labels[created.Id] = created
+	return created, nil
+}
+
+// CreateFilter records the filter; it applies to nothing, since no new mail arrives
+func (d *DemoProvider) CreateFilter(ctx context.Context, user string, filter *gmail.Filter) (*gmail.Filter, error) {
+	if err := d.checkUser(user); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	created := *filter
+	created.Id = d.newIDLocked("filter")
+	d.filters = append(d.filters, &created)
+	return &created, nil
+}
+
+func (d *DemoProvider) ListDrafts(ctx context.Context, user, pageToken string, maxResults int64) (*gmail.ListDraftsResponse, error) {
+	resp, err := d.ListMessages(ctx, user, MessageQuery{LabelIDs: []string{"DRAFT"}, PageToken: pageToken, MaxResults: maxResults})
+	if err != nil {
+		return nil, err
+	}
+	drafts := &gmail.ListDraftsResponse{NextPageToken: resp.NextPageToken, ResultSizeEstimate: resp.ResultSizeEstimate}
+	for _, msg := range resp.Messages {
+		drafts.Drafts = append(drafts.Drafts, &gmail.Draft{Id: "r" + msg.Id, Message: msg})
+	}
+	return drafts, nil
+}
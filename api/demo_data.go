@@ -0,0 +1,309 @@
+package api
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Seed of the synthetic mailbox, fixed so every demo shows the same inbox
+const demoSeed = 1
+
+// demoKind is a kind of sender in the synthetic mailbox, with how their mail looks
+type demoKind struct {
+	// Gmail category label of their mail
+	category string
+	// Share of senders of this kind
+	weight float64
+	// Local parts of their addresses; people get their own name instead
+	locals []string
+	// Subjects, with %s standing for the sender's name
+	subjects []string
+	// Whether their mail comes from a mailing list with unsubscribe links
+	list bool
+	// Chance that one of their messages has been read
+	readRate float64
+	// Range of the size of the HTML part of their mail, 0 for plain text only
+	html [2]int
+	// Chance that one of their messages carries an attachment, relative to the
+	// configured ratio
+	attachments float64
+}
+
+var demoKinds = []demoKind{
+	{
+		category: "CATEGORY_PROMOTIONS", weight: 0.3, locals: []string{"deals", "offers", "shop", "hello"},
+		subjects: []string{"%s: 30%% off everything this weekend", "Your exclusive %s coupon inside", "Last chance: %s summer sale ends tonight", "New arrivals at %s", "%s picked these just for you"},
+		list:     true, readRate: 0.15, html: [2]int{20000, 90000}, attachments: 0,
+	},
+	{
+		category: "CATEGORY_UPDATES", weight: 0.25, locals: []string{"newsletter", "digest", "news", "weekly"},
+		subjects: []string{"The %s Weekly", "%s digest: what you missed", "This month at %s", "%s news roundup"},
+		list:     true, readRate: 0.35, html: [2]int{15000, 60000}, attachments: 0.2,
+	},
+	{
+		category: "CATEGORY_UPDATES", weight: 0.15, locals: []string{"no-reply", "orders", "billing", "receipts"},
+		subjects: []string{"Your %s receipt", "Your %s order has shipped", "%s payment confirmation", "Your %s statement is ready", "Verify your %s sign-in"},
+		readRate: 0.7, html: [2]int{8000, 25000}, attachments: 3,
+	},
+	{
+		category: "CATEGORY_SOCIAL", weight: 0.1, locals: []string{"notifications", "notify", "updates"},
+		subjects: []string{"You have 3 new notifications on %s", "Someone mentioned you on %s", "New followers on %s", "%s: see what your friends are up to"},
+		list:     true, readRate: 0.2, html: [2]int{10000, 30000}, attachments: 0,
+	},
+	{
+		category: "CATEGORY_PERSONAL", weight: 0.2,
+		subjects: []string{"Lunch next week?", "Photos from the weekend", "Quick question", "Notes from today", "Plans for %s", "Catching up"},
+		readRate: 0.95, attachments: 2,
+	},
+}
+
+var (
+	demoBrands = []string{
+		"Acme", "Globex", "Initech", "Umbrella", "Hooli", "Vandelay", "Stark", "Wayne", "Wonka", "Soylent",
+		"Cyberdyne", "Tyrell", "Gringotts", "Oscorp", "Pied Piper", "Dunder Mifflin", "Monarch", "Aperture",
+		"Black Mesa", "Massive Dynamic", "Nakatomi", "Prestige", "Sterling Cooper", "Blue Sun", "Virtucon",
+		"Wernham Hogg", "Bluth", "Krusty", "Duff", "Gekko", "Octan", "Spacely", "Cogswell", "Rekall",
+	}
+	demoFirstNames = []string{
+		"Alex", "Sam", "Jordan", "Taylor", "Morgan", "Casey", "Riley", "Jamie", "Avery", "Quinn",
+		"Robin", "Drew", "Jesse", "Skyler", "Charlie", "Emerson", "Finley", "Harper", "Kai", "Rowan",
+	}
+	demoLastNames = []string{
+		"Nguyen", "Garcia", "Smith", "Okafor", "Kowalski", "Tanaka", "Silva", "Müller", "Haddad", "Ivanova",
+		"Rossi", "Dubois", "Patel", "Kim", "Johansson", "O'Brien", "Mensah", "Novak", "Costa", "Larsen",
+	}
+	demoWords = strings.Fields("the quick update about our plans for next week and a few thoughts on " +
+		"what comes after that project meeting schedule review notes ideas budget summary team travel " +
+		"weekend dinner photos family garden book movie concert trip order delivery account")
+	demoAttachmentNames = []string{"invoice-%d.pdf", "statement-%d.pdf", "IMG_%d.jpg", "report-%d.xlsx", "slides-%d.pptx", "scan-%d.pdf"}
+)
+
+// demoSender is one sender of the synthetic mailbox
+type demoSender struct {
+	name    string
+	address string
+	kind    *demoKind
+}
+
+// demoAttachment describes the attachment of a synthetic message, whose content is
+// only generated when fetched
+type demoAttachment struct {
+	filename string
+	mimeType string
+	size     int
+}
+
+// demoMessage is a message of the synthetic mailbox
+type demoMessage struct {
+	id       string
+	threadID string
+	labels   []string
+	date     time.Time
+	headers  []*gmail.MessagePartHeader
+	text     string
+	// Size of the HTML alternative, generated when fetched; 0 for plain text only
+	htmlSize   int
+	attachment *demoAttachment
+}
+
+// generateDemoMessages builds the synthetic mailbox of address described by the config,
+// newest message first. Senders' message counts follow a Zipf distribution, so a few
+// senders dominate like in a real inbox.
+func generateDemoMessages(now time.Time, address string) []*demoMessage {
+	rng := rand.New(rand.NewSource(demoSeed))
+	newID := func() string { return fmt.Sprintf("%016x", rng.Uint64()>>4) }
+	pick := func(options []string) string { return options[rng.Intn(len(options))] }
+
+	senders := make([]*demoSender, config.DemoSenders)
+	for i := range senders {
+		kind := &demoKinds[len(demoKinds)-1]
+		r := rng.Float64()
+		for k := range demoKinds {
+			if r < demoKinds[k].weight {
+				kind = &demoKinds[k]
+				break
+			}
+			r -= demoKinds[k].weight
+		}
+		sender := &demoSender{kind: kind}
+		if kind.locals == nil {
+			first, last := pick(demoFirstNames), pick(demoLastNames)
+			sender.name = first + " " + last
+			sender.address = fmt.Sprintf("%s.%s%d@people.example", strings.ToLower(first), strings.ToLower(strings.ReplaceAll(last, "'", "")), i)
+		} else {
+			brand := pick(demoBrands)
+			sender.name = brand
+			sender.address = fmt.Sprintf("%s@%s%d.example", pick(kind.locals), strings.ToLower(strings.ReplaceAll(brand, " ", "")), i)
+		}
+		senders[i] = sender
+	}
+
+	zipf := rand.NewZipf(rng, config.DemoSenderSkew, 1, uint64(len(senders)-1))
+	lastThread := make(map[*demoSender]*demoMessage)
+	messages := make([]*demoMessage, 0, config.DemoMessages)
+	for len(messages) < config.DemoMessages {
+		sender := senders[zipf.Uint64()]
+		kind := sender.kind
+		// Mail gets denser towards the present, over about three years
+		age := time.Duration(rng.Float64() * rng.Float64() * float64(3*365*24*time.Hour))
+
+		msg := &demoMessage{
+			id:   newID(),
+			date: now.Add(-age).Truncate(time.Second),
+			text: demoText(rng, 2+rng.Intn(6)),
+		}
+		msg.threadID = msg.id
+
+		subject := pick(kind.subjects)
+		if strings.Contains(subject, "%s") {
+			subject = fmt.Sprintf(subject, sender.name)
+		}
+		from, to := fmt.Sprintf("%s <%s>", sender.name, sender.address), address
+		labels := []string{kind.category}
+
+		if kind.locals == nil {
+			// People's mail comes in threads, some of them replied to
+			if prev, ok := lastThread[sender]; ok && rng.Float64() < 0.4 {
+				msg.threadID = prev.threadID
+				subject = "Re: " + strings.TrimPrefix(headerValue(prev.headers, "Subject"), "Re: ")
+			}
+			lastThread[sender] = msg
+			if rng.Float64() < 0.25 {
+				from, to = address, fmt.Sprintf("%s <%s>", sender.name, sender.address)
+				labels = []string{"SENT"}
+			} else if rng.Float64() < 0.5 {
+				labels = append(labels, "IMPORTANT")
+			}
+		} else {
+			msg.htmlSize = kind.html[0] + rng.Intn(kind.html[1]-kind.html[0])
+		}
+
+		if labels[0] != "SENT" {
+			recent := age < 30*24*time.Hour
+			if rng.Float64() >= kind.readRate {
+				labels = append(labels, "UNREAD")
+			}
+			if recent || rng.Float64() < 0.7 {
+				labels = append(labels, "INBOX")
+			}
+			if rng.Float64() < 0.02 {
+				labels = append(labels, "STARRED")
+			}
+		}
+		msg.labels = labels
+
+		if rng.Float64() < config.DemoAttachmentRatio*kind.attachments {
+			name := fmt.Sprintf(pick(demoAttachmentNames), 1000+rng.Intn(9000))
+			msg.attachment = &demoAttachment{
+				filename: name,
+				mimeType: demoMimeType(name),
+				// Mostly small documents, now and then a multi-megabyte file
+				size: 20000 + int(rng.ExpFloat64()*400000),
+			}
+		}
+
+		msg.headers = demoHeaders(msg, from, to, subject, sender, kind)
+		messages = append(messages, msg)
+	}
+
+	// A bit of junk, and a few drafts never sent
+	for i := 0; i < config.DemoMessages/50; i++ {
+		brand := pick(demoBrands)
+		msg := &demoMessage{
+			id:     newID(),
+			date:   now.Add(-time.Duration(rng.Float64() * float64(30*24*time.Hour))).Truncate(time.Second),
+			text:   demoText(rng, 3),
+			labels: []string{"SPAM", "UNREAD"},
+		}
+		msg.threadID = msg.id
+		msg.headers = demoHeaders(msg, fmt.Sprintf("Prize Desk <winner@%s-prizes.example>", strings.ToLower(strings.ReplaceAll(brand, " ", ""))),
+			address, "You have been selected!!!", nil, nil)
+		messages = append(messages, msg)
+	}
+	for i := 0; i < 5; i++ {
+		msg := &demoMessage{
+			id:     newID(),
+			date:   now.Add(-time.Duration(rng.Float64() * float64(365*24*time.Hour))).Truncate(time.Second),
+			text:   demoText(rng, 2),
+			labels: []string{"DRAFT"},
+		}
+		msg.threadID = msg.id
+		msg.headers = demoHeaders(msg, address, "", pick(demoKinds[len(demoKinds)-1].subjects), nil, nil)
+		messages = append(messages, msg)
+	}
+
+	sort.Slice(messages, func(i, j int) bool { return messages[i].date.After(messages[j].date) })
+	return messages
+}
+
+// demoHeaders returns the headers of a synthetic message, with list headers for list
+// mail
+func demoHeaders(msg *demoMessage, from, to, subject string, sender *demoSender, kind *demoKind) []*gmail.MessagePartHeader {
+	headers := []*gmail.MessagePartHeader{
+		{Name: "From", Value: from},
+		{Name: "Subject", Value: subject},
+		{Name: "Date", Value: msg.date.Format(time.RFC1123Z)},
+		{Name: "Message-ID", Value: "<" + msg.id + "@demo.example>"},
+	}
+	if to != "" {
+		headers = append(headers, &gmail.MessagePartHeader{Name: "To", Value: to})
+	}
+	if kind != nil && kind.list {
+		domain := sender.address[strings.Index(sender.address, "@")+1:]
+		headers = append(headers,
+			&gmail.MessagePartHeader{Name: "List-Id", Value: fmt.Sprintf("%s <%s.%s>", sender.name, strings.SplitN(sender.address, "@", 2)[0], domain)},
+			&gmail.MessagePartHeader{Name: "List-Unsubscribe", Value: fmt.Sprintf("<https://%s/unsubscribe?u=%s>, <mailto:unsubscribe@%s>", domain, msg.id, domain)},
+			&gmail.MessagePartHeader{Name: "List-Unsubscribe-Post", Value: "List-Unsubscribe=One-Click"},
+			&gmail.MessagePartHeader{Name: "Precedence", Value: "bulk"},
+		)
+	}
+	if sender != nil && strings.HasPrefix(sender.address, "no-reply@") {
+		headers = append(headers, &gmail.MessagePartHeader{Name: "Auto-Submitted", Value: "auto-generated"})
+	}
+	return headers
+}
+
+// demoText returns a few sentences of filler text
+func demoText(rng *rand.Rand, sentences int) string {
+	var text strings.Builder
+	for i := 0; i < sentences; i++ {
+		n := 6 + rng.Intn(10)
+		words := make([]string, n)
+		for j := range words {
+			words[j] = demoWords[rng.Intn(len(demoWords))]
+		}
+		sentence := strings.Join(words, " ")
+		text.WriteString(strings.ToUpper(sentence[:1]) + sentence[1:] + ". ")
+	}
+	return strings.TrimSpace(text.String())
+}
+
+// demoMimeType returns the content type of a synthetic attachment by its extension
+func demoMimeType(filename string) string {
+	switch filename[strings.LastIndex(filename, ".")+1:] {
+	case "pdf":
+		return "application/pdf"
+	case "jpg":
+		return "image/jpeg"
+	case "xlsx":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case "pptx":
+		return "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	}
+	return "application/octet-stream"
+}
+
+// headerValue returns the value of the first header with the given name, or ""
+func headerValue(headers []*gmail.MessagePartHeader, name string) string {
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
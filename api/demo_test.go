@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDemoVisitorsHaveTheirOwnAccount(t *testing.T) {
+	config.DemoMode = true
+	defer func() { config.DemoMode = false }()
+
+	providers := make([]*DemoProvider, 0, 2)
+	for i := 0; i < 2; i++ {
+		token := newDemoToken()
+		t.Cleanup(func() {
+			demoMailboxesMu.Lock()
+			delete(demoMailboxes, token.AccessToken)
+			demoMailboxesMu.Unlock()
+		})
+		provider, err := demoProvider(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		providers = append(providers, provider)
+	}
+
+	profile, err := providers[0].Profile(context.Background(), "me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := providers[1].Profile(context.Background(), "me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.EmailAddress == other.EmailAddress {
+		t.Fatalf("two demo visitors share the account %s", profile.EmailAddress)
+	}
+	if _, err := providers[1].Profile(context.Background(), profile.EmailAddress); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("acting on another visitor's mailbox: %v, want %v", err, ErrNotSupported)
+	}
+}
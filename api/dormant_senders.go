@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// defaultDormantDays is how long a sender must have been quiet to count as dormant
+const defaultDormantDays = 365
+
+// DormantSenders lists senders that haven't sent anything in a while
+type DormantSenders struct {
+	OlderThanDays int `json:"olderThanDays"`
+	// Senders whose latest message is older than this
+	Cutoff  time.Time       `json:"cutoff"`
+	Senders []SenderSummary `json:"senders"`
+	// Totals over every dormant sender, not just the page
+	Total      int   `json:"total"`
+	TotalCount int   `json:"totalCount"`
+	TotalSize  int64 `json:"totalSize"`
+	Offset     int   `json:"offset"`
+	Limit      int   `json:"limit"`
+}
+
+// DormantSenders returns the senders whose latest message is before cutoff, sorted by
+// size, count or lastSeen (longest quiet first). Senders none of whose messages had a
+// date are left out.
+func (p *InboxProcessor) DormantSenders(cutoff time.Time, category, sortBy string) []SenderSummary {
+	dormant := make([]SenderSummary, 0)
+	for _, sender := range p.Senders(category) {
+		if !sender.LastSeen.IsZero() && sender.LastSeen.Before(cutoff) {
+			dormant = append(dormant, sender)
+		}
+	}
+	sort.Slice(dormant, func(i, j int) bool {
+		a, b := &dormant[i], &dormant[j]
+		switch {
+		case sortBy == sendersByCount && a.Count != b.Count:
+			return a.Count > b.Count
+		case sortBy == sendersByLastSeen && !a.LastSeen.Equal(b.LastSeen):
+			return a.LastSeen.Before(b.LastSeen)
+		case sortBy == sendersBySize && a.Size != b.Size:
+			return a.Size > b.Size
+		}
+		return a.Email < b.Email
+	})
+	return dormant
+}
+
+// HandleGetDormantSenders lists senders that haven't sent anything for more than
+// `olderThanDays` days (default 365) with their total size: old newsletters and accounts
+// long closed, which hold plenty of mail without ever reaching the top senders. Sorts
+// by `sortBy` (size, the default, count or lastSeen), pages with `offset` and `limit`
+// and accepts a `category`.
+func HandleGetDormantSenders(w http.ResponseWriter, r *http.Request) {
+	olderThanDays, ok := parseOlderThanDays(r, defaultDormantDays)
+	if !ok {
+		writeError(w, "olderThanDays must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	category, err := parseCategoryFilter(r)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+	offset, limit, sortBy, err := parseSenderPage(r, sendersBySize, sendersByCount, sendersByLastSeen)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -olderThanDays)
+	senders := processor.DormantSenders(cutoff, category, sortBy)
+	result := DormantSenders{
+		OlderThanDays: olderThanDays,
+		Cutoff:        cutoff,
+		Senders:       senders[min(offset, len(senders)):min(offset+limit, len(senders))],
+		Total:         len(senders),
+		Offset:        offset,
+		Limit:         limit,
+	}
+	for _, sender := range senders {
+		result.TotalCount += sender.Count
+		result.TotalSize += sender.Size
+	}
+
+	writeJSON(w, result)
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultDraftAgeDays = 180
+
+// DraftSummary describes one draft in the drafts report
+type DraftSummary struct {
+	DraftID   string    `json:"draftId"`
+	MessageID string    `json:"messageId"`
+	Subject   string    `json:"subject"`
+	To        string    `json:"to"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	AgeDays   int       `json:"ageDays"`
+}
+
+// DraftsReport summarizes the user's drafts and the abandoned ones among them
+type DraftsReport struct {
+	Count          int            `json:"count"`
+	TotalSize      int64          `json:"totalSize"`
+	OlderThanDays  int            `json:"olderThanDays"`
+	AbandonedCount int            `json:"abandonedCount"`
+	AbandonedSize  int64          `json:"abandonedSize"`
+	Abandoned      []DraftSummary `json:"abandoned"`
+}
+
+// listDrafts fetches a summary of every draft in the mailbox
+func listDrafts(mb *mailbox) ([]DraftSummary, error) {
+	drafts := make([]DraftSummary, 0)
+	pageToken := ""
+	now := time.Now()
+
+	for {
+		if err := mb.quota.Wait(context.Background(), costMessagesList); err != nil {
+			return nil, err
+		}
+		resp, err := mb.provider.ListDrafts(mb.context(), mb.user, pageToken, 500)
+		if err != nil {
+			return nil, err
+		}
+
+		var mu sync.Mutex
+		var firstErr error
+		ids := make([]string, 0, len(resp.Drafts))
+		messageIDs := make(map[string]string, len(resp.Drafts))
+		for _, draft := range resp.Drafts {
+			ids = append(ids, draft.Id)
+			messageIDs[draft.Id] = draft.Message.Id
+		}
+
+		// Fetch each draft's headers, paced against the quota
+		fetch := func(draftID string) error {
+			msg, err := mb.provider.GetMessage(mb.context(), mb.user, messageIDs[draftID], "metadata", "Subject", "To")
+			if err != nil {
+				return err
+			}
+
+			summary := DraftSummary{
+				DraftID:   draftID,
+				MessageID: msg.Id,
+				Size:      msg.SizeEstimate,
+				UpdatedAt: time.UnixMilli(msg.InternalDate),
+			}
+			summary.AgeDays = int(now.Sub(summary.UpdatedAt).Hours() / 24)
+			for _, header := range msg.Payload.Headers {
+				switch header.Name {
+				case "Subject":
+					summary.Subject = header.Value
+				case "To":
+					summary.To = header.Value
+				}
+			}
+
+			mu.Lock()
+			drafts = append(drafts, summary)
+			mu.Unlock()
+			return nil
+		}
+		err = runPlanned(context.Background(), mb.quota, ids, costMessagesGet, fetch, func(results map[string]error) bool {
+			for _, err := range results {
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}
+			return firstErr == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if firstErr != nil {
+			return nil, firstErr
+		}
+
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+
+	// Oldest first
+	sort.Slice(drafts, func(i, j int) bool {
+		return drafts[i].UpdatedAt.Before(drafts[j].UpdatedAt)
+	})
+	return drafts, nil
+}
+
+// buildDraftsReport summarizes drafts, treating those untouched for olderThanDays as abandoned
+func buildDraftsReport(drafts []DraftSummary, olderThanDays int) *DraftsReport {
+	report := &DraftsReport{
+		OlderThanDays: olderThanDays,
+		Abandoned:     make([]DraftSummary, 0),
+	}
+	for _, draft := range drafts {
+		report.Count++
+		report.TotalSize += draft.Size
+		if draft.AgeDays >= olderThanDays {
+			report.AbandonedCount++
+			report.AbandonedSize += draft.Size
+			report.Abandoned = append(report.Abandoned, draft)
+		}
+	}
+	return report
+}
+
+// parseOlderThanDays reads the `olderThanDays` query parameter
+func parseOlderThanDays(r *http.Request, def int) (int, bool) {
+	v := r.URL.Query().Get("olderThanDays")
+	if v == "" {
+		return def, true
+	}
+	days, err := strconv.Atoi(v)
+	return days, err == nil && days >= 0
+}
+
+// HandleGetDraftsReport reports the count, size and age of the user's drafts, listing
+// those untouched for more than `olderThanDays` days (default 180)
+func HandleGetDraftsReport(w http.ResponseWriter, r *http.Request) {
+	olderThanDays, ok := parseOlderThanDays(r, defaultDraftAgeDays)
+	if !ok {
+		writeError(w, "olderThanDays must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+
+	report, err := Jobs.Run(r.Context(), "drafts-report", mb.userID, PriorityInteractive, func(job *Job) (interface{}, error) {
+		drafts, err := listDrafts(mb)
+		if err != nil {
+			return nil, err
+		}
+		return buildDraftsReport(drafts, olderThanDays), nil
+	})
+	if err != nil {
+		writeErrorFrom(w, "Failed to build drafts report", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, report)
+}
+
+// discardDraftsRequest is the body of HandleDiscardDrafts
+type discardDraftsRequest struct {
+	DraftIDs []string `json:"draftIds"`
+	// Discard every draft untouched for this many days instead
+	OlderThanDays *int `json:"olderThanDays"`
+}
+
+// HandleDiscardDrafts previews discarding drafts by moving their messages to trash, so
+// a discard can still be undone. The body lists {"draftIds": [...]} or gives {"olderThanDays": n}
+// to discard every draft untouched for that long.
+func HandleDiscardDrafts(w http.ResponseWriter, r *http.Request) {
+	var req discardDraftsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if len(req.DraftIDs) == 0 && req.OlderThanDays == nil {
+		writeError(w, "Provide draftIds or olderThanDays", http.StatusBadRequest)
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	handleOperationPreview(w, r, mb, "drafts-discard", "", func() ([]string, error) {
+		drafts, err := listDrafts(mb)
+		if err != nil {
+			return nil, err
+		}
+
+		wanted := make(map[string]bool, len(req.DraftIDs))
+		for _, id := range req.DraftIDs {
+			wanted[id] = true
+		}
+
+		messageIDs := make([]string, 0)
+		for _, draft := range drafts {
+			if wanted[draft.DraftID] || (req.OlderThanDays != nil && draft.AgeDays >= *req.OlderThanDays) {
+				messageIDs = append(messageIDs, draft.MessageID)
+			}
+		}
+		return messageIDs, nil
+	}, trashOperation)
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// SavedAttachment describes an attachment uploaded to Google Drive
+type SavedAttachment struct {
+	Filename string `json:"filename"`
+	MimeType string `json:"mimeType"`
+	Size     int    `json:"size"`
+	FileID   string `json:"fileId"`
+	Link     string `json:"link"`
+}
+
+// saveToDriveRequest is the optional body of HandleSaveAttachmentsToDrive
+type saveToDriveRequest struct {
+	// Drive folder to upload into, defaulting to DRIVE_FOLDER_ID
+	FolderID string `json:"folderId"`
+	// Trash the email once every attachment has been uploaded
+	Trash bool `json:"trash"`
+}
+
+// HandleSaveAttachmentsToDrive uploads a message's attachments to Google Drive and
+// returns their links, optionally trashing the message afterwards. This supports
+// "archive the invoice PDF, delete the email" workflows.
+func HandleSaveAttachmentsToDrive(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+
+	if !config.DriveEnabled {
+		writeError(w, "Drive integration is not enabled on this server", http.StatusNotImplemented)
+		return
+	}
+
+	var req saveToDriveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if req.FolderID == "" {
+		req.FolderID = config.DriveFolderID
+	}
+
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+	if !requireContentAccess(w, mb.token) {
+		return
+	}
+	if req.Trash && !requireModifyAccess(w, mb.token) {
+		return
+	}
+	// Drive belongs to the Google account, which IMAP and demo sessions don't have
+	if isIMAPToken(mb.token) || isDemoToken(mb.token) {
+		writeErrorFrom(w, "", fmt.Errorf("%w: saving attachments to Google Drive", ErrNotSupported), http.StatusNotImplemented)
+		return
+	}
+
+	client := googleClient(context.Background(), mb.token)
+	driveService, err := drive.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		writeErrorFrom(w, "Failed to create Drive service", err, http.StatusInternalServerError)
+		return
+	}
+
+	msg, err := mb.provider.GetMessage(r.Context(), mb.user, messageID, "full")
+	if err != nil {
+		writeErrorFrom(w, "Failed to fetch email", err, http.StatusNotFound)
+		return
+	}
+
+	parts := attachmentParts(msg.Payload)
+	if len(parts) == 0 {
+		writeError(w, "Email has no attachments", http.StatusUnprocessableEntity)
+		return
+	}
+
+	saved := make([]SavedAttachment, 0, len(parts))
+	for _, part := range parts {
+		data, err := attachmentData(r.Context(), mb.provider, mb.user, messageID, part)
+		if err != nil {
+			writeErrorFrom(w, "Failed to download attachment "+part.Filename, err, http.StatusInternalServerError)
+			return
+		}
+
+		file := &drive.File{Name: part.Filename, MimeType: part.MimeType}
+		if req.FolderID != "" {
+			file.Parents = []string{req.FolderID}
+		}
+		created, err := driveService.Files.Create(file).Media(bytes.NewReader(data)).Fields("id", "webViewLink").Do()
+		if err != nil {
+			writeErrorFrom(w, "Failed to upload attachment "+part.Filename, err, http.StatusInternalServerError)
+			return
+		}
+
+		saved = append(saved, SavedAttachment{
+			Filename: part.Filename,
+			MimeType: part.MimeType,
+			Size:     len(data),
+			FileID:   created.Id,
+			Link:     created.WebViewLink,
+		})
+	}
+
+	// Only trash once everything is safely in Drive
+	trashed := false
+	if req.Trash {
+		err := mb.provider.Trash(r.Context(), mb.user, messageID)
+		recordTrashAudit(mb, newID(), "save-to-drive", messageID, []string{messageID}, err)
+		if err != nil {
+			writeErrorFrom(w, "Attachments saved but failed to trash email", err, http.StatusInternalServerError)
+			return
+		}
+		trashed = true
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"messageId":   messageID,
+		"attachments": saved,
+		"trashed":     trashed,
+	})
+}
+
+// attachmentParts returns every part of a message payload that carries a file
+func attachmentParts(part *gmail.MessagePart) []*gmail.MessagePart {
+	if part == nil {
+		return nil
+	}
+
+	parts := make([]*gmail.MessagePart, 0)
+	if part.Filename != "" && part.Body != nil {
+		parts = append(parts, part)
+	}
+	for _, child := range part.Parts {
+		parts = append(parts, attachmentParts(child)...)
+	}
+	return parts
+}
+
+// attachmentData returns the decoded contents of an attachment part, downloading it
+// separately when Gmail didn't inline the data
+func attachmentData(ctx context.Context, provider MailProvider, user, messageID string, part *gmail.MessagePart) ([]byte, error) {
+	data := part.Body.Data
+	if part.Body.AttachmentId != "" {
+		body, err := provider.GetAttachment(ctx, user, messageID, part.Body.AttachmentId)
+		if err != nil {
+			return nil, err
+		}
+		data = body.Data
+	}
+	return decodeBase64URL(data)
+}
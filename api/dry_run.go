@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// dryRunProvider is a MailProvider that reads through to its backend but skips every
+// call that would change the mailbox, logging and counting it instead, so the whole
+// pipeline can be exercised without touching anyone's mail. Skipped calls succeed, so
+// results report what would have happened.
+type dryRunProvider struct {
+	MailProvider
+	// Key of the user the skipped calls are counted for
+	userID string
+}
+
+// withDryRun returns a copy of the mailbox whose changes are only logged and counted
+func (mb *mailbox) withDryRun() *mailbox {
+	if mb.dryRun() {
+		return mb
+	}
+	copied := *mb
+	copied.provider = &dryRunProvider{MailProvider: mb.provider, userID: mb.userID}
+	return &copied
+}
+
+// dryRun reports whether the mailbox's changes are only logged and counted
+func (mb *mailbox) dryRun() bool {
+	_, ok := mb.provider.(*dryRunProvider)
+	return ok
+}
+
+// Header flagging the responses of dry runs, and asking for one as gRPC metadata
+const dryRunHeader = "X-Dry-Run"
+
+// requestDryRun reports whether a request asked for a dry run with ?dryRun=true
+func requestDryRun(r *http.Request) bool {
+	return r.URL.Query().Get("dryRun") == "true"
+}
+
+// skip logs and counts a skipped call acting on count messages or other objects
+func (d *dryRunProvider) skip(method string, count int, detail string) {
+	log.Printf("Dry run: skipped %s of %d%s", method, count, detail)
+	Usage.addDryRun(d.userID, method, count)
+}
+
+// labelChanges describes the labels a call would add and remove, for the log
+func labelChanges(addLabelIDs, removeLabelIDs []string) string {
+	return " adding [" + strings.Join(addLabelIDs, " ") + "] and removing [" + strings.Join(removeLabelIDs, " ") + "]"
+}
+
+func (d *dryRunProvider) InsertMessage(ctx context.Context, user string, msg *gmail.Message) (*gmail.Message, error) {
+	d.skip("messages.insert", 1, "")
+	return &gmail.Message{Id: "dry-run"}, nil
+}
+
+func (d *dryRunProvider) ImportMessage(ctx context.Context, user string, raw io.Reader) (*gmail.Message, error) {
+	d.skip("messages.import", 1, "")
+	return &gmail.Message{Id: "dry-run"}, nil
+}
+
+func (d *dryRunProvider) SendMessage(ctx context.Context, user string, msg *gmail.Message) error {
+	d.skip("messages.send", 1, "")
+	return nil
+}
+
+func (d *dryRunProvider) ModifyMessage(ctx context.Context, user, id string, addLabelIDs, removeLabelIDs []string) error {
+	d.skip("messages.modify", 1, " ("+id+")"+labelChanges(addLabelIDs, removeLabelIDs))
+	return nil
+}
+
+func (d *dryRunProvider) BatchModify(ctx context.Context, user string, ids, addLabelIDs, removeLabelIDs []string) error {
+	d.skip("messages.batchModify", len(ids), labelChanges(addLabelIDs, removeLabelIDs))
+	return nil
+}
+
+func (d *dryRunProvider) Trash(ctx context.Context, user, id string) error {
+	d.skip("messages.trash", 1, " ("+id+")")
+	return nil
+}
+
+func (d *dryRunProvider) Untrash(ctx context.Context, user, id string) error {
+	d.skip("messages.untrash", 1, " ("+id+")")
+	return nil
+}
+
+func (d *dryRunProvider) BatchDelete(ctx context.Context, user string, ids []string) error {
+	d.skip("messages.batchDelete", len(ids), "")
+	return nil
+}
+
+func (d *dryRunProvider) ModifyThread(ctx context.Context, user, id string, addLabelIDs, removeLabelIDs []string) error {
+	d.skip("threads.modify", 1, " ("+id+")"+labelChanges(addLabelIDs, removeLabelIDs))
+	return nil
+}
+
+func (d *dryRunProvider) CreateLabel(ctx context.Context, user string, label *gmail.Label) (*gmail.Label, error) {
+	d.skip("labels.create", 1, "")
+	created := *label
+	created.Id = "dry-run"
+	return &created, nil
+}
+
+func (d *dryRunProvider) CreateFilter(ctx context.Context, user string, filter *gmail.Filter) (*gmail.Filter, error) {
+	d.skip("filters.create", 1, "")
+	created := *filter
+	created.Id = "dry-run"
+	return &created, nil
+}
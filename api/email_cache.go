@@ -0,0 +1,237 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// spilledRecord locates one record in an emailCache's spill file
+type spilledRecord struct {
+	offset int64
+	length int
+}
+
+// emailCache holds the metadata collected by a scan. The first maxMemory records are
+// kept in memory; later ones are spilled to a temporary file as JSON and read back on
+// demand, so huge mailboxes don't exhaust the server's memory. Only the location of each
+// spilled record stays in memory. The cache does no locking of its own: the processor's
+// lock guards it, and concurrent readers are safe because spilled records are read with
+// ReadAt.
+type emailCache struct {
+	maxMemory   int
+	memory      []EmailMetadata
+	memoryBytes int64
+
+	// Created on the first spill
+	file    *os.File
+	spilled []spilledRecord
+	// End of the spill file, where the next record is written
+	end int64
+}
+
+// newEmailCache creates a cache keeping up to maxMemory records in memory; zero or
+// less never spills
+func newEmailCache(maxMemory int) *emailCache {
+	return &emailCache{maxMemory: maxMemory, memory: make([]EmailMetadata, 0)}
+}
+
+// spillDir is where spill files are written
+func spillDir() string {
+	return filepath.Join(config.DataDir, "spill")
+}
+
+// len returns the number of records in the cache
+func (c *emailCache) len() int {
+	return len(c.memory) + len(c.spilled)
+}
+
+// diskBytes returns the size of the spill file, including superseded records
+func (c *emailCache) diskBytes() int64 {
+	return c.end
+}
+
+// append adds a record, spilling it to disk once the memory cap is reached
+func (c *emailCache) append(e EmailMetadata) error {
+	if c.maxMemory <= 0 || len(c.memory) < c.maxMemory {
+		c.memory = append(c.memory, e)
+		c.memoryBytes += approxMemory(&e)
+		return nil
+	}
+
+	record, err := c.write(&e)
+	if err != nil {
+		return err
+	}
+	c.spilled = append(c.spilled, record)
+	return nil
+}
+
+// write appends a record to the end of the spill file
+func (c *emailCache) write(e *EmailMetadata) (spilledRecord, error) {
+	if c.file == nil {
+		if err := os.MkdirAll(spillDir(), 0o700); err != nil {
+			return spilledRecord{}, fmt.Errorf("failed to create spill directory: %w", err)
+		}
+		file, err := os.CreateTemp(spillDir(), "emails-*.jsonl")
+		if err != nil {
+			return spilledRecord{}, fmt.Errorf("failed to create spill file: %w", err)
+		}
+		c.file = file
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return spilledRecord{}, err
+	}
+	data = append(data, '\n')
+	if _, err := c.file.WriteAt(data, c.end); err != nil {
+		return spilledRecord{}, fmt.Errorf("failed to spill message metadata: %w", err)
+	}
+	record := spilledRecord{offset: c.end, length: len(data)}
+	c.end += int64(len(data))
+	return record, nil
+}
+
+// read loads a spilled record into e
+func (c *emailCache) read(record spilledRecord, buf []byte, e *EmailMetadata) ([]byte, error) {
+	if cap(buf) < record.length {
+		buf = make([]byte, record.length)
+	}
+	buf = buf[:record.length]
+	if _, err := c.file.ReadAt(buf, record.offset); err != nil {
+		return buf, fmt.Errorf("failed to read spilled message metadata: %w", err)
+	}
+	*e = EmailMetadata{}
+	return buf, json.Unmarshal(buf, e)
+}
+
+// get returns a copy of the record at index i
+func (c *emailCache) get(i int) (EmailMetadata, error) {
+	if i < len(c.memory) {
+		return c.memory[i], nil
+	}
+	var e EmailMetadata
+	_, err := c.read(c.spilled[i-len(c.memory)], nil, &e)
+	return e, err
+}
+
+// each calls fn with every record in order until it returns false. Spilled records are
+// passed as temporary copies, so fn must not keep or modify them; use update for that.
+// A spilled record that can't be read back is logged and skipped rather than failing
+// every caller.
+func (c *emailCache) each(fn func(i int, e *EmailMetadata) bool) {
+	for i := range c.memory {
+		if !fn(i, &c.memory[i]) {
+			return
+		}
+	}
+
+	var buf []byte
+	var e EmailMetadata
+	for j, record := range c.spilled {
+		var err error
+		if buf, err = c.read(record, buf, &e); err != nil {
+			log.Printf("Skipping spilled message %d: %s", j, redactError(err))
+			continue
+		}
+		if !fn(len(c.memory)+j, &e) {
+			return
+		}
+	}
+}
+
+// update changes the record at index i. A changed spilled record is written again at
+// the end of the spill file.
+func (c *emailCache) update(i int, fn func(e *EmailMetadata)) error {
+	if i < len(c.memory) {
+		before := approxMemory(&c.memory[i])
+		fn(&c.memory[i])
+		c.memoryBytes += approxMemory(&c.memory[i]) - before
+		return nil
+	}
+
+	e, err := c.get(i)
+	if err != nil {
+		return err
+	}
+	fn(&e)
+	record, err := c.write(&e)
+	if err != nil {
+		return err
+	}
+	c.spilled[i-len(c.memory)] = record
+	return nil
+}
+
+// updateAll changes every record. Spilled records are rewritten to a fresh spill file,
+// which also drops the superseded copies left behind by update.
+func (c *emailCache) updateAll(fn func(e *EmailMetadata)) error {
+	c.memoryBytes = 0
+	for i := range c.memory {
+		fn(&c.memory[i])
+		c.memoryBytes += approxMemory(&c.memory[i])
+	}
+	if c.file == nil {
+		return nil
+	}
+
+	file, err := os.CreateTemp(spillDir(), "emails-*.jsonl")
+	if err != nil {
+		return fmt.Errorf("failed to create spill file: %w", err)
+	}
+	w := bufio.NewWriter(file)
+	spilled := make([]spilledRecord, 0, len(c.spilled))
+	var end int64
+	var buf []byte
+	var e EmailMetadata
+	for _, record := range c.spilled {
+		if buf, err = c.read(record, buf, &e); err != nil {
+			break
+		}
+		fn(&e)
+		var data []byte
+		if data, err = json.Marshal(&e); err != nil {
+			break
+		}
+		data = append(data, '\n')
+		if _, err = w.Write(data); err != nil {
+			break
+		}
+		spilled = append(spilled, spilledRecord{offset: end, length: len(data)})
+		end += int64(len(data))
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to rewrite spilled message metadata: %w", err)
+	}
+
+	c.removeFile()
+	c.file, c.spilled, c.end = file, spilled, end
+	return nil
+}
+
+// close deletes the spill file. The cache must not be used afterwards.
+func (c *emailCache) close() {
+	c.removeFile()
+	c.memory, c.spilled = nil, nil
+}
+
+// removeFile closes and deletes the current spill file, if any
+func (c *emailCache) removeFile() {
+	if c.file == nil {
+		return
+	}
+	c.file.Close()
+	if err := os.Remove(c.file.Name()); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove spill file: %s", redactError(err))
+	}
+	c.file = nil
+}
@@ -1,95 +1,129 @@
 package api
 
 import (
+	"container/heap"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
-	"time"
+	"sync/atomic"
+	"unsafe"
 
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
 	"golang.org/x/oauth2"
-	"google.golang.org/api/gmail/v1"
-	"google.golang.org/api/option"
 )
 
 // EmailMetadata stores information about emails
-type EmailMetadata struct {
-	ID           string    `json:"id"`
-	ThreadID     string    `json:"threadId"`
-	From         string    `json:"from"`
-	To           []string  `json:"to"`
-	Subject      string    `json:"subject"`
-	Date         time.Time `json:"date"`
-	Snippet      string    `json:"snippet"`
-	LabelIDs     []string  `json:"labelIds"`
-	SizeEstimate int64     `json:"sizeEstimate"`
-}
+type EmailMetadata = deepclean.EmailMetadata
 
 // EmailStats tracks statistics about email communications
-type EmailStats struct {
-	// Maps sender email to number of emails received
-	FromCount map[string]int `json:"fromCount"`
-	// Maps recipient email to number of emails sent
-	ToCount map[string]int `json:"toCount"`
-	// Maps sender to total size of emails received
-	FromSize map[string]int64 `json:"fromSize"`
-	// Maps date to number of emails
-	DateCount map[string]int `json:"dateCount"`
-	// Total emails processed
-	TotalEmails int `json:"totalEmails"`
-	// Lock for concurrent map access
-	mu sync.RWMutex
-}
-
-// NewEmailStats creates a new EmailStats instance
-func NewEmailStats() *EmailStats {
-	return &EmailStats{
-		FromCount: make(map[string]int),
-		ToCount:   make(map[string]int),
-		FromSize:  make(map[string]int64),
-		DateCount: make(map[string]int),
+type EmailStats = deepclean.Stats
+
+// approxMemory estimates the bytes held in memory by an email's record
+func approxMemory(e *EmailMetadata) int64 {
+	size := int64(unsafe.Sizeof(*e))
+	size += int64(len(e.ID) + len(e.ThreadID) + len(e.From) + len(e.Subject) + len(e.Snippet))
+	for _, to := range e.To {
+		size += int64(unsafe.Sizeof(to)) + int64(len(to))
+	}
+	for _, label := range e.LabelIDs {
+		size += int64(unsafe.Sizeof(label)) + int64(len(label))
 	}
+	return size
 }
 
 // InboxProcessor manages the process of downloading and analyzing inbox data
 type InboxProcessor struct {
-	token        *oauth2.Token
-	service      *gmail.Service
-	emails       []EmailMetadata
+	userID   string
+	user     string // Gmail user ID of the mailbox, "me" unless delegated
+	account  string // Address of the mailbox, which keys its checkpoints
+	token    *oauth2.Token
+	provider MailProvider
+	scope    ScanScope // Part of the mailbox the scan covers
+	// Set when the user only granted metadata access: no bodies are fetched and no
+	// snippets kept
+	metadataOnly bool
+	emails       *emailCache
 	stats        *EmailStats
 	pageToken    string
 	isProcessing bool
-	mu           sync.RWMutex
+	errorBudget  *ErrorBudget
+	quota        *QuotaLimiter
+	// Quota units the scan may use before it pauses (0 for no limit), and those it used
+	quotaBudget int64
+	quotaUsed   atomic.Int64
+	abortReason string
+	// Why the scan paused, to be resumed by starting it again
+	pauseReason string
+	jobID       string
+	// Messages and chunks saved by the last checkpoint, and whether the scan resumed one
+	checkpointed     int
+	checkpointChunks int
+	resumed          bool
+	// Messages fetched by earlier scans of the mailbox, open while scanning
+	known *messageIndex
+	mu    sync.RWMutex
+}
+
+// ScanQuota is the Gmail API quota a scan and its user consumed, in estimated units
+type ScanQuota struct {
+	Used int64 `json:"used"`
+	// Units the scan may use before it pauses, 0 for no limit
+	Budget int64 `json:"budget"`
+	// Units the user consumed today across scans, cleanups and other tools of the server
+	UsedToday int64 `json:"usedToday"`
+}
+
+// ResourceUsage describes the server resources held for one analyzed account
+type ResourceUsage struct {
+	CachedMessages int   `json:"cachedMessages"`
+	MemoryBytes    int64 `json:"memoryBytes"`
+	DiskBytes      int64 `json:"diskBytes"`
 }
 
-// NewInboxProcessor creates a new InboxProcessor
-func NewInboxProcessor(token *oauth2.Token) (*InboxProcessor, error) {
-	client := oauthConfig.Client(context.Background(), token)
-	service, err := gmail.NewService(context.Background(), option.WithHTTPClient(client))
+// NewInboxProcessor creates a new InboxProcessor for a mailbox, "me" for the token's own,
+// that scans the part of it described by scope
+func NewInboxProcessor(token *oauth2.Token, user string, scope ScanScope) (*InboxProcessor, error) {
+	mb, err := newMailbox(token, user)
+	if err != nil {
+		return nil, err
+	}
+	account, err := mb.account()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Gmail service: %w", err)
+		return nil, err
 	}
 
 	return &InboxProcessor{
+		userID:       mb.userID,
+		user:         user,
+		account:      account,
+		scope:        scope,
+		metadataOnly: metadataOnly(token),
 		token:        token,
-		service:      service,
-		emails:       make([]EmailMetadata, 0),
-		stats:        NewEmailStats(),
+		provider:     mb.provider,
+		emails:       newEmailCache(config.MaxCachedMessages),
+		stats:        deepclean.NewStats(),
 		isProcessing: false,
+		errorBudget:  NewErrorBudget(),
+		quota:        mb.quota,
 	}, nil
 }
 
-// StartProcessing begins downloading and processing emails in the background
-func (p *InboxProcessor) StartProcessing() error {
+// StartProcessing queues the download and processing of emails as a background job
+func (p *InboxProcessor) StartProcessing(priority JobPriority) error {
 	p.mu.Lock()
+	defer p.mu.Unlock()
 	if p.isProcessing {
-		p.mu.Unlock()
 		return fmt.Errorf("processing already in progress")
 	}
 	p.isProcessing = true
-	p.mu.Unlock()
 
-	go p.processInbox()
+	job := Jobs.Enqueue("scan", p.userID, priority, func(job *Job) (interface{}, error) {
+		p.processInbox(job.Context())
+		return p.GetProgress(), nil
+	})
+	p.jobID = job.ID
 	return nil
 }
 
@@ -103,84 +137,250 @@ func (p *InboxProcessor) GetProgress() map[string]interface{} {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	return map[string]interface{}{
+	_, failed := p.errorBudget.Counts()
+	usage := p.usageLocked()
+	progress := map[string]interface{}{
 		"totalEmails":  p.stats.TotalEmails,
 		"isProcessing": p.isProcessing,
+		"failedEmails": failed,
+		"aborted":      p.abortReason != "",
+		"usage":        usage,
+		"jobId":        p.jobID,
+		"scope":        p.scope,
+		"resumed":      p.resumed,
+		"metadataOnly": p.metadataOnly,
+		"paused":       p.pauseReason != "",
+		"quota": ScanQuota{
+			Used:      p.quotaUsed.Load(),
+			Budget:    p.quotaBudget,
+			UsedToday: Usage.quotaToday(p.userID),
+		},
+		"throttle": p.quota.throttle.State(),
+	}
+	if p.abortReason != "" {
+		progress["abortReason"] = p.abortReason
+	}
+	if p.pauseReason != "" {
+		progress["pauseReason"] = p.pauseReason
+	}
+	return progress
+}
+
+// Usage returns an estimate of the memory held by the processor's cache
+func (p *InboxProcessor) Usage() ResourceUsage {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.usageLocked()
+}
+
+func (p *InboxProcessor) usageLocked() ResourceUsage {
+	// Each stats map entry holds roughly one sender string plus its counters
+	p.stats.RLock()
+	statsEntries := len(p.stats.FromCount) + len(p.stats.ToCount) + len(p.stats.DateCount)
+	p.stats.RUnlock()
+
+	return ResourceUsage{
+		CachedMessages: p.emails.len(),
+		MemoryBytes:    p.emails.memoryBytes + int64(statsEntries)*64,
+		DiskBytes:      p.emails.diskBytes(),
 	}
 }
 
-// extractEmailAddress extracts the email address from the value field of a header
-func extractEmailAddress(header string) string {
-	// This is a simple extraction - you might want to use a regex for more accurate parsing
-	// Example: "John Doe <john@example.com>" -> "john@example.com"
+// EmailCount returns the number of emails collected so far
+func (p *InboxProcessor) EmailCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.emails.len()
+}
 
-	start := 0
-	end := len(header)
+// EmailsRange returns a copy of up to limit collected emails starting at offset.
+// Emails are only ever appended, so a given offset always refers to the same record.
+func (p *InboxProcessor) EmailsRange(offset, limit int) []EmailMetadata {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 
-	// Find the start of the email (after '<' if present)
-	for i := 0; i < len(header); i++ {
-		if header[i] == '<' {
-			start = i + 1
-			break
-		}
+	if offset >= p.emails.len() || limit <= 0 {
+		return nil
 	}
+	end := min(offset+limit, p.emails.len())
 
-	// Find the end of the email (before '>' if present)
-	for i := len(header) - 1; i >= 0; i-- {
-		if header[i] == '>' {
-			end = i
-			break
+	chunk := make([]EmailMetadata, 0, end-offset)
+	for i := offset; i < end; i++ {
+		email, err := p.emails.get(i)
+		if err != nil {
+			log.Printf("Skipping cached message %d: %s", i, redactError(err))
+			continue
 		}
+		chunk = append(chunk, email)
 	}
+	return chunk
+}
 
-	if start < end {
-		return header[start:end]
+// SizesOf returns the size of each of the given messages the scan has collected
+func (p *InboxProcessor) SizesOf(ids []string) map[string]int64 {
+	wanted := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		wanted[id] = struct{}{}
 	}
 
-	return header
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	sizes := make(map[string]int64, len(ids))
+	p.emails.each(func(_ int, email *EmailMetadata) bool {
+		if _, ok := wanted[email.ID]; ok {
+			sizes[email.ID] = email.Size()
+		}
+		return true
+	})
+	return sizes
 }
 
-// processInbox handles downloading all emails from the inbox
-func (p *InboxProcessor) processInbox() {
-	user := "me" // special value for the authenticated user
-	pageToken := ""
-	pageSize := int64(100) // Number of messages to fetch per API call
+// processInbox handles downloading all emails from the inbox. Each page and message
+// fetch is traced under the span in ctx.
+func (p *InboxProcessor) processInbox(ctx context.Context) {
+	pages := 0
 
-	for {
-		req := p.service.Users.Messages.List(user).MaxResults(pageSize)
-		if pageToken != "" {
-			req = req.PageToken(pageToken)
-		}
+	// Only fetch messages no earlier scan has; without the index everything is fetched
+	known, err := openMessageIndex(p.userID)
+	if err != nil {
+		log.Printf("Scanning without message index: %s", redactError(err))
+	} else {
+		p.known = known
+		defer func() {
+			p.known = nil
+			known.close()
+		}()
+	}
 
-		resp, err := req.Do()
-		if err != nil {
-			log.Printf("Failed to fetch messages: %v", err)
-			break
-		}
+	runningScans.Add(1)
+	defer runningScans.Done()
 
-		// Process each message
-		var wg sync.WaitGroup
-		for _, msg := range resp.Messages {
-			wg.Add(1)
-			go func(messageID string) {
-				defer wg.Done()
-				p.processMessage(user, messageID)
-			}(msg.Id)
+	// Stops between pages on shutdown, saving where to pick up after the restart
+	shuttingDown := func(pageToken string) bool {
+		if !stopping() {
+			return false
+		}
+		if err := p.checkpoint(pageToken); err != nil {
+			log.Printf("Failed to checkpoint scan: %s", redactError(err))
 		}
-		wg.Wait()
+		p.abort("server shutting down")
+		return true
+	}
 
-		// Update total count
-		p.stats.mu.Lock()
-		p.stats.TotalEmails += len(resp.Messages)
-		p.stats.mu.Unlock()
+	// Get the full message details, or only their headers when deep body scans are disabled
+	format := "full"
+	if !Features().DeepBodyScan || p.metadataOnly {
+		format = "metadata"
+	}
+	scanner := &deepclean.Scanner{
+		Provider: p.provider,
+		User:     p.user,
+		Query: MessageQuery{
+			Query:            p.scope.searchQuery(),
+			LabelIDs:         p.scope.LabelIDs,
+			IncludeSpamTrash: p.scope.IncludeSpamTrash,
+			PageToken:        p.pageToken, // Set when resuming from a checkpoint
+			MaxResults:       int64(config.ScanPageSize),
+		},
+		Format: format,
+		// Pace listing and fetching against the user's per-second quota
+		BeforeCall: func(ctx context.Context, method string) error {
+			cost := methodCosts[method]
+			if err := p.quota.Wait(ctx, cost); err != nil {
+				return err
+			}
+			p.quotaUsed.Add(int64(cost))
+			return nil
+		},
+		// Slow down while Gmail rate limits the user's calls
+		AfterCall: func(method string, err error) {
+			p.quota.throttle.Observe(err)
+		},
+		Concurrency: p.quota.throttle.Concurrency,
+		Lookup: func(id string) (*knownMessage, bool) {
+			if p.known == nil {
+				return nil, false
+			}
+			return p.known.lookup(id)
+		},
+		OnMessage: func(msg *knownMessage, fetched bool) error {
+			// Keep no content the user didn't grant access to, even if Gmail sent it
+			if p.metadataOnly {
+				msg.Snippet = ""
+			}
+			// Remember the message so later scans can skip fetching it
+			if fetched && p.known != nil {
+				if err := p.known.add(msg); err != nil {
+					log.Printf("Failed to index message %s: %s", msg.ID, redactError(err))
+				}
+			}
+			return p.addMessage(msg)
+		},
+		OnPage: func(ctx context.Context, page deepclean.Page) error {
+			for _, err := range page.Errors {
+				log.Printf("Failed to process message: %s", redactError(err))
+				p.errorBudget.Record(err)
+			}
+			for i := len(page.Errors); i < page.Messages; i++ {
+				p.errorBudget.Record(nil)
+			}
+
+			// Update total count
+			p.stats.Lock()
+			p.stats.TotalEmails += page.Messages
+			p.stats.Unlock()
 
-		// Check if there are more pages
-		if resp.NextPageToken == "" {
-			break
+			// Stop rather than produce stats with silent holes in them
+			if err := p.errorBudget.Exceeded(); err != nil {
+				log.Printf("Aborting email processing: %s", redactError(err))
+				p.abort(redactError(err))
+				return errScanStopped
+			}
+			if page.NextPageToken == "" {
+				return nil
+			}
+
+			if shuttingDown(page.NextPageToken) || p.overBudget(page.NextPageToken) {
+				return errScanStopped
+			}
+
+			// Save progress now and then so a restart can pick up from here
+			pages++
+			if config.ScanCheckpointPages > 0 && pages%config.ScanCheckpointPages == 0 {
+				if err := p.checkpoint(page.NextPageToken); err != nil {
+					log.Printf("Failed to checkpoint scan: %s", redactError(err))
+				}
+			}
+			return nil
+		},
+	}
+
+	err = errScanStopped
+	if !shuttingDown(p.pageToken) {
+		err = scanner.Scan(ctx)
+	}
+	if err != nil && !errors.Is(err, errScanStopped) {
+		log.Printf("Failed to fetch messages: %s", redactError(err))
+		p.abort(redactError(err))
+	}
+
+	// An aborted scan keeps its checkpoint so it can be resumed later
+	if err == nil {
+		p.clearCheckpoint()
+
+		// Drop indexed messages deleted since earlier scans. A resumed scan didn't list
+		// the messages before its checkpoint, so it can't tell which those are.
+		if p.known != nil && !p.resumed {
+			if err := p.known.compact(); err != nil {
+				log.Printf("Failed to compact message index: %s", redactError(err))
+			}
 		}
-		pageToken = resp.NextPageToken
 	}
 
+	// Final classification pass using per-sender signals
+	p.classifyAll()
+
 	p.mu.Lock()
 	p.isProcessing = false
 	p.mu.Unlock()
@@ -188,129 +388,159 @@ func (p *InboxProcessor) processInbox() {
 	log.Printf("Email processing complete. Total emails processed: %d", p.stats.TotalEmails)
 }
 
-// processMessage fetches and processes a single email message
-func (p *InboxProcessor) processMessage(user, messageID string) {
-	// Get the full message details
-	msg, err := p.service.Users.Messages.Get(user, messageID).Format("full").Do()
-	if err != nil {
-		log.Printf("Failed to fetch message %s: %v", messageID, err)
-		return
-	}
+// errScanStopped is returned from a scan's page hook when the processor stopped it,
+// having recorded why
+var errScanStopped = errors.New("scan stopped")
 
-	// Initialize metadata
-	metadata := EmailMetadata{
-		ID:           msg.Id,
-		ThreadID:     msg.ThreadId,
-		LabelIDs:     msg.LabelIds,
-		Snippet:      msg.Snippet,
-		SizeEstimate: msg.SizeEstimate,
-		To:           make([]string, 0),
+// overBudget pauses the scan once it has used its quota budget, saving where to pick
+// up when it is started again. The budget is checked between pages, so a scan may go
+// over by the cost of one page.
+func (p *InboxProcessor) overBudget(pageToken string) bool {
+	if p.quotaBudget <= 0 || p.quotaUsed.Load() < p.quotaBudget {
+		return false
 	}
+	if err := p.checkpoint(pageToken); err != nil {
+		log.Printf("Failed to checkpoint scan: %s", redactError(err))
+	}
+	log.Printf("Pausing scan after using %d quota units", p.quotaUsed.Load())
 
-	// Extract headers
-	for _, header := range msg.Payload.Headers {
-		switch header.Name {
-		case "From":
-			metadata.From = extractEmailAddress(header.Value)
-		case "To":
-			// Note: To might contain multiple addresses, this is a simplified version
-			metadata.To = append(metadata.To, extractEmailAddress(header.Value))
-		case "Subject":
-			metadata.Subject = header.Value
-		case "Date":
-			// Parse the date, with error handling
-			t, err := time.Parse(time.RFC1123Z, header.Value)
-			if err == nil {
-				metadata.Date = t
-			} else {
-				// Try alternative formats if the standard format fails
-				formats := []string{
-					time.RFC1123Z,
-					time.RFC1123,
-					"Mon, 2 Jan 2006 15:04:05 -0700",
-					"Mon, 2 Jan 2006 15:04:05 -0700 (MST)",
-				}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pauseReason = fmt.Sprintf("quota budget of %d units used", p.quotaBudget)
+	return true
+}
 
-				for _, format := range formats {
-					if t, err := time.Parse(format, header.Value); err == nil {
-						metadata.Date = t
-						break
-					}
-				}
-			}
-		}
+// paused reports whether the scan stopped at its quota budget
+func (p *InboxProcessor) paused() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.pauseReason != ""
+}
+
+// abort records why processing stopped early
+func (p *InboxProcessor) abort(reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.abortReason = reason
+}
+
+// addMessage adds a fetched message to the collected emails and statistics
+func (p *InboxProcessor) addMessage(known *knownMessage) error {
+	// Junk awaiting permanent deletion is only tallied
+	if deepclean.JunkLabel(known.LabelIDs) != "" {
+		p.stats.Add(known)
+		return nil
 	}
 
+	// Provisional category from this message alone; refined by classifyAll once
+	// the sender's unread ratio is known
+	known.Category = deepclean.Classify(&known.EmailMetadata, -1, 0)
+
 	// Add to emails list
 	p.mu.Lock()
-	p.emails = append(p.emails, metadata)
+	err := p.emails.append(known.EmailMetadata)
 	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
 
-	// Update statistics
-	p.stats.mu.Lock()
-
-	// Update from counts
-	p.stats.FromCount[metadata.From]++
-
-	// Update from size
-	p.stats.FromSize[metadata.From] += int64(metadata.SizeEstimate)
+	p.stats.Add(known)
+	return nil
+}
 
-	// Update to counts for each recipient
-	for _, to := range metadata.To {
-		p.stats.ToCount[to]++
-	}
+// How GetTopSenders may rank senders
+const (
+	sendersByCount = "count"
+	sendersBySize  = "size"
+)
 
-	// Update date counts
-	if !metadata.Date.IsZero() {
-		dateStr := metadata.Date.Format("2006-01-02")
-		p.stats.DateCount[dateStr]++
-	}
+// senderTotal is one sender's message count and storage
+type senderTotal struct {
+	Email string
+	Count int
+	Size  int64
+}
 
-	p.stats.mu.Unlock()
+// senderHeap is a min-heap of senders under less, so its root is the weakest kept sender
+type senderHeap struct {
+	senders []senderTotal
+	less    func(a, b *senderTotal) bool
 }
 
-// GetTopSenders returns the top N senders by email count
-func (p *InboxProcessor) GetTopSenders(n int) []map[string]interface{} {
-	p.stats.mu.RLock()
-	defer p.stats.mu.RUnlock()
+func (h *senderHeap) Len() int           { return len(h.senders) }
+func (h *senderHeap) Less(i, j int) bool { return h.less(&h.senders[i], &h.senders[j]) }
+func (h *senderHeap) Swap(i, j int)      { h.senders[i], h.senders[j] = h.senders[j], h.senders[i] }
+func (h *senderHeap) Push(x interface{}) { h.senders = append(h.senders, x.(senderTotal)) }
+func (h *senderHeap) Pop() interface{} {
+	last := h.senders[len(h.senders)-1]
+	h.senders = h.senders[:len(h.senders)-1]
+	return last
+}
 
-	// Convert map to slice for sorting
-	type emailCount struct {
-		Email string
-		Count int
-		Size  int64
+// GetTopSenders returns limit senders starting at offset, ranked by sortBy (count or
+// size, descending), optionally only those whose mail mostly falls into category. Only
+// the first offset+limit senders are kept while ranking, so a small page of a mailbox
+// with many senders stays cheap.
+func (p *InboxProcessor) GetTopSenders(offset, limit int, sortBy, category string) []map[string]interface{} {
+	p.stats.RLock()
+	defer p.stats.RUnlock()
+
+	// Ranks a below b; ties go by address so pages are stable
+	less := func(a, b *senderTotal) bool {
+		if a.Count != b.Count {
+			return a.Count < b.Count
+		}
+		return a.Email > b.Email
 	}
-
-	senders := make([]emailCount, 0, len(p.stats.FromCount))
-	for email, count := range p.stats.FromCount {
-		size := p.stats.FromSize[email]
-		senders = append(senders, emailCount{Email: email, Count: count, Size: size})
+	if sortBy == sendersBySize {
+		less = func(a, b *senderTotal) bool {
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+			return a.Email > b.Email
+		}
 	}
 
-	// Sort by count (descending)
-	// Note: A more efficient implementation would use a heap for top-N
-	for i := 0; i < len(senders); i++ {
-		for j := i + 1; j < len(senders); j++ {
-			if senders[i].Count < senders[j].Count {
-				senders[i], senders[j] = senders[j], senders[i]
-			}
+	keep := offset + limit
+	h := &senderHeap{senders: make([]senderTotal, 0, min(keep, len(p.stats.FromCount))), less: less}
+	for email, count := range p.stats.FromCount {
+		if category != "" && p.stats.FromCategory[email] != category {
+			continue
+		}
+		sender := senderTotal{Email: email, Count: count, Size: p.stats.FromSize[email]}
+		if h.Len() < keep {
+			heap.Push(h, sender)
+		} else if keep > 0 && less(&h.senders[0], &sender) {
+			h.senders[0] = sender
+			heap.Fix(h, 0)
 		}
 	}
 
-	// Take top N
-	if n > len(senders) {
-		n = len(senders)
+	// Popping yields the weakest first, so fill the ranking from the back
+	ranked := make([]senderTotal, h.Len())
+	for i := len(ranked) - 1; i >= 0; i-- {
+		ranked[i] = heap.Pop(h).(senderTotal)
 	}
-	senders = senders[:n]
+	page := ranked[min(offset, len(ranked)):]
 
 	// Convert to map for JSON response
-	result := make([]map[string]interface{}, n)
-	for i, sender := range senders {
+	result := make([]map[string]interface{}, len(page))
+	for i, sender := range page {
 		result[i] = map[string]interface{}{
 			"email": sender.Email,
 			"count": sender.Count,
 			"size":  sender.Size,
 		}
+		if category, ok := p.stats.FromCategory[sender.Email]; ok {
+			result[i]["category"] = category
+		}
+		if firstSeen, ok := p.stats.FromFirstSeen[sender.Email]; ok {
+			result[i]["firstSeen"] = firstSeen
+			result[i]["lastSeen"] = p.stats.FromLastSeen[sender.Email]
+		}
+		if unsubscribe, ok := p.stats.Unsubscribe[sender.Email]; ok {
+			result[i]["unsubscribe"] = unsubscribe
+		}
 	}
 
 	return result
@@ -344,9 +574,25 @@ func (r *ProcessorRegistry) Get(userID string) (*InboxProcessor, bool) {
 	return proc, ok
 }
 
-// Remove deletes a processor from the registry
+// All returns a snapshot of the registered processors keyed by user ID
+func (r *ProcessorRegistry) All() map[string]*InboxProcessor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	all := make(map[string]*InboxProcessor, len(r.processors))
+	for userID, proc := range r.processors {
+		all[userID] = proc
+	}
+	return all
+}
+
+// Remove deletes a processor from the registry along with its spilled metadata
 func (r *ProcessorRegistry) Remove(userID string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if proc, ok := r.processors[userID]; ok {
+		proc.mu.Lock()
+		proc.emails.close()
+		proc.mu.Unlock()
+	}
 	delete(r.processors, userID)
 }
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+)
+
+// EmptyResult reports the outcome of emptying Trash or Spam
+type EmptyResult struct {
+	Label   string `json:"label"`
+	Deleted int    `json:"deleted"`
+	Total   int    `json:"total"`
+	Error   string `json:"error,omitempty"`
+	// Set when the operation was confirmed with verify=true
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// listLabelMessageIDs returns the IDs of every message carrying a label, including
+// messages in Trash and Spam
+func listLabelMessageIDs(mb *mailbox, label string) ([]string, error) {
+	return listQueryMessageIDs(mb, MessageQuery{LabelIDs: []string{label}, IncludeSpamTrash: true})
+}
+
+// listQueryMessageIDs returns the IDs of every message a query lists, following its
+// pages
+func listQueryMessageIDs(mb *mailbox, query MessageQuery) ([]string, error) {
+	ids := make([]string, 0)
+	query.MaxResults = 500
+
+	for {
+		if err := mb.quota.Wait(context.Background(), costMessagesList); err != nil {
+			return ids, err
+		}
+
+		resp, err := mb.provider.ListMessages(mb.context(), mb.user, query)
+		if err != nil {
+			return ids, fmt.Errorf("failed to list messages: %w", err)
+		}
+
+		for _, msg := range resp.Messages {
+			ids = append(ids, msg.Id)
+		}
+
+		if resp.NextPageToken == "" {
+			return ids, nil
+		}
+		query.PageToken = resp.NextPageToken
+	}
+}
+
+// batchDelete permanently deletes messages in BatchDelete-sized chunks, reporting the
+// running total after each chunk
+func batchDelete(mb *mailbox, ids []string, progress func(deleted int)) (int, error) {
+	deleted := 0
+	for start := 0; start < len(ids); start += deepclean.BatchLimit {
+		end := min(start+deepclean.BatchLimit, len(ids))
+
+		if err := mb.quota.Wait(mb.context(), costMessagesBatchDelete); err != nil {
+			return deleted, err
+		}
+		err := mb.provider.BatchDelete(mb.context(), mb.user, ids[start:end])
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete messages %d-%d: %w", start, end-1, err)
+		}
+
+		deleted = end
+		progress(deleted)
+	}
+	return deleted, nil
+}
+
+// HandleEmptyTrash previews permanently deleting everything in Trash, which is when
+// the storage freed by a cleanup is actually reclaimed
+func HandleEmptyTrash(w http.ResponseWriter, r *http.Request) {
+	handleEmptyLabel(w, r, "TRASH")
+}
+
+// HandleEmptySpam previews permanently deleting everything in Spam
+func HandleEmptySpam(w http.ResponseWriter, r *http.Request) {
+	handleEmptyLabel(w, r, "SPAM")
+}
+
+// handleEmptyLabel previews permanently deleting every message with a system label.
+// Confirming it with async=true reports the number deleted so far as job progress.
+func handleEmptyLabel(w http.ResponseWriter, r *http.Request, label string) {
+	if !requireFeature(w, Features().PermanentDelete, "permanent delete") {
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	kind := "empty-" + strings.ToLower(label)
+	handleOperationPreview(w, r, mb, kind, "label:"+label, func() ([]string, error) {
+		return listLabelMessageIDs(mb, label)
+	}, func(mb *mailbox, job *Job, ids []string) (interface{}, error) {
+		result := &EmptyResult{Label: label, Total: len(ids)}
+
+		var err error
+		result.Deleted, err = batchDelete(mb, ids, func(deleted int) {
+			Jobs.SetProgress(job, map[string]int{"deleted": deleted, "total": len(ids)})
+		})
+		if err != nil {
+			result.Error = redactError(err)
+		}
+		return result, nil
+	})
+}
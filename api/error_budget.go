@@ -0,0 +1,63 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ErrorBudget tracks failures across the items of a long-running operation (a scan or
+// a cleanup) and reports when the failure rate is too high to keep going. Stopping
+// early beats grinding on and producing silently incomplete results.
+type ErrorBudget struct {
+	// Highest tolerated fraction of failed items, e.g. 0.05 for 5%
+	MaxFailureRate float64
+	// Number of items to attempt before the rate is enforced, so a couple of
+	// early failures don't abort the whole operation
+	MinSample int
+
+	attempted int
+	failed    int
+	mu        sync.Mutex
+}
+
+// NewErrorBudget creates an ErrorBudget using the configured policy
+func NewErrorBudget() *ErrorBudget {
+	return &ErrorBudget{
+		MaxFailureRate: config.ErrorBudget,
+		MinSample:      config.ErrorBudgetMinSample,
+	}
+}
+
+// Record counts one attempted item, failed if err is non-nil
+func (b *ErrorBudget) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.attempted++
+	if err != nil {
+		b.failed++
+	}
+}
+
+// Exceeded reports whether the failure rate has gone over budget. It returns an
+// error describing the breach, or nil while the operation is within budget.
+func (b *ErrorBudget) Exceeded() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.MaxFailureRate <= 0 || b.attempted < b.MinSample {
+		return nil
+	}
+	rate := float64(b.failed) / float64(b.attempted)
+	if rate <= b.MaxFailureRate {
+		return nil
+	}
+	return fmt.Errorf("error budget exceeded: %d of %d items failed (%.1f%%, limit %.1f%%)",
+		b.failed, b.attempted, rate*100, b.MaxFailureRate*100)
+}
+
+// Counts returns the number of attempted and failed items so far
+func (b *ErrorBudget) Counts() (attempted, failed int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.attempted, b.failed
+}
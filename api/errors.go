@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// Machine-readable codes of error responses, so clients can react without parsing the
+// message, e.g. by signing in again on token_expired or backing off on rate_limited
+const (
+	ErrCodeInvalidRequest = "invalid_request"
+	ErrCodeUnauthorized   = "unauthorized"
+	ErrCodeTokenExpired   = "token_expired"
+	ErrCodeForbidden      = "forbidden"
+	// The session wasn't granted the OAuth scope an endpoint needs
+	ErrCodeInsufficientScope = "insufficient_scope"
+	ErrCodeNotFound          = "not_found"
+	ErrCodeConflict          = "conflict"
+	ErrCodeGone              = "gone"
+	ErrCodeTooLarge          = "too_large"
+	ErrCodeOutOfRange        = "out_of_range"
+	ErrCodeUnprocessable     = "unprocessable"
+	ErrCodeRateLimited       = "rate_limited"
+	ErrCodeInternal          = "internal"
+	ErrCodeNotImplemented    = "not_implemented"
+	ErrCodeUpstream          = "upstream_error"
+	ErrCodeUnavailable       = "unavailable"
+)
+
+// Default code of each status an error response may have
+var statusErrorCodes = map[int]string{
+	http.StatusBadRequest:                   ErrCodeInvalidRequest,
+	http.StatusUnauthorized:                 ErrCodeUnauthorized,
+	http.StatusForbidden:                    ErrCodeForbidden,
+	http.StatusNotFound:                     ErrCodeNotFound,
+	http.StatusConflict:                     ErrCodeConflict,
+	http.StatusGone:                         ErrCodeGone,
+	http.StatusRequestEntityTooLarge:        ErrCodeTooLarge,
+	http.StatusRequestedRangeNotSatisfiable: ErrCodeOutOfRange,
+	http.StatusUnprocessableEntity:          ErrCodeUnprocessable,
+	http.StatusTooManyRequests:              ErrCodeRateLimited,
+	http.StatusInternalServerError:          ErrCodeInternal,
+	http.StatusNotImplemented:               ErrCodeNotImplemented,
+	http.StatusBadGateway:                   ErrCodeUpstream,
+	http.StatusServiceUnavailable:           ErrCodeUnavailable,
+}
+
+// APIError is the body of every error response: {"error": {"code": ..., "message": ...}}
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError writes an error response with the default code for status
+func writeError(w http.ResponseWriter, message string, status int) {
+	code, ok := statusErrorCodes[status]
+	if !ok {
+		code = ErrCodeInternal
+	}
+	writeErrorCode(w, status, code, message)
+}
+
+// writeErrorCode writes an error response with a specific code
+func writeErrorCode(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]APIError{"error": {Code: code, Message: message}})
+}
+
+// writeErrorFrom writes an error response for err, prefixed with what failed. Gmail
+// rejecting the token, rate limiting the user or not finding something takes precedence
+// over status, since the client should react to those the same way everywhere.
+func writeErrorFrom(w http.ResponseWriter, prefix string, err error, status int) {
+	message := err.Error()
+	if prefix != "" {
+		message = prefix + ": " + message
+	}
+
+	// Google refusing to refresh the token means the user has to sign in again
+	var rerr *oauth2.RetrieveError
+	if errors.As(err, &rerr) {
+		writeErrorCode(w, http.StatusUnauthorized, ErrCodeTokenExpired, message)
+		return
+	}
+
+	if errors.Is(err, ErrNotSupported) {
+		writeErrorCode(w, http.StatusNotImplemented, ErrCodeNotImplemented, message)
+		return
+	}
+	if isScopeError(err) {
+		writeErrorCode(w, http.StatusForbidden, ErrCodeInsufficientScope, message)
+		return
+	}
+
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		switch {
+		case gerr.Code == http.StatusUnauthorized:
+			writeErrorCode(w, http.StatusUnauthorized, ErrCodeTokenExpired, message)
+			return
+		case gerr.Code == http.StatusTooManyRequests || isRateLimitReason(gerr):
+			writeErrorCode(w, http.StatusTooManyRequests, ErrCodeRateLimited, message)
+			return
+		case gerr.Code == http.StatusNotFound:
+			writeErrorCode(w, http.StatusNotFound, ErrCodeNotFound, message)
+			return
+		}
+	}
+	writeError(w, message, status)
+}
+
+// isRateLimitReason reports whether Gmail refused a request for exceeding a rate limit,
+// which it signals with 403 as well as 429
+func isRateLimitReason(gerr *googleapi.Error) bool {
+	for _, item := range gerr.Errors {
+		switch item.Reason {
+		case "rateLimitExceeded", "userRateLimitExceeded":
+			return true
+		}
+	}
+	return false
+}
+
+// isScopeError reports whether a change was refused because the session can only read
+// the mailbox, either by us or by Gmail, which answers 403 insufficientPermissions
+func isScopeError(err error) bool {
+	if errors.Is(err, errReadonlyAccess) {
+		return true
+	}
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range gerr.Errors {
+		if item.Reason == "insufficientPermissions" {
+			return true
+		}
+	}
+	return false
+}
+
+// RecoverPanics turns a panicking handler into a 500 error response instead of a
+// dropped connection, logging the stack trace
+func RecoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				log.Printf("Panic handling %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+				writeErrorCode(w, http.StatusInternalServerError, ErrCodeInternal, "Internal server error")
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Number of records copied out of the processor and written between flushes
+	exportChunkSize = 500
+	// How long a single chunk may take to reach a slow client before the export is dropped
+	exportWriteTimeout = 30 * time.Second
+)
+
+// HandleExportEmails streams every collected EmailMetadata record as newline-delimited
+// JSON (JSON Lines) using chunked transfer encoding.
+//
+// Records are copied out of the processor one chunk at a time and flushed before the
+// next chunk is read, so a slow client throttles the export instead of the server
+// buffering the whole cache. Interrupted downloads can be resumed with either an
+// `offset` query parameter or a `Range: records=N-` header.
+func HandleExportEmails(w http.ResponseWriter, r *http.Request) {
+	// Parse token from Authorization header
+	token, err := ParseToken(r)
+	if err != nil {
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := requestUserID(r, token)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+
+	// Get processor
+	processor, exists := Registry.Get(userID)
+	if !exists {
+		writeError(w, "No processing found for this user", http.StatusNotFound)
+		return
+	}
+
+	offset, err := parseExportOffset(r)
+	if err != nil {
+		writeErrorFrom(w, "Invalid export range", err, http.StatusBadRequest)
+		return
+	}
+
+	// Snapshot the record count so a resumed export covers a stable range even
+	// while a scan is still appending
+	total := processor.EmailCount()
+	// A resumed export starting at the end has no range left to describe
+	if offset > 0 && offset >= total {
+		w.Header().Set("Content-Range", fmt.Sprintf("records */%d", total))
+		writeError(w, "Export offset is past the end of the collected emails", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="inbox-export.jsonl"`)
+	w.Header().Set("Accept-Ranges", "records")
+	w.Header().Set("X-Total-Records", strconv.Itoa(total))
+	if offset > 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("records %d-%d/%d", offset, total-1, total))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+
+	written, err := streamEmails(w, r, processor, offset, total)
+	if err != nil {
+		log.Printf("Export aborted after %d of %d records: %s", offset+written, total, redactError(err))
+	}
+}
+
+// streamEmails writes records [offset, total) to w, returning how many were written
+func streamEmails(w http.ResponseWriter, r *http.Request, processor *InboxProcessor, offset, total int) (int, error) {
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	written := 0
+
+	for pos := offset; pos < total; pos += exportChunkSize {
+		if err := r.Context().Err(); err != nil {
+			return written, err
+		}
+
+		// Bound how long the client may stall before we give up on it
+		if err := rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return written, err
+		}
+
+		chunk := processor.EmailsRange(pos, min(exportChunkSize, total-pos))
+		for i := range chunk {
+			if err := enc.Encode(&chunk[i]); err != nil {
+				return written, err
+			}
+			written++
+		}
+
+		if err := rc.Flush(); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// parseExportOffset reads the resume position from the `offset` query parameter or
+// an open-ended `Range: records=N-` header
+func parseExportOffset(r *http.Request) (int, error) {
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		spec, ok := strings.CutPrefix(rangeHeader, "records=")
+		if !ok || !strings.HasSuffix(spec, "-") {
+			return 0, fmt.Errorf("only open-ended record ranges are supported, e.g. records=1000-")
+		}
+		offset, err := strconv.Atoi(strings.TrimSuffix(spec, "-"))
+		if err != nil || offset < 0 {
+			return 0, fmt.Errorf("invalid range start %q", spec)
+		}
+		return offset, nil
+	}
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, fmt.Errorf("invalid offset %q", v)
+		}
+		return offset, nil
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// FeatureFlags lets instance operators switch off risky capabilities for all users
+type FeatureFlags struct {
+	// Permanently delete messages instead of only moving them to trash
+	PermanentDelete bool `json:"permanentDelete"`
+	// Create Gmail filters (mute, auto-archive, block)
+	FilterCreation bool `json:"filterCreation"`
+	// Follow List-Unsubscribe links on the user's behalf
+	Unsubscribe bool `json:"unsubscribe"`
+	// Download full message bodies while scanning, not just headers
+	DeepBodyScan bool `json:"deepBodyScan"`
+	// Let users register webhooks the server POSTs events to
+	Webhooks bool `json:"webhooks"`
+	// Let users post cleanup summaries to Slack, ntfy and Pushover
+	Notifications bool `json:"notifications"`
+	// Let users set up cleanups the server runs on a schedule, such as retention
+	// policies, keeping their sign-in to run them with
+	ScheduledCleanup bool `json:"scheduledCleanup"`
+}
+
+// featureFlagsUpdate is a partial update of FeatureFlags; omitted fields are unchanged
+type featureFlagsUpdate struct {
+	PermanentDelete  *bool `json:"permanentDelete"`
+	FilterCreation   *bool `json:"filterCreation"`
+	Unsubscribe      *bool `json:"unsubscribe"`
+	DeepBodyScan     *bool `json:"deepBodyScan"`
+	Webhooks         *bool `json:"webhooks"`
+	Notifications    *bool `json:"notifications"`
+	ScheduledCleanup *bool `json:"scheduledCleanup"`
+}
+
+var (
+	features   FeatureFlags
+	featuresMu sync.RWMutex
+)
+
+// initFeatures sets the feature flags to the configured defaults
+func initFeatures() {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	features = config.Features
+}
+
+// Features returns the current feature flags
+func Features() FeatureFlags {
+	featuresMu.RLock()
+	defer featuresMu.RUnlock()
+	return features
+}
+
+// requireFeature writes a 403 response and returns false if a feature is disabled
+func requireFeature(w http.ResponseWriter, enabled bool, name string) bool {
+	if !enabled {
+		writeError(w, "The "+name+" feature is disabled on this server", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requireAdmin checks the X-Admin-Token header against ADMIN_TOKEN, writing an error
+// response and returning false if the request isn't from an operator
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if config.AdminToken == "" {
+		writeError(w, "Admin API is disabled (ADMIN_TOKEN not set)", http.StatusForbidden)
+		return false
+	}
+	if !isAdminRequest(r) {
+		writeError(w, "Invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// isAdminRequest reports whether a request carries the admin token
+func isAdminRequest(r *http.Request) bool {
+	provided := r.Header.Get("X-Admin-Token")
+	return config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(config.AdminToken)) == 1
+}
+
+// HandleGetFeatures returns the feature flags so the frontend can hide disabled actions
+func HandleGetFeatures(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, Features())
+}
+
+// HandleUpdateFeatures lets an operator enable or disable features at runtime
+func HandleUpdateFeatures(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var update featureFlagsUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+
+	featuresMu.Lock()
+	if update.PermanentDelete != nil {
+		features.PermanentDelete = *update.PermanentDelete
+	}
+	if update.FilterCreation != nil {
+		features.FilterCreation = *update.FilterCreation
+	}
+	if update.Unsubscribe != nil {
+		features.Unsubscribe = *update.Unsubscribe
+	}
+	if update.DeepBodyScan != nil {
+		features.DeepBodyScan = *update.DeepBodyScan
+	}
+	if update.Webhooks != nil {
+		features.Webhooks = *update.Webhooks
+	}
+	if update.Notifications != nil {
+		features.Notifications = *update.Notifications
+	}
+	if update.ScheduledCleanup != nil {
+		features.ScheduledCleanup = *update.ScheduledCleanup
+	}
+	current := features
+	featuresMu.Unlock()
+
+	writeJSON(w, current)
+}
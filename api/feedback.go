@@ -0,0 +1,208 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+	"github.com/gorilla/mux"
+)
+
+// Serializes read-modify-write updates of feedback
+var feedbackMu sync.Mutex
+
+// ClassificationFeedback holds a user's corrections to the classifier. Corrections
+// outrank the classifier on every later run: a message override beats a sender override,
+// which beats the classifier's own verdict.
+type ClassificationFeedback struct {
+	// Category overrides by sender address
+	Senders map[string]string `json:"senders"`
+	// Category overrides by message ID
+	Messages map[string]string `json:"messages"`
+	// Senders the user marked important. Their mail is treated as personal unless
+	// overridden and is never recommended for cleanup.
+	Important map[string]bool `json:"important"`
+}
+
+// feedbackKey is the storage key of an account's classification feedback
+func feedbackKey(account string) string {
+	return "feedback/" + account
+}
+
+// loadFeedback returns an account's saved classification feedback
+func loadFeedback(account string) (*ClassificationFeedback, error) {
+	feedback := &ClassificationFeedback{}
+	if _, err := Storage.Get(feedbackKey(account), feedback); err != nil {
+		return nil, fmt.Errorf("failed to load classification feedback: %w", err)
+	}
+	if feedback.Senders == nil {
+		feedback.Senders = make(map[string]string)
+	}
+	if feedback.Messages == nil {
+		feedback.Messages = make(map[string]string)
+	}
+	if feedback.Important == nil {
+		feedback.Important = make(map[string]bool)
+	}
+	return feedback, nil
+}
+
+// apply returns the category of a message after the user's corrections
+func (f *ClassificationFeedback) apply(e *EmailMetadata, classified string) string {
+	if category, ok := f.Messages[e.ID]; ok {
+		return category
+	}
+	sender := strings.ToLower(e.From)
+	if category, ok := f.Senders[sender]; ok {
+		return category
+	}
+	if f.Important[sender] {
+		return deepclean.CategoryPersonal
+	}
+	return classified
+}
+
+// feedback loads the classification feedback for the processor's mailbox. Classification
+// goes ahead without it if it can't be loaded.
+func (p *InboxProcessor) feedback() *ClassificationFeedback {
+	mb := &mailbox{provider: p.provider, user: p.user, userID: p.userID, quota: p.quota}
+	account, err := mb.account()
+	if err == nil {
+		var feedback *ClassificationFeedback
+		if feedback, err = loadFeedback(account); err == nil {
+			return feedback
+		}
+	}
+	log.Printf("Classifying without feedback: %s", redactError(err))
+	return &ClassificationFeedback{}
+}
+
+// updateFeedback applies change to the request's account's feedback, saves it and
+// reclassifies the user's scanned mail so the correction shows up right away
+func updateFeedback(w http.ResponseWriter, r *http.Request, change func(f *ClassificationFeedback)) {
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	feedbackMu.Lock()
+	defer feedbackMu.Unlock()
+
+	feedback, err := loadFeedback(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	change(feedback)
+	if err := Storage.Put(feedbackKey(account), feedback); err != nil {
+		writeErrorFrom(w, "Failed to save feedback", err, http.StatusInternalServerError)
+		return
+	}
+
+	if processor, exists := Registry.Get(mb.userID); exists {
+		processor.classifyAll()
+	}
+
+	writeJSON(w, feedback)
+}
+
+// feedbackRequest is the body of the feedback endpoints
+type feedbackRequest struct {
+	// Correct category, or empty to drop an earlier correction
+	Category *string `json:"category"`
+	// Whether the sender is important; only applies to senders
+	Important *bool `json:"important"`
+}
+
+// decodeFeedbackRequest reads and validates a feedback request body
+func decodeFeedbackRequest(w http.ResponseWriter, r *http.Request) (*feedbackRequest, bool) {
+	var req feedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return nil, false
+	}
+	if req.Category != nil {
+		if err := validateCategory(*req.Category); err != nil {
+			writeErrorFrom(w, "", err, http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	return &req, true
+}
+
+// HandleGetFeedback returns the user's classification corrections
+func HandleGetFeedback(w http.ResponseWriter, r *http.Request) {
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	feedback, err := loadFeedback(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, feedback)
+}
+
+// HandleSenderFeedback corrects the category of all mail from a sender, e.g. "this is
+// not a newsletter", and/or marks the sender important
+func HandleSenderFeedback(w http.ResponseWriter, r *http.Request) {
+	sender := strings.ToLower(mux.Vars(r)["email"])
+	req, ok := decodeFeedbackRequest(w, r)
+	if !ok {
+		return
+	}
+
+	updateFeedback(w, r, func(f *ClassificationFeedback) {
+		if req.Category != nil {
+			if *req.Category == "" {
+				delete(f.Senders, sender)
+			} else {
+				f.Senders[sender] = *req.Category
+			}
+		}
+		if req.Important != nil {
+			if *req.Important {
+				f.Important[sender] = true
+			} else {
+				delete(f.Important, sender)
+			}
+		}
+	})
+}
+
+// HandleMessageFeedback corrects the category of a single message
+func HandleMessageFeedback(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+	req, ok := decodeFeedbackRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Category == nil {
+		writeError(w, "A category is required", http.StatusBadRequest)
+		return
+	}
+
+	updateFeedback(w, r, func(f *ClassificationFeedback) {
+		if *req.Category == "" {
+			delete(f.Messages, messageID)
+		} else {
+			f.Messages[messageID] = *req.Category
+		}
+	})
+}
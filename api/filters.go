@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/api/gmail/v1"
+)
+
+// What a sender filter does with future mail
+const (
+	filterActionArchive = "archive"
+	filterActionLabel   = "label"
+	filterActionDelete  = "delete"
+)
+
+// senderFilterRequest is the body of HandleCreateSenderFilter
+type senderFilterRequest struct {
+	// archive, label or delete
+	Action string `json:"action"`
+	// Label to apply for the label action; created if it doesn't exist
+	Label string `json:"label"`
+	// Also keep labeled mail out of the inbox
+	SkipInbox bool `json:"skipInbox"`
+}
+
+// HandleCreateSenderFilter creates a Gmail filter that handles all future mail from a
+// sender: archive it, label it or send it straight to trash. Existing mail is left as
+// is, so this pairs with the sender cleanup endpoints to keep it from piling up again.
+func HandleCreateSenderFilter(w http.ResponseWriter, r *http.Request) {
+	sender := mux.Vars(r)["email"]
+
+	if !requireFeature(w, Features().FilterCreation, "filter creation") {
+		return
+	}
+
+	var req senderFilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	req.Label = strings.TrimSpace(req.Label)
+	switch req.Action {
+	case filterActionArchive, filterActionDelete:
+	case filterActionLabel:
+		if req.Label == "" {
+			writeError(w, "A label is required for the label action", http.StatusBadRequest)
+			return
+		}
+	default:
+		writeError(w, "action must be archive, label or delete", http.StatusBadRequest)
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+	if req.Action == filterActionDelete && !refuseProtectedSenders(w, mb, []string{sender}) {
+		return
+	}
+
+	action := &gmail.FilterAction{}
+	switch req.Action {
+	case filterActionArchive:
+		action.RemoveLabelIds = []string{"INBOX"}
+	case filterActionDelete:
+		action = deleteFilterAction()
+	case filterActionLabel:
+		labelID, err := findOrCreateLabel(mb, req.Label)
+		if err != nil {
+			writeErrorFrom(w, "Failed to prepare label", err, http.StatusInternalServerError)
+			return
+		}
+		action.AddLabelIds = []string{labelID}
+		if req.SkipInbox {
+			action.RemoveLabelIds = []string{"INBOX"}
+		}
+	}
+
+	filter, err := createSenderFilter(mb, sender, action)
+	if req.Action == filterActionDelete {
+		recordAudit(mb, "filter-delete", SenderQuery(sender), nil, err)
+	}
+	if err != nil {
+		writeErrorFrom(w, "Failed to create filter", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"sender":   sender,
+		"action":   req.Action,
+		"filterId": filter.Id,
+	})
+}
+
+// refuseProtectedSenders checks that a filter deleting mail from senders wouldn't delete
+// mail the protection rules cover, writing an error response and returning false if it
+// would
+func refuseProtectedSenders(w http.ResponseWriter, mb *mailbox, senders []string) bool {
+	protected, err := protectedSenders(mb, senders)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return false
+	}
+	if len(protected) > 0 {
+		writeError(w, "Protection rules cover "+strings.Join(protected, ", ")+"; remove them from the rules before deleting their mail with a filter", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// deleteFilterAction returns the filter action that deletes mail on arrival. Filters
+// can only trash; Gmail deletes it for good after 30 days.
+func deleteFilterAction() *gmail.FilterAction {
+	return &gmail.FilterAction{
+		AddLabelIds:    []string{"TRASH"},
+		RemoveLabelIds: []string{"INBOX"},
+	}
+}
+
+// createSenderFilter creates a filter applying action to all future mail from sender
+func createSenderFilter(mb *mailbox, sender string, action *gmail.FilterAction) (*gmail.Filter, error) {
+	if err := mb.quota.Wait(context.Background(), costFiltersCreate); err != nil {
+		return nil, err
+	}
+	return mb.provider.CreateFilter(mb.context(), mb.user, &gmail.Filter{
+		Criteria: &gmail.FilterCriteria{From: sender},
+		Action:   action,
+	})
+}
+
+// findOrCreateLabel returns the ID of the user label with the given name, creating it
+// if needed
+func findOrCreateLabel(mb *mailbox, name string) (string, error) {
+	labels, err := listLabels(mb)
+	if err != nil {
+		return "", err
+	}
+	for _, label := range labels {
+		if strings.EqualFold(label.Name, name) {
+			return label.Id, nil
+		}
+	}
+
+	if err := mb.quota.Wait(context.Background(), costLabelsCreate); err != nil {
+		return "", err
+	}
+	label, err := mb.provider.CreateLabel(mb.context(), mb.user, &gmail.Label{
+		Name:                  name,
+		LabelListVisibility:   "labelShow",
+		MessageListVisibility: "show",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create label: %w", err)
+	}
+	return label.Id, nil
+}
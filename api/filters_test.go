@@ -0,0 +1,20 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDeleteFilterRefusedForProtectedSender(t *testing.T) {
+	user := newTestUser(t)
+	if err := Storage.Put(protectionKey(user.account()), &ProtectionRules{
+		Senders: []string{"boss@work.example"},
+		Domains: []string{},
+		Labels:  []string{},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := user.request(t, http.MethodPost, "/api/v1/senders/boss@work.example/filter", senderFilterRequest{Action: filterActionDelete}, map[string]string{"email": "boss@work.example"})
+	decodeResponse(t, serve(HandleCreateSenderFilter, r), http.StatusConflict, nil)
+}
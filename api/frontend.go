@@ -0,0 +1,53 @@
+package api
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// Where the frontend is served from without FRONTEND_DIR or an embedded build
+const defaultFrontendDir = "frontend/dist"
+
+// FrontendHandler serves the built frontend. FRONTEND_DIR serves it from disk, which is
+// handy while developing the frontend; otherwise the files embedded in the binary are
+// used, or frontend/dist if it was built without them. Paths that aren't files get
+// index.html, so the app's client-side routes survive a reload.
+func FrontendHandler(embedded fs.FS) http.Handler {
+	files := embedded
+	switch {
+	case config.FrontendDir != "":
+		files = os.DirFS(config.FrontendDir)
+	case files == nil:
+		files = os.DirFS(defaultFrontendDir)
+	}
+	fileServer := http.FileServer(http.FS(files))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if name == "" {
+			name = "."
+		}
+
+		if _, err := fs.Stat(files, name); err != nil {
+			// Missing assets are real 404s; anything else is a client-side route
+			if path.Ext(name) != "" || strings.HasPrefix(name, "auth/") {
+				writeError(w, "Not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-cache")
+			http.ServeFileFS(w, r, files, "index.html")
+			return
+		}
+
+		// Vite puts a content hash in asset names, so they never change
+		if strings.HasPrefix(name, "assets/") {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
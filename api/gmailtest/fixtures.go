@@ -0,0 +1,151 @@
+package gmailtest
+
+import (
+	"fmt"
+	"time"
+)
+
+// FixtureTime is when the fixture mailbox was last delivered to; fixture dates count
+// back from it
+var FixtureTime = time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+// Fixtures returns a small mailbox with the kinds of mail the app sorts out: a weekly
+// newsletter with unsubscribe headers, shop promotions, receipts with PDF attachments,
+// a personal thread with a reply, notifications, and a message each in Spam and Trash.
+// It is the same on every call, so tests can assert on exact counts.
+func Fixtures() []Message {
+	messages := make([]Message, 0, 24)
+	day := func(n int) time.Time { return FixtureTime.AddDate(0, 0, -n) }
+
+	for i := 0; i < 8; i++ {
+		labels := []string{"CATEGORY_UPDATES"}
+		if i < 3 {
+			labels = append(labels, "INBOX", "UNREAD")
+		}
+		messages = append(messages, Message{
+			ID:       fmt.Sprintf("news%02d", i),
+			From:     "Weekly Digest <news@weekly.example>",
+			To:       DefaultEmailAddress,
+			Subject:  fmt.Sprintf("Weekly digest #%d", 120-i),
+			Date:     day(7 * i),
+			LabelIDs: labels,
+			Body:     "This week's top stories, picked for you.",
+			Headers: map[string]string{
+				"List-Id":               "Weekly Digest <digest.weekly.example>",
+				"List-Unsubscribe":      "<https://weekly.example/unsubscribe?u=1>, <mailto:unsubscribe@weekly.example>",
+				"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+				"Precedence":            "bulk",
+			},
+		})
+	}
+
+	for i := 0; i < 6; i++ {
+		messages = append(messages, Message{
+			ID:       fmt.Sprintf("promo%02d", i),
+			From:     "Gadget Shop <deals@gadgetshop.example>",
+			To:       DefaultEmailAddress,
+			Subject:  fmt.Sprintf("%d%% off everything this weekend", 10+5*i),
+			Date:     day(3 + 11*i),
+			LabelIDs: []string{"CATEGORY_PROMOTIONS", "UNREAD"},
+			Body:     "Our biggest sale of the season starts now.",
+			Headers: map[string]string{
+				"List-Unsubscribe": "<https://gadgetshop.example/unsubscribe>",
+			},
+		})
+	}
+
+	for i := 0; i < 3; i++ {
+		messages = append(messages, Message{
+			ID:       fmt.Sprintf("receipt%02d", i),
+			From:     "Gadget Shop <orders@gadgetshop.example>",
+			To:       DefaultEmailAddress,
+			Subject:  fmt.Sprintf("Your order #%d", 5000+i),
+			Date:     day(20 + 30*i),
+			LabelIDs: []string{"INBOX", "CATEGORY_UPDATES"},
+			Body:     "Thanks for your order. Your receipt is attached.",
+			Attachments: []Attachment{{
+				Filename: fmt.Sprintf("receipt-%d.pdf", 5000+i),
+				MimeType: "application/pdf",
+				Data:     fixtureData(i, 40_000+i*20_000),
+			}},
+		})
+	}
+
+	messages = append(messages,
+		Message{
+			ID:       "thread00",
+			From:     "Alex Rivera <alex@friends.example>",
+			To:       DefaultEmailAddress,
+			Subject:  "Dinner on Friday?",
+			Date:     day(2),
+			LabelIDs: []string{"INBOX", "CATEGORY_PERSONAL", "IMPORTANT"},
+			Body:     "Are you free on Friday? There's a new place downtown.",
+		},
+		Message{
+			ID:       "thread01",
+			ThreadID: "thread00",
+			From:     "Me <" + DefaultEmailAddress + ">",
+			To:       "Alex Rivera <alex@friends.example>",
+			Subject:  "Re: Dinner on Friday?",
+			Date:     day(1),
+			LabelIDs: []string{"SENT"},
+			Body:     "Sounds great, see you at 7.",
+		},
+		Message{
+			ID:       "thread02",
+			ThreadID: "thread00",
+			From:     "Alex Rivera <alex@friends.example>",
+			To:       DefaultEmailAddress,
+			Subject:  "Re: Dinner on Friday?",
+			Date:     day(1).Add(time.Hour),
+			LabelIDs: []string{"INBOX", "CATEGORY_PERSONAL", "IMPORTANT", "UNREAD", "STARRED"},
+			Body:     "Perfect. I'll book a table.",
+		},
+	)
+
+	for i := 0; i < 4; i++ {
+		messages = append(messages, Message{
+			ID:       fmt.Sprintf("notify%02d", i),
+			From:     "Social Network <no-reply@social.example>",
+			To:       DefaultEmailAddress,
+			Subject:  fmt.Sprintf("You have %d new notifications", i+2),
+			Date:     day(5 + 90*i),
+			LabelIDs: []string{"CATEGORY_SOCIAL"},
+			Body:     "See what your friends have been up to.",
+			Headers: map[string]string{
+				"Auto-Submitted": "auto-generated",
+			},
+		})
+	}
+
+	messages = append(messages,
+		Message{
+			ID:       "spam00",
+			From:     "Prize Desk <winner@prizes.example>",
+			To:       DefaultEmailAddress,
+			Subject:  "You have been selected!!!",
+			Date:     day(4),
+			LabelIDs: []string{"SPAM", "UNREAD"},
+			Body:     "Claim your prize now.",
+		},
+		Message{
+			ID:       "trash00",
+			From:     "Gadget Shop <deals@gadgetshop.example>",
+			To:       DefaultEmailAddress,
+			Subject:  "Last chance: free shipping",
+			Date:     day(10),
+			LabelIDs: []string{"TRASH", "CATEGORY_PROMOTIONS"},
+			Body:     "Free shipping ends tonight.",
+		},
+	)
+	return messages
+}
+
+// fixtureData returns size bytes that differ per seed but not between runs
+func fixtureData(seed, size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte((i*31 + seed*17) % 251)
+	}
+	return data
+}
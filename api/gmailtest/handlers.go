@@ -0,0 +1,328 @@
+package gmailtest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Most IDs batchModify and batchDelete take per call
+const maxBatchIDs = 1000
+
+// page returns the offset and size of the page a list request asks for
+func page(r *http.Request, defaultSize, maxSize int) (offset, size int, ok bool) {
+	size = defaultSize
+	if s := r.FormValue("maxResults"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		size = min(n, maxSize)
+	}
+	if token := r.FormValue("pageToken"); token != "" {
+		n, err := strconv.Atoi(token)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return offset, size, true
+}
+
+func (s *Server) getProfile(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	threads := make(map[string]bool)
+	for _, msg := range s.mailbox.byID {
+		threads[msg.ThreadID] = true
+	}
+	writeJSON(w, &gmail.Profile{
+		EmailAddress:  s.EmailAddress,
+		MessagesTotal: int64(len(s.mailbox.byID)),
+		ThreadsTotal:  int64(len(threads)),
+		HistoryId:     s.mailbox.historyID,
+	})
+}
+
+func (s *Server) listMessages(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	offset, size, ok := page(r, 100, 500)
+	if !ok {
+		badRequest(w, "Invalid maxResults or pageToken")
+		return
+	}
+	q, err := parseQuery(r.FormValue("q"))
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	labelIDs := r.Form["labelIds"]
+	// Like Gmail, skip Spam and Trash unless asked for them
+	includeSpamTrash := r.FormValue("includeSpamTrash") == "true" || q.anywhere ||
+		slices.ContainsFunc(append(slices.Clone(labelIDs), q.labels()...), func(label string) bool { return label == "SPAM" || label == "TRASH" })
+
+	matched := make([]*gmail.Message, 0)
+	for _, msg := range s.mailbox.sorted() {
+		if !includeSpamTrash && (slices.Contains(msg.LabelIDs, "SPAM") || slices.Contains(msg.LabelIDs, "TRASH")) {
+			continue
+		}
+		if slices.ContainsFunc(labelIDs, func(label string) bool { return !slices.Contains(msg.LabelIDs, label) }) || !q.matches(msg, s.mailbox) {
+			continue
+		}
+		matched = append(matched, &gmail.Message{Id: msg.ID, ThreadId: msg.ThreadID})
+	}
+
+	resp := &gmail.ListMessagesResponse{ResultSizeEstimate: int64(len(matched))}
+	if offset < len(matched) {
+		resp.Messages = matched[offset:min(offset+size, len(matched))]
+	}
+	if offset+size < len(matched) {
+		resp.NextPageToken = strconv.Itoa(offset + size)
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) getMessage(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	msg, ok := s.mailbox.byID[vars["id"]]
+	if !ok {
+		notFound(w)
+		return
+	}
+	r.ParseForm()
+	writeJSON(w, msg.format(r.FormValue("format"), r.Form["metadataHeaders"]))
+}
+
+// decode reads a request body, writing Gmail's error if it isn't valid
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		badRequest(w, "Invalid JSON payload received. "+err.Error())
+		return false
+	}
+	return true
+}
+
+// checkLabels writes Gmail's error and returns false if a label doesn't exist
+func (s *Server) checkLabels(w http.ResponseWriter, labels ...[]string) bool {
+	for _, ids := range labels {
+		for _, id := range ids {
+			if !s.mailbox.labelExists(id) {
+				badRequest(w, "Invalid label: "+id)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *Server) modifyMessage(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	var req gmail.ModifyMessageRequest
+	if !decode(w, r, &req) || !s.checkLabels(w, req.AddLabelIds, req.RemoveLabelIds) {
+		return
+	}
+	msg, ok := s.mailbox.byID[vars["id"]]
+	if !ok {
+		notFound(w)
+		return
+	}
+	s.mailbox.modify([]*message{msg}, req.AddLabelIds, req.RemoveLabelIds)
+	writeJSON(w, msg.minimal())
+}
+
+func (s *Server) trashMessage(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	msg, ok := s.mailbox.byID[vars["id"]]
+	if !ok {
+		notFound(w)
+		return
+	}
+	s.mailbox.modify([]*message{msg}, []string{"TRASH"}, []string{"SPAM"})
+	writeJSON(w, msg.minimal())
+}
+
+func (s *Server) untrashMessage(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	msg, ok := s.mailbox.byID[vars["id"]]
+	if !ok {
+		notFound(w)
+		return
+	}
+	s.mailbox.modify([]*message{msg}, nil, []string{"TRASH"})
+	writeJSON(w, msg.minimal())
+}
+
+// batchMessages returns the messages of a batch request, skipping unknown IDs like
+// Gmail does, or writes an error if there are too many
+func (s *Server) batchMessages(w http.ResponseWriter, ids []string) ([]*message, bool) {
+	if len(ids) > maxBatchIDs {
+		badRequest(w, "Too many ids in the request")
+		return nil, false
+	}
+	messages := make([]*message, 0, len(ids))
+	for _, id := range ids {
+		if msg, ok := s.mailbox.byID[id]; ok {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, true
+}
+
+func (s *Server) batchModify(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	var req gmail.BatchModifyMessagesRequest
+	if !decode(w, r, &req) || !s.checkLabels(w, req.AddLabelIds, req.RemoveLabelIds) {
+		return
+	}
+	messages, ok := s.batchMessages(w, req.Ids)
+	if !ok {
+		return
+	}
+	s.mailbox.modify(messages, req.AddLabelIds, req.RemoveLabelIds)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) batchDelete(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	var req gmail.BatchDeleteMessagesRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	messages, ok := s.batchMessages(w, req.Ids)
+	if !ok {
+		return
+	}
+	if len(messages) > 0 {
+		s.mailbox.remove(messages...)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getAttachment(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	msg, ok := s.mailbox.byID[vars["messageId"]]
+	if !ok {
+		notFound(w)
+		return
+	}
+	for i, att := range msg.Attachments {
+		if msg.attachmentID(i) == vars["id"] {
+			writeJSON(w, &gmail.MessagePartBody{
+				AttachmentId: vars["id"],
+				Size:         int64(len(att.Data)),
+				Data:         base64.URLEncoding.EncodeToString(att.Data),
+			})
+			return
+		}
+	}
+	notFound(w)
+}
+
+func (s *Server) getThread(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	messages := s.mailbox.thread(vars["id"])
+	if len(messages) == 0 {
+		notFound(w)
+		return
+	}
+	r.ParseForm()
+	thread := &gmail.Thread{Id: vars["id"], Snippet: messages[len(messages)-1].minimal().Snippet}
+	for _, msg := range messages {
+		thread.Messages = append(thread.Messages, msg.format(r.FormValue("format"), r.Form["metadataHeaders"]))
+		thread.HistoryId = max(thread.HistoryId, msg.historyID)
+	}
+	writeJSON(w, thread)
+}
+
+func (s *Server) modifyThread(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	var req gmail.ModifyThreadRequest
+	if !decode(w, r, &req) || !s.checkLabels(w, req.AddLabelIds, req.RemoveLabelIds) {
+		return
+	}
+	messages := s.mailbox.thread(vars["id"])
+	if len(messages) == 0 {
+		notFound(w)
+		return
+	}
+	s.mailbox.modify(messages, req.AddLabelIds, req.RemoveLabelIds)
+	writeJSON(w, &gmail.Thread{Id: vars["id"]})
+}
+
+func (s *Server) listLabels(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	labels := make([]*gmail.Label, 0, len(systemLabels)+len(s.mailbox.labels))
+	for _, id := range systemLabels {
+		labels = append(labels, &gmail.Label{Id: id, Name: id, Type: "system"})
+	}
+	ids := make([]string, 0, len(s.mailbox.labels))
+	for id := range s.mailbox.labels {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		labels = append(labels, s.mailbox.labels[id])
+	}
+	writeJSON(w, &gmail.ListLabelsResponse{Labels: labels})
+}
+
+func (s *Server) createLabel(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	var req gmail.Label
+	if !decode(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		badRequest(w, "Invalid label name")
+		return
+	}
+	for _, label := range s.mailbox.labels {
+		if strings.EqualFold(label.Name, req.Name) {
+			writeError(w, http.StatusConflict, "alreadyExists", "Label name exists or conflicts")
+			return
+		}
+	}
+	writeJSON(w, s.mailbox.createLabel(req.Name))
+}
+
+// listHistory lists the changes after startHistoryId, optionally only those of some
+// types or touching a label
+func (s *Server) listHistory(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	start, err := strconv.ParseUint(r.FormValue("startHistoryId"), 10, 64)
+	if err != nil {
+		badRequest(w, "Invalid startHistoryId")
+		return
+	}
+	offset, size, ok := page(r, 100, 500)
+	if !ok {
+		badRequest(w, "Invalid maxResults or pageToken")
+		return
+	}
+	types, label := r.Form["historyTypes"], r.FormValue("labelId")
+
+	matched := make([]*gmail.History, 0)
+	for _, change := range s.mailbox.history {
+		if change.Id <= start || !historyMatches(change, types, label) {
+			continue
+		}
+		matched = append(matched, change)
+	}
+
+	resp := &gmail.ListHistoryResponse{HistoryId: s.mailbox.historyID}
+	if offset < len(matched) {
+		resp.History = matched[offset:min(offset+size, len(matched))]
+	}
+	if offset+size < len(matched) {
+		resp.NextPageToken = strconv.Itoa(offset + size)
+	}
+	writeJSON(w, resp)
+}
+
+// historyMatches reports whether a change is of one of the types, if any are given, and
+// touches the label, if one is given
+func historyMatches(change *gmail.History, types []string, label string) bool {
+	var kind string
+	var labels []string
+	switch {
+	case len(change.MessagesAdded) > 0:
+		kind, labels = "messageAdded", change.MessagesAdded[0].Message.LabelIds
+	case len(change.MessagesDeleted) > 0:
+		kind, labels = "messageDeleted", change.MessagesDeleted[0].Message.LabelIds
+	case len(change.LabelsAdded) > 0:
+		kind, labels = "labelAdded", change.LabelsAdded[0].LabelIds
+	case len(change.LabelsRemoved) > 0:
+		kind, labels = "labelRemoved", change.LabelsRemoved[0].LabelIds
+	}
+	return (len(types) == 0 || slices.Contains(types, kind)) && (label == "" || slices.Contains(labels, label))
+}
@@ -0,0 +1,315 @@
+package gmailtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Message is a message to put in a fake mailbox. IDs are assigned if empty, and a
+// message without a thread ID starts its own thread.
+type Message struct {
+	ID       string
+	ThreadID string
+	From     string
+	To       string
+	Subject  string
+	Date     time.Time
+	LabelIDs []string
+	Body     string
+	// Headers besides From, To, Subject and Date, such as List-Unsubscribe
+	Headers     map[string]string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to a Message
+type Attachment struct {
+	Filename string
+	MimeType string
+	Data     []byte
+}
+
+// message is a Message in the mailbox, with its rendered forms
+type message struct {
+	Message
+	historyID uint64
+	payload   *gmail.MessagePart
+	raw       []byte
+}
+
+// mailbox is the state of a fake mailbox. Callers must hold the server's lock.
+type mailbox struct {
+	byID   map[string]*message
+	labels map[string]*gmail.Label
+	// Changes, oldest first, and the history ID of the latest
+	history   []*gmail.History
+	historyID uint64
+	nextID    int
+}
+
+func newMailbox() *mailbox {
+	return &mailbox{
+		byID:      make(map[string]*message),
+		labels:    make(map[string]*gmail.Label),
+		historyID: 1000,
+	}
+}
+
+// newID returns an ID for a new message or label
+func (m *mailbox) newID() string {
+	m.nextID++
+	return fmt.Sprintf("%016x", 0x18f0000000000000+m.nextID)
+}
+
+// record adds a change to the history and stamps the messages it touched
+func (m *mailbox) record(change *gmail.History, messages ...*message) {
+	m.historyID++
+	change.Id = m.historyID
+	for _, msg := range messages {
+		msg.historyID = m.historyID
+		change.Messages = append(change.Messages, &gmail.Message{Id: msg.ID, ThreadId: msg.ThreadID})
+	}
+	m.history = append(m.history, change)
+}
+
+// add renders a message and puts it in the mailbox
+func (m *mailbox) add(in Message) *message {
+	msg := &message{Message: in}
+	if msg.ID == "" {
+		msg.ID = m.newID()
+	}
+	if msg.ThreadID == "" {
+		msg.ThreadID = msg.ID
+	}
+	if msg.Date.IsZero() {
+		msg.Date = time.Now()
+	}
+	msg.Date = msg.Date.Truncate(time.Second)
+	msg.LabelIDs = slices.Clone(msg.LabelIDs)
+	msg.render()
+	m.byID[msg.ID] = msg
+
+	m.record(&gmail.History{MessagesAdded: []*gmail.HistoryMessageAdded{{Message: msg.minimal()}}}, msg)
+	return msg
+}
+
+// remove deletes messages for good
+func (m *mailbox) remove(messages ...*message) {
+	change := &gmail.History{}
+	for _, msg := range messages {
+		delete(m.byID, msg.ID)
+		change.MessagesDeleted = append(change.MessagesDeleted, &gmail.HistoryMessageDeleted{Message: msg.minimal()})
+	}
+	m.record(change, messages...)
+}
+
+// labelExists reports whether a label ID is a system label or one created in the mailbox
+func (m *mailbox) labelExists(id string) bool {
+	return slices.Contains(systemLabels, id) || m.labels[id] != nil
+}
+
+// createLabel creates a user label
+func (m *mailbox) createLabel(name string) *gmail.Label {
+	label := &gmail.Label{
+		Id:                    "Label_" + strconv.Itoa(len(m.labels)+1),
+		Name:                  name,
+		Type:                  "user",
+		LabelListVisibility:   "labelShow",
+		MessageListVisibility: "show",
+	}
+	m.labels[label.Id] = label
+	return label
+}
+
+// modify changes the labels of messages, recording a change for each message whose
+// labels actually changed
+func (m *mailbox) modify(messages []*message, add, remove []string) {
+	for _, msg := range messages {
+		added, removed := make([]string, 0), make([]string, 0)
+		for _, label := range remove {
+			if i := slices.Index(msg.LabelIDs, label); i >= 0 {
+				msg.LabelIDs = slices.Delete(msg.LabelIDs, i, i+1)
+				removed = append(removed, label)
+			}
+		}
+		for _, label := range add {
+			if !slices.Contains(msg.LabelIDs, label) {
+				msg.LabelIDs = append(msg.LabelIDs, label)
+				added = append(added, label)
+			}
+		}
+		if len(added) > 0 {
+			m.record(&gmail.History{LabelsAdded: []*gmail.HistoryLabelAdded{{Message: msg.minimal(), LabelIds: added}}}, msg)
+		}
+		if len(removed) > 0 {
+			m.record(&gmail.History{LabelsRemoved: []*gmail.HistoryLabelRemoved{{Message: msg.minimal(), LabelIds: removed}}}, msg)
+		}
+	}
+}
+
+// thread returns the messages of a thread, oldest first
+func (m *mailbox) thread(id string) []*message {
+	messages := make([]*message, 0)
+	for _, msg := range m.byID {
+		if msg.ThreadID == id {
+			messages = append(messages, msg)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Date.Before(messages[j].Date) })
+	return messages
+}
+
+// sorted returns every message, newest first like Gmail lists them
+func (m *mailbox) sorted() []*message {
+	messages := make([]*message, 0, len(m.byID))
+	for _, msg := range m.byID {
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].Date.Equal(messages[j].Date) {
+			return messages[i].Date.After(messages[j].Date)
+		}
+		return messages[i].ID > messages[j].ID
+	})
+	return messages
+}
+
+// headers returns the message's top-level headers in order
+func (msg *message) headers() []*gmail.MessagePartHeader {
+	headers := []*gmail.MessagePartHeader{
+		{Name: "From", Value: msg.From},
+		{Name: "To", Value: msg.To},
+		{Name: "Subject", Value: msg.Subject},
+		{Name: "Date", Value: msg.Date.Format(time.RFC1123Z)},
+		{Name: "Message-ID", Value: "<" + msg.ID + "@gmailtest.example>"},
+	}
+	names := make([]string, 0, len(msg.Headers))
+	for name := range msg.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		headers = append(headers, &gmail.MessagePartHeader{Name: name, Value: msg.Headers[name]})
+	}
+	return headers
+}
+
+// header returns the value of a header, matching its name case-insensitively
+func (msg *message) header(name string) string {
+	for _, h := range msg.headers() {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// attachmentID returns the ID of the message's nth attachment
+func (msg *message) attachmentID(n int) string {
+	return fmt.Sprintf("att-%s-%d", msg.ID, n)
+}
+
+// render builds the message's payload and RFC 822 form
+func (msg *message) render() {
+	var raw bytes.Buffer
+	for _, h := range msg.headers() {
+		raw.WriteString(h.Name + ": " + h.Value + "\r\n")
+	}
+	text := &gmail.MessagePart{
+		MimeType: "text/plain",
+		Headers:  []*gmail.MessagePartHeader{{Name: "Content-Type", Value: "text/plain; charset=UTF-8"}},
+		Body:     &gmail.MessagePartBody{Size: int64(len(msg.Body)), Data: base64.URLEncoding.EncodeToString([]byte(msg.Body))},
+	}
+
+	if len(msg.Attachments) == 0 {
+		text.Headers = append(msg.headers(), text.Headers...)
+		msg.payload = text
+		raw.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n" + msg.Body + "\r\n")
+		msg.raw = raw.Bytes()
+		return
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+	part.Write([]byte(msg.Body))
+	msg.payload = &gmail.MessagePart{
+		MimeType: "multipart/mixed",
+		Headers:  append(msg.headers(), &gmail.MessagePartHeader{Name: "Content-Type", Value: "multipart/mixed; boundary=" + writer.Boundary()}),
+		Body:     &gmail.MessagePartBody{},
+		Parts:    []*gmail.MessagePart{text},
+	}
+	for i, att := range msg.Attachments {
+		headers := textproto.MIMEHeader{
+			"Content-Type":              {att.MimeType + "; name=\"" + att.Filename + "\""},
+			"Content-Disposition":       {"attachment; filename=\"" + att.Filename + "\""},
+			"Content-Transfer-Encoding": {"base64"},
+		}
+		part, _ := writer.CreatePart(headers)
+		part.Write([]byte(base64.StdEncoding.EncodeToString(att.Data)))
+
+		gmailPart := &gmail.MessagePart{
+			PartId:   strconv.Itoa(i + 1),
+			MimeType: att.MimeType,
+			Filename: att.Filename,
+			Body:     &gmail.MessagePartBody{AttachmentId: msg.attachmentID(i), Size: int64(len(att.Data))},
+		}
+		for _, name := range []string{"Content-Type", "Content-Disposition", "Content-Transfer-Encoding"} {
+			gmailPart.Headers = append(gmailPart.Headers, &gmail.MessagePartHeader{Name: name, Value: headers.Get(name)})
+		}
+		msg.payload.Parts = append(msg.payload.Parts, gmailPart)
+	}
+	writer.Close()
+
+	raw.WriteString("Content-Type: multipart/mixed; boundary=" + writer.Boundary() + "\r\n\r\n")
+	raw.Write(body.Bytes())
+	msg.raw = raw.Bytes()
+}
+
+// minimal returns the message in Gmail's minimal format
+func (msg *message) minimal() *gmail.Message {
+	snippet := strings.Join(strings.Fields(msg.Body), " ")
+	if len(snippet) > 200 {
+		snippet = snippet[:200]
+	}
+	return &gmail.Message{
+		Id:           msg.ID,
+		ThreadId:     msg.ThreadID,
+		LabelIds:     slices.Clone(msg.LabelIDs),
+		Snippet:      snippet,
+		SizeEstimate: int64(len(msg.raw)),
+		InternalDate: msg.Date.UnixMilli(),
+		HistoryId:    msg.historyID,
+	}
+}
+
+// format returns the message in one of Gmail's formats, with only the wanted headers
+// in the metadata format if any are given
+func (msg *message) format(format string, wanted []string) *gmail.Message {
+	out := msg.minimal()
+	switch format {
+	case "metadata":
+		headers := msg.headers()
+		if len(wanted) > 0 {
+			headers = slices.DeleteFunc(headers, func(h *gmail.MessagePartHeader) bool {
+				return !slices.ContainsFunc(wanted, func(name string) bool { return strings.EqualFold(name, h.Name) })
+			})
+		}
+		out.Payload = &gmail.MessagePart{MimeType: msg.payload.MimeType, Headers: headers}
+	case "raw":
+		out.Raw = base64.URLEncoding.EncodeToString(msg.raw)
+	case "minimal":
+	default:
+		out.Payload = msg.payload
+	}
+	return out
+}
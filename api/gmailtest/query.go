@@ -0,0 +1,278 @@
+package gmailtest
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// query is a parsed Gmail search. Terms are ANDed, except those grouped in braces,
+// which match if any of them does; OR and operators the fake doesn't know are rejected,
+// so a test doesn't silently pass on a search that matched nothing.
+type query struct {
+	terms []term
+	// Whether in:anywhere asked to include Spam and Trash
+	anywhere bool
+}
+
+// term is one search term, such as from:x or -is:unread, or a group of them in braces
+type term struct {
+	op, value string
+	negated   bool
+	anyOf     []term
+}
+
+// Labels in: and is: terms name by their search name
+var searchLabels = map[string]string{
+	"inbox": "INBOX", "sent": "SENT", "drafts": "DRAFT", "draft": "DRAFT", "spam": "SPAM",
+	"trash": "TRASH", "starred": "STARRED", "unread": "UNREAD", "important": "IMPORTANT",
+	"chats": "CHAT",
+}
+
+// parseQuery parses the from:, to:, subject:, list:, label:, in:, is:, category:,
+// has:attachment, filename:, larger:, smaller:, after:, before:, older_than: and
+// newer_than: operators, plain words and quoted phrases, negation with "-" and groups
+// of terms in braces
+func parseQuery(q string) (*query, error) {
+	parsed := &query{}
+	// The group being parsed, while inside braces
+	var group *term
+	for _, word := range splitQuery(q) {
+		switch word {
+		case "OR", "AROUND":
+			return nil, fmt.Errorf("gmailtest: %s is not supported in searches", word)
+		case "{", "-{":
+			if group != nil {
+				return nil, fmt.Errorf("gmailtest: nested groups are not supported in searches")
+			}
+			group = &term{op: "{", negated: word == "-{", anyOf: make([]term, 0)}
+			continue
+		case "}":
+			if group == nil {
+				return nil, fmt.Errorf("gmailtest: unbalanced } in search")
+			}
+			parsed.terms = append(parsed.terms, *group)
+			group = nil
+			continue
+		}
+
+		t, err := parseTerm(word, parsed)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			continue
+		}
+		if group != nil {
+			group.anyOf = append(group.anyOf, *t)
+		} else {
+			parsed.terms = append(parsed.terms, *t)
+		}
+	}
+	if group != nil {
+		return nil, fmt.Errorf("gmailtest: unbalanced { in search")
+	}
+	return parsed, nil
+}
+
+// parseTerm parses one word of a search into a term, or nil for in:anywhere, which
+// it notes on parsed instead
+func parseTerm(word string, parsed *query) (*term, error) {
+	t := term{}
+	if strings.HasPrefix(word, "-") && len(word) > 1 {
+		t.negated, word = true, word[1:]
+	}
+	op, value, ok := strings.Cut(word, ":")
+	if !ok || strings.HasPrefix(word, `"`) {
+		t.value = strings.ToLower(strings.Trim(word, `"`))
+		return &t, nil
+	}
+	t.op, t.value = strings.ToLower(op), strings.ToLower(strings.Trim(value, `"`))
+
+	switch t.op {
+	case "from", "to", "subject", "list", "label", "category", "filename":
+	case "in":
+		if t.value == "anywhere" {
+			parsed.anywhere = true
+			return nil, nil
+		}
+	case "is":
+		if _, ok := searchLabels[t.value]; !ok && t.value != "read" {
+			return nil, fmt.Errorf("gmailtest: is:%s is not supported", t.value)
+		}
+	case "has":
+		if t.value != "attachment" {
+			return nil, fmt.Errorf("gmailtest: has:%s is not supported", t.value)
+		}
+	case "larger", "smaller":
+		if _, err := parseSize(t.value); err != nil {
+			return nil, err
+		}
+	case "after", "before", "older_than", "newer_than":
+		if _, err := parseTime(t.op, t.value); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("gmailtest: search operator %s: is not supported", t.op)
+	}
+	return &t, nil
+}
+
+// splitQuery splits a search into words, keeping quoted phrases whole, dropping
+// grouping parentheses and making braces, with any "-" negating them, words of their own
+func splitQuery(q string) []string {
+	words := make([]string, 0)
+	var word strings.Builder
+	quoted := false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			word.WriteRune(r)
+		case !quoted && r == '{' && word.String() == "-":
+			words = append(words, "-{")
+			word.Reset()
+		case !quoted && (r == '{' || r == '}'):
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+			words = append(words, string(r))
+		case !quoted && (r == ' ' || r == '\t' || r == '(' || r == ')'):
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		default:
+			word.WriteRune(r)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// parseSize parses a size in bytes, or with a K or M suffix
+func parseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "k"):
+		multiplier, value = 1<<10, strings.TrimSuffix(value, "k")
+	case strings.HasSuffix(value, "m"):
+		multiplier, value = 1<<20, strings.TrimSuffix(value, "m")
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("gmailtest: invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
+
+// parseTime returns the time a date or age term compares with
+func parseTime(op, value string) (time.Time, error) {
+	if op == "after" || op == "before" {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(seconds, 0), nil
+		}
+		date, err := time.ParseInLocation("2006/1/2", strings.ReplaceAll(value, "-", "/"), time.UTC)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("gmailtest: invalid date %q", value)
+		}
+		return date, nil
+	}
+
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("gmailtest: invalid age %q", value)
+	}
+	now := time.Now()
+	switch value[len(value)-1] {
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	case 'm':
+		return now.AddDate(0, -n, 0), nil
+	case 'y':
+		return now.AddDate(-n, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("gmailtest: invalid age %q", value)
+}
+
+// labels returns the label IDs the query's in: terms ask for
+func (q *query) labels() []string {
+	labels := make([]string, 0)
+	for _, t := range q.terms {
+		if t.op == "in" && !t.negated {
+			if label, ok := searchLabels[t.value]; ok {
+				labels = append(labels, label)
+			}
+		}
+	}
+	return labels
+}
+
+// matches reports whether a message matches every term of the query
+func (q *query) matches(msg *message, m *mailbox) bool {
+	for _, t := range q.terms {
+		if t.matches(msg, m) == t.negated {
+			return false
+		}
+	}
+	return true
+}
+
+// hasLabel reports whether a message has a label, given by ID or by name
+func (msg *message) hasLabel(label string, m *mailbox) bool {
+	for _, id := range msg.LabelIDs {
+		if strings.EqualFold(id, label) || (m.labels[id] != nil && strings.EqualFold(m.labels[id].Name, label)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t term) matches(msg *message, m *mailbox) bool {
+	contains := func(s string) bool { return strings.Contains(strings.ToLower(s), t.value) }
+	switch t.op {
+	case "{":
+		return slices.ContainsFunc(t.anyOf, func(sub term) bool { return sub.matches(msg, m) != sub.negated })
+	case "":
+		return contains(msg.From) || contains(msg.To) || contains(msg.Subject) || contains(msg.Body)
+	case "from", "to", "subject":
+		return contains(msg.header(t.op))
+	case "list":
+		return contains(msg.header("List-Id"))
+	case "label":
+		return msg.hasLabel(strings.ReplaceAll(t.value, "-", " "), m) || msg.hasLabel(t.value, m)
+	case "in", "is":
+		if t.value == "read" {
+			return !slices.Contains(msg.LabelIDs, "UNREAD")
+		}
+		label, ok := searchLabels[t.value]
+		if !ok {
+			label = t.value
+		}
+		return msg.hasLabel(label, m)
+	case "category":
+		return slices.Contains(msg.LabelIDs, "CATEGORY_"+strings.ToUpper(t.value))
+	case "has":
+		return len(msg.Attachments) > 0
+	case "filename":
+		return slices.ContainsFunc(msg.Attachments, func(a Attachment) bool { return contains(a.Filename) })
+	case "larger", "smaller":
+		size, _ := parseSize(t.value)
+		if t.op == "larger" {
+			return int64(len(msg.raw)) > size
+		}
+		return int64(len(msg.raw)) < size
+	case "after", "newer_than":
+		at, _ := parseTime(t.op, t.value)
+		return !msg.Date.Before(at)
+	case "before", "older_than":
+		at, _ := parseTime(t.op, t.value)
+		return msg.Date.Before(at)
+	}
+	return false
+}
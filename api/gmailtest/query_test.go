@@ -0,0 +1,54 @@
+package gmailtest
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	server := NewServer(
+		Message{ID: "news", From: "Digest <news@weekly.example>", Subject: "Weekly digest", Date: FixtureTime, LabelIDs: []string{"INBOX", "UNREAD"}},
+		Message{ID: "deal", From: "Shop <deals@shop.example>", Subject: "Sale", Date: FixtureTime, LabelIDs: []string{"INBOX", "STARRED"}},
+		Message{ID: "friend", From: "Alex <alex@friends.example>", Subject: "Lunch?", Date: FixtureTime, LabelIDs: []string{"INBOX"}},
+		Message{ID: "old", From: "Shop <deals@shop.example>", Subject: "Old sale", Date: FixtureTime, LabelIDs: []string{"TRASH"}},
+	)
+	defer server.Close()
+	service, err := server.Service(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`from:deals@shop.example`, []string{"deal"}},
+		{`from:"deals@shop.example" in:anywhere`, []string{"deal", "old"}},
+		{`{from:alex@friends.example is:starred}`, []string{"deal", "friend"}},
+		{`-{from:alex@friends.example is:starred}`, []string{"news"}},
+		{`is:unread {subject:digest subject:lunch}`, []string{"news"}},
+		{`{from:"shop.example" is:unread} in:trash`, []string{"old"}},
+	}
+	for _, tt := range tests {
+		resp, err := service.Users.Messages.List("me").Q(tt.query).Do()
+		if err != nil {
+			t.Errorf("search %s: %v", tt.query, err)
+			continue
+		}
+		got := make([]string, 0, len(resp.Messages))
+		for _, msg := range resp.Messages {
+			got = append(got, msg.Id)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("search %s = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{`{from:a {from:b}}`, `{from:a`, `from:a}`, `from:a OR from:b`} {
+		if _, err := service.Users.Messages.List("me").Q(query).Do(); err == nil {
+			t.Errorf("search %s succeeded, want an error", query)
+		}
+	}
+}
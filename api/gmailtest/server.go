@@ -0,0 +1,193 @@
+// Package gmailtest provides a fake Gmail API server for tests. It serves the part of
+// the Gmail REST API the app uses (listing and getting messages, attachments and
+// threads, trashing and modifying them, labels and history) from an in-memory mailbox,
+// so scans and handlers can be tested without credentials:
+//
+//	server := gmailtest.NewServer(gmailtest.Fixtures()...)
+//	defer server.Close()
+//	service, _ := server.Service(ctx)
+//	api.NewMailProvider = func(*oauth2.Token) (api.MailProvider, error) {
+//		return api.NewGmailProviderFromService(service), nil
+//	}
+package gmailtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// Address of the fake mailbox, unless changed before the first request
+const DefaultEmailAddress = "me@example.com"
+
+// Labels every fake mailbox has, besides those created in it
+var systemLabels = []string{
+	"INBOX", "SENT", "DRAFT", "SPAM", "TRASH", "UNREAD", "STARRED", "IMPORTANT", "CHAT",
+	"CATEGORY_PERSONAL", "CATEGORY_SOCIAL", "CATEGORY_PROMOTIONS", "CATEGORY_UPDATES", "CATEGORY_FORUMS",
+}
+
+// Server is a fake Gmail API server holding one mailbox. It answers as that mailbox for
+// "me" and for its address. Its methods are safe to call while requests are served.
+type Server struct {
+	*httptest.Server
+	// Address the mailbox answers to and its profile reports
+	EmailAddress string
+
+	mu       sync.Mutex
+	mailbox  *mailbox
+	failures map[string][]int
+	calls    map[string]int
+}
+
+// NewServer starts a fake server with the given messages in its mailbox
+func NewServer(messages ...Message) *Server {
+	s := &Server{
+		EmailAddress: DefaultEmailAddress,
+		mailbox:      newMailbox(),
+		failures:     make(map[string][]int),
+		calls:        make(map[string]int),
+	}
+	for _, msg := range messages {
+		s.mailbox.add(msg)
+	}
+
+	router := mux.NewRouter()
+	users := router.PathPrefix("/gmail/v1/users/{userId}").Subrouter()
+	users.HandleFunc("/profile", s.handle("getProfile", s.getProfile)).Methods("GET")
+	users.HandleFunc("/messages", s.handle("messages.list", s.listMessages)).Methods("GET")
+	users.HandleFunc("/messages/batchModify", s.handle("messages.batchModify", s.batchModify)).Methods("POST")
+	users.HandleFunc("/messages/batchDelete", s.handle("messages.batchDelete", s.batchDelete)).Methods("POST")
+	users.HandleFunc("/messages/{id}", s.handle("messages.get", s.getMessage)).Methods("GET")
+	users.HandleFunc("/messages/{id}/modify", s.handle("messages.modify", s.modifyMessage)).Methods("POST")
+	users.HandleFunc("/messages/{id}/trash", s.handle("messages.trash", s.trashMessage)).Methods("POST")
+	users.HandleFunc("/messages/{id}/untrash", s.handle("messages.untrash", s.untrashMessage)).Methods("POST")
+	users.HandleFunc("/messages/{messageId}/attachments/{id}", s.handle("messages.attachments.get", s.getAttachment)).Methods("GET")
+	users.HandleFunc("/threads/{id}", s.handle("threads.get", s.getThread)).Methods("GET")
+	users.HandleFunc("/threads/{id}/modify", s.handle("threads.modify", s.modifyThread)).Methods("POST")
+	users.HandleFunc("/labels", s.handle("labels.list", s.listLabels)).Methods("GET")
+	users.HandleFunc("/labels", s.handle("labels.create", s.createLabel)).Methods("POST")
+	users.HandleFunc("/history", s.handle("history.list", s.listHistory)).Methods("GET")
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "notFound", fmt.Sprintf("gmailtest: %s %s is not implemented", r.Method, r.URL.Path))
+	})
+
+	s.Server = httptest.NewServer(router)
+	return s
+}
+
+// Service returns a Gmail service that talks to the fake server
+func (s *Server) Service(ctx context.Context) (*gmail.Service, error) {
+	return gmail.NewService(ctx, option.WithEndpoint(s.URL+"/"), option.WithHTTPClient(s.Client()))
+}
+
+// FailNext makes the next calls of a method, named like "messages.list", fail with the
+// given statuses in turn. 429 and 403 fail with Gmail's rate limit errors.
+func (s *Server) FailNext(method string, statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], statuses...)
+}
+
+// Calls returns how often a method was called, including failed calls
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+// AddMessage adds a message as if it was delivered, and returns its ID
+func (s *Server) AddMessage(msg Message) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mailbox.add(msg).ID
+}
+
+// AddLabel creates a user label and returns its ID
+func (s *Server) AddLabel(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mailbox.createLabel(name).Id
+}
+
+// Labels returns the label IDs of a message, or false if there is no such message
+func (s *Server) Labels(id string) ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg, ok := s.mailbox.byID[id]
+	if !ok {
+		return nil, false
+	}
+	return append([]string(nil), msg.LabelIDs...), true
+}
+
+// Len returns the number of messages in the mailbox, trashed ones included
+func (s *Server) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.mailbox.byID)
+}
+
+// handle wraps a method's handler: it counts the call, checks the user, fails it if
+// FailNext asked to, and holds the lock while the handler runs
+func (s *Server) handle(method string, fn func(w http.ResponseWriter, r *http.Request, vars map[string]string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.calls[method]++
+
+		if statuses := s.failures[method]; len(statuses) > 0 {
+			s.failures[method] = statuses[1:]
+			switch statuses[0] {
+			case http.StatusTooManyRequests, http.StatusForbidden:
+				writeError(w, statuses[0], "rateLimitExceeded", "Rate Limit Exceeded")
+			default:
+				writeError(w, statuses[0], "backendError", http.StatusText(statuses[0]))
+			}
+			return
+		}
+
+		vars := mux.Vars(r)
+		if user := vars["userId"]; user != "me" && user != s.EmailAddress {
+			writeError(w, http.StatusForbidden, "forbidden", "Delegation denied for "+s.EmailAddress)
+			return
+		}
+		fn(w, r, vars)
+	}
+}
+
+// writeJSON writes a response body
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the format of Google APIs, which the client library
+// turns into a *googleapi.Error
+func writeError(w http.ResponseWriter, code int, reason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"code":    code,
+			"message": message,
+			"errors":  []map[string]string{{"domain": "global", "reason": reason, "message": message}},
+		},
+	})
+}
+
+// notFound writes Gmail's error for a missing message, thread or attachment
+func notFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, "notFound", "Requested entity was not found.")
+}
+
+// badRequest writes Gmail's error for an invalid argument
+func badRequest(w http.ResponseWriter, message string) {
+	writeError(w, http.StatusBadRequest, "invalidArgument", message)
+}
@@ -0,0 +1,525 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepcleanpb"
+)
+
+const (
+	// Default and shortest time between the updates of WatchScan
+	defaultWatchInterval = time.Second
+	minWatchInterval     = 100 * time.Millisecond
+)
+
+// GRPCServer serves the gRPC API defined in proto/deepclean/v1 from the same scans,
+// stats and bulk actions as the HTTP API
+type GRPCServer struct {
+	deepcleanpb.UnimplementedDeepCleanServer
+}
+
+// NewGRPCServer creates a gRPC server with the DeepClean service registered
+func NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(opts...)
+	deepcleanpb.RegisterDeepCleanServer(server, &GRPCServer{})
+	return server
+}
+
+// GatewayHandler serves the gRPC API as JSON over HTTP, under the paths of its http
+// options. Calls go straight to the service without a connection, which grpc-gateway
+// doesn't support for streaming methods, so WatchScan and ListSenders answer 501 here.
+func GatewayHandler(ctx context.Context) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(gatewayHeader),
+		runtime.WithOutgoingHeaderMatcher(gatewayResponseHeader),
+		runtime.WithMetadata(gatewayDryRun),
+	)
+	if err := deepcleanpb.RegisterDeepCleanHandlerServer(ctx, mux, &GRPCServer{}); err != nil {
+		return nil, err
+	}
+	return mux, nil
+}
+
+// gatewayHeader passes the headers the HTTP API reads on to the service as metadata,
+// along with grpc-gateway's defaults
+func gatewayHeader(key string) (string, bool) {
+	switch http.CanonicalHeaderKey(key) {
+	case mailboxHeader, impersonateHeader, "X-Admin-Token":
+		return strings.ToLower(key), true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// gatewayResponseHeader writes the dry-run flag of a call as the same header the HTTP
+// API sets, and other header metadata as grpc-gateway does
+func gatewayResponseHeader(key string) (string, bool) {
+	if http.CanonicalHeaderKey(key) == dryRunHeader {
+		return dryRunHeader, true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+// gatewayDryRun asks for a dry run of the call with x-dry-run metadata when the request
+// has ?dryRun=true, as it does over the HTTP API
+func gatewayDryRun(ctx context.Context, r *http.Request) metadata.MD {
+	if requestDryRun(r) {
+		return metadata.Pairs(strings.ToLower(dryRunHeader), "true")
+	}
+	return nil
+}
+
+// grpcRequest rebuilds a request from the metadata of a gRPC call, so the call
+// authenticates and picks its mailbox exactly like an HTTP request
+func grpcRequest(ctx context.Context) *http.Request {
+	r := (&http.Request{Header: make(http.Header), URL: &url.URL{}}).WithContext(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, v := range values {
+			r.Header.Add(key, v)
+		}
+	}
+	return r
+}
+
+// grpcMailbox returns the token and mailbox of a gRPC call. Like ?dryRun=true over HTTP,
+// x-dry-run metadata asks for a dry run of the call, and dry runs set x-dry-run header
+// metadata.
+func grpcMailbox(ctx context.Context) (*oauth2.Token, *mailbox, error) {
+	r, err := withSession(grpcRequest(ctx))
+	if err != nil {
+		return nil, nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	token, err := ParseToken(r)
+	if err != nil {
+		return nil, nil, status.Error(codes.Unauthenticated, "Unauthorized: "+err.Error())
+	}
+	user, err := requestMailbox(r)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mb, err := newMailbox(token, user)
+	if errors.Is(err, errNotDelegate) {
+		return nil, nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return nil, nil, grpcError("Failed to create Gmail service", err, codes.Internal)
+	}
+	if r.Header.Get(dryRunHeader) == "true" {
+		mb = mb.withDryRun()
+	}
+	if mb.dryRun() {
+		grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(dryRunHeader), "true"))
+	}
+	return token, mb, nil
+}
+
+// grpcProcessor returns the processor of the mailbox of a gRPC call
+func grpcProcessor(ctx context.Context) (*InboxProcessor, error) {
+	_, mb, err := grpcMailbox(ctx)
+	if err != nil {
+		return nil, err
+	}
+	processor, exists := Registry.Get(mb.userID)
+	if !exists {
+		return nil, status.Error(codes.NotFound, "No processing found for this user")
+	}
+	return processor, nil
+}
+
+// grpcError is the status of a failed call, like writeErrorFrom is for HTTP: Gmail
+// rejecting the token, rate limiting the user or not finding something take precedence
+// over code
+func grpcError(prefix string, err error, code codes.Code) error {
+	message := err.Error()
+	if prefix != "" {
+		message = prefix + ": " + message
+	}
+
+	var rerr *oauth2.RetrieveError
+	if errors.As(err, &rerr) {
+		return status.Error(codes.Unauthenticated, message)
+	}
+	if errors.Is(err, ErrNotSupported) {
+		return status.Error(codes.Unimplemented, message)
+	}
+	if isScopeError(err) {
+		return status.Error(codes.PermissionDenied, message)
+	}
+
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		switch {
+		case gerr.Code == http.StatusUnauthorized:
+			return status.Error(codes.Unauthenticated, message)
+		case gerr.Code == http.StatusTooManyRequests || isRateLimitReason(gerr):
+			return status.Error(codes.ResourceExhausted, message)
+		case gerr.Code == http.StatusNotFound:
+			return status.Error(codes.NotFound, message)
+		}
+	}
+	return status.Error(code, message)
+}
+
+// StartScan starts scanning the mailbox like HandleStartProcessingInbox
+func (s *GRPCServer) StartScan(ctx context.Context, req *deepcleanpb.StartScanRequest) (*deepcleanpb.ScanProgress, error) {
+	token, mb, err := grpcMailbox(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := ScanScope{
+		LabelIDs:         req.LabelIds,
+		Query:            strings.TrimSpace(req.Query),
+		After:            req.After,
+		Before:           req.Before,
+		IncludeSpamTrash: req.IncludeSpamTrash,
+	}
+	if err := scope.validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	priority := PriorityUser
+	if req.Background {
+		priority = PriorityBackground
+	}
+
+	processor, err := startScan(token, mb.user, mb.userID, scope, req.Rescan, priority, 0)
+	if errors.Is(err, errMetadataSearch) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, grpcError("", err, codes.Internal)
+	}
+	return progressMessage(processor), nil
+}
+
+// GetScanProgress returns the progress of the mailbox's scan
+func (s *GRPCServer) GetScanProgress(ctx context.Context, req *deepcleanpb.GetScanProgressRequest) (*deepcleanpb.ScanProgress, error) {
+	processor, err := grpcProcessor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return progressMessage(processor), nil
+}
+
+// WatchScan sends the progress of the mailbox's scan whenever it changes, checking every
+// interval, and ends with the progress of the finished scan
+func (s *GRPCServer) WatchScan(req *deepcleanpb.WatchScanRequest, stream grpc.ServerStreamingServer[deepcleanpb.ScanProgress]) error {
+	processor, err := grpcProcessor(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	interval := defaultWatchInterval
+	if req.IntervalMs > 0 {
+		interval = max(time.Duration(req.IntervalMs)*time.Millisecond, minWatchInterval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last *deepcleanpb.ScanProgress
+	for {
+		progress := progressMessage(processor)
+		if last == nil || !proto.Equal(progress, last) {
+			if err := stream.Send(progress); err != nil {
+				return err
+			}
+			last = progress
+		}
+		if !progress.IsProcessing {
+			return nil
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// progressMessage converts a processor's progress for the gRPC API
+func progressMessage(processor *InboxProcessor) *deepcleanpb.ScanProgress {
+	progress := processor.GetProgress()
+	usage := progress["usage"].(ResourceUsage)
+	abortReason, _ := progress["abortReason"].(string)
+	return &deepcleanpb.ScanProgress{
+		TotalEmails:    int64(progress["totalEmails"].(int)),
+		FailedEmails:   int64(progress["failedEmails"].(int)),
+		IsProcessing:   progress["isProcessing"].(bool),
+		Aborted:        progress["aborted"].(bool),
+		AbortReason:    abortReason,
+		JobId:          progress["jobId"].(string),
+		Resumed:        progress["resumed"].(bool),
+		CachedMessages: int64(usage.CachedMessages),
+		MemoryBytes:    usage.MemoryBytes,
+	}
+}
+
+// GetStats returns the totals of the scanned messages
+func (s *GRPCServer) GetStats(ctx context.Context, req *deepcleanpb.GetStatsRequest) (*deepcleanpb.Stats, error) {
+	processor, err := grpcProcessor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := processor.GetStats()
+	stats.RLock()
+	defer stats.RUnlock()
+
+	result := &deepcleanpb.Stats{
+		TotalEmails:   int64(stats.TotalEmails),
+		Senders:       int64(len(stats.FromCount)),
+		CategoryCount: make(map[string]int64, len(stats.CategoryCount)),
+		JunkCount:     make(map[string]int64, len(stats.JunkCount)),
+		JunkSize:      make(map[string]int64, len(stats.JunkSize)),
+	}
+	for category, count := range stats.CategoryCount {
+		result.CategoryCount[category] = int64(count)
+	}
+	for label, count := range stats.JunkCount {
+		result.JunkCount[label] = int64(count)
+	}
+	for label, size := range stats.JunkSize {
+		result.JunkSize[label] = size
+	}
+	return result, nil
+}
+
+// ListSenders streams the senders of the scanned messages ranked like HandleGetTopSenders
+func (s *GRPCServer) ListSenders(req *deepcleanpb.ListSendersRequest, stream grpc.ServerStreamingServer[deepcleanpb.Sender]) error {
+	processor, err := grpcProcessor(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	sortBy := req.SortBy
+	if sortBy == "" {
+		sortBy = sendersByCount
+	}
+	if sortBy != sendersByCount && sortBy != sendersBySize {
+		return status.Errorf(codes.InvalidArgument, "sort_by must be one of %s, %s", sendersByCount, sendersBySize)
+	}
+	if err := validateCategory(req.Category); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	limit := int(req.Limit)
+	if limit <= 0 {
+		stats := processor.GetStats()
+		stats.RLock()
+		limit = len(stats.FromCount)
+		stats.RUnlock()
+	}
+
+	for _, sender := range processor.GetTopSenders(0, limit, sortBy, req.Category) {
+		category, _ := sender["category"].(string)
+		msg := &deepcleanpb.Sender{
+			Email:    sender["email"].(string),
+			Count:    int64(sender["count"].(int)),
+			Size:     sender["size"].(int64),
+			Category: category,
+		}
+		if firstSeen, ok := sender["firstSeen"].(time.Time); ok {
+			msg.FirstSeen = firstSeen.Unix()
+			msg.LastSeen = sender["lastSeen"].(time.Time).Unix()
+		}
+		err := stream.Send(msg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArchiveMessages removes the selected messages from the inbox
+func (s *GRPCServer) ArchiveMessages(ctx context.Context, req *deepcleanpb.BulkRequest) (*deepcleanpb.BulkResult, error) {
+	return modifyMessages(ctx, req, "archive", "in:inbox", []string{"INBOX"})
+}
+
+// MarkRead marks the selected messages read
+func (s *GRPCServer) MarkRead(ctx context.Context, req *deepcleanpb.BulkRequest) (*deepcleanpb.BulkResult, error) {
+	return modifyMessages(ctx, req, "mark-read", "is:unread", []string{"UNREAD"})
+}
+
+// modifyMessages removes labels from the messages a bulk request selects as a
+// user-priority job, reporting the messages it fails on rather than failing. Queries
+// are narrowed with filter to the messages carrying them.
+func modifyMessages(ctx context.Context, req *deepcleanpb.BulkRequest, action, filter string, removeLabelIDs []string) (*deepcleanpb.BulkResult, error) {
+	_, mb, err := grpcMailbox(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !canModify(mb.token) {
+		return nil, grpcError("", errReadonlyAccess, codes.PermissionDenied)
+	}
+	kind, resolve, err := bulkSelection(mb, req, action, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := Jobs.Run(ctx, kind, mb.userID, PriorityUser, func(job *Job) (interface{}, error) {
+		ids, err := resolve()
+		if err != nil {
+			return nil, err
+		}
+		result := modifyEach(mb.forJob(job), ids, nil, removeLabelIDs)
+
+		failed := make([]*deepcleanpb.BatchFailure, 0, len(result.Failed))
+		for _, failure := range result.Failed {
+			failed = append(failed, &deepcleanpb.BatchFailure{
+				Id:        failure.ID,
+				Reason:    failure.Reason,
+				Code:      failure.Code,
+				Retryable: failure.Retryable,
+			})
+		}
+		return &deepcleanpb.BulkResult{
+			Affected:  int64(len(result.Succeeded)),
+			Succeeded: result.Succeeded,
+			Failed:    failed,
+			Retry:     retryMessage(result.Retry),
+		}, nil
+	})
+	if err != nil {
+		return nil, grpcError("Failed to "+action+" emails", err, codes.Internal)
+	}
+	return result.(*deepcleanpb.BulkResult), nil
+}
+
+// PreviewTrash previews trashing the selected messages as an operation to confirm
+func (s *GRPCServer) PreviewTrash(ctx context.Context, req *deepcleanpb.BulkRequest) (*deepcleanpb.Operation, error) {
+	_, mb, err := grpcMailbox(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kind, resolve, err := bulkSelection(mb, req, bulkActionTrash, "")
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := previewOperation(ctx, mb, kind, req.Query, resolve, trashOperation)
+	if err != nil {
+		return nil, grpcError("Failed to preview operation", err, codes.Internal)
+	}
+	return &deepcleanpb.Operation{
+		Id:            op.ID,
+		Kind:          op.Kind,
+		Count:         int64(op.Summary.Count),
+		EstimatedSize: op.Summary.EstimatedSize,
+		Savings:       op.Summary.Savings,
+		ExpiresAt:     op.ExpiresAt.Unix(),
+	}, nil
+}
+
+// bulkSelection checks the selection of a bulk request and returns the job kind of
+// action on it along with the function listing the selected messages
+func bulkSelection(mb *mailbox, req *deepcleanpb.BulkRequest, action, filter string) (string, func() ([]string, error), error) {
+	if (len(req.Ids) == 0) == (req.Query == "") {
+		return "", nil, status.Error(codes.InvalidArgument, "Either message IDs or a search query is required")
+	}
+	if err := validateCategory(req.Category); err != nil {
+		return "", nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if req.Query == "" {
+		return "batch-" + action, func() ([]string, error) {
+			return filterIDsByCategory(mb.userID, req.Ids, req.Category)
+		}, nil
+	}
+
+	query := strings.TrimSpace("(" + req.Query + ") " + filter)
+	return action + "-by-query", func() ([]string, error) {
+		ids, err := listMessageIDs(mb, query)
+		if err != nil {
+			return nil, err
+		}
+		return filterIDsByCategory(mb.userID, ids, req.Category)
+	}, nil
+}
+
+// ConfirmOperation executes a previewed operation like HandleConfirmOperation. Its
+// result is a TrashResult, so blocking senders, unsubscribe campaigns and emptying a
+// label, which report results of their own, are refused without running.
+func (s *GRPCServer) ConfirmOperation(ctx context.Context, req *deepcleanpb.ConfirmOperationRequest) (*deepcleanpb.TrashResult, error) {
+	_, mb, err := grpcMailbox(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !canModify(mb.token) {
+		return nil, grpcError("", errReadonlyAccess, codes.PermissionDenied)
+	}
+	account, err := mb.account()
+	if err != nil {
+		return nil, grpcError("", err, codes.Internal)
+	}
+
+	// Only trash results can be reported, so other operations are left to confirm
+	// over HTTP rather than run without a result
+	if kind, ok := pendingOperationKind(account, req.Id); ok && !trashesOnly(kind) {
+		return nil, status.Errorf(codes.FailedPrecondition, "Operation %s can only be confirmed over HTTP", kind)
+	}
+	op, err := takeOperation(account, req.Id)
+	if errors.Is(err, errOperationNotFound) {
+		return nil, status.Error(codes.NotFound, "Operation not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, "Operation preview expired, request a new one")
+	}
+
+	result, err := Jobs.Run(ctx, op.Kind, mb.userID, PriorityUser, op.run(mb, account, false, false, false))
+	if err != nil {
+		return nil, grpcError("Operation failed", err, codes.Internal)
+	}
+
+	trashed, ok := result.(*TrashResult)
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "Operation %s has no trash result", op.Kind)
+	}
+	return &deepcleanpb.TrashResult{
+		Trashed:     trashed.Trashed,
+		Failed:      trashed.Failed,
+		Protected:   trashed.Protected,
+		Aborted:     trashed.Aborted,
+		AbortReason: trashed.AbortReason,
+		Retry:       retryMessage(trashed.Retry),
+	}, nil
+}
+
+// trashesOnly reports whether the operations of a kind only trash messages, returning a
+// TrashResult
+func trashesOnly(kind string) bool {
+	switch {
+	case kind == "block", kind == "unsubscribe-campaign", strings.HasPrefix(kind, "empty-"):
+		return false
+	}
+	return true
+}
+
+// retryMessage converts retry guidance to its message, which is nil without any
+func retryMessage(guidance *RetryGuidance) *deepcleanpb.RetryGuidance {
+	if guidance == nil {
+		return nil
+	}
+	return &deepcleanpb.RetryGuidance{
+		Ids:          guidance.IDs,
+		AfterSeconds: int64(guidance.AfterSeconds),
+		Advice:       guidance.Advice,
+	}
+}
+
+// Compile-time check that the server implements the whole service
+var _ deepcleanpb.DeepCleanServer = (*GRPCServer)(nil)
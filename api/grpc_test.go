@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepcleanpb"
+)
+
+// grpcContext returns the context of a gRPC call of the user, with the extra metadata
+// pairs
+func (u *testUser) grpcContext(t *testing.T, pairs ...string) context.Context {
+	t.Helper()
+	raw, err := json.Marshal(u.token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := metadata.Pairs(append([]string{"authorization", "Bearer " + string(raw)}, pairs...)...)
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func TestGRPCConfirmOperationRefusesOtherResults(t *testing.T) {
+	user := newTestUser(t, senderMessages("deal", "deals@shop.example", 2, 0)...)
+	ctx := user.grpcContext(t)
+
+	ran := false
+	op, err := previewOperation(ctx, user.mailbox(t), "empty-trash", "label:TRASH", func() ([]string, error) {
+		return []string{"deala"}, nil
+	}, func(mb *mailbox, job *Job, ids []string) (interface{}, error) {
+		ran = true
+		return &EmptyResult{Label: "TRASH", Total: len(ids)}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = (&GRPCServer{}).ConfirmOperation(ctx, &deepcleanpb.ConfirmOperationRequest{Id: op.ID})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("error = %v, want FailedPrecondition", err)
+	}
+	if ran {
+		t.Error("refused operation ran")
+	}
+
+	// The operation is left to confirm over HTTP
+	if _, err := takeOperation(user.account(), op.ID); err != nil {
+		t.Errorf("operation no longer pending: %v", err)
+	}
+}
+
+func TestGRPCDryRun(t *testing.T) {
+	user := newTestUser(t, senderMessages("deal", "deals@shop.example", 2, 0)...)
+	ctx := user.grpcContext(t, "x-dry-run", "true")
+	server := &GRPCServer{}
+
+	op, err := server.PreviewTrash(ctx, &deepcleanpb.BulkRequest{Ids: []string{"deala", "dealb"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := server.ConfirmOperation(ctx, &deepcleanpb.ConfirmOperationRequest{Id: op.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Trashed) != 2 {
+		t.Errorf("trashed %v, want both messages reported", result.Trashed)
+	}
+	for _, id := range []string{"deala", "dealb"} {
+		if messageHasLabel(t, user.server, id, "TRASH") {
+			t.Errorf("message %s trashed by a dry run", id)
+		}
+	}
+}
@@ -2,42 +2,74 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/gorilla/mux"
-	"google.golang.org/api/gmail/v1"
-	"google.golang.org/api/option"
+	"golang.org/x/oauth2"
 )
 
-// HandleGetEmails retrieves emails using the Gmail API
+// Most messages one page of HandleGetEmails may list; Gmail's own limit
+const maxEmailsPageSize = 500
+
+// HandleGetEmails lists a page of messages using the Gmail API. It accepts a Gmail
+// search `q`, `labelIds` (comma-separated or repeated), `maxResults` (default 10) and
+// the `pageToken` from a previous page's nextPageToken. Without a query or labels it
+// lists the inbox.
 func HandleGetEmails(w http.ResponseWriter, r *http.Request) {
-	// Parse token from Authorization header
-	token, err := ParseToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
-		return
+	query := r.URL.Query()
+
+	maxResults := int64(10)
+	if v := query.Get("maxResults"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > maxEmailsPageSize {
+			writeError(w, fmt.Sprintf("maxResults must be between 1 and %d", maxEmailsPageSize), http.StatusBadRequest)
+			return
+		}
+		maxResults = n
+	}
+	labelIDs := make([]string, 0)
+	for _, v := range query["labelIds"] {
+		for _, label := range strings.Split(v, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				labelIDs = append(labelIDs, label)
+			}
+		}
+	}
+	q := query.Get("q")
+	if q == "" && len(labelIDs) == 0 {
+		q = "in:inbox"
 	}
 
-	// Create Gmail service
-	client := oauthConfig.Client(context.Background(), token)
-	gmailService, err := gmail.NewService(context.Background(), option.WithHTTPClient(client))
-	if err != nil {
-		http.Error(w, "Failed to create Gmail service: "+err.Error(), http.StatusInternalServerError)
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
 		return
 	}
 
-	// Get emails (example: list 10 messages from inbox)
-	user := "me" // special value for the authenticated user
-	messages, err := gmailService.Users.Messages.List(user).MaxResults(10).Q("in:inbox").Do()
+	if err := mb.quota.Wait(r.Context(), costMessagesList); err != nil {
+		writeErrorFrom(w, "Request cancelled", err, http.StatusServiceUnavailable)
+		return
+	}
+	messages, err := mb.provider.ListMessages(r.Context(), mb.user, MessageQuery{
+		Query:      q,
+		LabelIDs:   labelIDs,
+		PageToken:  query.Get("pageToken"),
+		MaxResults: maxResults,
+	})
 	if err != nil {
-		http.Error(w, "Failed to fetch emails: "+err.Error(), http.StatusInternalServerError)
+		writeErrorFrom(w, "Failed to fetch emails", err, http.StatusInternalServerError)
 		return
 	}
 
-	// Return messages as JSON
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	// Return messages as JSON, including nextPageToken when there are more
+	writeJSON(w, messages)
 }
 
 // HandleDeleteEmail deletes an email using the Gmail API
@@ -46,30 +78,191 @@ func HandleDeleteEmail(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	messageID := vars["id"]
 
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	// Delete message (using trash)
+	err := mb.provider.Trash(mb.context(), mb.user, messageID)
+	recordTrashAudit(mb, newID(), "trash", messageID, []string{messageID}, err)
+	if err != nil {
+		writeErrorFrom(w, "Failed to delete email", err, http.StatusInternalServerError)
+		return
+	}
+
+	// Return success
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Email moved to trash"})
+}
+
+// mailbox is a Gmail mailbox the server acts on, together with its owner's quota pacing
+type mailbox struct {
+	// Backend the mailbox is read and changed through
+	provider MailProvider
+	token    *oauth2.Token
+	// Mailbox passed to API calls, "me" for the authenticated user or the address of
+	// a mailbox delegated to them
+	user string
+	// Key for the user's server-side state
+	userID string
+	// Key for the signed-in user, the same as userID unless the mailbox is delegated
+	actorID string
+	quota   *QuotaLimiter
+	// Context of API calls, carrying the span of the job they are made for
+	ctx context.Context
+}
+
+// context returns the context API calls on the mailbox are made with
+func (mb *mailbox) context() context.Context {
+	if mb.ctx == nil {
+		return context.Background()
+	}
+	return mb.ctx
+}
+
+// forJob returns a copy of the mailbox whose API calls are traced as part of job
+func (mb *mailbox) forJob(job *Job) *mailbox {
+	copied := *mb
+	copied.ctx = job.Context()
+	return &copied
+}
+
+// newMailbox creates a mailbox the token's user can access, "me" for their own
+func newMailbox(token *oauth2.Token, user string) (*mailbox, error) {
+	provider, err := NewMailProvider(token)
+	if err != nil {
+		return nil, err
+	}
+
+	userID := mailboxUserID(token, user)
+	mb := &mailbox{
+		provider: provider,
+		token:    token,
+		user:     user,
+		userID:   userID,
+		actorID:  userIDFromToken(token),
+		quota:    Quota.For(userID),
+	}
+	// Gmail doesn't let a delegate's own token open the mailbox, so it is acted on as
+	// itself once the user is confirmed a delegate
+	if user != "me" {
+		delegated, err := mailboxDelegateToken(mb)
+		if err != nil {
+			return nil, err
+		}
+		if mb.provider, err = NewMailProvider(delegated); err != nil {
+			return nil, err
+		}
+	}
+	if config.DryRun {
+		mb = mb.withDryRun()
+	}
+	return mb, nil
+}
+
+var (
+	// Maps user IDs to the address of the account they belong to
+	accountCache   = make(map[string]string)
+	accountCacheMu sync.Mutex
+)
+
+// knownAccount returns the address of the account a user ID belongs to, or "" if it
+// hasn't been looked up yet
+func knownAccount(userID string) string {
+	accountCacheMu.Lock()
+	defer accountCacheMu.Unlock()
+	return accountCache[userID]
+}
+
+// account returns the mailbox's email address, or imap:<username>@<host> for an IMAP
+// mailbox. Unlike the user ID it stays the same across token refreshes, so it keys
+// everything persisted for the user.
+func (mb *mailbox) account() (string, error) {
+	return mb.lookupAddress(mb.user, mb.userID)
+}
+
+// actor returns the email address of the signed-in user acting on the mailbox, which
+// differs from account for delegated mailboxes
+func (mb *mailbox) actor() (string, error) {
+	return mb.lookupAddress("me", mb.actorID)
+}
+
+// lookupAddress returns the email address of a Gmail user, or the login of an IMAP
+// user, caching it under key
+func (mb *mailbox) lookupAddress(user, key string) (string, error) {
+	accountCacheMu.Lock()
+	address, ok := accountCache[key]
+	accountCacheMu.Unlock()
+	if ok {
+		return address, nil
+	}
+
+	// IMAP users give their address themselves, so it mustn't key their state; the
+	// login the server authenticated them with does
+	if isIMAPToken(mb.token) {
+		address = imapUserID(mb.token)
+	} else {
+		if err := mb.quota.Wait(context.Background(), costGetProfile); err != nil {
+			return "", err
+		}
+		profile, err := mb.provider.Profile(mb.context(), user)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up account: %w", err)
+		}
+		address = strings.ToLower(profile.EmailAddress)
+	}
+
+	accountCacheMu.Lock()
+	accountCache[key] = address
+	accountCacheMu.Unlock()
+	return address, nil
+}
+
+// mailboxForRequest creates the mailbox of the request's user, writing an error response
+// and returning nil if that fails. Dry runs, server-wide or asked for with
+// ?dryRun=true, are flagged with an X-Dry-Run response header.
+func mailboxForRequest(w http.ResponseWriter, r *http.Request) *mailbox {
 	// Parse token from Authorization header
 	token, err := ParseToken(r)
 	if err != nil {
-		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
-		return
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
+		return nil
 	}
 
-	// Create Gmail service
-	client := oauthConfig.Client(context.Background(), token)
-	gmailService, err := gmail.NewService(context.Background(), option.WithHTTPClient(client))
+	user, err := requestMailbox(r)
 	if err != nil {
-		http.Error(w, "Failed to create Gmail service: "+err.Error(), http.StatusInternalServerError)
-		return
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return nil
 	}
 
-	// Delete message (using trash)
-	user := "me" // special value for the authenticated user
-	_, err = gmailService.Users.Messages.Trash(user, messageID).Do()
+	mb, err := newMailbox(token, user)
+	if errors.Is(err, errNotDelegate) {
+		writeErrorFrom(w, "", err, http.StatusForbidden)
+		return nil
+	}
 	if err != nil {
-		http.Error(w, "Failed to delete email: "+err.Error(), http.StatusInternalServerError)
-		return
+		writeErrorFrom(w, "Failed to create Gmail service", err, http.StatusInternalServerError)
+		return nil
+	}
+	if requestDryRun(r) {
+		mb = mb.withDryRun()
 	}
+	if mb.dryRun() {
+		w.Header().Set(dryRunHeader, "true")
+	}
+	return mb
+}
 
-	// Return success
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Email moved to trash"})
+	json.NewEncoder(w).Encode(v)
+}
+
+// newID returns a random, unguessable identifier
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
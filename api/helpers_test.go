@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dustinmichels/gmail-deepclean/api/gmailtest"
+	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// Fake mailboxes of the test users, by access token
+	testServers   = make(map[string]*gmailtest.Server)
+	testServersMu sync.Mutex
+	// Numbers test users, so each has its own user ID and address
+	testUserCount atomic.Int64
+)
+
+// TestMain initializes the API once for every test, with its state in a temporary
+// directory and each user's mailbox served by a fake Gmail server
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "deepclean-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	cfg := defaultConfig()
+	cfg.DataDir = dir
+	cfg.SchedulerMinutes = 0
+	Init(cfg)
+	NewMailProvider = func(token *oauth2.Token) (MailProvider, error) {
+		testServersMu.Lock()
+		server, ok := testServers[token.AccessToken]
+		testServersMu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("no test mailbox for this token")
+		}
+		service, err := server.Service(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		return NewGmailProviderFromService(service), nil
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testUser is a signed-in user whose mailbox is a fake Gmail server
+type testUser struct {
+	server *gmailtest.Server
+	token  *oauth2.Token
+}
+
+// newTestUser starts a fake mailbox holding messages, with an address of its own
+func newTestUser(t *testing.T, messages ...gmailtest.Message) *testUser {
+	t.Helper()
+	n := testUserCount.Add(1)
+	server := gmailtest.NewServer(messages...)
+	server.EmailAddress = fmt.Sprintf("user%d@example.com", n)
+	t.Cleanup(server.Close)
+
+	// Like Google's, the access tokens of different users share a long prefix
+	token := &oauth2.Token{
+		AccessToken: fmt.Sprintf("ya29.a0AfB_byTest%06d", n),
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(time.Hour),
+	}
+	testServersMu.Lock()
+	testServers[token.AccessToken] = server
+	testServersMu.Unlock()
+	return &testUser{server: server, token: token}
+}
+
+// account returns the user's address, as the server keys their state
+func (u *testUser) account() string {
+	return u.server.EmailAddress
+}
+
+// mailbox returns the mailbox the server acts on for the user
+func (u *testUser) mailbox(t *testing.T) *mailbox {
+	t.Helper()
+	mb, err := newMailbox(u.token, "me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mb
+}
+
+// request builds a request of the user with body encoded as JSON, if not nil, and the
+// route variables vars
+func (u *testUser) request(t *testing.T, method, target string, body interface{}, vars map[string]string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest(method, target, &buf)
+	raw, err := json.Marshal(u.token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+string(raw))
+	return mux.SetURLVars(r, vars)
+}
+
+// serve runs a handler on a request and returns the recorded response
+func serve(handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// decodeResponse decodes a JSON response into v, failing the test unless it has the
+// wanted status
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", w.Code, status, w.Body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("decoding response: %v; body: %s", err, w.Body)
+		}
+	}
+}
+
+// messageHasLabel reports whether a message of the fake mailbox carries a label
+func messageHasLabel(t *testing.T, server *gmailtest.Server, id, label string) bool {
+	t.Helper()
+	labels, ok := server.Labels(id)
+	if !ok {
+		t.Fatalf("message %s is not in the mailbox", id)
+	}
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// senderMessages returns count messages from sender, the first starred ones of them
+func senderMessages(prefix, sender string, count, starred int) []gmailtest.Message {
+	messages := make([]gmailtest.Message, 0, count)
+	for i := 0; i < count; i++ {
+		labels := []string{"INBOX"}
+		if i < starred {
+			labels = append(labels, "STARRED")
+		}
+		messages = append(messages, gmailtest.Message{
+			ID:       prefix + string(rune('a'+i)),
+			From:     "Deals <" + sender + ">",
+			Subject:  "Offer",
+			Date:     gmailtest.FixtureTime.Add(-time.Duration(i) * time.Hour),
+			LabelIDs: labels,
+			Body:     "Buy now.",
+		})
+	}
+	return messages
+}
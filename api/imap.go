@@ -0,0 +1,284 @@
+package api
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-sasl"
+	"golang.org/x/oauth2"
+)
+
+// Token type of the tokens handed out by POST /auth/imap. Their access token is the ID
+// of the server-side IMAP session holding the credentials.
+const imapTokenType = "imap"
+
+// Prefix of the user IDs of IMAP mailboxes, followed by the account's address and server
+const imapUserPrefix = "imap:"
+
+// How long an IMAP command may take before the connection is dropped
+const imapTimeout = time.Minute
+
+// IMAPAccount is how to sign in to an IMAP server: with a password (usually an app
+// password) or with an OAuth access token over XOAUTH2
+type IMAPAccount struct {
+	// Server as host or host:port. Port 993 (the default) is TLS from the start; any
+	// other port must offer STARTTLS.
+	Server   string `json:"server"`
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+	// OAuth access token to authenticate with instead of a password
+	AccessToken string `json:"accessToken,omitempty"`
+	// Email address of the account, if the username isn't one. It is only shown, since
+	// nothing checks it; the account's state is keyed by the username and server.
+	Email string `json:"email,omitempty"`
+}
+
+// address returns the server's host:port
+func (a *IMAPAccount) address() string {
+	if _, _, err := net.SplitHostPort(a.Server); err == nil {
+		return a.Server
+	}
+	return net.JoinHostPort(a.Server, "993")
+}
+
+// emailAddress returns the account's email address, falling back to the username at
+// the server's domain
+func (a *IMAPAccount) emailAddress() string {
+	if a.Email != "" {
+		return strings.ToLower(a.Email)
+	}
+	if strings.Contains(a.Username, "@") {
+		return strings.ToLower(a.Username)
+	}
+	host, _, _ := net.SplitHostPort(a.address())
+	return strings.ToLower(a.Username + "@" + strings.TrimPrefix(host, "imap."))
+}
+
+// validate checks the account is complete and its server is one the config allows
+func (a *IMAPAccount) validate() error {
+	a.Server = strings.ToLower(strings.TrimSpace(a.Server))
+	a.Username = strings.TrimSpace(a.Username)
+	switch {
+	case a.Server == "" || a.Username == "":
+		return errors.New("server and username are required")
+	case (a.Password == "") == (a.AccessToken == ""):
+		return errors.New("give either a password or an accessToken")
+	}
+	host, port, err := net.SplitHostPort(a.address())
+	if err != nil || !validPort(port) {
+		return fmt.Errorf("invalid server %q", a.Server)
+	}
+	for _, allowed := range config.IMAPServers {
+		if allowed == "*" || strings.EqualFold(allowed, host) || strings.EqualFold(allowed, a.address()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("IMAP server %s is not allowed on this server", host)
+}
+
+// imapSession is a signed-in IMAP account and its connection, shared by every request
+// and scan of the account. IMAP connections handle one command at a time, so access to
+// the connection is serialized.
+type imapSession struct {
+	account IMAPAccount
+	mu      sync.Mutex
+	client  *client.Client
+	// Folders of the account and when they were listed
+	folders   []imapFolder
+	foldersAt time.Time
+}
+
+var (
+	// Maps session IDs to the sessions signed in through POST /auth/imap. Sessions only
+	// live in memory, so credentials are never written to disk and users sign in again
+	// after a restart.
+	imapSessions   = make(map[string]*imapSession)
+	imapSessionsMu sync.Mutex
+)
+
+// isIMAPToken reports whether a token belongs to an IMAP session rather than Google
+func isIMAPToken(token *oauth2.Token) bool {
+	return token.TokenType == imapTokenType
+}
+
+// imapSessionFor returns the session of an IMAP token, or nil if it has ended
+func imapSessionFor(token *oauth2.Token) *imapSession {
+	imapSessionsMu.Lock()
+	defer imapSessionsMu.Unlock()
+	return imapSessions[token.AccessToken]
+}
+
+// imapUserID derives the key of an IMAP mailbox's server-side state, which stays the
+// same across sessions
+func imapUserID(token *oauth2.Token) string {
+	session := imapSessionFor(token)
+	if session == nil {
+		return imapUserPrefix + token.AccessToken
+	}
+	host, _, _ := net.SplitHostPort(session.account.address())
+	return imapUserPrefix + strings.ToLower(session.account.Username) + "@" + host
+}
+
+// connect dials the account's server and signs in
+func (a *IMAPAccount) connect() (*client.Client, error) {
+	host, port, _ := net.SplitHostPort(a.address())
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	tlsConfig := &tls.Config{ServerName: host}
+
+	var c *client.Client
+	var err error
+	if port == "993" {
+		c, err = client.DialWithDialerTLS(dialer, a.address(), tlsConfig)
+	} else {
+		c, err = client.DialWithDialer(dialer, a.address())
+		if err == nil {
+			// Never send credentials in the clear
+			if ok, _ := c.SupportStartTLS(); !ok {
+				c.Logout()
+				return nil, fmt.Errorf("%s doesn't support STARTTLS", a.address())
+			}
+			err = c.StartTLS(tlsConfig)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", a.address(), err)
+	}
+	c.Timeout = imapTimeout
+
+	if a.AccessToken != "" {
+		err = c.Authenticate(&xoauth2Client{username: a.Username, token: a.AccessToken})
+	} else {
+		err = c.Login(a.Username, a.Password)
+	}
+	if err != nil {
+		c.Logout()
+		return nil, fmt.Errorf("failed to sign in to %s: %w", a.address(), err)
+	}
+	return c, nil
+}
+
+// xoauth2Client authenticates with an OAuth access token using the XOAUTH2 mechanism
+// of Gmail, Outlook and Yahoo
+type xoauth2Client struct {
+	username string
+	token    string
+}
+
+func (c *xoauth2Client) Start() (string, []byte, error) {
+	return "XOAUTH2", []byte("user=" + c.username + "\x01auth=Bearer " + c.token + "\x01\x01"), nil
+}
+
+// Next is only called when the server rejects the token, with its error details
+func (c *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	return nil, fmt.Errorf("XOAUTH2 authentication failed: %s", challenge)
+}
+
+var _ sasl.Client = (*xoauth2Client)(nil)
+
+// do runs fn with the session's connection, connecting or reconnecting as needed.
+// A connection that failed is dropped, so the next call starts afresh.
+func (s *imapSession) do(fn func(c *client.Client) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		select {
+		case <-s.client.LoggedOut():
+			s.client = nil
+		default:
+		}
+	}
+	if s.client == nil {
+		c, err := s.account.connect()
+		if err != nil {
+			return err
+		}
+		s.client = c
+	}
+
+	err := fn(s.client)
+	var netErr net.Error
+	if errors.As(err, &netErr) || s.client.State() == imap.LogoutState {
+		s.client.Terminate()
+		s.client = nil
+	}
+	return err
+}
+
+// close signs out of the server
+func (s *imapSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		s.client.Logout()
+		s.client = nil
+	}
+}
+
+// HandleIMAPConnect signs in to an IMAP server and returns a token to use like the
+// Google token from /auth/gmail/callback. The credentials stay in the server's memory
+// for as long as the session lasts.
+func HandleIMAPConnect(w http.ResponseWriter, r *http.Request) {
+	if len(config.IMAPServers) == 0 {
+		writeError(w, "IMAP accounts are not enabled on this server (IMAP_SERVERS not set)", http.StatusNotFound)
+		return
+	}
+
+	var account IMAPAccount
+	if err := json.NewDecoder(r.Body).Decode(&account); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if err := account.validate(); err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+
+	c, err := account.connect()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusUnauthorized)
+		return
+	}
+
+	id := newID()
+	imapSessionsMu.Lock()
+	imapSessions[id] = &imapSession{account: account, client: c}
+	imapSessionsMu.Unlock()
+	log.Printf("Signed in to IMAP server %s", account.address())
+
+	writeJSON(w, &oauth2.Token{AccessToken: id, TokenType: imapTokenType})
+}
+
+// HandleIMAPDisconnect signs out of the IMAP session of the request's token and forgets
+// its credentials
+func HandleIMAPDisconnect(w http.ResponseWriter, r *http.Request) {
+	token, err := ParseToken(r)
+	if err != nil {
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
+		return
+	}
+	if !isIMAPToken(token) {
+		writeError(w, "Not an IMAP session", http.StatusBadRequest)
+		return
+	}
+
+	imapSessionsMu.Lock()
+	session := imapSessions[token.AccessToken]
+	delete(imapSessions, token.AccessToken)
+	imapSessionsMu.Unlock()
+	if session != nil {
+		session.close()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
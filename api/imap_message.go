@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Longest snippet built for IMAP messages, about what Gmail shows
+const snippetLength = 200
+
+// splitMessage returns the header fields of a raw message in order, with encoded words
+// decoded as Gmail does, and the body after them
+func splitMessage(raw []byte) ([]*gmail.MessagePartHeader, []byte) {
+	headerEnd, sep := bytes.Index(raw, []byte("\r\n\r\n")), 4
+	if headerEnd < 0 {
+		headerEnd, sep = bytes.Index(raw, []byte("\n\n")), 2
+	}
+	block, body := raw, []byte(nil)
+	if headerEnd >= 0 {
+		block, body = raw[:headerEnd], raw[headerEnd+sep:]
+	}
+
+	var decoder mime.WordDecoder
+	headers := make([]*gmail.MessagePartHeader, 0)
+	for _, line := range strings.Split(string(block), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		// Folded lines continue the field before them
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			last := headers[len(headers)-1]
+			last.Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		headers = append(headers, &gmail.MessagePartHeader{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+	}
+	for _, header := range headers {
+		if decoded, err := decoder.DecodeHeader(header.Value); err == nil {
+			header.Value = decoded
+		}
+	}
+	return headers, body
+}
+
+// mimeHeader indexes header fields by name, for the stdlib MIME helpers
+func mimeHeader(headers []*gmail.MessagePartHeader) textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader, len(headers))
+	for _, h := range headers {
+		header.Add(h.Name, h.Value)
+	}
+	return header
+}
+
+// messagePart converts a raw MIME entity into a Gmail payload, recursing into multipart
+// containers. Leaf bodies are decoded and inlined, so no part needs an attachment ID.
+func messagePart(partID string, raw []byte) *gmail.MessagePart {
+	headers, body := splitMessage(raw)
+	header := mimeHeader(headers)
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	part := &gmail.MessagePart{
+		PartId:   partID,
+		MimeType: mediaType,
+		Headers:  headers,
+		Body:     &gmail.MessagePartBody{},
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for i := 0; ; i++ {
+			child, err := reader.NextRawPart()
+			if err != nil {
+				break
+			}
+			var raw bytes.Buffer
+			for name, values := range child.Header {
+				for _, value := range values {
+					raw.WriteString(name + ": " + value + "\r\n")
+				}
+			}
+			raw.WriteString("\r\n")
+			io.Copy(&raw, child)
+
+			childID := strconv.Itoa(i)
+			if partID != "" {
+				childID = partID + "." + childID
+			}
+			part.Parts = append(part.Parts, messagePart(childID, raw.Bytes()))
+		}
+		return part
+	}
+
+	data := decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+	part.Filename, _ = attachmentFilename(header)
+	part.Body.Size = int64(len(data))
+	part.Body.Data = base64.URLEncoding.EncodeToString(data)
+	return part
+}
+
+// decodeTransferEncoding undoes a part's Content-Transfer-Encoding, leaving the body as
+// is if it doesn't decode
+func decodeTransferEncoding(encoding string, body []byte) []byte {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		cleaned := strings.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+			return r
+		}, string(body))
+		if data, err := base64.StdEncoding.DecodeString(cleaned); err == nil {
+			return data
+		}
+		if data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(cleaned, "=")); err == nil {
+			return data
+		}
+	case "quoted-printable":
+		if data, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body))); err == nil {
+			return data
+		}
+	}
+	return body
+}
+
+// messageSnippet returns the start of a payload's first plain text body, with its
+// whitespace collapsed
+func messageSnippet(payload *gmail.MessagePart) string {
+	var snippet string
+	walkMessageParts(payload, func(part *gmail.MessagePart) {
+		if snippet != "" || part.Filename != "" || !strings.EqualFold(part.MimeType, "text/plain") {
+			return
+		}
+		data, err := decodeBase64URL(part.Body.Data)
+		if err != nil {
+			return
+		}
+		snippet = strings.Join(strings.Fields(string(data)), " ")
+	})
+	if utf8.RuneCountInString(snippet) > snippetLength {
+		snippet = string([]rune(snippet)[:snippetLength])
+	}
+	return snippet
+}
@@ -0,0 +1,934 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// IMAP has no labels, only folders and flags. Each folder maps onto one label: INBOX,
+// the special-use folders onto SENT, DRAFT, TRASH and SPAM, and the rest onto user
+// labels named like the folder. \Seen and \Flagged map onto UNREAD and STARRED. The
+// archive folder has no label, so its messages are simply not in the inbox, and the
+// \All and \Flagged virtual folders are skipped since they only repeat other messages.
+
+// How long the folder list of a session is reused before listing it again
+const imapFolderTTL = 5 * time.Minute
+
+// imapFolder is a folder of an IMAP account and the label it maps onto
+type imapFolder struct {
+	Name string
+	// System label ID, the folder name for user labels, or "" for the archive folder
+	Label   string
+	archive bool
+}
+
+// Folder names servers without SPECIAL-USE commonly give their special folders
+var imapSpecialNames = map[string]string{
+	"sent": "SENT", "sent items": "SENT", "sent messages": "SENT", "sent mail": "SENT",
+	"drafts": "DRAFT",
+	"trash":  "TRASH", "deleted items": "TRASH", "deleted messages": "TRASH", "bin": "TRASH",
+	"junk": "SPAM", "spam": "SPAM", "junk e-mail": "SPAM", "junk email": "SPAM", "bulk mail": "SPAM",
+}
+
+// Labels IMAP flags map onto
+var imapFlagLabels = map[string]string{
+	"UNREAD":  imap.SeenFlag,
+	"STARRED": imap.FlaggedFlag,
+}
+
+// Sections of a message fetched for each Gmail format
+var (
+	imapThreadSection, _ = imap.ParseBodySectionName("BODY.PEEK[HEADER.FIELDS (MESSAGE-ID REFERENCES IN-REPLY-TO)]")
+	imapHeaderSection, _ = imap.ParseBodySectionName("BODY.PEEK[HEADER]")
+	imapFullSection, _   = imap.ParseBodySectionName("BODY.PEEK[]")
+)
+
+// IMAPProvider is the MailProvider backed by an IMAP session. Message IDs encode the
+// folder, UID and Message-ID header of a message, so a message moved to another folder,
+// e.g. by trashing it, can still be found.
+type IMAPProvider struct {
+	session *imapSession
+}
+
+// newIMAPProvider creates the provider of an IMAP token's session
+func newIMAPProvider(token *oauth2.Token) (*IMAPProvider, error) {
+	session := imapSessionFor(token)
+	if session == nil {
+		return nil, errIMAPSessionEnded
+	}
+	return &IMAPProvider{session: session}, nil
+}
+
+var errIMAPSessionEnded = errors.New("IMAP session has ended, sign in again")
+
+// imapNotFound reports a message, thread or label that doesn't exist like Gmail does
+func imapNotFound(what, id string) error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("%s %s not found", what, id)}
+}
+
+// imapMessageRef locates a message of an IMAP account
+type imapMessageRef struct {
+	Folder string
+	UID    uint32
+	// Message-ID header, to find the message again once it moved
+	MessageID string
+}
+
+// String encodes the reference as a URL-safe message ID
+func (r imapMessageRef) String() string {
+	id := base64.RawURLEncoding.EncodeToString([]byte(r.Folder)) + "." + strconv.FormatUint(uint64(r.UID), 10)
+	if r.MessageID != "" {
+		id += "." + base64.RawURLEncoding.EncodeToString([]byte(r.MessageID))
+	}
+	return id
+}
+
+// parseIMAPMessageRef decodes a message ID made by imapMessageRef.String
+func parseIMAPMessageRef(id string) (imapMessageRef, error) {
+	parts := strings.Split(id, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return imapMessageRef{}, imapNotFound("message", id)
+	}
+	folder, err := base64.RawURLEncoding.DecodeString(parts[0])
+	uid, uidErr := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil || uidErr != nil || uid == 0 {
+		return imapMessageRef{}, imapNotFound("message", id)
+	}
+	ref := imapMessageRef{Folder: string(folder), UID: uint32(uid)}
+	if len(parts) == 3 {
+		messageID, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			return imapMessageRef{}, imapNotFound("message", id)
+		}
+		ref.MessageID = string(messageID)
+	}
+	return ref, nil
+}
+
+// call runs fn on the session's connection, traced as op
+func (p *IMAPProvider) call(ctx context.Context, user, op string, fn func(c *client.Client) error) (err error) {
+	_, span := tracer.Start(ctx, "imap."+op)
+	defer func() { endSpan(span, err) }()
+
+	if user != "me" {
+		return fmt.Errorf("%w: acting on another user's mailbox", ErrNotSupported)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.session.do(fn)
+}
+
+// listFolders returns the account's folders, listing them if the cached list is stale.
+// The session must be locked.
+func (s *imapSession) listFolders(c *client.Client) ([]imapFolder, error) {
+	if s.folders != nil && time.Since(s.foldersAt) < imapFolderTTL {
+		return s.folders, nil
+	}
+
+	ch := make(chan *imap.MailboxInfo, 16)
+	done := make(chan error, 1)
+	go func() { done <- c.List("", "*", ch) }()
+	infos := make([]*imap.MailboxInfo, 0)
+	for info := range ch {
+		infos = append(infos, info)
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+
+	// Special-use attributes take precedence over well-known names
+	folders := make([]imapFolder, 0, len(infos))
+	taken := make(map[string]bool)
+	byName := make([]*imap.MailboxInfo, 0)
+	for _, info := range infos {
+		if slices.Contains(info.Attributes, imap.NoSelectAttr) ||
+			slices.Contains(info.Attributes, imap.AllAttr) || slices.Contains(info.Attributes, imap.FlaggedAttr) {
+			continue
+		}
+		label := ""
+		switch {
+		case strings.EqualFold(info.Name, imap.InboxName):
+			label = "INBOX"
+		case slices.Contains(info.Attributes, imap.SentAttr):
+			label = "SENT"
+		case slices.Contains(info.Attributes, imap.DraftsAttr):
+			label = "DRAFT"
+		case slices.Contains(info.Attributes, imap.TrashAttr):
+			label = "TRASH"
+		case slices.Contains(info.Attributes, imap.JunkAttr):
+			label = "SPAM"
+		case slices.Contains(info.Attributes, imap.ArchiveAttr):
+			folders = append(folders, imapFolder{Name: info.Name, archive: true})
+			taken["archive"] = true
+			continue
+		default:
+			byName = append(byName, info)
+			continue
+		}
+		folders = append(folders, imapFolder{Name: info.Name, Label: label})
+		taken[label] = true
+	}
+	for _, info := range byName {
+		// Folders under the inbox, like INBOX.Sent, count by their own name
+		name := info.Name
+		if info.Delimiter != "" {
+			name = strings.TrimPrefix(name, imap.InboxName+info.Delimiter)
+		}
+		name = strings.ToLower(name)
+		if label, ok := imapSpecialNames[name]; ok && !taken[label] {
+			folders = append(folders, imapFolder{Name: info.Name, Label: label})
+			taken[label] = true
+		} else if name == "archive" && !taken["archive"] {
+			folders = append(folders, imapFolder{Name: info.Name, archive: true})
+			taken["archive"] = true
+		} else {
+			folders = append(folders, imapFolder{Name: info.Name, Label: info.Name})
+		}
+	}
+
+	s.folders, s.foldersAt = folders, time.Now()
+	return folders, nil
+}
+
+// folderNamed returns the folder with the given name, or the one with the given label
+func folderNamed(folders []imapFolder, name, label string) *imapFolder {
+	for i := range folders {
+		if (name != "" && folders[i].Name == name) || (label != "" && folders[i].Label == label) {
+			return &folders[i]
+		}
+	}
+	return nil
+}
+
+// archiveFolder returns the folder messages go to when they leave the inbox
+func archiveFolder(folders []imapFolder) (*imapFolder, error) {
+	for i := range folders {
+		if folders[i].archive {
+			return &folders[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: archiving without an Archive folder", ErrNotSupported)
+}
+
+// selectFolder selects a folder unless it already is, read-only unless writable
+func selectFolder(c *client.Client, name string, writable bool) error {
+	if mbox := c.Mailbox(); mbox != nil && mbox.Name == name && (!mbox.ReadOnly || !writable) {
+		return nil
+	}
+	_, err := c.Select(name, !writable)
+	return err
+}
+
+// locate finds a message, in its original folder or, if it moved, wherever its
+// Message-ID turns up, and selects that folder
+func (s *imapSession) locate(c *client.Client, ref imapMessageRef, writable bool) (*imapFolder, uint32, error) {
+	folders, err := s.listFolders(c)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if folder := folderNamed(folders, ref.Folder, ""); folder != nil {
+		if err := selectFolder(c, folder.Name, writable); err != nil {
+			return nil, 0, err
+		}
+		uids, err := c.UidSearch(&imap.SearchCriteria{Uid: uidSet(ref.UID)})
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(uids) > 0 {
+			return folder, uids[0], nil
+		}
+	}
+
+	if ref.MessageID != "" {
+		criteria := &imap.SearchCriteria{Header: map[string][]string{"Message-Id": {ref.MessageID}}}
+		for i := range folders {
+			if folders[i].Name == ref.Folder {
+				continue
+			}
+			if err := selectFolder(c, folders[i].Name, writable); err != nil {
+				return nil, 0, err
+			}
+			uids, err := c.UidSearch(criteria)
+			if err != nil {
+				return nil, 0, err
+			}
+			if len(uids) > 0 {
+				return &folders[i], slices.Max(uids), nil
+			}
+		}
+	}
+	return nil, 0, imapNotFound("message", ref.String())
+}
+
+// uidSet returns a set of the given UIDs
+func uidSet(uids ...uint32) *imap.SeqSet {
+	set := new(imap.SeqSet)
+	set.AddNum(uids...)
+	return set
+}
+
+// fetchMessages fetches the given items of messages of the selected folder by UID
+func fetchMessages(c *client.Client, uids []uint32, items []imap.FetchItem) (map[uint32]*imap.Message, error) {
+	messages := make(map[uint32]*imap.Message, len(uids))
+	if len(uids) == 0 {
+		return messages, nil
+	}
+	ch := make(chan *imap.Message, 16)
+	done := make(chan error, 1)
+	go func() { done <- c.UidFetch(uidSet(uids...), append(items, imap.FetchUid), ch) }()
+	for msg := range ch {
+		messages[msg.Uid] = msg
+	}
+	return messages, <-done
+}
+
+// readSection returns a fetched body section of a message, or nil
+func readSection(msg *imap.Message, section *imap.BodySectionName) []byte {
+	literal := msg.GetBody(section)
+	if literal == nil {
+		return nil
+	}
+	data, _ := io.ReadAll(literal)
+	return data
+}
+
+// fetchGmailMessages fetches messages of the selected folder in a Gmail format, only
+// with the wanted headers if the format is metadata. Messages that are gone are left out.
+func fetchGmailMessages(c *client.Client, folder *imapFolder, uids []uint32, format string, wanted ...string) ([]*gmail.Message, error) {
+	items := []imap.FetchItem{imap.FetchFlags, imap.FetchInternalDate, imap.FetchRFC822Size, imapThreadSection.FetchItem()}
+	switch format {
+	case "metadata":
+		items = append(items, imapHeaderSection.FetchItem())
+	case "full", "raw":
+		items = append(items, imapFullSection.FetchItem())
+	}
+	fetched, err := fetchMessages(c, uids, items)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*gmail.Message, 0, len(uids))
+	for _, uid := range uids {
+		msg, ok := fetched[uid]
+		if !ok {
+			continue
+		}
+
+		idHeaders, _ := splitMessage(readSection(msg, imapThreadSection))
+		messageID, root := threadRoot(idHeaders)
+		ref := imapMessageRef{Folder: folder.Name, UID: uid, MessageID: messageID}
+		message := &gmail.Message{
+			Id:           ref.String(),
+			ThreadId:     ref.String(),
+			LabelIds:     messageLabels(folder, msg.Flags),
+			SizeEstimate: int64(msg.Size),
+			InternalDate: msg.InternalDate.UnixMilli(),
+		}
+		if root != "" {
+			message.ThreadId = base64.RawURLEncoding.EncodeToString([]byte(root))
+		}
+
+		switch format {
+		case "metadata":
+			headers, _ := splitMessage(readSection(msg, imapHeaderSection))
+			if len(wanted) > 0 {
+				headers = slices.DeleteFunc(headers, func(h *gmail.MessagePartHeader) bool {
+					return !slices.ContainsFunc(wanted, func(name string) bool { return strings.EqualFold(name, h.Name) })
+				})
+			}
+			message.Payload = &gmail.MessagePart{Headers: headers}
+		case "full":
+			message.Payload = messagePart("", readSection(msg, imapFullSection))
+			message.Snippet = messageSnippet(message.Payload)
+		case "raw":
+			message.Raw = base64.URLEncoding.EncodeToString(readSection(msg, imapFullSection))
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// threadRoot returns a message's Message-ID and that of the first message of its
+// thread, which IMAP messages are threaded by
+func threadRoot(headers []*gmail.MessagePartHeader) (messageID, root string) {
+	var references, inReplyTo string
+	for _, h := range headers {
+		switch strings.ToLower(h.Name) {
+		case "message-id":
+			messageID = strings.TrimSpace(h.Value)
+		case "references":
+			references = h.Value
+		case "in-reply-to":
+			inReplyTo = h.Value
+		}
+	}
+	if fields := strings.Fields(references); len(fields) > 0 {
+		return messageID, fields[0]
+	}
+	if fields := strings.Fields(inReplyTo); len(fields) > 0 {
+		return messageID, fields[0]
+	}
+	return messageID, messageID
+}
+
+// messageLabels maps a message's folder and flags onto Gmail label IDs
+func messageLabels(folder *imapFolder, flags []string) []string {
+	labels := make([]string, 0, 3)
+	if folder.Label != "" {
+		labels = append(labels, folder.Label)
+	}
+	if !slices.Contains(flags, imap.SeenFlag) {
+		labels = append(labels, "UNREAD")
+	}
+	if slices.Contains(flags, imap.FlaggedFlag) {
+		labels = append(labels, "STARRED")
+	}
+	if slices.Contains(flags, imap.DraftFlag) && folder.Label != "DRAFT" {
+		labels = append(labels, "DRAFT")
+	}
+	return labels
+}
+
+// modify changes the labels of a message: flag labels are stored as flags, and folder
+// labels copy the message to their folder, or move it there when the message leaves
+// its own folder or goes to Trash or Spam. The session must be locked.
+func (s *imapSession) modify(c *client.Client, ref imapMessageRef, addLabelIDs, removeLabelIDs []string) error {
+	folder, uid, err := s.locate(c, ref, true)
+	if err != nil {
+		return err
+	}
+	folders, err := s.listFolders(c)
+	if err != nil {
+		return err
+	}
+	set := uidSet(uid)
+
+	addFlags, removeFlags := make([]interface{}, 0), make([]interface{}, 0)
+	targets := make([]*imapFolder, 0)
+	for _, label := range addLabelIDs {
+		switch {
+		case label == "UNREAD":
+			removeFlags = append(removeFlags, imap.SeenFlag)
+		case imapFlagLabels[label] != "":
+			addFlags = append(addFlags, imapFlagLabels[label])
+		case label != folder.Label:
+			target := folderNamed(folders, "", label)
+			if target == nil {
+				return imapNotFound("label", label)
+			}
+			targets = append(targets, target)
+		}
+	}
+	for _, label := range removeLabelIDs {
+		switch {
+		case label == "UNREAD":
+			addFlags = append(addFlags, imap.SeenFlag)
+		case imapFlagLabels[label] != "":
+			removeFlags = append(removeFlags, imapFlagLabels[label])
+		}
+	}
+
+	if len(addFlags) > 0 {
+		if err := c.UidStore(set, imap.FormatFlagsOp(imap.AddFlags, true), addFlags, nil); err != nil {
+			return err
+		}
+	}
+	if len(removeFlags) > 0 {
+		if err := c.UidStore(set, imap.FormatFlagsOp(imap.RemoveFlags, true), removeFlags, nil); err != nil {
+			return err
+		}
+	}
+
+	leaves := folder.Label != "" && slices.Contains(removeLabelIDs, folder.Label)
+	var moveTo *imapFolder
+	copies := make([]*imapFolder, 0, len(targets))
+	for _, target := range targets {
+		if moveTo == nil && (leaves || target.Label == "TRASH" || target.Label == "SPAM") {
+			moveTo = target
+			continue
+		}
+		copies = append(copies, target)
+	}
+	if leaves && moveTo == nil {
+		if moveTo, err = archiveFolder(folders); err != nil {
+			return err
+		}
+	}
+
+	for _, target := range copies {
+		if err := c.UidCopy(set, target.Name); err != nil {
+			return err
+		}
+	}
+	if moveTo != nil {
+		return c.UidMove(set, moveTo.Name)
+	}
+	return nil
+}
+
+// threadMessages returns the messages of a thread in every folder, oldest first. A
+// message without a Message-ID is its own thread, with the message's ID.
+func (s *imapSession) threadMessages(c *client.Client, threadID, format string, wanted ...string) ([]*gmail.Message, error) {
+	if ref, err := parseIMAPMessageRef(threadID); err == nil {
+		folder, uid, err := s.locate(c, ref, false)
+		if err != nil {
+			return nil, err
+		}
+		return fetchGmailMessages(c, folder, []uint32{uid}, format, wanted...)
+	}
+
+	root, err := base64.RawURLEncoding.DecodeString(threadID)
+	if err != nil || len(root) == 0 {
+		return nil, imapNotFound("thread", threadID)
+	}
+	folders, err := s.listFolders(c)
+	if err != nil {
+		return nil, err
+	}
+	criteria := &imap.SearchCriteria{Or: [][2]*imap.SearchCriteria{{
+		{Header: map[string][]string{"Message-Id": {string(root)}}},
+		{Header: map[string][]string{"References": {string(root)}}},
+	}}}
+
+	messages := make([]*gmail.Message, 0)
+	for i := range folders {
+		if err := selectFolder(c, folders[i].Name, false); err != nil {
+			return nil, err
+		}
+		uids, err := c.UidSearch(criteria)
+		if err != nil {
+			return nil, err
+		}
+		found, err := fetchGmailMessages(c, &folders[i], uids, format, wanted...)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, found...)
+	}
+	if len(messages) == 0 {
+		return nil, imapNotFound("thread", threadID)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].InternalDate < messages[j].InternalDate })
+	return messages, nil
+}
+
+// listCursor is where the next page of a listing starts: the folder, and the UID the
+// page's messages are below (0 for the start of the folder)
+func listCursor(folder string, belowUID uint32) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(folder)) + "." + strconv.FormatUint(uint64(belowUID), 10)
+}
+
+// parseListCursor decodes a page token made by listCursor
+func parseListCursor(token string) (string, uint32, error) {
+	encoded, below, ok := strings.Cut(token, ".")
+	folder, err := base64.RawURLEncoding.DecodeString(encoded)
+	uid, uidErr := strconv.ParseUint(below, 10, 32)
+	if !ok || err != nil || uidErr != nil {
+		return "", 0, &googleapi.Error{Code: http.StatusBadRequest, Message: "invalid pageToken"}
+	}
+	return string(folder), uint32(uid), nil
+}
+
+func (p *IMAPProvider) Profile(ctx context.Context, user string) (*gmail.Profile, error) {
+	profile := &gmail.Profile{EmailAddress: p.session.account.emailAddress()}
+	err := p.call(ctx, user, "profile", func(c *client.Client) error {
+		folders, err := p.session.listFolders(c)
+		if err != nil {
+			return err
+		}
+		for _, folder := range folders {
+			status, err := c.Status(folder.Name, []imap.StatusItem{imap.StatusMessages})
+			if err != nil {
+				return err
+			}
+			profile.MessagesTotal += int64(status.Messages)
+		}
+		return nil
+	})
+	return profile, err
+}
+
+// ListMessages lists messages newest first, folder by folder. Label IDs and in: terms
+// pick the folders, and the rest of the Gmail query is translated into an IMAP search.
+func (p *IMAPProvider) ListMessages(ctx context.Context, user string, query MessageQuery) (*gmail.ListMessagesResponse, error) {
+	search, err := parseIMAPSearch(query.Query)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := int(query.MaxResults)
+	if pageSize <= 0 || pageSize > maxEmailsPageSize {
+		pageSize = 100
+	}
+	var startFolder string
+	var belowUID uint32
+	if query.PageToken != "" {
+		if startFolder, belowUID, err = parseListCursor(query.PageToken); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &gmail.ListMessagesResponse{Messages: make([]*gmail.Message, 0)}
+	err = p.call(ctx, user, "list", func(c *client.Client) error {
+		folders, err := p.session.listFolders(c)
+		if err != nil {
+			return err
+		}
+		selected, err := search.folders(folders, query.LabelIDs, query.IncludeSpamTrash)
+		if err != nil {
+			return err
+		}
+
+		start := 0
+		if startFolder != "" {
+			start = slices.IndexFunc(selected, func(f *imapFolder) bool { return f.Name == startFolder })
+			if start < 0 {
+				return &googleapi.Error{Code: http.StatusBadRequest, Message: "invalid pageToken"}
+			}
+		}
+		for i := start; i < len(selected); i++ {
+			criteria := *search.criteria
+			if i == start && belowUID > 0 {
+				if belowUID == 1 {
+					continue
+				}
+				criteria.Uid = new(imap.SeqSet)
+				criteria.Uid.AddRange(1, belowUID-1)
+			}
+			if err := selectFolder(c, selected[i].Name, false); err != nil {
+				return err
+			}
+			uids, err := c.UidSearch(&criteria)
+			if err != nil {
+				return err
+			}
+			slices.Sort(uids)
+			slices.Reverse(uids)
+
+			room := pageSize - len(resp.Messages)
+			page := uids[:min(room, len(uids))]
+			messages, err := fetchGmailMessages(c, selected[i], page, "minimal")
+			if err != nil {
+				return err
+			}
+			for _, msg := range messages {
+				resp.Messages = append(resp.Messages, &gmail.Message{Id: msg.Id, ThreadId: msg.ThreadId})
+			}
+
+			if len(uids) > room {
+				resp.NextPageToken = listCursor(selected[i].Name, page[len(page)-1])
+				return nil
+			}
+			if len(resp.Messages) >= pageSize && i+1 < len(selected) {
+				resp.NextPageToken = listCursor(selected[i+1].Name, 0)
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.ResultSizeEstimate = int64(len(resp.Messages))
+	return resp, nil
+}
+
+func (p *IMAPProvider) GetMessage(ctx context.Context, user, id, format string, headers ...string) (*gmail.Message, error) {
+	ref, err := parseIMAPMessageRef(id)
+	if err != nil {
+		return nil, err
+	}
+	var message *gmail.Message
+	err = p.call(ctx, user, "get", func(c *client.Client) error {
+		folder, uid, err := p.session.locate(c, ref, false)
+		if err != nil {
+			return err
+		}
+		messages, err := fetchGmailMessages(c, folder, []uint32{uid}, format, headers...)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return imapNotFound("message", id)
+		}
+		message = messages[0]
+		return nil
+	})
+	return message, err
+}
+
+// GetAttachment always fails: IMAP message parts are inlined, so none has an
+// attachment ID
+func (p *IMAPProvider) GetAttachment(ctx context.Context, user, messageID, attachmentID string) (*gmail.MessagePartBody, error) {
+	return nil, imapNotFound("attachment", attachmentID)
+}
+
+// InsertMessage appends a raw message to the folder of its first folder label, the
+// inbox by default, with flags from its flag labels
+func (p *IMAPProvider) InsertMessage(ctx context.Context, user string, msg *gmail.Message) (*gmail.Message, error) {
+	raw, err := decodeBase64URL(msg.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw message: %w", err)
+	}
+
+	var inserted *gmail.Message
+	err = p.call(ctx, user, "insert", func(c *client.Client) error {
+		folders, err := p.session.listFolders(c)
+		if err != nil {
+			return err
+		}
+		folder := folderNamed(folders, "", "INBOX")
+		for _, label := range msg.LabelIds {
+			if target := folderNamed(folders, "", label); target != nil && imapFlagLabels[label] == "" {
+				folder = target
+				break
+			}
+		}
+		if folder == nil {
+			return imapNotFound("label", "INBOX")
+		}
+		flags := make([]string, 0, 2)
+		if !slices.Contains(msg.LabelIds, "UNREAD") {
+			flags = append(flags, imap.SeenFlag)
+		}
+		if slices.Contains(msg.LabelIds, "STARRED") {
+			flags = append(flags, imap.FlaggedFlag)
+		}
+
+		date := time.Now()
+		headers, _ := splitMessage(raw)
+		if parsed, err := mail.ParseDate(mimeHeader(headers).Get("Date")); err == nil {
+			date = parsed
+		}
+		if err := c.Append(folder.Name, flags, date, bytes.NewBuffer(raw)); err != nil {
+			return err
+		}
+
+		// APPEND doesn't say which UID the message got, so look for the newest with its
+		// Message-ID
+		criteria := &imap.SearchCriteria{}
+		if messageID, _ := threadRoot(headers); messageID != "" {
+			criteria.Header = map[string][]string{"Message-Id": {messageID}}
+		}
+		if err := selectFolder(c, folder.Name, false); err != nil {
+			return err
+		}
+		uids, err := c.UidSearch(criteria)
+		if err != nil {
+			return err
+		}
+		if len(uids) == 0 {
+			return imapNotFound("message", "appended to "+folder.Name)
+		}
+		messages, err := fetchGmailMessages(c, folder, []uint32{slices.Max(uids)}, "minimal")
+		if err != nil || len(messages) == 0 {
+			return err
+		}
+		inserted = messages[0]
+		return nil
+	})
+	return inserted, err
+}
+
+// ImportMessage appends a message to the inbox, unread
+func (p *IMAPProvider) ImportMessage(ctx context.Context, user string, raw io.Reader) (*gmail.Message, error) {
+	data, err := io.ReadAll(raw)
+	if err != nil {
+		return nil, err
+	}
+	return p.InsertMessage(ctx, user, &gmail.Message{
+		Raw:      base64.URLEncoding.EncodeToString(data),
+		LabelIds: []string{"INBOX", "UNREAD"},
+	})
+}
+
+func (p *IMAPProvider) SendMessage(ctx context.Context, user string, msg *gmail.Message) error {
+	return fmt.Errorf("%w: sending mail over IMAP", ErrNotSupported)
+}
+
+func (p *IMAPProvider) ModifyMessage(ctx context.Context, user, id string, addLabelIDs, removeLabelIDs []string) error {
+	ref, err := parseIMAPMessageRef(id)
+	if err != nil {
+		return err
+	}
+	return p.call(ctx, user, "modify", func(c *client.Client) error {
+		return p.session.modify(c, ref, addLabelIDs, removeLabelIDs)
+	})
+}
+
+func (p *IMAPProvider) BatchModify(ctx context.Context, user string, ids, addLabelIDs, removeLabelIDs []string) error {
+	return p.call(ctx, user, "batchModify", func(c *client.Client) error {
+		var errs []error
+		for _, id := range ids {
+			ref, err := parseIMAPMessageRef(id)
+			if err == nil {
+				err = p.session.modify(c, ref, addLabelIDs, removeLabelIDs)
+			}
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	})
+}
+
+func (p *IMAPProvider) Trash(ctx context.Context, user, id string) error {
+	return p.ModifyMessage(ctx, user, id, []string{"TRASH"}, nil)
+}
+
+// Untrash moves a message from Trash back to the folder it was trashed from
+func (p *IMAPProvider) Untrash(ctx context.Context, user, id string) error {
+	ref, err := parseIMAPMessageRef(id)
+	if err != nil {
+		return err
+	}
+	return p.call(ctx, user, "untrash", func(c *client.Client) error {
+		folder, uid, err := p.session.locate(c, ref, true)
+		if err != nil || folder.Label != "TRASH" {
+			return err
+		}
+		folders, err := p.session.listFolders(c)
+		if err != nil {
+			return err
+		}
+		dest := folderNamed(folders, ref.Folder, "")
+		if dest == nil || dest.Label == "TRASH" {
+			dest = folderNamed(folders, "", "INBOX")
+		}
+		return c.UidMove(uidSet(uid), dest.Name)
+	})
+}
+
+// BatchDelete flags the messages deleted and expunges their folders. Expunging also
+// removes messages another client flagged deleted but left in place.
+func (p *IMAPProvider) BatchDelete(ctx context.Context, user string, ids []string) error {
+	return p.call(ctx, user, "batchDelete", func(c *client.Client) error {
+		byFolder := make(map[string][]uint32)
+		for _, id := range ids {
+			ref, err := parseIMAPMessageRef(id)
+			if err != nil {
+				return err
+			}
+			folder, uid, err := p.session.locate(c, ref, true)
+			if err != nil {
+				return err
+			}
+			byFolder[folder.Name] = append(byFolder[folder.Name], uid)
+		}
+		for name, uids := range byFolder {
+			if err := selectFolder(c, name, true); err != nil {
+				return err
+			}
+			set := uidSet(uids...)
+			if err := c.UidStore(set, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil); err != nil {
+				return err
+			}
+			if err := c.Expunge(nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *IMAPProvider) GetThread(ctx context.Context, user, id, format string, headers ...string) (*gmail.Thread, error) {
+	thread := &gmail.Thread{Id: id}
+	err := p.call(ctx, user, "getThread", func(c *client.Client) error {
+		messages, err := p.session.threadMessages(c, id, format, headers...)
+		thread.Messages = messages
+		return err
+	})
+	return thread, err
+}
+
+func (p *IMAPProvider) ModifyThread(ctx context.Context, user, id string, addLabelIDs, removeLabelIDs []string) error {
+	return p.call(ctx, user, "modifyThread", func(c *client.Client) error {
+		messages, err := p.session.threadMessages(c, id, "minimal")
+		if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			ref, err := parseIMAPMessageRef(msg.Id)
+			if err != nil {
+				return err
+			}
+			if err := p.session.modify(c, ref, addLabelIDs, removeLabelIDs); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Labels lists a label for each folder, and the labels flags map onto
+func (p *IMAPProvider) Labels(ctx context.Context, user string) ([]*gmail.Label, error) {
+	labels := []*gmail.Label{
+		{Id: "UNREAD", Name: "UNREAD", Type: "system"},
+		{Id: "STARRED", Name: "STARRED", Type: "system"},
+	}
+	err := p.call(ctx, user, "labels", func(c *client.Client) error {
+		folders, err := p.session.listFolders(c)
+		if err != nil {
+			return err
+		}
+		for _, folder := range folders {
+			switch {
+			case folder.Label == "":
+			case folder.Label == folder.Name && folder.Label != "INBOX":
+				labels = append(labels, &gmail.Label{Id: folder.Label, Name: folder.Name, Type: "user"})
+			default:
+				labels = append(labels, &gmail.Label{Id: folder.Label, Name: folder.Label, Type: "system"})
+			}
+		}
+		return nil
+	})
+	return labels, err
+}
+
+// CreateLabel creates a folder named like the label
+func (p *IMAPProvider) CreateLabel(ctx context.Context, user string, label *gmail.Label) (*gmail.Label, error) {
+	err := p.call(ctx, user, "createLabel", func(c *client.Client) error {
+		if err := c.Create(label.Name); err != nil {
+			return err
+		}
+		p.session.folders = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &gmail.Label{Id: label.Name, Name: label.Name, Type: "user"}, nil
+}
+
+func (p *IMAPProvider) CreateFilter(ctx context.Context, user string, filter *gmail.Filter) (*gmail.Filter, error) {
+	return nil, fmt.Errorf("%w: filters over IMAP", ErrNotSupported)
+}
+
+// ListDrafts lists the messages of the Drafts folder, each as its own draft
+func (p *IMAPProvider) ListDrafts(ctx context.Context, user, pageToken string, maxResults int64) (*gmail.ListDraftsResponse, error) {
+	resp, err := p.ListMessages(ctx, user, MessageQuery{LabelIDs: []string{"DRAFT"}, PageToken: pageToken, MaxResults: maxResults})
+	if err != nil {
+		return nil, err
+	}
+	drafts := &gmail.ListDraftsResponse{NextPageToken: resp.NextPageToken, ResultSizeEstimate: resp.ResultSizeEstimate}
+	for _, msg := range resp.Messages {
+		drafts.Drafts = append(drafts.Drafts, &gmail.Draft{Id: msg.Id, Message: msg})
+	}
+	return drafts, nil
+}
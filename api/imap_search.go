@@ -0,0 +1,224 @@
+package api
+
+import (
+	"fmt"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+	"github.com/emersion/go-imap"
+)
+
+// imapSearch is a Gmail search query translated for an IMAP server: the folders it is
+// limited to by in: and label: terms, and a search for the rest
+type imapSearch struct {
+	criteria *imap.SearchCriteria
+	labelIDs []string
+}
+
+// Labels in: terms name by their Gmail search name
+var searchLabelNames = map[string]string{
+	"inbox": "INBOX", "sent": "SENT", "drafts": "DRAFT", "draft": "DRAFT",
+	"trash": "TRASH", "spam": "SPAM", "starred": "STARRED", "unread": "UNREAD",
+}
+
+// parseIMAPSearch translates the Gmail search operators IMAP can express: from:, to:,
+// cc:, bcc:, subject:, list:, after:, before:, older_than:, newer_than:, larger:,
+// smaller:, is:, in: and label:, plain words, and negation with "-". Parentheses only
+// group, and OR and other operators are refused rather than silently ignored.
+func parseIMAPSearch(query string) (*imapSearch, error) {
+	search := &imapSearch{criteria: &imap.SearchCriteria{Header: make(textproto.MIMEHeader)}}
+	for _, term := range searchTerms(query) {
+		if term == "OR" || term == "AROUND" {
+			return nil, fmt.Errorf("%w: %s in searches", ErrNotSupported, term)
+		}
+
+		criteria := search.criteria
+		negated := strings.HasPrefix(term, "-") && len(term) > 1
+		if negated {
+			term = term[1:]
+			criteria = &imap.SearchCriteria{Header: make(textproto.MIMEHeader)}
+			search.criteria.Not = append(search.criteria.Not, criteria)
+		}
+
+		op, value, ok := strings.Cut(term, ":")
+		if !ok || value == "" || strings.ContainsAny(op, `"' `) {
+			criteria.Text = append(criteria.Text, strings.Trim(term, `"`))
+			continue
+		}
+		op, value = strings.ToLower(op), strings.Trim(value, `"`)
+
+		switch op {
+		case "from", "to", "cc", "bcc", "subject":
+			criteria.Header.Add(op, value)
+		case "list":
+			criteria.Header.Add("List-Id", value)
+		case "after", "before":
+			date, err := parseSearchDate(value)
+			if err != nil {
+				return nil, err
+			}
+			if op == "after" {
+				criteria.Since = date
+			} else {
+				criteria.Before = date
+			}
+		case "older_than", "newer_than":
+			age, err := parseSearchAge(value)
+			if err != nil {
+				return nil, err
+			}
+			if op == "older_than" {
+				criteria.Before = age
+			} else {
+				criteria.Since = age
+			}
+		case "larger", "smaller":
+			size, err := parseSearchSize(value)
+			if err != nil {
+				return nil, err
+			}
+			if op == "larger" {
+				criteria.Larger = size
+			} else {
+				criteria.Smaller = size
+			}
+		case "is":
+			switch strings.ToLower(value) {
+			case "unread":
+				criteria.WithoutFlags = append(criteria.WithoutFlags, imap.SeenFlag)
+			case "read":
+				criteria.WithFlags = append(criteria.WithFlags, imap.SeenFlag)
+			case "starred":
+				criteria.WithFlags = append(criteria.WithFlags, imap.FlaggedFlag)
+			case "draft":
+				criteria.WithFlags = append(criteria.WithFlags, imap.DraftFlag)
+			default:
+				return nil, fmt.Errorf("%w: search term is:%s", ErrNotSupported, value)
+			}
+		case "in", "label":
+			if negated {
+				return nil, fmt.Errorf("%w: negated %s: in searches", ErrNotSupported, op)
+			}
+			label, ok := searchLabelNames[strings.ToLower(value)]
+			if !ok {
+				label = value
+			}
+			search.labelIDs = append(search.labelIDs, label)
+		default:
+			return nil, fmt.Errorf("%w: search operator %s:", ErrNotSupported, op)
+		}
+	}
+	return search, nil
+}
+
+// searchTerms splits a search query into terms, keeping quoted phrases whole and
+// dropping grouping parentheses
+func searchTerms(query string) []string {
+	terms := make([]string, 0)
+	var term strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			term.WriteRune(r)
+		case quoted:
+			term.WriteRune(r)
+		case r == '(' || r == ')' || r == ' ' || r == '\t':
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+		default:
+			term.WriteRune(r)
+		}
+	}
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+	return terms
+}
+
+// parseSearchDate parses a Gmail search date, YYYY/MM/DD or YYYY-MM-DD
+func parseSearchDate(value string) (time.Time, error) {
+	date, err := time.Parse("2006/1/2", strings.ReplaceAll(value, "-", "/"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q in search", value)
+	}
+	return date, nil
+}
+
+// parseSearchAge returns the time a Gmail search age like 30d, 6m or 2y ago was
+func parseSearchAge(value string) (time.Time, error) {
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("invalid age %q in search", value)
+	}
+	now := time.Now()
+	switch value[len(value)-1] {
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	case 'm':
+		return now.AddDate(0, -n, 0), nil
+	case 'y':
+		return now.AddDate(-n, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid age %q in search", value)
+}
+
+// parseSearchSize parses a Gmail search size in bytes, or with a K or M suffix
+func parseSearchSize(value string) (uint32, error) {
+	multiplier := uint64(1)
+	switch strings.ToLower(value[len(value)-1:]) {
+	case "k":
+		multiplier, value = 1<<10, value[:len(value)-1]
+	case "m":
+		multiplier, value = 1<<20, value[:len(value)-1]
+	}
+	n, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || n*multiplier > 1<<32-1 {
+		return 0, fmt.Errorf("invalid size %q in search", value)
+	}
+	return uint32(n * multiplier), nil
+}
+
+// folders returns the folders to search, for the query's labels and labelIDs. Flag
+// labels become part of the search instead. A message is in only one folder, so two
+// folder labels match nothing. Without folder labels every folder is searched, but
+// Spam and Trash only if includeSpamTrash.
+func (s *imapSearch) folders(all []imapFolder, labelIDs []string, includeSpamTrash bool) ([]*imapFolder, error) {
+	folderLabels := make([]string, 0, 1)
+	for _, label := range slices.Concat(labelIDs, s.labelIDs) {
+		switch label {
+		case "UNREAD":
+			s.criteria.WithoutFlags = append(s.criteria.WithoutFlags, imap.SeenFlag)
+		case "STARRED":
+			s.criteria.WithFlags = append(s.criteria.WithFlags, imap.FlaggedFlag)
+		default:
+			if !slices.Contains(folderLabels, label) {
+				folderLabels = append(folderLabels, label)
+			}
+		}
+	}
+
+	selected := make([]*imapFolder, 0, len(all))
+	switch len(folderLabels) {
+	case 0:
+		for i := range all {
+			if includeSpamTrash || deepclean.JunkLabel([]string{all[i].Label}) == "" {
+				selected = append(selected, &all[i])
+			}
+		}
+	case 1:
+		folder := folderNamed(all, "", folderLabels[0])
+		if folder == nil {
+			return nil, imapNotFound("label", folderLabels[0])
+		}
+		selected = append(selected, folder)
+	}
+	return selected, nil
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestIMAPAccountKeyedByLogin(t *testing.T) {
+	token := &oauth2.Token{AccessToken: newID(), TokenType: imapTokenType}
+	imapSessionsMu.Lock()
+	imapSessions[token.AccessToken] = &imapSession{account: IMAPAccount{
+		Server:   "imap.mail.example",
+		Username: "Bob",
+		Password: "secret",
+		// Someone else's address, which the IMAP server never checked
+		Email: "victim@gmail.com",
+	}}
+	imapSessionsMu.Unlock()
+	t.Cleanup(func() {
+		imapSessionsMu.Lock()
+		delete(imapSessions, token.AccessToken)
+		imapSessionsMu.Unlock()
+	})
+
+	userID := userIDFromToken(token)
+	mb := &mailbox{token: token, user: "me", userID: userID, actorID: userID, quota: Quota.For(userID)}
+	for name, lookup := range map[string]func() (string, error){"account": mb.account, "actor": mb.actor} {
+		got, err := lookup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "imap:bob@imap.mail.example" {
+			t.Errorf("%s = %q, want the login the server authenticated", name, got)
+		}
+	}
+}
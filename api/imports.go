@@ -0,0 +1,535 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Largest chunk accepted by a single upload request
+	maxImportChunkSize = 32 << 20
+	// Number of imported messages between progress checkpoints
+	importCheckpointEvery = 100
+)
+
+// ImportState is the lifecycle state of an mbox import
+type ImportState string
+
+const (
+	ImportUploading ImportState = "uploading"
+	ImportImporting ImportState = "importing"
+	ImportDone      ImportState = "done"
+	ImportFailed    ImportState = "failed"
+)
+
+// ImportSession is a resumable mbox upload and the import of its messages into Gmail.
+// Sessions are persisted, so both the upload and the import pick up where they left off
+// after a dropped connection or a server restart.
+type ImportSession struct {
+	ID       string      `json:"id"`
+	Owner    string      `json:"owner"`
+	Filename string      `json:"filename"`
+	Size     int64       `json:"size"`
+	Received int64       `json:"received"`
+	State    ImportState `json:"state"`
+	JobID    string      `json:"jobId,omitempty"`
+	// Byte offset of the first message not yet imported
+	Parsed    int64     `json:"parsed"`
+	Imported  int       `json:"imported"`
+	Failed    int       `json:"failed"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	mu sync.Mutex
+}
+
+var (
+	// Import sessions loaded since startup, by ID
+	importSessions   = make(map[string]*ImportSession)
+	importSessionsMu sync.Mutex
+	// Serializes creating imports, so concurrent ones can't exceed the storage cap
+	importCreateMu sync.Mutex
+)
+
+// importKey is the storage key of an import session
+func importKey(id string) string {
+	return "imports/" + id
+}
+
+// path is where the session's upload is assembled
+func (s *ImportSession) path() string {
+	return filepath.Join(config.DataDir, "imports", s.ID+".mbox")
+}
+
+// save persists the session. Callers must hold s.mu.
+func (s *ImportSession) save() error {
+	s.UpdatedAt = time.Now()
+	return Storage.Put(importKey(s.ID), s)
+}
+
+// loadImportSession returns an import session owned by account
+func loadImportSession(id, account string) (*ImportSession, error) {
+	importSessionsMu.Lock()
+	defer importSessionsMu.Unlock()
+
+	session, ok := importSessions[id]
+	if !ok {
+		session = &ImportSession{}
+		found, err := Storage.Get(importKey(id), session)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, nil
+		}
+
+		// Nothing is running an import left over from before a restart
+		if session.State == ImportImporting {
+			session.State = ImportFailed
+			session.Error = "import interrupted by a server restart"
+		}
+		importSessions[id] = session
+	}
+
+	if session.Owner != account {
+		return nil, nil
+	}
+	return session, nil
+}
+
+// importSessionsOf returns the stored import sessions owned by account
+func importSessionsOf(account string) ([]*ImportSession, error) {
+	keys, err := Storage.List(importKey(""))
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*ImportSession, 0)
+	for _, key := range keys {
+		session := &ImportSession{}
+		if _, err := Storage.Get(key, session); err != nil {
+			return nil, err
+		}
+		if session.Owner == account {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// importStorageInUse returns the disk space reserved by an account's imports whose
+// upload is still on disk: the declared size of each one that isn't done
+func importStorageInUse(account string) (int64, error) {
+	sessions, err := importSessionsOf(account)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, session := range sessions {
+		if session.State != ImportDone {
+			size += session.Size
+		}
+	}
+	return size, nil
+}
+
+// importSessionForRequest resolves the mailbox and the import session named in the URL,
+// writing an error response and returning nil if either is unavailable
+func importSessionForRequest(w http.ResponseWriter, r *http.Request) (*mailbox, *ImportSession) {
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return nil, nil
+	}
+
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return nil, nil
+	}
+	session, err := loadImportSession(mux.Vars(r)["id"], account)
+	if err != nil {
+		writeErrorFrom(w, "Failed to load import", err, http.StatusInternalServerError)
+		return nil, nil
+	}
+	if session == nil {
+		writeError(w, "Import not found", http.StatusNotFound)
+		return nil, nil
+	}
+	return mb, session
+}
+
+// createImportRequest is the body of HandleCreateImport
+type createImportRequest struct {
+	Filename string `json:"filename"`
+	// Size of the whole mbox file in bytes
+	Size int64 `json:"size"`
+}
+
+// HandleCreateImport starts a resumable mbox upload. The file is then sent in chunks
+// with HandleUploadImportChunk and imported with HandleCompleteImport.
+func HandleCreateImport(w http.ResponseWriter, r *http.Request) {
+	var req createImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if req.Size <= 0 {
+		writeError(w, "The size of the mbox file is required", http.StatusBadRequest)
+		return
+	}
+	if req.Size > int64(config.MaxImportMB)<<20 {
+		writeError(w, fmt.Sprintf("The mbox file is larger than the %d MB this server imports", config.MaxImportMB), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	importCreateMu.Lock()
+	defer importCreateMu.Unlock()
+	inUse, err := importStorageInUse(account)
+	if err != nil {
+		writeErrorFrom(w, "Failed to load imports", err, http.StatusInternalServerError)
+		return
+	}
+	if inUse+req.Size > int64(config.ImportStorageMB)<<20 {
+		writeError(w, fmt.Sprintf("Unfinished imports may take up %d MB per account; finish or delete one first", config.ImportStorageMB), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	session := &ImportSession{
+		ID:        newID(),
+		Owner:     account,
+		Filename:  req.Filename,
+		Size:      req.Size,
+		State:     ImportUploading,
+		CreatedAt: time.Now(),
+	}
+	if err := os.MkdirAll(filepath.Dir(session.path()), 0o700); err != nil {
+		writeErrorFrom(w, "Failed to create import directory", err, http.StatusInternalServerError)
+		return
+	}
+	file, err := os.OpenFile(session.path(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		writeErrorFrom(w, "Failed to create import file", err, http.StatusInternalServerError)
+		return
+	}
+	file.Close()
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if err := session.save(); err != nil {
+		os.Remove(session.path())
+		writeErrorFrom(w, "Failed to save import", err, http.StatusInternalServerError)
+		return
+	}
+
+	importSessionsMu.Lock()
+	importSessions[session.ID] = session
+	importSessionsMu.Unlock()
+
+	writeJSON(w, map[string]interface{}{
+		"import":       session,
+		"maxChunkSize": maxImportChunkSize,
+	})
+}
+
+// HandleGetImport reports an import's upload and import progress. After a dropped
+// connection, clients resume the upload from the returned received offset.
+func HandleGetImport(w http.ResponseWriter, r *http.Request) {
+	_, session := importSessionForRequest(w, r)
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	writeJSON(w, session)
+}
+
+// HandleUploadImportChunk appends a chunk to an import's upload. The ?offset= parameter
+// must equal the bytes received so far; bytes that arrive before a connection drops are
+// kept, so the client resumes from the received offset rather than resending the chunk.
+func HandleUploadImportChunk(w http.ResponseWriter, r *http.Request) {
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeError(w, "A valid offset is required", http.StatusBadRequest)
+		return
+	}
+
+	_, session := importSessionForRequest(w, r)
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.State != ImportUploading {
+		writeError(w, "Import is no longer accepting uploads", http.StatusConflict)
+		return
+	}
+	if offset != session.Received {
+		writeError(w, fmt.Sprintf("Chunk must start at offset %d", session.Received), http.StatusConflict)
+		return
+	}
+
+	file, err := os.OpenFile(session.path(), os.O_WRONLY, 0o600)
+	if err != nil {
+		writeErrorFrom(w, "Failed to open import file", err, http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	// Drop anything past the last acknowledged byte before appending
+	if err := file.Truncate(offset); err != nil {
+		writeErrorFrom(w, "Failed to prepare import file", err, http.StatusInternalServerError)
+		return
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		writeErrorFrom(w, "Failed to prepare import file", err, http.StatusInternalServerError)
+		return
+	}
+
+	remaining := session.Size - offset
+	body := http.MaxBytesReader(w, r.Body, maxImportChunkSize)
+	n, copyErr := io.Copy(file, io.LimitReader(body, remaining+1))
+	if n > remaining {
+		file.Truncate(offset)
+		writeError(w, "Chunk runs past the declared size of the file", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	session.Received += n
+	if err := session.save(); err != nil {
+		writeErrorFrom(w, "Failed to save import", err, http.StatusInternalServerError)
+		return
+	}
+	if copyErr != nil {
+		writeError(w, fmt.Sprintf("Upload interrupted at offset %d: %s", session.Received, copyErr), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, session)
+}
+
+// HandleCompleteImport starts importing a fully uploaded mbox file as a background job.
+// Calling it again on a failed import resumes after the last imported message.
+func HandleCompleteImport(w http.ResponseWriter, r *http.Request) {
+	mb, session := importSessionForRequest(w, r)
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	switch {
+	case session.State == ImportImporting || session.State == ImportDone:
+		writeError(w, "Import has already been started", http.StatusConflict)
+		return
+	case session.Received != session.Size:
+		writeError(w, fmt.Sprintf("Upload is incomplete, received %d of %d bytes", session.Received, session.Size), http.StatusConflict)
+		return
+	}
+
+	job := Jobs.Enqueue("import", mb.userID, PriorityUser, func(job *Job) (interface{}, error) {
+		return importMbox(mb, session)
+	})
+	session.State = ImportImporting
+	session.JobID = job.ID
+	session.Error = ""
+	if err := session.save(); err != nil {
+		log.Printf("Failed to save import %s: %s", session.ID, redactError(err))
+	}
+
+	writeJSON(w, session)
+}
+
+// HandleDeleteImport cancels an import that isn't running and deletes its upload
+func HandleDeleteImport(w http.ResponseWriter, r *http.Request) {
+	_, session := importSessionForRequest(w, r)
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.State == ImportImporting {
+		writeError(w, "Import is running", http.StatusConflict)
+		return
+	}
+	if err := os.Remove(session.path()); err != nil && !os.IsNotExist(err) {
+		writeErrorFrom(w, "Failed to delete import file", err, http.StatusInternalServerError)
+		return
+	}
+	if err := Storage.Delete(importKey(session.ID)); err != nil {
+		writeErrorFrom(w, "Failed to delete import", err, http.StatusInternalServerError)
+		return
+	}
+
+	importSessionsMu.Lock()
+	delete(importSessions, session.ID)
+	importSessionsMu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// importMbox imports every message of an uploaded mbox file into the mailbox, starting
+// from the session's checkpoint. It gives up if the error budget is exceeded, leaving
+// the session failed so it can be resumed.
+func importMbox(mb *mailbox, session *ImportSession) (interface{}, error) {
+	err := runImport(mb, session)
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if err != nil {
+		session.State = ImportFailed
+		session.Error = redactError(err)
+	} else {
+		session.State = ImportDone
+		// The messages live in Gmail now, so reclaim the disk space
+		os.Remove(session.path())
+	}
+	if saveErr := session.save(); saveErr != nil {
+		log.Printf("Failed to save import %s: %s", session.ID, redactError(saveErr))
+	}
+
+	return map[string]interface{}{
+		"importId": session.ID,
+		"imported": session.Imported,
+		"failed":   session.Failed,
+	}, err
+}
+
+// runImport does the work of importMbox
+func runImport(mb *mailbox, session *ImportSession) error {
+	session.mu.Lock()
+	start := session.Parsed
+	session.mu.Unlock()
+
+	file, err := os.Open(session.path())
+	if err != nil {
+		return fmt.Errorf("failed to open import file: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	budget := NewErrorBudget()
+	reader := newMboxReader(file, start)
+	for count := 1; ; count++ {
+		raw, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read mbox file: %w", err)
+		}
+
+		if err := mb.quota.Wait(context.Background(), costMessagesImport); err != nil {
+			return err
+		}
+		_, err = mb.provider.ImportMessage(mb.context(), mb.user, bytes.NewReader(raw))
+		budget.Record(err)
+
+		session.mu.Lock()
+		if err != nil {
+			session.Failed++
+		} else {
+			session.Imported++
+		}
+		session.Parsed = reader.offset
+		if count%importCheckpointEvery == 0 {
+			if err := session.save(); err != nil {
+				log.Printf("Failed to checkpoint import %s: %s", session.ID, redactError(err))
+			}
+		}
+		session.mu.Unlock()
+
+		if err := budget.Exceeded(); err != nil {
+			return err
+		}
+	}
+}
+
+// mboxReader splits an mboxrd file into raw messages, the inverse of writeMboxMessage
+type mboxReader struct {
+	r *bufio.Reader
+	// Byte offset just past the last message returned
+	offset int64
+}
+
+func newMboxReader(r io.Reader, offset int64) *mboxReader {
+	return &mboxReader{r: bufio.NewReaderSize(r, 1<<20), offset: offset}
+}
+
+// Next returns the next message with its "From " separator removed and quoted
+// "From " lines unquoted, or io.EOF when there are no more
+func (m *mboxReader) Next() ([]byte, error) {
+	var msg bytes.Buffer
+	inMessage := false
+
+	for {
+		// A separator line ends the current message
+		if inMessage {
+			if next, _ := m.r.Peek(5); bytes.Equal(next, []byte("From ")) {
+				return trimMboxMessage(msg.Bytes()), nil
+			}
+		}
+
+		line, err := m.r.ReadBytes('\n')
+		m.offset += int64(len(line))
+		switch {
+		case len(line) == 0:
+		case !inMessage:
+			// Anything before the first separator isn't part of a message
+			inMessage = bytes.HasPrefix(line, []byte("From "))
+		default:
+			if line[0] == '>' && bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+				line = line[1:]
+			}
+			msg.Write(line)
+		}
+
+		if err == io.EOF {
+			if inMessage {
+				return trimMboxMessage(msg.Bytes()), nil
+			}
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// trimMboxMessage drops the blank line that separates a message from the next one
+func trimMboxMessage(msg []byte) []byte {
+	if bytes.HasSuffix(msg, []byte("\n\n")) {
+		return msg[:len(msg)-1]
+	}
+	return msg
+}
@@ -2,117 +2,273 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
 )
 
-// HandleStartProcessingInbox initiates the inbox processing
+// Most senders one page of a sender listing may return
+const maxSendersPageSize = 500
+
+// HandleStartProcessingInbox initiates the inbox processing, resuming from the last
+// checkpoint if a scan of the same scope was interrupted by a server restart. A finished
+// scan is repeated with `rescan=true`; messages earlier scans fetched aren't fetched again.
 func HandleStartProcessingInbox(w http.ResponseWriter, r *http.Request) {
 	// Parse token from Authorization header
 	token, err := ParseToken(r)
 	if err != nil {
-		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
 		return
 	}
 
-	// Use token hash as user ID (simplified, use a better ID method in production)
-	userID := token.AccessToken[:10]
+	userID, err := requestUserID(r, token)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
 
-	// Check if already processing
-	if processor, exists := Registry.Get(userID); exists {
-		// Return current status
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(processor.GetProgress())
+	// Scheduled syncs pass priority=background so they don't hold up interactive users
+	priority, err := ParseRequestPriority(r.URL.Query().Get("priority"), PriorityUser)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
 		return
 	}
 
-	// Create new processor
-	processor, err := NewInboxProcessor(token)
+	// Scans can be limited to labels, a search query and a date range
+	scope, err := parseScanScope(r)
 	if err != nil {
-		http.Error(w, "Failed to create inbox processor: "+err.Error(), http.StatusInternalServerError)
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
 		return
 	}
 
-	// Register processor
-	Registry.Register(userID, processor)
+	// A budget pauses the scan once it has used that many quota units
+	var quotaBudget int64
+	if v := r.URL.Query().Get("quotaBudget"); v != "" {
+		quotaBudget, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || quotaBudget < 0 {
+			writeError(w, "quotaBudget must be a non-negative number of quota units", http.StatusBadRequest)
+			return
+		}
+	}
 
-	// Start processing
-	if err := processor.StartProcessing(); err != nil {
-		http.Error(w, "Failed to start processing: "+err.Error(), http.StatusInternalServerError)
+	user, err := requestMailbox(r)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
 		return
 	}
 
-	// Return initial status
+	processor, err := startScan(token, user, userID, scope, r.URL.Query().Get("rescan") == "true", priority, quotaBudget)
+	if errors.Is(err, errMetadataSearch) {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errNotDelegate) {
+		writeErrorFrom(w, "", err, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	// Return the current or initial status
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(processor.GetProgress())
 }
 
+// errMetadataSearch is returned for scans narrowed by a search query or dates, which
+// Gmail doesn't accept under the metadata scope
+var errMetadataSearch = errors.New("scans with metadata access only can't use a search query or dates; narrow them with labelIds instead")
+
+// startScan queues a scan of a mailbox with the given priority and returns its
+// processor. A scan already registered for userID is returned as is, unless rescan is set
+// and it has finished, and one interrupted by a restart or paused at its quota budget is
+// resumed. quotaBudget pauses the scan after that many quota units (0 for no limit).
+func startScan(token *oauth2.Token, user, userID string, scope ScanScope, rescan bool, priority JobPriority, quotaBudget int64) (*InboxProcessor, error) {
+	// Check if already processing
+	processor, exists := Registry.Get(userID)
+	if exists && (rescan || processor.paused()) && !processor.GetProgress()["isProcessing"].(bool) {
+		Registry.Remove(userID)
+		exists = false
+	}
+	if exists {
+		return processor, nil
+	}
+
+	if metadataOnly(token) && scope.searchQuery() != "" {
+		return nil, errMetadataSearch
+	}
+
+	// Create new processor
+	processor, err := NewInboxProcessor(token, user, scope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create inbox processor: %w", err)
+	}
+
+	// Pick up a scan interrupted by a restart rather than starting over
+	if _, err := processor.resume(); err != nil {
+		log.Printf("Failed to resume scan, starting over: %s", redactError(err))
+		processor.emails.close()
+		if processor, err = NewInboxProcessor(token, user, scope); err != nil {
+			return nil, fmt.Errorf("failed to create inbox processor: %w", err)
+		}
+	}
+
+	processor.quotaBudget = quotaBudget
+
+	// Register processor
+	Registry.Register(userID, processor)
+
+	// Start processing
+	if err := processor.StartProcessing(priority); err != nil {
+		return nil, fmt.Errorf("failed to start processing: %w", err)
+	}
+	return processor, nil
+}
+
 // HandleGetInboxStatus returns the current processing status
 func HandleGetInboxStatus(w http.ResponseWriter, r *http.Request) {
 	// Parse token from Authorization header
 	token, err := ParseToken(r)
 	if err != nil {
-		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
 		return
 	}
 
-	// Use token hash as user ID (simplified, use a better ID method in production)
-	userID := token.AccessToken[:10]
+	userID, err := requestUserID(r, token)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
 
 	// Get processor
 	processor, exists := Registry.Get(userID)
 	if !exists {
-		http.Error(w, "No processing found for this user", http.StatusNotFound)
+		writeError(w, "No processing found for this user", http.StatusNotFound)
 		return
 	}
 
-	// Return current progress
+	// Return current progress, including disk held for this account
+	progress := processor.GetProgress()
+	usage := progress["usage"].(ResourceUsage)
+	usage.DiskBytes += Backups.DiskUsage(knownAccount(userID))
+	progress["usage"] = usage
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(processor.GetProgress())
+	json.NewEncoder(w).Encode(progress)
 }
 
-// HandleGetTopSenders returns the top email senders
+// HandleGetTopSenders returns the top email senders, ranked by `sortBy` (count or size)
+// and paged with `offset` and `limit` (default 20). Like the other stats endpoints it
+// accepts `since` and `asOf` dates to recompute the ranking for a past era. When contact
+// lookups are enabled, senders in the user's own contacts include their name and photo.
 func HandleGetTopSenders(w http.ResponseWriter, r *http.Request) {
 	// Parse token from Authorization header
 	token, err := ParseToken(r)
 	if err != nil {
-		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
 		return
 	}
 
-	// Use token hash as user ID (simplified, use a better ID method in production)
-	userID := token.AccessToken[:10]
+	userID, err := requestUserID(r, token)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
 
 	// Get processor
 	processor, exists := Registry.Get(userID)
 	if !exists {
-		http.Error(w, "No processing found for this user", http.StatusNotFound)
+		writeError(w, "No processing found for this user", http.StatusNotFound)
+		return
+	}
+
+	category, err := parseCategoryFilter(r)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+	offset, limit, sortBy, err := parseSenderPage(r, sendersByCount, sendersBySize)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+
+	// Optionally look back at a past era
+	processor = statsView(w, r, processor)
+	if processor == nil {
 		return
 	}
 
-	// Get the top 20 senders
-	topSenders := processor.GetTopSenders(20)
+	topSenders := processor.GetTopSenders(offset, limit, sortBy, category)
+
+	// Contacts belong to the signed-in user, so delegated mailboxes aren't enriched
+	if user, _ := requestMailbox(r); config.ContactsEnabled && user == "me" {
+		enrichSenders(r.Context(), token, userID, topSenders)
+	}
 
 	// Return results
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(topSenders)
 }
 
+// parseSenderPage reads the `offset`, `limit` and `sortBy` parameters of a sender listing.
+// sortBy must be one of sorts and defaults to the first.
+func parseSenderPage(r *http.Request, sorts ...string) (offset, limit int, sortBy string, err error) {
+	query := r.URL.Query()
+	limit = 20
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, "", fmt.Errorf("offset must be a non-negative number")
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxSendersPageSize {
+			return 0, 0, "", fmt.Errorf("limit must be between 1 and %d", maxSendersPageSize)
+		}
+	}
+	sortBy = query.Get("sortBy")
+	if sortBy == "" {
+		return offset, limit, sorts[0], nil
+	}
+	for _, s := range sorts {
+		if sortBy == s {
+			return offset, limit, sortBy, nil
+		}
+	}
+	return 0, 0, "", fmt.Errorf("sortBy must be one of %s", strings.Join(sorts, ", "))
+}
+
 // HandleGetEmailStats returns the email statistics
 func HandleGetEmailStats(w http.ResponseWriter, r *http.Request) {
 	// Parse token from Authorization header
 	token, err := ParseToken(r)
 	if err != nil {
-		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
 		return
 	}
 
-	// Use token hash as user ID (simplified, use a better ID method in production)
-	userID := token.AccessToken[:10]
+	userID, err := requestUserID(r, token)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
 
 	// Get processor
 	processor, exists := Registry.Get(userID)
 	if !exists {
-		http.Error(w, "No processing found for this user", http.StatusNotFound)
+		writeError(w, "No processing found for this user", http.StatusNotFound)
+		return
+	}
+
+	// Optionally look back at a past era
+	processor = statsView(w, r, processor)
+	if processor == nil {
 		return
 	}
 
@@ -120,3 +276,28 @@ func HandleGetEmailStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(processor.GetStats())
 }
+
+// processorForRequest looks up the processor for the request's user, writing an error
+// response and returning nil if there is none
+func processorForRequest(w http.ResponseWriter, r *http.Request) *InboxProcessor {
+	// Parse token from Authorization header
+	token, err := ParseToken(r)
+	if err != nil {
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
+		return nil
+	}
+
+	// Get processor
+	userID, err := requestUserID(r, token)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return nil
+	}
+	processor, exists := Registry.Get(userID)
+	if !exists {
+		writeError(w, "No processing found for this user", http.StatusNotFound)
+		return nil
+	}
+
+	return processor
+}
@@ -0,0 +1,508 @@
+package api
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// How long finished jobs are kept for the job history
+const jobRetention = 7 * 24 * time.Hour
+
+// JobPriority decides which queued job a free worker picks up next; lower runs first
+type JobPriority int
+
+const (
+	// Previews and other requests a user is actively waiting on
+	PriorityInteractive JobPriority = iota
+	// Scans and cleanups the user triggered
+	PriorityUser
+	// Scheduled background syncs that nobody is watching
+	PriorityBackground
+)
+
+var priorityNames = map[JobPriority]string{
+	PriorityInteractive: "interactive",
+	PriorityUser:        "user",
+	PriorityBackground:  "background",
+}
+
+// String returns the priority's name
+func (p JobPriority) String() string {
+	return priorityNames[p]
+}
+
+// MarshalText encodes the priority by name
+func (p JobPriority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes a priority from its name, as stored jobs carry it
+func (p *JobPriority) UnmarshalText(text []byte) error {
+	priority, err := ParseJobPriority(string(text), PriorityUser)
+	*p = priority
+	return err
+}
+
+// ParseRequestPriority parses the priority a client asked for, defaulting to def when
+// empty. Clients may lower the priority of their jobs but not claim interactive, which
+// is the server's to give to the requests a user is waiting on.
+func ParseRequestPriority(name string, def JobPriority) (JobPriority, error) {
+	priority, err := ParseJobPriority(name, def)
+	if err == nil && priority == PriorityInteractive {
+		return def, fmt.Errorf("priority must be user or background")
+	}
+	return priority, err
+}
+
+// ParseJobPriority parses a priority name, defaulting to def when empty
+func ParseJobPriority(name string, def JobPriority) (JobPriority, error) {
+	if name == "" {
+		return def, nil
+	}
+	for priority, n := range priorityNames {
+		if n == name {
+			return priority, nil
+		}
+	}
+	return def, fmt.Errorf("unknown priority %q", name)
+}
+
+// JobState is the lifecycle state of a job
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// JobFunc does the work of a job and returns its result
+type JobFunc func(job *Job) (interface{}, error)
+
+// Job is a unit of long-running work executed by the job queue
+type Job struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	UserID string `json:"userId"`
+	// Account the job acts for, which keys its history. It is known once the server
+	// looked up the address of the job's user, which every cleanup does.
+	Account  string      `json:"account,omitempty"`
+	Priority JobPriority `json:"priority"`
+	State    JobState    `json:"state"`
+	Error    string      `json:"error,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+	// Progress reported by a running job, in a shape specific to its kind
+	Progress   interface{} `json:"progress,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	StartedAt  time.Time   `json:"startedAt,omitempty"`
+	FinishedAt time.Time   `json:"finishedAt,omitempty"`
+
+	run  JobFunc
+	seq  uint64 // keeps jobs of equal priority in FIFO order
+	done chan struct{}
+	// Span of the request that started the job, which the job's span continues
+	parent trace.SpanContext
+	// Carries the job's span while it runs
+	ctx context.Context
+}
+
+// Context returns the context of the running job, for tracing the API calls it makes
+func (j *Job) Context() context.Context {
+	if j.ctx == nil {
+		return context.Background()
+	}
+	return j.ctx
+}
+
+// jobHeap orders pending jobs by priority, then by enqueue order
+type jobHeap []*Job
+
+func (h jobHeap) Len() int { return len(h) }
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority < h[j].Priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h jobHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *jobHeap) Push(x interface{}) { *h = append(*h, x.(*Job)) }
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	job := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return job
+}
+
+// JobQueue runs jobs on a fixed pool of workers, highest priority first. One more worker
+// only runs interactive jobs, so a user waiting on a preview never waits for long scans
+// holding every worker of the pool.
+type JobQueue struct {
+	pending jobHeap
+	jobs    map[string]*Job
+	seq     uint64
+	mu      sync.Mutex
+	cond    *sync.Cond
+}
+
+var (
+	// Global job queue, started by Init
+	Jobs *JobQueue
+)
+
+// NewJobQueue creates a job queue and starts its pool of workers plus the worker
+// reserved for interactive jobs
+func NewJobQueue(workers int) *JobQueue {
+	q := &JobQueue{
+		jobs: make(map[string]*Job),
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go q.worker(false)
+	}
+	go q.worker(true)
+	return q
+}
+
+// Enqueue adds a job to the queue and returns it immediately
+func (q *JobQueue) Enqueue(kind, userID string, priority JobPriority, run JobFunc) *Job {
+	return q.enqueue(context.Background(), kind, userID, priority, run)
+}
+
+// enqueue adds a job to the queue, tracing it as part of the span in ctx
+func (q *JobQueue) enqueue(ctx context.Context, kind, userID string, priority JobPriority, run JobFunc) *Job {
+	job := &Job{
+		ID:        newID(),
+		Kind:      kind,
+		UserID:    userID,
+		Account:   knownAccount(userID),
+		Priority:  priority,
+		State:     JobQueued,
+		CreatedAt: time.Now(),
+		run:       run,
+		done:      make(chan struct{}),
+		parent:    trace.SpanContextFromContext(ctx),
+	}
+	// Save it before any worker can pick it up, so the saved state is never stale
+	q.persist(*job)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pruneLocked()
+	q.seq++
+	job.seq = q.seq
+	q.jobs[job.ID] = job
+	heap.Push(&q.pending, job)
+	// Wakes the reserved worker too, which may be the only one a job can run on
+	q.cond.Broadcast()
+	return job
+}
+
+// Run enqueues a job and waits for it to finish, for request handlers that respond with
+// the job's result. If ctx ends first the job keeps running and ctx's error is returned.
+func (q *JobQueue) Run(ctx context.Context, kind, userID string, priority JobPriority, run JobFunc) (interface{}, error) {
+	job := q.enqueue(ctx, kind, userID, priority, run)
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	snapshot := q.snapshot(job)
+	if snapshot.State == JobFailed {
+		return nil, fmt.Errorf("%s", snapshot.Error)
+	}
+	return snapshot.Result, nil
+}
+
+// Get returns a snapshot of a job
+func (q *JobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	job, ok := q.jobs[id]
+	q.mu.Unlock()
+	if !ok {
+		return Job{}, false
+	}
+	return q.snapshot(job), true
+}
+
+// Lookup returns a snapshot of one of an account's jobs, including finished jobs from
+// before a restart or an earlier sign-in that only storage still knows about
+func (q *JobQueue) Lookup(account, id string) (Job, bool) {
+	if job, ok := q.Get(id); ok {
+		return job, job.Account == account
+	}
+
+	var job Job
+	found, err := Storage.Get(jobKey(account, id), &job)
+	if err != nil {
+		log.Printf("Failed to load job %s: %s", id, redactError(err))
+	}
+	if !found {
+		return Job{}, false
+	}
+	interrupted(&job)
+	return job, true
+}
+
+// History returns snapshots of an account's jobs, newest first, both those still in
+// memory and those stored before a restart. Jobs older than jobRetention are dropped
+// from storage as they are found.
+func (q *JobQueue) History(account string) ([]Job, error) {
+	jobs := make(map[string]Job)
+
+	keys, err := Storage.List(jobKey(account, ""))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		var job Job
+		if _, err := Storage.Get(key, &job); err != nil {
+			return nil, err
+		}
+		if time.Since(job.CreatedAt) > jobRetention {
+			if err := Storage.Delete(key); err != nil {
+				log.Printf("Failed to drop expired job %s: %s", job.ID, redactError(err))
+			}
+			continue
+		}
+		interrupted(&job)
+		jobs[job.ID] = job
+	}
+
+	q.mu.Lock()
+	live := make([]*Job, 0)
+	for _, job := range q.jobs {
+		if job.Account == account {
+			live = append(live, job)
+		}
+	}
+	q.mu.Unlock()
+	for _, job := range live {
+		jobs[job.ID] = q.snapshot(job)
+	}
+
+	history := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		history = append(history, job)
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].CreatedAt.After(history[j].CreatedAt)
+	})
+	return history, nil
+}
+
+// active reports whether any of an account's jobs are queued or running
+func (q *JobQueue) active(account string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.jobs {
+		if job.Account == account && (job.State == JobQueued || job.State == JobRunning) {
+			return true
+		}
+	}
+	return false
+}
+
+// forget deletes an account's finished jobs from memory and storage and returns how
+// many there were. Callers make sure none of the account's jobs are still running.
+func (q *JobQueue) forget(account string) (int, error) {
+	forgotten := make(map[string]struct{})
+	q.mu.Lock()
+	for id, job := range q.jobs {
+		if job.Account == account {
+			delete(q.jobs, id)
+			forgotten[id] = struct{}{}
+		}
+	}
+	q.mu.Unlock()
+
+	keys, err := Storage.List(jobKey(account, ""))
+	if err != nil {
+		return len(forgotten), err
+	}
+	for _, key := range keys {
+		var job Job
+		if _, err := Storage.Get(key, &job); err != nil {
+			return len(forgotten), err
+		}
+		if err := Storage.Delete(key); err != nil {
+			return len(forgotten), err
+		}
+		forgotten[job.ID] = struct{}{}
+	}
+	return len(forgotten), nil
+}
+
+// jobKey is the storage key of an account's job
+func jobKey(account, id string) string {
+	return "jobs/" + account + "/" + id
+}
+
+// persist saves a job snapshot so its outcome survives a restart. Jobs of no known
+// account, such as org-wide ones, are kept in memory only. Failing to save it doesn't
+// affect the job itself, so failures are only logged.
+func (q *JobQueue) persist(job Job) {
+	if Storage == nil || job.Account == "" {
+		return
+	}
+	if err := Storage.Put(jobKey(job.Account, job.ID), &job); err != nil {
+		log.Printf("Failed to save job %s: %s", job.ID, redactError(err))
+	}
+}
+
+// interrupted marks a stored job that never finished as failed, since nothing runs
+// jobs left over from before a restart. Jobs in memory are never passed in.
+func interrupted(job *Job) {
+	if job.State == JobQueued || job.State == JobRunning {
+		job.State = JobFailed
+		job.Error = "job interrupted by a server restart"
+	}
+}
+
+// pruneLocked forgets finished jobs older than jobRetention. Storage keeps them until
+// History drops them. Callers must hold q.mu.
+func (q *JobQueue) pruneLocked() {
+	for id, job := range q.jobs {
+		if (job.State == JobSucceeded || job.State == JobFailed) && time.Since(job.FinishedAt) > jobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// resolveAccountLocked fills in the account of a job whose user's address was looked up
+// after it was queued, usually by the job itself. Callers must hold q.mu.
+func (q *JobQueue) resolveAccountLocked(job *Job) {
+	if job.Account == "" {
+		job.Account = knownAccount(job.UserID)
+	}
+}
+
+// SetProgress records the progress of a running job for status requests
+func (q *JobQueue) SetProgress(job *Job, progress interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job.Progress = progress
+}
+
+// snapshot copies a job's exported state under the lock
+func (q *JobQueue) snapshot(job *Job) Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	copied := *job
+	copied.run = nil
+	copied.done = nil
+	copied.ctx = nil
+	return copied
+}
+
+// worker repeatedly takes the highest priority pending job and runs it. A worker
+// reserved for interactive jobs only takes those.
+func (q *JobQueue) worker(interactiveOnly bool) {
+	for {
+		q.mu.Lock()
+		for q.pending.Len() == 0 || (interactiveOnly && q.pending[0].Priority != PriorityInteractive) {
+			q.cond.Wait()
+		}
+		job := heap.Pop(&q.pending).(*Job)
+		job.State = JobRunning
+		job.StartedAt = time.Now()
+		q.resolveAccountLocked(job)
+		q.mu.Unlock()
+		q.persist(q.snapshot(job))
+
+		result, err := q.execute(job)
+
+		q.mu.Lock()
+		job.FinishedAt = time.Now()
+		q.resolveAccountLocked(job)
+		if err != nil {
+			job.State = JobFailed
+			job.Error = redactError(err)
+		} else {
+			job.State = JobSucceeded
+			job.Result = result
+		}
+		q.mu.Unlock()
+		q.persist(q.snapshot(job))
+		Usage.addJob(job.UserID, job.Kind, err != nil)
+		close(job.done)
+		publishJobEvent(q.snapshot(job))
+	}
+}
+
+// execute runs a job, turning a panic into a job failure so a worker is never lost
+func (q *JobQueue) execute(job *Job) (result interface{}, err error) {
+	ctx, span := tracer.Start(trace.ContextWithSpanContext(context.Background(), job.parent), "job "+job.Kind, jobAttributes(job))
+	// Snapshots copy the job under the lock while it runs
+	q.mu.Lock()
+	job.ctx = ctx
+	q.mu.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s (%s) panicked: %v", job.ID, job.Kind, r)
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+		endSpan(span, err)
+	}()
+	return job.run(job)
+}
+
+// HandleGetJob returns the status, progress and, once finished, the result of one of
+// the user's jobs
+func HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	job, ok := Jobs.Lookup(account, mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, job)
+}
+
+// HandleListJobs returns the user's job history, newest first, optionally filtered by
+// `kind` and `state`. Results are left out; fetch a job by ID to see its result.
+func HandleListJobs(w http.ResponseWriter, r *http.Request) {
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	history, err := Jobs.History(account)
+	if err != nil {
+		writeErrorFrom(w, "Failed to list jobs", err, http.StatusInternalServerError)
+		return
+	}
+
+	kind := r.URL.Query().Get("kind")
+	state := JobState(r.URL.Query().Get("state"))
+	jobs := make([]Job, 0, len(history))
+	for _, job := range history {
+		if (kind != "" && job.Kind != kind) || (state != "" && job.State != state) {
+			continue
+		}
+		job.Result = nil
+		jobs = append(jobs, job)
+	}
+
+	writeJSON(w, jobs)
+}
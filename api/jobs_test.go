@@ -0,0 +1,63 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInteractiveJobsRunWhileWorkersAreBusy(t *testing.T) {
+	q := NewJobQueue(1)
+	release := make(chan struct{})
+	defer close(release)
+
+	started := make(chan struct{})
+	q.Enqueue("scan", "user", PriorityUser, func(job *Job) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	background := q.Enqueue("sync", "user", PriorityBackground, func(job *Job) (interface{}, error) {
+		return nil, nil
+	})
+	interactive := q.Enqueue("preview", "user", PriorityInteractive, func(job *Job) (interface{}, error) {
+		return "ok", nil
+	})
+
+	select {
+	case <-interactive.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("interactive job didn't run while the general worker was busy")
+	}
+	if snapshot, _ := q.Get(interactive.ID); snapshot.State != JobSucceeded || snapshot.Result != "ok" {
+		t.Errorf("interactive job = %+v, want succeeded with its result", snapshot)
+	}
+
+	// The reserved worker only takes interactive jobs
+	select {
+	case <-background.done:
+		t.Error("background job ran on the worker reserved for interactive jobs")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestParseRequestPriority(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    JobPriority
+		wantErr bool
+	}{
+		{"", PriorityUser, false},
+		{"user", PriorityUser, false},
+		{"background", PriorityBackground, false},
+		{"interactive", PriorityUser, true},
+		{"urgent", PriorityUser, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRequestPriority(tt.name, PriorityUser)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseRequestPriority(%q) = %v, %v; want %v, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
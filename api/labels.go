@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/api/gmail/v1"
+)
+
+// LabelUsage is how much of the scanned mail carries a label
+type LabelUsage struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// system or user
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+	Size  int64  `json:"size"`
+	// Date of the oldest message with the label, a starting point for a retention date
+	Oldest *time.Time `json:"oldest,omitempty"`
+}
+
+// LabelUsage counts and sizes the collected mail of every label, by label ID
+func (p *InboxProcessor) LabelUsage() map[string]*LabelUsage {
+	usage := make(map[string]*LabelUsage)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	p.emails.each(func(_ int, email *EmailMetadata) bool {
+		for _, id := range email.LabelIDs {
+			label, ok := usage[id]
+			if !ok {
+				label = &LabelUsage{ID: id, Name: id}
+				usage[id] = label
+			}
+			label.Count++
+			label.Size += email.Size()
+			if !email.Date.IsZero() && (label.Oldest == nil || email.Date.Before(*label.Oldest)) {
+				date := email.Date
+				label.Oldest = &date
+			}
+		}
+		return true
+	})
+	return usage
+}
+
+// listLabels returns the mailbox's labels
+func listLabels(mb *mailbox) ([]*gmail.Label, error) {
+	if err := mb.quota.Wait(context.Background(), costLabelsList); err != nil {
+		return nil, err
+	}
+	labels, err := mb.provider.Labels(mb.context(), mb.user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labels: %w", err)
+	}
+	return labels, nil
+}
+
+// HandleGetLabels returns every label of the mailbox with the number and total size of
+// the scanned messages carrying it, largest first. Labels the scan found no mail in are
+// listed with zero.
+func HandleGetLabels(w http.ResponseWriter, r *http.Request) {
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return
+	}
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+
+	labels, err := listLabels(mb)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	usage := processor.LabelUsage()
+	for _, label := range labels {
+		if _, ok := usage[label.Id]; !ok {
+			usage[label.Id] = &LabelUsage{ID: label.Id}
+		}
+		usage[label.Id].Name = label.Name
+		usage[label.Id].Type = label.Type
+	}
+
+	result := make([]*LabelUsage, 0, len(usage))
+	for _, label := range usage {
+		result = append(result, label)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Size != result[j].Size {
+			return result[i].Size > result[j].Size
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	writeJSON(w, result)
+}
+
+// HandleTrashLabel previews moving mail with a label to trash. Like HandleTrashSender,
+// optional `before` and `after` dates (YYYY-MM-DD) limit it to a date range, so that
+// e.g. only what is older than a retention date goes.
+func HandleTrashLabel(w http.ResponseWriter, r *http.Request) {
+	handleLabelAction(w, r, "label-trash", bulkActionTrash)
+}
+
+// HandleArchiveLabel archives the inbox mail with a label, optionally limited to a date
+// range like HandleTrashLabel
+func HandleArchiveLabel(w http.ResponseWriter, r *http.Request) {
+	handleLabelAction(w, r, "label-archive", bulkActionArchive)
+}
+
+// handleLabelAction trashes or archives the mail with the label of the route in the date
+// range of the request. Messages are selected by label ID, which unlike a label: search
+// doesn't depend on how the label's name is spelled.
+func handleLabelAction(w http.ResponseWriter, r *http.Request, kind, action string) {
+	labelID := mux.Vars(r)["labelId"]
+	if labelID == "TRASH" || labelID == "SPAM" {
+		writeError(w, "Mail in Trash and Spam is cleaned up by emptying them", http.StatusBadRequest)
+		return
+	}
+	dateRange, err := dateRangeQuery(r)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	labels, err := listLabels(mb)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	var label *gmail.Label
+	for _, l := range labels {
+		if l.Id == labelID {
+			label = l
+		}
+	}
+	if label == nil {
+		writeError(w, "Label not found", http.StatusNotFound)
+		return
+	}
+
+	query := MessageQuery{LabelIDs: []string{labelID}, Query: dateRange}
+	if action == bulkActionArchive && labelID != "INBOX" {
+		query.LabelIDs = append(query.LabelIDs, "INBOX")
+	}
+	target := strings.TrimSpace("label:" + label.Name + " " + dateRange)
+	handleResolvedAction(w, r, mb, kind, target, func() ([]string, error) {
+		return listQueryMessageIDs(mb, query)
+	}, action)
+}
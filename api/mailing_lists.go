@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// MailingList is the aggregate of all messages carrying the same List-Id
+type MailingList struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name,omitempty"`
+	Count   int      `json:"count"`
+	Size    int64    `json:"size"`
+	Senders []string `json:"senders"`
+}
+
+// GetMailingLists returns every mailing list seen, largest by count first, optionally
+// only lists with a sender whose mail mostly falls into category
+func (p *InboxProcessor) GetMailingLists(category string) []MailingList {
+	listSenders := p.stats.ListSenders()
+	p.stats.RLock()
+	defer p.stats.RUnlock()
+
+	lists := make([]MailingList, 0, len(p.stats.ListCount))
+	for id, count := range p.stats.ListCount {
+		senders := listSenders[id]
+		if senders == nil {
+			senders = make([]string, 0)
+		}
+		matches := category == ""
+		for _, sender := range senders {
+			matches = matches || p.stats.FromCategory[sender] == category
+		}
+		if !matches {
+			continue
+		}
+
+		lists = append(lists, MailingList{
+			ID:      id,
+			Name:    p.stats.ListNames[id],
+			Count:   count,
+			Size:    p.stats.ListSize[id],
+			Senders: senders,
+		})
+	}
+
+	sort.Slice(lists, func(i, j int) bool {
+		if lists[i].Count != lists[j].Count {
+			return lists[i].Count > lists[j].Count
+		}
+		return lists[i].ID < lists[j].ID
+	})
+	return lists
+}
+
+// HandleGetMailingLists returns list-level aggregates grouped by List-Id
+func HandleGetMailingLists(w http.ResponseWriter, r *http.Request) {
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return
+	}
+
+	category, err := parseCategoryFilter(r)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+
+	processor = statsView(w, r, processor)
+	if processor == nil {
+		return
+	}
+
+	writeJSON(w, processor.GetMailingLists(category))
+}
+
+// HandleTrashMailingList previews moving every message from a mailing list to trash
+func HandleTrashMailingList(w http.ResponseWriter, r *http.Request) {
+	listID := mux.Vars(r)["listId"]
+	handleQueryAction(w, r, "list-trash", "list:"+listID, bulkActionTrash)
+}
+
+// HandleArchiveMailingList archives every inbox message from a mailing list
+func HandleArchiveMailingList(w http.ResponseWriter, r *http.Request) {
+	listID := mux.Vars(r)["listId"]
+	handleQueryAction(w, r, "list-archive", "list:"+listID+" in:inbox", bulkActionArchive)
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"google.golang.org/api/gmail/v1"
+)
+
+// MessageDetail is a full message prepared for previewing in the browser
+type MessageDetail struct {
+	ID           string          `json:"id"`
+	ThreadID     string          `json:"threadId"`
+	LabelIDs     []string        `json:"labelIds"`
+	Snippet      string          `json:"snippet"`
+	SizeEstimate int64           `json:"sizeEstimate"`
+	Headers      []MessageHeader `json:"headers"`
+	Text         string          `json:"text"`
+	// HTML body with scripts, styles, forms and remote content removed
+	HTML        string           `json:"html"`
+	Attachments []AttachmentInfo `json:"attachments"`
+}
+
+// MessageHeader is one header of a message, in the order it appears
+type MessageHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// AttachmentInfo describes a file attached to a message without its contents
+type AttachmentInfo struct {
+	PartID       string `json:"partId"`
+	Filename     string `json:"filename"`
+	MimeType     string `json:"mimeType"`
+	Size         int64  `json:"size"`
+	AttachmentID string `json:"attachmentId,omitempty"`
+}
+
+// HandleGetEmail returns a message's headers, plain-text and sanitized HTML bodies and
+// attachment metadata, so users can preview it before deciding to delete it
+func HandleGetEmail(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+	if !requireContentAccess(w, mb.token) {
+		return
+	}
+
+	if err := mb.quota.Wait(r.Context(), costMessagesGet); err != nil {
+		writeErrorFrom(w, "Request cancelled", err, http.StatusServiceUnavailable)
+		return
+	}
+	msg, err := mb.provider.GetMessage(mb.context(), mb.user, messageID, "full")
+	if err != nil {
+		writeErrorFrom(w, "Failed to fetch email", err, http.StatusNotFound)
+		return
+	}
+
+	detail := &MessageDetail{
+		ID:           msg.Id,
+		ThreadID:     msg.ThreadId,
+		LabelIDs:     msg.LabelIds,
+		Snippet:      msg.Snippet,
+		SizeEstimate: msg.SizeEstimate,
+		Headers:      make([]MessageHeader, 0),
+		Attachments:  make([]AttachmentInfo, 0),
+	}
+	if msg.Payload != nil {
+		for _, header := range msg.Payload.Headers {
+			detail.Headers = append(detail.Headers, MessageHeader{Name: header.Name, Value: header.Value})
+		}
+	}
+
+	var rawHTML string
+	walkMessageParts(msg.Payload, func(part *gmail.MessagePart) {
+		if part.Filename != "" {
+			info := AttachmentInfo{PartID: part.PartId, Filename: part.Filename, MimeType: part.MimeType}
+			if part.Body != nil {
+				info.Size = part.Body.Size
+				info.AttachmentID = part.Body.AttachmentId
+			}
+			detail.Attachments = append(detail.Attachments, info)
+			return
+		}
+		if part.Body == nil || part.Body.Data == "" {
+			return
+		}
+
+		// Use the first body of each type; later ones are usually forwarded parts
+		switch strings.ToLower(part.MimeType) {
+		case "text/plain":
+			if detail.Text == "" {
+				if data, err := decodeBase64URL(part.Body.Data); err == nil {
+					detail.Text = string(data)
+				}
+			}
+		case "text/html":
+			if rawHTML == "" {
+				if data, err := decodeBase64URL(part.Body.Data); err == nil {
+					rawHTML = string(data)
+				}
+			}
+		}
+	})
+	detail.HTML = sanitizeHTML(rawHTML)
+
+	writeJSON(w, detail)
+}
+
+// walkMessageParts calls fn for a payload part and all of its descendants
+func walkMessageParts(part *gmail.MessagePart, fn func(part *gmail.MessagePart)) {
+	if part == nil {
+		return
+	}
+	fn(part)
+	for _, child := range part.Parts {
+		walkMessageParts(child, fn)
+	}
+}
+
+// Elements kept by sanitizeHTML; everything else is unwrapped to its text
+var allowedHTMLElements = map[atom.Atom]bool{
+	atom.A: true, atom.B: true, atom.Blockquote: true, atom.Br: true, atom.Caption: true,
+	atom.Center: true, atom.Code: true, atom.Div: true, atom.Em: true, atom.Font: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Hr: true, atom.I: true, atom.Img: true, atom.Li: true, atom.Ol: true, atom.P: true,
+	atom.Pre: true, atom.S: true, atom.Small: true, atom.Span: true, atom.Strong: true,
+	atom.Sub: true, atom.Sup: true, atom.Table: true, atom.Tbody: true, atom.Td: true,
+	atom.Tfoot: true, atom.Th: true, atom.Thead: true, atom.Tr: true, atom.U: true, atom.Ul: true,
+}
+
+// Elements dropped by sanitizeHTML together with everything inside them. Void elements
+// such as input and embed have no content and are dropped as disallowed.
+var droppedHTMLElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Iframe: true, atom.Object: true,
+	atom.Form: true, atom.Button: true, atom.Select: true, atom.Textarea: true,
+	atom.Head: true, atom.Title: true, atom.Svg: true, atom.Math: true, atom.Noscript: true,
+	atom.Template: true, atom.Frameset: true, atom.Applet: true,
+}
+
+// Attributes kept by sanitizeHTML on allowed elements
+var allowedHTMLAttributes = map[string]bool{
+	"href": true, "src": true, "alt": true, "title": true, "width": true, "height": true,
+	"align": true, "valign": true, "colspan": true, "rowspan": true, "color": true,
+}
+
+// sanitizeHTML keeps only simple formatting from an email's HTML body. Scripts, styles,
+// forms and event handlers are removed, links only keep http(s) and mailto targets and
+// open in a new tab, and remote images are dropped so previewing a message can't tell
+// the sender it was opened.
+func sanitizeHTML(src string) string {
+	var b strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(src))
+	// Depth inside dropped elements, whose content is skipped entirely
+	dropDepth := 0
+
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			// Either the end of the body or markup too broken to go on with
+			return b.String()
+		}
+		token := tokenizer.Token()
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedHTMLElements[token.DataAtom] {
+				if tt == html.StartTagToken {
+					dropDepth++
+				}
+				continue
+			}
+			if dropDepth > 0 || !allowedHTMLElements[token.DataAtom] {
+				continue
+			}
+			token.Attr = sanitizeAttributes(token.DataAtom, token.Attr)
+			if token.DataAtom == atom.Img && !hasAttribute(token.Attr, "src") {
+				// An image without a safe source is just its alt text
+				for _, attr := range token.Attr {
+					if attr.Key == "alt" {
+						b.WriteString(html.EscapeString(attr.Val))
+					}
+				}
+				continue
+			}
+			b.WriteString(token.String())
+		case html.EndTagToken:
+			if droppedHTMLElements[token.DataAtom] {
+				if dropDepth > 0 {
+					dropDepth--
+				}
+				continue
+			}
+			if dropDepth == 0 && allowedHTMLElements[token.DataAtom] {
+				b.WriteString(token.String())
+			}
+		case html.TextToken:
+			if dropDepth == 0 {
+				b.WriteString(html.EscapeString(token.Data))
+			}
+		}
+	}
+}
+
+// sanitizeAttributes keeps the allowed attributes of an element with safe values
+func sanitizeAttributes(element atom.Atom, attrs []html.Attribute) []html.Attribute {
+	kept := make([]html.Attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		key := strings.ToLower(attr.Key)
+		if attr.Namespace != "" || !allowedHTMLAttributes[key] {
+			continue
+		}
+		switch key {
+		case "href":
+			if element != atom.A || !safeURL(attr.Val, "http", "https", "mailto") {
+				continue
+			}
+		case "src":
+			// Inline images only; remote ones would load tracking pixels
+			if element != atom.Img || !strings.HasPrefix(strings.ToLower(strings.TrimSpace(attr.Val)), "data:image/") {
+				continue
+			}
+		}
+		kept = append(kept, html.Attribute{Key: key, Val: attr.Val})
+	}
+	if element == atom.A && hasAttribute(kept, "href") {
+		kept = append(kept,
+			html.Attribute{Key: "target", Val: "_blank"},
+			html.Attribute{Key: "rel", Val: "noopener noreferrer"},
+		)
+	}
+	return kept
+}
+
+// safeURL reports whether a URL uses one of the given schemes
+func safeURL(raw string, schemes ...string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return false
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAttribute reports whether attrs contains key
+func hasAttribute(attrs []html.Attribute, key string) bool {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
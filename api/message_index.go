@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+)
+
+// knownMessage is what a scan keeps of a fetched message: enough to count it again on a
+// later scan without another Messages.Get. Gmail messages never change apart from their
+// labels, so only labels such as UNREAD can be out of date.
+type knownMessage = deepclean.Message
+
+// messageIndex is the persisted set of messages scans of a mailbox have fetched, kept as
+// JSON lines on disk with only each message's location held in memory
+type messageIndex struct {
+	mu   sync.Mutex
+	file *os.File
+	byID map[string]spilledRecord
+	end  int64
+	// Messages the current scan listed, so messages deleted since can be dropped
+	seen map[string]struct{}
+}
+
+// messageIndexPath is the file of a mailbox's message index
+func messageIndexPath(userID string) string {
+	return filepath.Join(config.DataDir, "message-index", url.PathEscape(userID)+".jsonl")
+}
+
+// openMessageIndex opens a mailbox's message index, creating it if needed. A record cut
+// short by a crash is dropped.
+func openMessageIndex(userID string) (*messageIndex, error) {
+	path := messageIndexPath(userID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create message index directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message index: %w", err)
+	}
+
+	x := &messageIndex{file: file, byID: make(map[string]spilledRecord), seen: make(map[string]struct{})}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read message index: %w", err)
+		}
+
+		var record struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(line, &record) == nil && record.ID != "" {
+			x.byID[record.ID] = spilledRecord{offset: x.end, length: len(line)}
+		}
+		x.end += int64(len(line))
+	}
+	// Anything after the last full line is a partial write
+	if err := file.Truncate(x.end); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to repair message index: %w", err)
+	}
+	return x, nil
+}
+
+// lookup returns a message fetched by an earlier scan
+func (x *messageIndex) lookup(id string) (*knownMessage, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	record, ok := x.byID[id]
+	if !ok {
+		return nil, false
+	}
+	buf := make([]byte, record.length)
+	var m knownMessage
+	if _, err := x.file.ReadAt(buf, record.offset); err != nil || json.Unmarshal(buf, &m) != nil {
+		// Fetch it again instead
+		delete(x.byID, id)
+		return nil, false
+	}
+	x.seen[id] = struct{}{}
+	return &m, true
+}
+
+// add records a newly fetched message
+func (x *messageIndex) add(m *knownMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, err := x.file.WriteAt(data, x.end); err != nil {
+		return fmt.Errorf("failed to write message index: %w", err)
+	}
+	x.byID[m.ID] = spilledRecord{offset: x.end, length: len(data)}
+	x.end += int64(len(data))
+	x.seen[m.ID] = struct{}{}
+	return nil
+}
+
+// compact rewrites the index with only the messages the finished scan listed, dropping
+// messages deleted since earlier scans and superseded records
+func (x *messageIndex) compact() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	path := x.file.Name()
+	tmp, err := os.CreateTemp(filepath.Dir(path), "compact-*")
+	if err != nil {
+		return fmt.Errorf("failed to compact message index: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	byID := make(map[string]spilledRecord, len(x.seen))
+	var end int64
+	for id := range x.seen {
+		record, ok := x.byID[id]
+		if !ok {
+			continue
+		}
+		buf := make([]byte, record.length)
+		if _, err = x.file.ReadAt(buf, record.offset); err != nil {
+			break
+		}
+		if _, err = w.Write(buf); err != nil {
+			break
+		}
+		byID[id] = spilledRecord{offset: end, length: record.length}
+		end += int64(record.length)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to compact message index: %w", err)
+	}
+
+	x.file.Close()
+	x.file, x.byID, x.end = tmp, byID, end
+	return nil
+}
+
+// close closes the index file
+func (x *messageIndex) close() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.file.Close(); err != nil {
+		log.Printf("Failed to close message index: %s", redactError(err))
+	}
+}
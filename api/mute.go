@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+	"github.com/gorilla/mux"
+	"google.golang.org/api/gmail/v1"
+)
+
+// HandleMuteSender archives everything from a sender that is currently in the inbox and
+// creates a filter so future mail from them skips the inbox, like Gmail's mute
+func HandleMuteSender(w http.ResponseWriter, r *http.Request) {
+	sender := mux.Vars(r)["email"]
+
+	if !requireFeature(w, Features().FilterCreation, "filter creation") {
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	result, err := muteMessages(mb, SenderQuery(sender)+" in:inbox", &gmail.FilterCriteria{From: sender})
+	if err != nil {
+		writeErrorFrom(w, "Failed to mute sender", err, http.StatusInternalServerError)
+		return
+	}
+
+	result["sender"] = sender
+	writeJSON(w, result)
+}
+
+// HandleMuteThread archives a thread and creates a filter that keeps further replies
+// from its sender with the same subject out of the inbox
+func HandleMuteThread(w http.ResponseWriter, r *http.Request) {
+	threadID := mux.Vars(r)["id"]
+
+	if !requireFeature(w, Features().FilterCreation, "filter creation") {
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	if err := mb.quota.Wait(r.Context(), costThreadsGet+costThreadsModify+costFiltersCreate); err != nil {
+		writeErrorFrom(w, "Request cancelled", err, http.StatusServiceUnavailable)
+		return
+	}
+	thread, err := mb.provider.GetThread(mb.context(), mb.user, threadID, "metadata", "From", "Subject")
+	if err != nil {
+		writeErrorFrom(w, "Failed to fetch thread", err, http.StatusNotFound)
+		return
+	}
+	if len(thread.Messages) == 0 {
+		writeError(w, "Thread has no messages", http.StatusNotFound)
+		return
+	}
+
+	// Filters can't match a thread ID, so key the filter on the thread's
+	// original sender and subject instead
+	var sender, subject string
+	for _, header := range thread.Messages[0].Payload.Headers {
+		switch header.Name {
+		case "From":
+			sender = deepclean.ExtractEmailAddress(header.Value)
+		case "Subject":
+			subject = header.Value
+		}
+	}
+	if subject == "" {
+		writeError(w, "Thread has no subject to build a filter from", http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = mb.provider.ModifyThread(mb.context(), mb.user, threadID, nil, []string{"INBOX"})
+	if err != nil {
+		writeErrorFrom(w, "Failed to archive thread", err, http.StatusInternalServerError)
+		return
+	}
+
+	filter, err := createSkipInboxFilter(mb, &gmail.FilterCriteria{
+		From:    sender,
+		Subject: strings.TrimSpace(subject),
+	})
+	if err != nil {
+		writeErrorFrom(w, "Thread archived but failed to create filter", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"threadId": threadID,
+		"archived": len(thread.Messages),
+		"filterId": filter.Id,
+	})
+}
+
+// muteMessages archives the messages matching query and creates a skip-inbox filter
+func muteMessages(mb *mailbox, query string, criteria *gmail.FilterCriteria) (map[string]interface{}, error) {
+	ids, err := listMessageIDs(mb, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := batchModify(mb, ids, nil, []string{"INBOX"}); err != nil {
+		return nil, err
+	}
+
+	if err := mb.quota.Wait(context.Background(), costFiltersCreate); err != nil {
+		return nil, err
+	}
+	filter, err := createSkipInboxFilter(mb, criteria)
+	if err != nil {
+		return nil, fmt.Errorf("archived %d messages but failed to create filter: %w", len(ids), err)
+	}
+
+	return map[string]interface{}{
+		"archived": len(ids),
+		"filterId": filter.Id,
+	}, nil
+}
+
+// createSkipInboxFilter creates a filter that archives matching mail on arrival
+func createSkipInboxFilter(mb *mailbox, criteria *gmail.FilterCriteria) (*gmail.Filter, error) {
+	return mb.provider.CreateFilter(mb.context(), mb.user, &gmail.Filter{
+		Criteria: criteria,
+		Action: &gmail.FilterAction{
+			RemoveLabelIds: []string{"INBOX"},
+		},
+	})
+}
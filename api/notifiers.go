@@ -0,0 +1,403 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Most notifiers one account may configure
+const maxNotifiers = 10
+
+// Client for posting notifications. Like webhookClient, it only connects to the
+// addresses webhooks may be delivered to.
+var notifierClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: addressDialControl(errNotifierAddress)}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+var errNotifierAddress = errors.New("notifications can only be posted to public addresses")
+
+// Serializes read-modify-write updates of notifiers
+var notifiersMu sync.Mutex
+
+// Notifier is somewhere a user's cleanup summaries are posted, such as a Slack channel
+// or a phone
+type Notifier struct {
+	ID string `json:"id"`
+	// slack, ntfy or pushover
+	Type string `json:"type"`
+	// Incoming webhook URL for Slack, topic URL for ntfy, e.g. https://ntfy.sh/my-topic
+	URL string `json:"url,omitempty"`
+	// Access token of a protected ntfy topic, or the Pushover application token
+	Token string `json:"token,omitempty"`
+	// Pushover user or group key
+	UserKey   string    `json:"userKey,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// notification is a short message posted to notifiers
+type notification struct {
+	Title   string
+	Message string
+	// Whether it reports a failure, which backends may flag
+	Failed bool
+}
+
+// notifierBackend is a service notifications can be posted to
+type notifierBackend interface {
+	// validate checks that a notifier has what the service needs
+	validate(n *Notifier) error
+	// send posts a notification
+	send(ctx context.Context, n *Notifier, note notification) error
+	// mask clears the secrets of a notifier for display
+	mask(n *Notifier)
+}
+
+// notifierBackends are the supported services by notifier type
+var notifierBackends = map[string]notifierBackend{
+	"slack":    slackBackend{},
+	"ntfy":     ntfyBackend{},
+	"pushover": pushoverBackend{},
+}
+
+// postNotification sends a request built by a backend and checks the service accepted it
+func postNotification(req *http.Request) error {
+	resp, err := notifierClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("service answered %s", resp.Status)
+	}
+	return nil
+}
+
+// validateHTTPURL checks that a notifier URL is an absolute http(s) URL whose host
+// notifications may be posted to
+func validateHTTPURL(raw string, https bool) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && (https || u.Scheme != "http")) {
+		if https {
+			return fmt.Errorf("url must be an https URL")
+		}
+		return fmt.Errorf("url must be an http or https URL")
+	}
+	if ip := disallowedAddress(u.Hostname()); ip != nil {
+		return fmt.Errorf("%w, not %s", errNotifierAddress, ip)
+	}
+	return nil
+}
+
+// slackBackend posts to a Slack incoming webhook
+type slackBackend struct{}
+
+func (slackBackend) validate(n *Notifier) error {
+	if err := validateHTTPURL(n.URL, true); err != nil {
+		return err
+	}
+	n.Token, n.UserKey = "", ""
+	return nil
+}
+
+func (slackBackend) send(ctx context.Context, n *Notifier, note notification) error {
+	text := "*" + note.Title + "*\n" + note.Message
+	if note.Failed {
+		text = ":warning: " + text
+	}
+	body, _ := json.Marshal(map[string]string{"text": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return postNotification(req)
+}
+
+// The URL of a Slack webhook is its secret, so only its host is shown
+func (slackBackend) mask(n *Notifier) {
+	if u, err := url.Parse(n.URL); err == nil {
+		n.URL = u.Scheme + "://" + u.Host + "/…"
+	}
+}
+
+// ntfyBackend publishes to an ntfy topic
+type ntfyBackend struct{}
+
+func (ntfyBackend) validate(n *Notifier) error {
+	if err := validateHTTPURL(n.URL, false); err != nil {
+		return err
+	}
+	if u, _ := url.Parse(n.URL); strings.Trim(u.Path, "/") == "" {
+		return fmt.Errorf("url must name a topic, e.g. https://ntfy.sh/my-topic")
+	}
+	n.UserKey = ""
+	return nil
+}
+
+func (ntfyBackend) send(ctx context.Context, n *Notifier, note notification) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, strings.NewReader(note.Message))
+	if err != nil {
+		return err
+	}
+	tags := "broom"
+	if note.Failed {
+		tags = "warning"
+		req.Header.Set("Priority", "high")
+	}
+	req.Header.Set("Title", note.Title)
+	req.Header.Set("Tags", tags)
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return postNotification(req)
+}
+
+func (ntfyBackend) mask(n *Notifier) {
+	n.Token = ""
+}
+
+// Pushover's message API
+var pushoverURL = "https://api.pushover.net/1/messages.json"
+
+// pushoverBackend sends Pushover messages
+type pushoverBackend struct{}
+
+func (pushoverBackend) validate(n *Notifier) error {
+	if n.Token == "" || n.UserKey == "" {
+		return fmt.Errorf("pushover needs an application token and a user key")
+	}
+	n.URL = ""
+	return nil
+}
+
+func (pushoverBackend) send(ctx context.Context, n *Notifier, note notification) error {
+	form := url.Values{"token": {n.Token}, "user": {n.UserKey}, "title": {note.Title}, "message": {note.Message}}
+	if note.Failed {
+		form.Set("priority", "1")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pushoverURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return postNotification(req)
+}
+
+func (pushoverBackend) mask(n *Notifier) {
+	n.Token, n.UserKey = "", ""
+}
+
+// notifiersKey is the storage key of an account's notifiers
+func notifiersKey(account string) string {
+	return "notifiers/" + account
+}
+
+// loadNotifiers returns an account's notifiers, with their secrets
+func loadNotifiers(account string) ([]Notifier, error) {
+	notifiers := make([]Notifier, 0)
+	if _, err := Storage.Get(notifiersKey(account), &notifiers); err != nil {
+		return nil, fmt.Errorf("failed to load notifiers: %w", err)
+	}
+	return notifiers, nil
+}
+
+// maskNotifiers returns copies of notifiers with their secrets cleared
+func maskNotifiers(notifiers []Notifier) []Notifier {
+	masked := slices.Clone(notifiers)
+	for i := range masked {
+		if backend, ok := notifierBackends[masked[i].Type]; ok {
+			backend.mask(&masked[i])
+		}
+	}
+	return masked
+}
+
+// notify posts a notification to every notifier of the account in the background.
+// Failures only affect the notification, so they are only logged.
+func notify(account string, note notification) {
+	if !Features().Notifications || account == "" {
+		return
+	}
+	notifiers, err := loadNotifiers(account)
+	if err != nil {
+		log.Printf("Not sending notification: %s", redactError(err))
+		return
+	}
+	for _, n := range notifiers {
+		backend, ok := notifierBackends[n.Type]
+		if !ok {
+			continue
+		}
+		go func() {
+			if err := backend.send(context.Background(), &n, note); err != nil {
+				log.Printf("Failed to notify through %s notifier %s: %s", n.Type, n.ID, redactError(err))
+			}
+		}()
+	}
+}
+
+// cleanupNotification summarizes a finished operation: messages removed and storage freed
+func cleanupNotification(op *Operation, affected []string, runErr error) notification {
+	what := op.Kind
+	if op.Target != "" {
+		what += " of " + op.Target
+	}
+	summary := fmt.Sprintf("%d of %d messages removed", len(affected), op.Summary.Count)
+	if reclaimed := op.reclaimed(len(affected)); reclaimed > 0 {
+		summary += ", about " + FormatBytes(reclaimed) + " freed"
+	}
+
+	if runErr != nil {
+		return notification{
+			Title:   "Cleanup failed",
+			Message: fmt.Sprintf("Your %s failed: %s. %s.", what, redactError(runErr), summary),
+			Failed:  true,
+		}
+	}
+	return notification{Title: "Cleanup finished", Message: fmt.Sprintf("Your %s has finished. %s.", what, summary)}
+}
+
+// HandleListNotifiers returns the user's notifiers, without their secrets
+func HandleListNotifiers(w http.ResponseWriter, r *http.Request) {
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	notifiers, err := loadNotifiers(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, maskNotifiers(notifiers))
+}
+
+// HandleCreateNotifier adds a Slack, ntfy or Pushover notifier, which is sent a summary
+// of every cleanup that runs in the background
+func HandleCreateNotifier(w http.ResponseWriter, r *http.Request) {
+	if !requireFeature(w, Features().Notifications, "notifications") {
+		return
+	}
+	var n Notifier
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	n.URL, n.Token, n.UserKey = strings.TrimSpace(n.URL), strings.TrimSpace(n.Token), strings.TrimSpace(n.UserKey)
+	backend, ok := notifierBackends[n.Type]
+	if !ok {
+		writeError(w, "type must be slack, ntfy or pushover", http.StatusBadRequest)
+		return
+	}
+	if err := backend.validate(&n); err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+	n.ID = newID()
+	n.CreatedAt = time.Now()
+
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	notifiersMu.Lock()
+	defer notifiersMu.Unlock()
+
+	notifiers, err := loadNotifiers(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	if len(notifiers) >= maxNotifiers {
+		writeError(w, fmt.Sprintf("An account may have at most %d notifiers", maxNotifiers), http.StatusBadRequest)
+		return
+	}
+	notifiers = append(notifiers, n)
+	if err := Storage.Put(notifiersKey(account), notifiers); err != nil {
+		writeErrorFrom(w, "Failed to save notifier", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, maskNotifiers([]Notifier{n})[0])
+}
+
+// HandleDeleteNotifier removes a notifier
+func HandleDeleteNotifier(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	notifiersMu.Lock()
+	defer notifiersMu.Unlock()
+
+	notifiers, err := loadNotifiers(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	kept := slices.DeleteFunc(slices.Clone(notifiers), func(n Notifier) bool { return n.ID == id })
+	if len(kept) == len(notifiers) {
+		writeError(w, "Notifier not found", http.StatusNotFound)
+		return
+	}
+	if err := Storage.Put(notifiersKey(account), kept); err != nil {
+		writeErrorFrom(w, "Failed to delete notifier", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, maskNotifiers(kept))
+}
+
+// HandleTestNotifier posts a test notification through a notifier right away and
+// reports whether the service accepted it. Why it failed is only logged, so the test
+// can't be used to probe what a URL answers.
+func HandleTestNotifier(w http.ResponseWriter, r *http.Request) {
+	if !requireFeature(w, Features().Notifications, "notifications") {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	notifiers, err := loadNotifiers(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	i := slices.IndexFunc(notifiers, func(n Notifier) bool { return n.ID == id })
+	if i < 0 {
+		writeError(w, "Notifier not found", http.StatusNotFound)
+		return
+	}
+
+	note := notification{Title: "Test notification", Message: "Cleanup summaries for " + account + " will show up here."}
+	err = notifierBackends[notifiers[i].Type].send(r.Context(), &notifiers[i], note)
+	result := map[string]interface{}{"delivered": err == nil}
+	if err != nil {
+		log.Printf("Failed to test %s notifier %s: %s", notifiers[i].Type, id, redactError(err))
+		result["error"] = "The notification could not be delivered"
+	}
+	writeJSON(w, result)
+}
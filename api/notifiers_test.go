@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotifiersOnlyPostToPublicAddresses(t *testing.T) {
+	user := newTestUser(t)
+	posted := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = true
+	}))
+	defer receiver.Close()
+
+	r := user.request(t, http.MethodPost, "/api/v1/notifiers", Notifier{Type: "ntfy", URL: receiver.URL + "/topic"}, nil)
+	decodeResponse(t, serve(HandleCreateNotifier, r), http.StatusBadRequest, nil)
+
+	// A notifier whose name now resolves inside is refused when connecting
+	saved := Notifier{ID: newID(), Type: "ntfy", URL: receiver.URL + "/topic", CreatedAt: time.Now()}
+	if err := Storage.Put(notifiersKey(user.account()), []Notifier{saved}); err != nil {
+		t.Fatal(err)
+	}
+	err := ntfyBackend{}.send(context.Background(), &saved, notification{Title: "Test", Message: "Hi"})
+	if !errors.Is(err, errNotifierAddress) {
+		t.Errorf("send error = %v, want errNotifierAddress", err)
+	}
+
+	// The test reports the failure without the reason
+	r = user.request(t, http.MethodPost, "/api/v1/notifiers/"+saved.ID+"/test", nil, map[string]string{"id": saved.ID})
+	var result struct {
+		Delivered bool   `json:"delivered"`
+		Error     string `json:"error"`
+	}
+	decodeResponse(t, serve(HandleTestNotifier, r), http.StatusOK, &result)
+	if result.Delivered || result.Error != "The notification could not be delivered" {
+		t.Errorf("test result = %+v, want an undelivered notification with the generic error", result)
+	}
+	if posted {
+		t.Error("notification posted to a loopback address")
+	}
+}
@@ -0,0 +1,583 @@
+package api
+
+import (
+	"encoding"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+)
+
+// The OpenAPI document is generated from the router and the Go types handlers decode and
+// encode, so it can't drift from the code. apiRoutes adds what the types can't say:
+// summaries, query parameters and which types each route uses.
+
+// apiSchema is a literal JSON schema
+type apiSchema map[string]interface{}
+
+// apiObject describes an ad-hoc JSON object by example: each value is a zero value of
+// the property's type, or an apiSchema
+type apiObject map[string]interface{}
+
+// apiOneOf describes a value that is one of several types, given by example
+type apiOneOf []interface{}
+
+// apiParam documents a query parameter
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// apiRoute documents one route
+type apiRoute struct {
+	Summary string
+	Query   []apiParam
+	// Zero value of the JSON request body, nil for none
+	Body interface{}
+	// Content type of a request body that isn't JSON
+	BodyType string
+	// Zero value of the JSON response, an apiObject or apiSchema, or nil for no body
+	Response interface{}
+	// Content type of a response that isn't JSON, such as a file download
+	Produces string
+	// Status of a successful response, 200 if unset
+	Status int
+	// Needs no Authorization header
+	Public bool
+	// Needs the X-Admin-Token header instead of Authorization
+	Admin bool
+}
+
+// Query parameters several routes share
+var (
+	categoryParam = apiParam{"category", "string", "Only include messages classified into this category"}
+	pageParams    = []apiParam{
+		{"offset", "integer", "Number of results to skip"},
+		{"limit", "integer", "Maximum number of results"},
+	}
+	senderPageParams = append([]apiParam{
+		{"sortBy", "string", "count or size"},
+		categoryParam,
+	}, pageParams...)
+	calendarAgeParam = apiParam{"olderThanDays", "integer", "Events count as past once they ended this many days ago (default 30)"}
+	dateRangeParams  = []apiParam{
+		{"after", "string", "Only messages on or after this date (YYYY-MM-DD)"},
+		{"before", "string", "Only messages before this date (YYYY-MM-DD)"},
+	}
+	timeTravelParams = []apiParam{
+		{"since", "string", "Only count messages since this date (YYYY-MM-DD)"},
+		{"asOf", "string", "Count the mailbox as it was at the end of this date (YYYY-MM-DD)"},
+	}
+	confirmParams = []apiParam{
+		{"async", "boolean", "Return the job at once instead of waiting for the result"},
+		{"verify", "boolean", "Check the messages afterwards to verify the operation"},
+		{"report", "boolean", "Email the user a report of the cleanup"},
+		{"notify", "boolean", "Post a summary to the user's notifiers; always done with async"},
+	}
+)
+
+// Results of jobs whose shape depends on what they did
+var jobResult = apiSchema{"description": "Result of the job, in a shape specific to its kind"}
+
+var apiRoutes = map[string]apiRoute{
+	"GET /auth/gmail":            {Summary: "Start signing in with Google", Public: true, Query: []apiParam{{"access", "string", "modify (default) to analyze and clean, readonly to only analyze, or metadata, which grants no access to message content either"}}, Status: http.StatusTemporaryRedirect},
+	"GET /auth/gmail/callback":   {Summary: "Finish signing in and hand a session token to the opening window", Public: true, Produces: "text/html"},
+	"POST /auth/session/refresh": {Summary: "Exchange a valid session token for a new one", Response: SessionToken{}},
+	"DELETE /auth/session":       {Summary: "Sign out, ending the session of the token", Status: http.StatusNoContent},
+	"GET /auth/csrf":             {Summary: "CSRF token for requests without an Authorization header, setting its cookie", Public: true, Response: apiSchema{"type": "object"}},
+	"POST /auth/imap":            {Summary: "Sign in to an IMAP server, returning a token to use like the Google one", Public: true, Body: IMAPAccount{}, Response: oauth2.Token{}},
+	"DELETE /auth/imap":          {Summary: "Sign out of the IMAP session of the token", Status: http.StatusNoContent},
+	"GET /api/v1/openapi.json":   {Summary: "This OpenAPI document", Public: true, Response: apiSchema{"type": "object"}},
+	"GET /api/v1/docs":           {Summary: "Swagger UI for this document", Public: true, Produces: "text/html"},
+
+	"GET /api/v1/me":         {Summary: "Profile of the signed-in account and its token", Response: AccountProfile{}},
+	"DELETE /api/v1/me/data": {Summary: "Make the server forget everything it holds about the mailbox, ending its sessions", Response: DeletionReceipt{}},
+	"GET /api/v1/me/export":  {Summary: "Download a zip of everything the server holds about the mailbox", Produces: "application/zip"},
+	"GET /api/v1/storage":    {Summary: "Storage quota of the account", Response: StorageQuota{}},
+
+	"GET /api/v1/emails": {Summary: "List messages from Gmail", Query: []apiParam{
+		{"q", "string", "Gmail search query"},
+		{"labelIds", "string", "Only messages with these labels, comma-separated or repeated"},
+		{"maxResults", "integer", "Page size"},
+		{"pageToken", "string", "nextPageToken of the previous page"},
+	}, Response: gmail.ListMessagesResponse{}},
+	"GET /api/v1/emails/{id}":    {Summary: "Headers, bodies and attachments of a message", Response: MessageDetail{}},
+	"DELETE /api/v1/emails/{id}": {Summary: "Move a message to trash", Response: apiObject{"status": "", "message": ""}},
+
+	"POST /api/v1/emails/batch-trash":        {Summary: "Preview trashing messages", Body: batchTrashRequest{}, Response: Operation{}},
+	"POST /api/v1/emails/batch-archive":      {Summary: "Archive messages", Body: batchActionRequest{}, Response: ArchiveResult{}},
+	"POST /api/v1/emails/batch-untrash":      {Summary: "Move messages, or those an operation trashed, out of trash", Body: batchUntrashRequest{}, Response: UntrashResult{}},
+	"POST /api/v1/emails/batch-mark-read":    {Summary: "Mark messages read", Body: batchActionRequest{}, Response: MarkReadResult{}},
+	"POST /api/v1/emails/batch-spam":         {Summary: "Report messages as spam", Body: batchActionRequest{}, Response: SpamResult{}},
+	"POST /api/v1/emails/delete-by-query":    {Summary: "Preview trashing messages matching a Gmail search", Body: queryRequest{}, Response: Operation{}},
+	"POST /api/v1/emails/archive-by-query":   {Summary: "Archive inbox messages matching a Gmail search", Query: []apiParam{categoryParam}, Body: queryRequest{}, Response: ArchiveResult{}},
+	"POST /api/v1/emails/mark-read-by-query": {Summary: "Mark unread messages matching a Gmail search read", Query: []apiParam{categoryParam}, Body: queryRequest{}, Response: MarkReadResult{}},
+
+	"POST /api/v1/emails/{id}/spam":              {Summary: "Report a message as spam", Response: apiObject{"status": "", "message": ""}},
+	"POST /api/v1/emails/{id}/strip-attachments": {Summary: "Replace a message with a copy without its attachments", Response: apiObject{"originalId": "", "newId": "", "removed": []StrippedAttachment{}, "bytesBefore": 0, "bytesAfter": 0}},
+	"POST /api/v1/emails/{id}/attachments/drive": {Summary: "Save a message's attachments to Google Drive", Body: saveToDriveRequest{}, Response: apiObject{"messageId": "", "attachments": []SavedAttachment{}, "trashed": false}},
+
+	"POST /api/v1/threads/{id}/mute": {Summary: "Archive a thread and keep its replies out of the inbox", Response: apiObject{"threadId": "", "archived": 0, "filterId": ""}},
+	"POST /api/v1/threads/prune":     {Summary: "Preview trashing all but the latest message of threads", Body: pruneRequest{}, Response: Operation{}},
+
+	"GET /api/v1/senders/{email}/timeline":   {Summary: "Monthly message counts and sizes of a sender's scanned mail", Response: SenderTimeline{}},
+	"POST /api/v1/senders/{email}/mute":      {Summary: "Archive a sender's mail and skip the inbox for new mail", Response: apiObject{"archived": 0, "filterId": ""}},
+	"POST /api/v1/senders/{email}/filter":    {Summary: "Create a Gmail filter for a sender's mail", Body: senderFilterRequest{}, Response: apiObject{"sender": "", "action": "", "filterId": ""}},
+	"POST /api/v1/senders/{email}/block":     {Summary: "Preview blocking a sender", Body: blockRequest{}, Response: Operation{}},
+	"POST /api/v1/senders/block":             {Summary: "Preview blocking several senders", Body: blockRequest{}, Response: Operation{}},
+	"POST /api/v1/senders/{email}/trash":     {Summary: "Preview trashing a sender's mail", Query: append([]apiParam{categoryParam}, dateRangeParams...), Response: Operation{}},
+	"POST /api/v1/senders/{email}/archive":   {Summary: "Archive a sender's inbox mail", Query: append([]apiParam{categoryParam}, dateRangeParams...), Response: ArchiveResult{}},
+	"POST /api/v1/senders/{email}/mark-read": {Summary: "Mark a sender's mail read", Query: append([]apiParam{categoryParam}, dateRangeParams...), Response: MarkReadResult{}},
+
+	"POST /api/v1/campaigns/unsubscribe": {Summary: "Preview unsubscribing from every newsletter of a domain", Body: campaignRequest{}, Response: Operation{}},
+
+	"POST /api/v1/lists/{listId}/trash":   {Summary: "Preview trashing a mailing list's mail", Query: []apiParam{categoryParam}, Response: Operation{}},
+	"POST /api/v1/lists/{listId}/archive": {Summary: "Archive a mailing list's inbox mail", Query: []apiParam{categoryParam}, Response: ArchiveResult{}},
+
+	"POST /api/v1/labels/{labelId}/trash":   {Summary: "Preview trashing a label's mail", Query: dateRangeParams, Response: Operation{}},
+	"POST /api/v1/labels/{labelId}/archive": {Summary: "Archive a label's inbox mail", Query: dateRangeParams, Response: ArchiveResult{}},
+
+	"POST /api/v1/clusters/{id}/trash":   {Summary: "Preview trashing a subject cluster", Response: Operation{}},
+	"POST /api/v1/clusters/{id}/archive": {Summary: "Archive a subject cluster", Response: ArchiveResult{}},
+	"POST /api/v1/calendar/trash":        {Summary: "Preview trashing invites and notifications of past events", Query: []apiParam{calendarAgeParam}, Response: Operation{}},
+	"GET /api/v1/presets":                {Summary: "Built-in cleanup presets", Response: []Preset{}},
+	"POST /api/v1/presets/{id}/run":      {Summary: "Preview trashing what a preset selects", Body: presetRunRequest{}, Response: Operation{}},
+
+	"POST /api/v1/inbox/process": {Summary: "Start scanning the mailbox", Query: []apiParam{
+		{"q", "string", "Only scan messages matching this Gmail search"},
+		{"after", "string", "Only scan messages after this date (YYYY-MM-DD)"},
+		{"before", "string", "Only scan messages before this date (YYYY-MM-DD)"},
+		{"includeSpamTrash", "boolean", "Also scan Spam and Trash"},
+		{"priority", "string", "Job priority: user or background"},
+		{"rescan", "boolean", "Fetch every message again instead of reusing earlier scans"},
+		{"quotaBudget", "integer", "Pause the scan after it has used this many Gmail quota units"},
+	}, Response: scanProgress},
+	"GET /api/v1/inbox/status":      {Summary: "Progress of the scan", Response: scanProgress},
+	"GET /api/v1/inbox/top-senders": {Summary: "Senders with the most or largest mail", Query: append(senderPageParams, timeTravelParams...), Response: []apiObject{{"email": "", "count": 0, "size": int64(0), "category": "", "firstSeen": time.Time{}, "lastSeen": time.Time{}, "name": "", "photo": ""}}},
+	"GET /api/v1/inbox/senders": {Summary: "Every sender of scanned mail", Query: append([]apiParam{
+		{"sortBy", "string", "count, size, unread, firstSeen, lastSeen or email"},
+		{"order", "string", "asc or desc"},
+		{"lastSeenBefore", "string", "Only senders whose latest message is older than this date (YYYY-MM-DD)"},
+		{"firstSeenAfter", "string", "Only senders whose earliest message is newer than this date (YYYY-MM-DD)"},
+		categoryParam,
+	}, pageParams...), Response: apiObject{"senders": []SenderSummary{}, "total": 0, "offset": 0, "limit": 0}},
+	"GET /api/v1/inbox/senders/dormant": {Summary: "Senders quiet for a while, largest first", Query: append([]apiParam{
+		{"olderThanDays", "integer", "Only senders whose latest message is older than this many days (default 365)"},
+		{"sortBy", "string", "size (default), count or lastSeen"},
+		categoryParam,
+	}, pageParams...), Response: DormantSenders{}},
+	"GET /api/v1/inbox/recommendations": {Summary: "Senders worth cleaning up, best first", Query: []apiParam{
+		{"limit", "integer", "Maximum number of recommendations"},
+		{"minScore", "number", "Only recommendations scoring at least this"},
+	}, Response: apiObject{"recommendations": []Recommendation{}, "total": 0, "totalSavings": int64(0), "savings": ""}},
+	"GET /api/v1/inbox/search": {Summary: "Search scanned message metadata", Query: append([]apiParam{
+		{"sender", "string", "Sender address contains this"},
+		{"domain", "string", "Sender domain"},
+		{"subject", "string", "Subject contains this"},
+		{"label", "string", "Has this label"},
+		{"after", "string", "Sent on or after this date (YYYY-MM-DD)"},
+		{"before", "string", "Sent before this date (YYYY-MM-DD)"},
+		{"minSize", "integer", "At least this many bytes"},
+		{"maxSize", "integer", "At most this many bytes"},
+		{"sort", "string", "date, size, sender or subject"},
+		{"order", "string", "asc or desc"},
+	}, pageParams...), Response: apiObject{"emails": []EmailMetadata{}, "total": 0, "offset": 0, "limit": 0}},
+	"GET /api/v1/inbox/stats":              {Summary: "Statistics of scanned mail", Query: timeTravelParams, Response: EmailStats{}},
+	"GET /api/v1/inbox/lists":              {Summary: "Mailing lists of scanned mail", Query: []apiParam{categoryParam}, Response: []MailingList{}},
+	"GET /api/v1/inbox/labels":             {Summary: "Count and size of scanned mail by label", Response: []LabelUsage{}},
+	"GET /api/v1/inbox/calendar":           {Summary: "Calendar invites and event notifications of scanned mail", Query: []apiParam{calendarAgeParam}, Response: CalendarMail{}},
+	"GET /api/v1/inbox/clusters":           {Summary: "Groups of messages with similar subjects", Query: []apiParam{{"sender", "string", "Only clusters from this sender"}, {"minSize", "integer", "Smallest cluster to include"}}, Response: []SubjectCluster{}},
+	"POST /api/v1/inbox/size-audit":        {Summary: "Compare Gmail's size estimates of the largest messages with their raw size", Query: []apiParam{{"top", "integer", "Number of largest messages to audit"}}, Response: SizeAudit{}},
+	"GET /api/v1/inbox/export.jsonl":       {Summary: "Export scanned message metadata as JSON lines", Query: []apiParam{{"offset", "integer", "Record to resume from"}}, Produces: "application/x-ndjson"},
+	"GET /api/v1/inbox/export":             {Summary: "Export scanned message metadata as JSON lines", Query: []apiParam{{"offset", "integer", "Record to resume from"}}, Produces: "application/x-ndjson"},
+	"GET /api/v1/operations":               {Summary: "Trash operations that can still be undone", Response: []TrashRecord{}},
+	"POST /api/v1/operations/{id}/confirm": {Summary: "Run a previewed operation", Query: confirmParams, Response: jobResult},
+	"POST /api/v1/operations/{id}/undo":    {Summary: "Move an operation's messages back out of trash", Response: UntrashResult{}},
+
+	"GET /api/v1/audit": {Summary: "Log of destructive actions", Query: []apiParam{
+		{"action", "string", "Only entries of this action"},
+		{"actor", "string", "Only entries by this actor"},
+		{"since", "string", "Only entries since this time (RFC 3339)"},
+		{"until", "string", "Only entries until this time (RFC 3339)"},
+		{"format", "string", "json or csv"},
+	}, Response: []AuditEntry{}},
+
+	"POST /api/v1/imports":               {Summary: "Start a resumable mbox upload", Body: createImportRequest{}, Response: apiObject{"import": ImportSession{}, "maxChunkSize": int64(0)}},
+	"GET /api/v1/imports/{id}":           {Summary: "Upload and import progress", Response: ImportSession{}},
+	"DELETE /api/v1/imports/{id}":        {Summary: "Cancel an import", Status: http.StatusNoContent},
+	"PUT /api/v1/imports/{id}/chunks":    {Summary: "Upload the next chunk of the mbox file", Query: []apiParam{{"offset", "integer", "Byte offset of the chunk in the file"}}, BodyType: "application/octet-stream", Response: ImportSession{}},
+	"POST /api/v1/imports/{id}/complete": {Summary: "Import the uploaded file", Response: ImportSession{}},
+
+	"GET /api/v1/feedback":                 {Summary: "The user's classification corrections", Response: ClassificationFeedback{}},
+	"PUT /api/v1/feedback/senders/{email}": {Summary: "Correct the category of a sender's mail", Body: feedbackRequest{}, Response: ClassificationFeedback{}},
+	"PUT /api/v1/feedback/messages/{id}":   {Summary: "Correct the category of a message", Body: feedbackRequest{}, Response: ClassificationFeedback{}},
+
+	"GET /api/v1/protection": {Summary: "Rules protecting mail from cleanup", Response: ProtectionRules{}},
+	"PUT /api/v1/protection": {Summary: "Replace the protection rules", Body: ProtectionRules{}, Response: ProtectionRules{}},
+
+	"GET /api/v1/searches":                 {Summary: "Saved searches", Response: []SavedSearch{}},
+	"POST /api/v1/searches":                {Summary: "Save a search", Body: SavedSearch{}, Response: SavedSearch{}},
+	"DELETE /api/v1/searches/{id}":         {Summary: "Delete a saved search", Response: []SavedSearch{}},
+	"GET /api/v1/webhooks":                 {Summary: "Webhooks, without their secrets", Response: []Webhook{}},
+	"POST /api/v1/webhooks":                {Summary: "Register a webhook; the response holds its signing secret", Body: webhookRequest{}, Response: Webhook{}},
+	"DELETE /api/v1/webhooks/{id}":         {Summary: "Delete a webhook", Response: []Webhook{}},
+	"POST /api/v1/webhooks/{id}/test":      {Summary: "Send a ping event to a webhook", Response: apiObject{"delivered": false, "status": 0, "error": ""}},
+	"GET /api/v1/notifiers":                {Summary: "Slack, ntfy and Pushover notifiers, without their secrets", Response: []Notifier{}},
+	"POST /api/v1/notifiers":               {Summary: "Post summaries of background cleanups to Slack, ntfy or Pushover", Body: Notifier{}, Response: Notifier{}},
+	"DELETE /api/v1/notifiers/{id}":        {Summary: "Delete a notifier", Response: []Notifier{}},
+	"POST /api/v1/notifiers/{id}/test":     {Summary: "Send a test notification", Response: apiObject{"delivered": false, "error": ""}},
+	"GET /api/v1/searches/{id}/run":        {Summary: "Run a saved search", Query: append(pageParams, apiParam{"maxResults", "integer", "Page size of Gmail searches"}, apiParam{"pageToken", "string", "Next page of Gmail searches"}), Response: apiSchema{"description": "Results in the shape of /api/inbox/search or /api/emails"}},
+	"GET /api/v1/rules":                    {Summary: "Cleanup rules", Response: []Rule{}},
+	"POST /api/v1/rules":                   {Summary: "Add a cleanup rule", Body: Rule{}, Response: []Rule{}},
+	"GET /api/v1/rules/simulate":           {Summary: "Messages each rule would affect", Query: []apiParam{{"id", "string", "Only simulate this rule"}}, Response: []RuleSimulation{}},
+	"PUT /api/v1/rules/{id}":               {Summary: "Replace a cleanup rule", Body: Rule{}, Response: []Rule{}},
+	"DELETE /api/v1/rules/{id}":            {Summary: "Delete a cleanup rule", Response: []Rule{}},
+	"POST /api/v1/rules/{id}/run":          {Summary: "Apply a rule now; trash rules return a preview", Response: apiOneOf{Operation{}, Job{}}},
+	"GET /api/v1/retention":                {Summary: "Retention policies", Response: []RetentionPolicy{}},
+	"POST /api/v1/retention":               {Summary: "Keep a label's mail for a number of days, trashing or archiving it daily after", Body: retentionRequest{}, Response: RetentionPolicy{}},
+	"PUT /api/v1/retention/{id}":           {Summary: "Replace a retention policy", Body: retentionRequest{}, Response: RetentionPolicy{}},
+	"DELETE /api/v1/retention/{id}":        {Summary: "Delete a retention policy and its run reports", Response: []RetentionPolicy{}},
+	"POST /api/v1/retention/{id}/run":      {Summary: "Enforce a retention policy now; the job's result is the run report", Response: Job{}},
+	"GET /api/v1/retention/{id}/runs":      {Summary: "Reports of a retention policy's latest runs, newest first", Response: []RetentionRun{}},
+	"POST /api/v1/trash/empty":             {Summary: "Preview deleting everything in Trash for good", Response: Operation{}},
+	"POST /api/v1/spam/empty":              {Summary: "Preview deleting everything in Spam for good", Response: Operation{}},
+	"GET /api/v1/trash/auto-empty":         {Summary: "Automatic trash emptying settings and the latest run", Response: AutoEmptySettings{}},
+	"PUT /api/v1/trash/auto-empty":         {Summary: "Delete what this tool trashed a number of days ago for good, daily", Body: autoEmptyRequest{}, Response: AutoEmptySettings{}},
+	"POST /api/v1/trash/auto-empty/run":    {Summary: "Empty trash of old trashes now; the job's result is the run report", Response: Job{}},
+	"GET /api/v1/drafts/report":            {Summary: "Drafts, oldest first", Query: []apiParam{{"olderThanDays", "integer", "Only drafts untouched for this many days"}}, Response: DraftsReport{}},
+	"POST /api/v1/drafts/discard":          {Summary: "Preview discarding drafts", Body: discardDraftsRequest{}, Response: Operation{}},
+	"POST /api/v1/share":                   {Summary: "Create a read-only link to the dashboard", Body: shareRequest{}, Response: apiObject{"token": "", "url": "", "expiresAt": time.Time{}}},
+	"GET /api/v1/shared/{token}":           {Summary: "Dashboard behind a share link", Public: true, Response: SharedDashboard{}},
+	"GET /api/v1/jobs":                     {Summary: "The user's jobs", Query: []apiParam{{"kind", "string", "Only jobs of this kind"}, {"state", "string", "Only jobs in this state"}}, Response: []Job{}},
+	"GET /api/v1/jobs/{id}":                {Summary: "A job's state and result", Response: Job{}},
+	"GET /api/v1/backups/{id}":             {Summary: "Download a backup made before trashing", Produces: "application/octet-stream"},
+	"GET /api/v1/features":                 {Summary: "Enabled features", Public: true, Response: FeatureFlags{}},
+	"PUT /api/v1/admin/features":           {Summary: "Enable or disable features", Admin: true, Body: featureFlagsUpdate{}, Response: FeatureFlags{}},
+	"GET /api/v1/admin/usage":              {Summary: "Memory and disk held for each account", Admin: true, Response: apiObject{"accounts": []AccountUsage{}, "totals": ResourceUsage{}}},
+	"GET /api/v1/admin/metrics":            {Summary: "Daily usage of the server", Admin: true, Query: []apiParam{{"days", "integer", "Number of days of history"}}, Response: apiObject{"days": []DailyUsage{}, "current": apiObject{"accounts": 0, "cachedMessages": 0, "memoryBytes": int64(0), "diskBytes": int64(0)}}},
+	"GET /api/v1/admin/delegation":         {Summary: "Service account of domain-wide delegation mode", Admin: true, Response: Delegation{}},
+	"POST /api/v1/admin/org/scans":         {Summary: "Scan domain users, listed or by org unit", Admin: true, Body: orgScanRequest{}, Response: apiObject{"scans": []OrgScan{}}},
+	"GET /api/v1/admin/org/stats":          {Summary: "Top storage consumers and senders across the domain's scans", Admin: true, Query: append([]apiParam{{"sortBy", "string", "size (default) or count"}}, pageParams...), Response: apiObject{"users": []OrgUserStats{}, "senders": []OrgSenderStats{}, "totals": apiObject{"users": 0, "messages": 0, "size": int64(0)}}},
+	"GET /api/v1/admin/org/retention":      {Summary: "Retention rules per org unit", Admin: true, Response: retentionRules{}},
+	"PUT /api/v1/admin/org/retention":      {Summary: "Replace the retention rules", Admin: true, Body: retentionRules{}, Response: retentionRules{}},
+	"POST /api/v1/admin/org/retention/run": {Summary: "Apply the retention rules to every domain user as a job", Admin: true, Query: []apiParam{{"dryRun", "boolean", "Only count matching messages"}}, Response: Job{}},
+}
+
+// Progress of a scan, as reported by GetProgress
+var scanProgress = apiObject{
+	"totalEmails":  0,
+	"isProcessing": false,
+	"failedEmails": 0,
+	"aborted":      false,
+	"abortReason":  "",
+	"usage":        ResourceUsage{},
+	"jobId":        "",
+	"scope":        ScanScope{},
+	"resumed":      false,
+	"metadataOnly": false,
+	"paused":       false,
+	"pauseReason":  "",
+	"quota":        ScanQuota{},
+	"throttle":     ThrottleState{},
+}
+
+// RegisterDocs serves the OpenAPI document of every route registered on router so far
+// at /openapi.json of the versioned subrouter, and Swagger UI for it at /docs. Routes
+// without an entry in apiRoutes are logged, so new routes don't go undocumented
+// unnoticed.
+func RegisterDocs(router, versioned *mux.Router) {
+	var document apiSchema
+	versioned.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, document)
+	}).Methods("GET")
+	versioned.HandleFunc("/docs", HandleSwaggerUI).Methods("GET")
+
+	document = openAPIDocument(router)
+}
+
+// openAPIDocument builds the OpenAPI document of the router's routes
+func openAPIDocument(router *mux.Router) apiSchema {
+	b := &schemaBuilder{components: make(apiSchema)}
+	paths := make(apiSchema)
+
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Catch-alls such as the frontend's file server
+			return nil
+		}
+		for _, method := range methods {
+			doc, ok := apiRoutes[method+" "+template]
+			if !ok {
+				log.Printf("Route %s %s is missing from the OpenAPI document", method, template)
+			}
+			item, _ := paths[template].(apiSchema)
+			if item == nil {
+				item = make(apiSchema)
+				paths[template] = item
+			}
+			item[strings.ToLower(method)] = b.operation(template, doc)
+		}
+		return nil
+	})
+
+	b.components["Error"] = b.schema(reflect.TypeOf(map[string]APIError{}))
+	return apiSchema{
+		"openapi": "3.0.3",
+		"info": apiSchema{
+			"title":   "gmail-deepclean API",
+			"version": "1.0.0",
+			"description": "Errors are returned as {\"error\": {\"code\": ..., \"message\": ...}}. " +
+				"With domain-wide delegation configured, set the X-Mailbox header to act on a mailbox you are a delegate of instead of your own. " +
+				"With domain-wide delegation configured, admins can call any endpoint as a user of the domain " +
+				"by sending X-Admin-Token and naming the user in X-Impersonate instead of Authorization. " +
+				"POST, PUT, PATCH and DELETE requests without an Authorization or X-Admin-Token header must send the token from /auth/csrf in X-CSRF-Token. " +
+				"Endpoints that change the mailbox answer 403 insufficient_scope for sessions signed in with access=readonly or access=metadata. " +
+				"Add ?dryRun=true to any request to skip the changes it would make to the mailbox, which are logged and counted instead; dry-run responses carry X-Dry-Run: true. " +
+				"The unversioned /api paths are deprecated aliases of /api/v1.",
+		},
+		"paths": paths,
+		"components": apiSchema{
+			"schemas": b.components,
+			"securitySchemes": apiSchema{
+				"token": apiSchema{"type": "apiKey", "in": "header", "name": "Authorization",
+					"description": "\"Bearer \" and the session token handed out by /auth/gmail/callback, or an OAuth token as JSON"},
+				"admin": apiSchema{"type": "apiKey", "in": "header", "name": "X-Admin-Token"},
+				"impersonate": apiSchema{"type": "apiKey", "in": "header", "name": impersonateHeader,
+					"description": "Workspace user to act as through domain-wide delegation, together with X-Admin-Token"},
+			},
+		},
+	}
+}
+
+// operation builds the OpenAPI operation of one route
+func (b *schemaBuilder) operation(template string, doc apiRoute) apiSchema {
+	op := apiSchema{"summary": doc.Summary, "tags": []string{routeTag(template)}}
+
+	params := make([]apiSchema, 0)
+	for _, segment := range strings.Split(template, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, apiSchema{"name": strings.Trim(segment, "{}"), "in": "path", "required": true, "schema": apiSchema{"type": "string"}})
+		}
+	}
+	for _, param := range doc.Query {
+		params = append(params, apiSchema{"name": param.Name, "in": "query", "description": param.Description, "schema": apiSchema{"type": param.Type}})
+	}
+	if !doc.Public && !doc.Admin {
+		params = append(params, apiSchema{"name": mailboxHeader, "in": "header", "description": "Mailbox you are a delegate of to act on; needs domain-wide delegation", "schema": apiSchema{"type": "string", "format": "email"}})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	switch {
+	case doc.Body != nil:
+		op["requestBody"] = apiSchema{"content": apiSchema{"application/json": apiSchema{"schema": b.value(doc.Body)}}}
+	case doc.BodyType != "":
+		op["requestBody"] = apiSchema{"content": apiSchema{doc.BodyType: apiSchema{"schema": apiSchema{"type": "string", "format": "binary"}}}}
+	}
+
+	status := doc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := apiSchema{"description": http.StatusText(status)}
+	switch {
+	case doc.Produces != "":
+		success["content"] = apiSchema{doc.Produces: apiSchema{"schema": apiSchema{"type": "string", "format": "binary"}}}
+	case doc.Response != nil:
+		success["content"] = apiSchema{"application/json": apiSchema{"schema": b.value(doc.Response)}}
+	}
+	op["responses"] = apiSchema{
+		fmt.Sprint(status): success,
+		"default": apiSchema{
+			"description": "Error",
+			"content":     apiSchema{"application/json": apiSchema{"schema": apiSchema{"$ref": "#/components/schemas/Error"}}},
+		},
+	}
+
+	switch {
+	case doc.Admin:
+		op["security"] = []apiSchema{{"admin": []string{}}}
+	case !doc.Public:
+		op["security"] = []apiSchema{{"token": []string{}}, {"admin": []string{}, "impersonate": []string{}}}
+	}
+	return op
+}
+
+// routeTag groups a route by the first segment after /api/vN, e.g. "inbox"
+func routeTag(template string) string {
+	segments := strings.Split(versionedPath.ReplaceAllString(template, "/"), "/")
+	if len(segments) < 2 || segments[1] == "" {
+		return "api"
+	}
+	return strings.TrimSuffix(segments[1], ".json")
+}
+
+// schemaBuilder derives JSON schemas from Go types, collecting named types as components
+type schemaBuilder struct {
+	components apiSchema
+}
+
+// value returns the schema of an apiSchema, apiObject or Go value
+func (b *schemaBuilder) value(v interface{}) apiSchema {
+	switch v := v.(type) {
+	case apiSchema:
+		return v
+	case apiObject:
+		properties := make(apiSchema, len(v))
+		for name, value := range v {
+			properties[name] = b.value(value)
+		}
+		return apiSchema{"type": "object", "properties": properties}
+	case []apiObject:
+		return apiSchema{"type": "array", "items": b.value(v[0])}
+	case apiOneOf:
+		schemas := make([]apiSchema, len(v))
+		for i, value := range v {
+			schemas[i] = b.value(value)
+		}
+		return apiSchema{"oneOf": schemas}
+	}
+	return b.schema(reflect.TypeOf(v))
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schema returns the schema of values of t as encoding/json encodes them
+func (b *schemaBuilder) schema(t reflect.Type) apiSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return apiSchema{"type": "string", "format": "date-time"}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return apiSchema{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return apiSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return apiSchema{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return apiSchema{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return apiSchema{"type": "number"}
+	case reflect.String:
+		return apiSchema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return apiSchema{"type": "string", "format": "byte"}
+		}
+		return apiSchema{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return apiSchema{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := componentName(t)
+		if _, ok := b.components[name]; !ok {
+			// Registered before its fields so recursive types terminate
+			b.components[name] = apiSchema{}
+			b.components[name] = b.structSchema(t)
+		}
+		return apiSchema{"$ref": "#/components/schemas/" + name}
+	}
+	// Interfaces hold any JSON value
+	return apiSchema{}
+}
+
+// structSchema returns the schema of a struct's exported, JSON-encoded fields
+func (b *schemaBuilder) structSchema(t reflect.Type) apiSchema {
+	properties := make(apiSchema)
+	b.addFields(t, properties)
+	return apiSchema{"type": "object", "properties": properties}
+}
+
+// addFields adds the properties of t's fields, including those of embedded structs
+func (b *schemaBuilder) addFields(t reflect.Type, properties apiSchema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			b.addFields(fieldType, properties)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := b.schema(field.Type)
+		if strings.Contains(options, "string") {
+			schema = apiSchema{"type": "string"}
+		}
+		properties[name] = schema
+	}
+}
+
+// componentName names a type's schema, qualifying types from other packages such as
+// Gmail's with their package, e.g. GmailMessage
+func componentName(t reflect.Type) string {
+	pkgPath := t.PkgPath()
+	if pkgPath == "github.com/dustinmichels/gmail-deepclean/api" {
+		// Request types are unexported, but their schemas are part of the API
+		return strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	}
+	// Versioned packages like google.golang.org/api/gmail/v1 are named by their parent
+	pkg := path.Base(pkgPath)
+	if len(pkg) > 1 && pkg[0] == 'v' && strings.Trim(pkg[1:], "0123456789") == "" {
+		pkg = path.Base(path.Dir(pkgPath))
+	}
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + t.Name()
+}
+
+// HandleSwaggerUI serves Swagger UI for the OpenAPI document
+func HandleSwaggerUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(swaggerUIPage))
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+    <title>gmail-deepclean API</title>
+    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+    <script>
+        window.ui = SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+    </script>
+</body>
+</html>`
@@ -0,0 +1,351 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// How long a previewed operation waits for confirmation
+	operationTTL = 10 * time.Minute
+	// Number of affected messages described in a preview
+	operationSampleSize = 10
+	// Most messages a preview fetches to learn sizes the last scan doesn't know
+	maxPreviewSizeLookups = 500
+)
+
+// operationFunc executes a confirmed operation against the given messages as job
+type operationFunc func(mb *mailbox, job *Job, ids []string) (interface{}, error)
+
+// OperationSummary describes the messages a destructive operation would affect
+type OperationSummary struct {
+	Count     int   `json:"count"`
+	TotalSize int64 `json:"totalSize"`
+	// Number of messages TotalSize accounts for. Sizes come from the last inbox scan,
+	// and only a limited number of other messages are looked up.
+	SizedCount int `json:"sizedCount"`
+	// TotalSize scaled up to every message, from the average of those sized
+	EstimatedSize int64 `json:"estimatedSize"`
+	// Count and storage saved for display, such as "1,204 messages, about 1.2 GB"
+	Savings string          `json:"savings"`
+	Sample  []MessageSample `json:"sample"`
+	// Account storage before and after, when the server can read the storage quota
+	Storage *StorageProjection `json:"storage,omitempty"`
+}
+
+// MessageSample is a short description of one message affected by an operation
+type MessageSample struct {
+	ID      string `json:"id"`
+	From    string `json:"from"`
+	Subject string `json:"subject"`
+	Date    string `json:"date"`
+	Size    int64  `json:"size"`
+}
+
+// Operation is a previewed destructive operation. Bulk destructive endpoints only
+// return a preview; nothing happens until it is confirmed with HandleConfirmOperation.
+type Operation struct {
+	ID        string           `json:"id"`
+	Kind      string           `json:"kind"`
+	Target    string           `json:"target,omitempty"`
+	Summary   OperationSummary `json:"summary"`
+	ExpiresAt time.Time        `json:"expiresAt"`
+
+	account string
+	ids     []string
+	execute operationFunc
+}
+
+var (
+	pendingOperations   = make(map[string]*Operation)
+	pendingOperationsMu sync.Mutex
+)
+
+// Errors of confirming an operation that can't be confirmed
+var (
+	errOperationNotFound = errors.New("operation not found")
+	errOperationExpired  = errors.New("operation preview expired, request a new one")
+)
+
+// handleOperationPreview previews an operation with previewOperation and writes the
+// preview
+func handleOperationPreview(w http.ResponseWriter, r *http.Request, mb *mailbox, kind, target string, resolve func() ([]string, error), execute operationFunc) {
+	op, err := previewOperation(r.Context(), mb, kind, target, resolve, execute)
+	if err != nil {
+		writeErrorFrom(w, "Failed to preview operation", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, op)
+}
+
+// previewOperation resolves the messages an operation would affect as an interactive
+// job, then registers the operation. Confirming it runs execute on exactly the
+// previewed messages. target describes what was selected, such as the search query,
+// for the audit log.
+func previewOperation(ctx context.Context, mb *mailbox, kind, target string, resolve func() ([]string, error), execute operationFunc) (*Operation, error) {
+	if !canModify(mb.token) {
+		return nil, errReadonlyAccess
+	}
+	account, err := mb.account()
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := Jobs.Run(ctx, kind+"-preview", mb.userID, PriorityInteractive, func(job *Job) (interface{}, error) {
+		ids, err := resolve()
+		if err != nil {
+			return nil, err
+		}
+		summary, err := summarizeMessages(mb, ids)
+		if err != nil {
+			return nil, err
+		}
+		summary.Storage = projectStorage(mb, summary.EstimatedSize)
+
+		op := &Operation{
+			ID:        newID(),
+			Kind:      kind,
+			Target:    target,
+			Summary:   *summary,
+			ExpiresAt: time.Now().Add(operationTTL),
+			account:   account,
+			ids:       ids,
+			execute:   execute,
+		}
+		pendingOperationsMu.Lock()
+		pruneExpiredOperations()
+		pendingOperations[op.ID] = op
+		pendingOperationsMu.Unlock()
+		return op, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return op.(*Operation), nil
+}
+
+// HandleConfirmOperation executes a previewed operation and writes its result, or with
+// `async=true` the job running it. `verify=true` rescans the affected messages and lists
+// those not as reported, `report=true` emails a summary to the mailbox, and
+// `notify=true` posts one to the user's notifiers, which async runs always do.
+// Operations are single use and expire operationTTL after the preview. What they trash
+// can be restored with HandleUndoOperation.
+func HandleConfirmOperation(w http.ResponseWriter, r *http.Request) {
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	op, err := takeOperation(account, mux.Vars(r)["id"])
+	if errors.Is(err, errOperationNotFound) {
+		writeError(w, "Operation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, "Operation preview expired, request a new one", http.StatusConflict)
+		return
+	}
+
+	query := r.URL.Query()
+	async := query.Get("async") == "true"
+	run := op.run(mb, account, query.Get("verify") == "true", query.Get("report") == "true", async || query.Get("notify") == "true")
+
+	// Long operations can run in the background and be followed through the job status
+	if async {
+		job := Jobs.Enqueue(op.Kind, mb.userID, PriorityUser, run)
+		snapshot, _ := Jobs.Get(job.ID)
+		writeJSON(w, snapshot)
+		return
+	}
+
+	result, err := Jobs.Run(r.Context(), op.Kind, mb.userID, PriorityUser, run)
+	if err != nil {
+		writeErrorFrom(w, "Operation failed", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, result)
+}
+
+// takeOperation removes a pending operation of account so it can be executed, failing
+// with errOperationNotFound or errOperationExpired
+func takeOperation(account, id string) (*Operation, error) {
+	pendingOperationsMu.Lock()
+	op, ok := pendingOperations[id]
+	if ok && op.account == account {
+		delete(pendingOperations, id)
+	}
+	pendingOperationsMu.Unlock()
+
+	if !ok || op.account != account {
+		return nil, errOperationNotFound
+	}
+	if time.Now().After(op.ExpiresAt) {
+		return nil, errOperationExpired
+	}
+	return op, nil
+}
+
+// pendingOperationKind returns the kind of a pending operation of account, without
+// taking it
+func pendingOperationKind(account, id string) (string, bool) {
+	pendingOperationsMu.Lock()
+	defer pendingOperationsMu.Unlock()
+	op, ok := pendingOperations[id]
+	if !ok || op.account != account {
+		return "", false
+	}
+	return op.Kind, true
+}
+
+// run returns the job executing the operation on the mailbox, which records what it
+// trashed for undo and audits it, verifying, reporting and notifying of the result if
+// asked to
+func (op *Operation) run(mb *mailbox, account string, verify, report, notifyResult bool) func(job *Job) (interface{}, error) {
+	return func(job *Job) (interface{}, error) {
+		mb := mb.forJob(job)
+		result, err := op.execute(mb, job, op.ids)
+		// Nothing was changed by a dry run, so there is nothing to verify
+		if err == nil && verify && !mb.dryRun() {
+			verifyOperation(mb, op.Kind, result, op.ids)
+		}
+		affected := affectedIDs(result, op.ids)
+		if _, trashed := result.(trashReporter); trashed {
+			recordTrashAudit(mb, op.ID, op.Kind, op.Target, affected, err)
+		} else {
+			recordAudit(mb, op.Kind, op.Target, affected, err)
+		}
+		if report {
+			sendCleanupReport(mb, op, result, affected, err)
+		}
+		if notifyResult {
+			notify(account, cleanupNotification(op, affected, err))
+		}
+		return result, err
+	}
+}
+
+// summarizeMessages counts and sizes the given messages and describes a sample of them
+func summarizeMessages(mb *mailbox, ids []string) (*OperationSummary, error) {
+	summary := &OperationSummary{Count: len(ids)}
+
+	samples, err := sampleMessages(mb, ids, operationSampleSize)
+	if err != nil {
+		return nil, err
+	}
+	summary.Sample = samples
+
+	sizes := make(map[string]int64, len(ids))
+	if processor, exists := Registry.Get(mb.userID); exists {
+		sizes = processor.SizesOf(ids)
+	}
+	for _, sample := range samples {
+		sizes[sample.ID] = sample.Size
+	}
+
+	lookups := 0
+	for _, id := range ids {
+		size, ok := sizes[id]
+		if !ok {
+			if lookups == maxPreviewSizeLookups {
+				continue
+			}
+			lookups++
+			if err := mb.quota.Wait(context.Background(), costMessagesGet); err != nil {
+				return nil, err
+			}
+			msg, err := mb.provider.GetMessage(mb.context(), mb.user, id, "minimal")
+			if err != nil {
+				return nil, err
+			}
+			size = msg.SizeEstimate
+		}
+		summary.TotalSize += size
+		summary.SizedCount++
+	}
+
+	summary.EstimatedSize = summary.TotalSize
+	if summary.SizedCount > 0 && summary.SizedCount < summary.Count {
+		summary.EstimatedSize = summary.TotalSize * int64(summary.Count) / int64(summary.SizedCount)
+	}
+	summary.Savings = describeSavings(summary.Count, summary.EstimatedSize, summary.SizedCount < summary.Count)
+	return summary, nil
+}
+
+// describeSavings formats a message count and the storage removing them would free.
+// estimated marks sizes extrapolated from only some of the messages.
+func describeSavings(count int, size int64, estimated bool) string {
+	if count == 0 {
+		return "0 messages"
+	}
+	noun := "messages"
+	if count == 1 {
+		noun = "message"
+	}
+	if estimated {
+		return fmt.Sprintf("%s %s, about %s", formatCount(count), noun, FormatBytes(size))
+	}
+	return fmt.Sprintf("%s %s, %s", formatCount(count), noun, FormatBytes(size))
+}
+
+// formatCount formats a count with thousands separators
+func formatCount(n int) string {
+	s := fmt.Sprint(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// sampleMessages fetches the headers of up to n of the given messages
+func sampleMessages(mb *mailbox, ids []string, n int) ([]MessageSample, error) {
+	samples := make([]MessageSample, 0, n)
+	for _, id := range ids[:min(n, len(ids))] {
+		if err := mb.quota.Wait(context.Background(), costMessagesGet); err != nil {
+			return samples, err
+		}
+
+		msg, err := mb.provider.GetMessage(mb.context(), mb.user, id, "metadata", "From", "Subject", "Date")
+		if err != nil {
+			return samples, err
+		}
+
+		sample := MessageSample{ID: id, Size: msg.SizeEstimate}
+		for _, header := range msg.Payload.Headers {
+			switch header.Name {
+			case "From":
+				sample.From = deepclean.ExtractEmailAddress(header.Value)
+			case "Subject":
+				sample.Subject = header.Value
+			case "Date":
+				sample.Date = header.Value
+			}
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// pruneExpiredOperations drops previews nobody confirmed. Callers must hold
+// pendingOperationsMu.
+func pruneExpiredOperations() {
+	now := time.Now()
+	for id, op := range pendingOperations {
+		if now.After(op.ExpiresAt) {
+			delete(pendingOperations, id)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dustinmichels/gmail-deepclean/api/gmailtest"
+)
+
+func TestTrashSenderPreviewAndConfirm(t *testing.T) {
+	messages := senderMessages("deal", "deals@shop.example", 4, 1)
+	messages = append(messages, gmailtest.Message{
+		ID: "friend", From: "Alex <alex@friends.example>", Subject: "Hi", Date: gmailtest.FixtureTime, LabelIDs: []string{"INBOX"},
+	})
+	user := newTestUser(t, messages...)
+
+	r := user.request(t, http.MethodPost, "/api/v1/senders/deals@shop.example/trash", nil, map[string]string{"email": "deals@shop.example"})
+	var op Operation
+	decodeResponse(t, serve(HandleTrashSender, r), http.StatusOK, &op)
+	if op.ID == "" || op.Kind != "sender-trash" {
+		t.Fatalf("preview = %+v, want a sender-trash operation", op)
+	}
+	if op.Summary.Count != 4 {
+		t.Errorf("preview count = %d, want 4", op.Summary.Count)
+	}
+	for _, id := range []string{"deala", "dealb", "dealc", "deald", "friend"} {
+		if messageHasLabel(t, user.server, id, "TRASH") {
+			t.Fatalf("message %s trashed by the preview", id)
+		}
+	}
+
+	r = user.request(t, http.MethodPost, "/api/v1/operations/"+op.ID+"/confirm", nil, map[string]string{"id": op.ID})
+	var result TrashResult
+	decodeResponse(t, serve(HandleConfirmOperation, r), http.StatusOK, &result)
+	if len(result.Trashed) != 3 || len(result.Protected) != 1 || result.Protected[0] != "deala" {
+		t.Errorf("result trashed %v and protected %v, want 3 trashed and deala protected", result.Trashed, result.Protected)
+	}
+	for id, want := range map[string]bool{"deala": false, "dealb": true, "dealc": true, "deald": true, "friend": false} {
+		if got := messageHasLabel(t, user.server, id, "TRASH"); got != want {
+			t.Errorf("message %s in trash = %v, want %v", id, got, want)
+		}
+	}
+
+	// Operations are single use
+	r = user.request(t, http.MethodPost, "/api/v1/operations/"+op.ID+"/confirm", nil, map[string]string{"id": op.ID})
+	decodeResponse(t, serve(HandleConfirmOperation, r), http.StatusNotFound, nil)
+}
+
+func TestConfirmOperationOfOtherAccount(t *testing.T) {
+	owner := newTestUser(t, senderMessages("deal", "deals@shop.example", 2, 0)...)
+	other := newTestUser(t)
+
+	r := owner.request(t, http.MethodPost, "/api/v1/senders/deals@shop.example/trash", nil, map[string]string{"email": "deals@shop.example"})
+	var op Operation
+	decodeResponse(t, serve(HandleTrashSender, r), http.StatusOK, &op)
+
+	r = other.request(t, http.MethodPost, "/api/v1/operations/"+op.ID+"/confirm", nil, map[string]string{"id": op.ID})
+	decodeResponse(t, serve(HandleConfirmOperation, r), http.StatusNotFound, nil)
+	if messageHasLabel(t, owner.server, "deala", "TRASH") {
+		t.Error("another account's confirmation trashed the owner's mail")
+	}
+
+	// The failed attempt leaves the operation for its owner
+	r = owner.request(t, http.MethodPost, "/api/v1/operations/"+op.ID+"/confirm", nil, map[string]string{"id": op.ID})
+	decodeResponse(t, serve(HandleConfirmOperation, r), http.StatusOK, nil)
+	if !messageHasLabel(t, owner.server, "deala", "TRASH") {
+		t.Error("owner's confirmation didn't trash the mail")
+	}
+}
@@ -0,0 +1,436 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/option"
+)
+
+// Storage key of the organization's retention rules
+const retentionRulesKey = "org/retention"
+
+// domainUser is an active user of the Workspace domain
+type domainUser struct {
+	Email       string
+	OrgUnitPath string
+}
+
+// domainUsers returns the active users in an org unit and the units below it, the whole
+// domain for "/" or "", looked up in the directory as the configured Workspace admin
+func domainUsers(ctx context.Context, orgUnitPath string) ([]domainUser, error) {
+	if config.WorkspaceAdmin == "" {
+		return nil, fmt.Errorf("looking up the domain's users needs WORKSPACE_ADMIN")
+	}
+	token, err := DelegatedToken(config.WorkspaceAdmin)
+	if err != nil {
+		return nil, err
+	}
+	service, err := admin.NewService(ctx, option.WithHTTPClient(googleClient(ctx, token)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create directory service: %w", err)
+	}
+
+	call := service.Users.List().Customer("my_customer").MaxResults(500).
+		Fields("nextPageToken", "users(primaryEmail,orgUnitPath,suspended)")
+	if orgUnitPath != "" && orgUnitPath != "/" {
+		call = call.Query("orgUnitPath='" + orgUnitPath + "'")
+	}
+
+	users := make([]domainUser, 0)
+	err = call.Pages(ctx, func(page *admin.Users) error {
+		for _, user := range page.Users {
+			if !user.Suspended {
+				users = append(users, domainUser{Email: strings.ToLower(user.PrimaryEmail), OrgUnitPath: user.OrgUnitPath})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domain users: %w", err)
+	}
+	return users, nil
+}
+
+// validOrgUnitPath checks an org unit path, which is quoted in directory queries
+func validOrgUnitPath(path string) error {
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, `'"\`) {
+		return fmt.Errorf("invalid org unit path %q, expected e.g. /Sales", path)
+	}
+	return nil
+}
+
+// orgScanRequest is the body of HandleStartOrgScans: either users or an org unit
+type orgScanRequest struct {
+	Users []string `json:"users,omitempty"`
+	// Scan every active user in this org unit and those below it
+	OrgUnitPath string `json:"orgUnitPath,omitempty"`
+	// Repeat finished scans instead of returning them
+	Rescan bool `json:"rescan,omitempty"`
+}
+
+// OrgScan is the scan of one domain user, or why it couldn't be started
+type OrgScan struct {
+	User     string                 `json:"user"`
+	Progress map[string]interface{} `json:"progress,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+// HandleStartOrgScans queues background scans of a list of domain users, or of every user
+// in an org unit, through domain-wide delegation. Users already being scanned are
+// reported as they are.
+func HandleStartOrgScans(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req orgScanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+
+	users := req.Users
+	switch {
+	case len(users) > 0 && req.OrgUnitPath != "":
+		writeError(w, "Give either users or orgUnitPath, not both", http.StatusBadRequest)
+		return
+	case req.OrgUnitPath != "":
+		if err := validOrgUnitPath(req.OrgUnitPath); err != nil {
+			writeErrorFrom(w, "", err, http.StatusBadRequest)
+			return
+		}
+		members, err := domainUsers(r.Context(), req.OrgUnitPath)
+		if err != nil {
+			writeErrorFrom(w, "", err, http.StatusBadGateway)
+			return
+		}
+		for _, member := range members {
+			users = append(users, member.Email)
+		}
+	case len(users) == 0:
+		writeError(w, "Give the users to scan or an orgUnitPath", http.StatusBadRequest)
+		return
+	}
+
+	// Each user's scan is its own background job, so they queue behind interactive work
+	scans := make([]OrgScan, 0, len(users))
+	for _, user := range users {
+		scan := OrgScan{User: user}
+		token, err := DelegatedToken(user)
+		if err == nil {
+			var processor *InboxProcessor
+			processor, err = startScan(token, "me", userIDFromToken(token), ScanScope{}, req.Rescan, PriorityBackground, 0)
+			if err == nil {
+				scan.Progress = processor.GetProgress()
+			}
+		}
+		if err != nil {
+			scan.Error = redactError(err)
+		}
+		scans = append(scans, scan)
+	}
+
+	writeJSON(w, map[string]interface{}{"scans": scans})
+}
+
+// OrgUserStats is one scanned domain user's share of the organization's mail
+type OrgUserStats struct {
+	User         string `json:"user"`
+	Messages     int    `json:"messages"`
+	Size         int64  `json:"size"`
+	IsProcessing bool   `json:"isProcessing"`
+}
+
+// OrgSenderStats is a sender's mail across every scanned domain user
+type OrgSenderStats struct {
+	Email string `json:"email"`
+	Count int    `json:"count"`
+	Size  int64  `json:"size"`
+	// Number of users who received mail from the sender
+	Users int `json:"users"`
+}
+
+// HandleGetOrgStats aggregates the scans of domain users: the users whose mail takes up
+// the most storage, and the senders with the most mail across the organization. The
+// `offset`, `limit` and `sortBy` (size or count) parameters page both rankings.
+func HandleGetOrgStats(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	offset, limit, sortBy, err := parseSenderPage(r, sendersBySize, sendersByCount)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+
+	users := make([]OrgUserStats, 0)
+	senders := make(map[string]*OrgSenderStats)
+	var totalMessages int
+	var totalSize int64
+	for userID, processor := range Registry.All() {
+		user, ok := strings.CutPrefix(userID, delegatedUserPrefix)
+		if !ok {
+			continue
+		}
+		stats := OrgUserStats{User: user, IsProcessing: processor.GetProgress()["isProcessing"].(bool)}
+
+		processor.stats.RLock()
+		stats.Messages = processor.stats.TotalEmails
+		for email, count := range processor.stats.FromCount {
+			size := processor.stats.FromSize[email]
+			stats.Size += size
+
+			sender, ok := senders[email]
+			if !ok {
+				sender = &OrgSenderStats{Email: email}
+				senders[email] = sender
+			}
+			sender.Count += count
+			sender.Size += size
+			sender.Users++
+		}
+		processor.stats.RUnlock()
+
+		totalMessages += stats.Messages
+		totalSize += stats.Size
+		users = append(users, stats)
+	}
+
+	// Largest first by the chosen measure, ties by address so pages are stable
+	bySize := sortBy == sendersBySize
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if bySize && a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		if !bySize && a.Messages != b.Messages {
+			return a.Messages > b.Messages
+		}
+		return a.User < b.User
+	})
+	topSenders := make([]OrgSenderStats, 0, len(senders))
+	for _, sender := range senders {
+		topSenders = append(topSenders, *sender)
+	}
+	sort.Slice(topSenders, func(i, j int) bool {
+		a, b := topSenders[i], topSenders[j]
+		if bySize && a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		if !bySize && a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Email < b.Email
+	})
+
+	writeJSON(w, map[string]interface{}{
+		"users":   page(users, offset, limit),
+		"senders": page(topSenders, offset, limit),
+		"totals": map[string]interface{}{
+			"users":    len(users),
+			"messages": totalMessages,
+			"size":     totalSize,
+		},
+	})
+}
+
+// page returns up to limit items starting at offset
+func page[T any](items []T, offset, limit int) []T {
+	start := min(offset, len(items))
+	return items[start:min(start+limit, len(items))]
+}
+
+// RetentionRule trashes the mail of an org unit's users once it is older than a number
+// of days. A rule covers the units below its own unless they have a rule of their own.
+type RetentionRule struct {
+	OrgUnitPath   string `json:"orgUnitPath"`
+	OlderThanDays int    `json:"olderThanDays"`
+	// Gmail search narrowing the messages the rule trashes, e.g. "-is:starred"
+	Query string `json:"query,omitempty"`
+}
+
+// retentionRules is the body of the retention rule endpoints
+type retentionRules struct {
+	Rules []RetentionRule `json:"rules"`
+}
+
+// query returns the Gmail search of the messages the rule trashes
+func (rule *RetentionRule) query() string {
+	query := fmt.Sprintf("older_than:%dd", rule.OlderThanDays)
+	if rule.Query != "" {
+		query += " (" + rule.Query + ")"
+	}
+	return query
+}
+
+// covers reports whether the rule's org unit is orgUnitPath or one of its parents
+func (rule *RetentionRule) covers(orgUnitPath string) bool {
+	return rule.OrgUnitPath == "/" || orgUnitPath == rule.OrgUnitPath || strings.HasPrefix(orgUnitPath, rule.OrgUnitPath+"/")
+}
+
+// retentionRuleFor returns the rule of the closest org unit containing orgUnitPath, or
+// nil if none does
+func retentionRuleFor(rules []RetentionRule, orgUnitPath string) *RetentionRule {
+	var closest *RetentionRule
+	for i := range rules {
+		if rules[i].covers(orgUnitPath) && (closest == nil || len(rules[i].OrgUnitPath) > len(closest.OrgUnitPath)) {
+			closest = &rules[i]
+		}
+	}
+	return closest
+}
+
+// loadRetentionRules returns the organization's retention rules
+func loadRetentionRules() ([]RetentionRule, error) {
+	var saved retentionRules
+	if _, err := Storage.Get(retentionRulesKey, &saved); err != nil {
+		return nil, fmt.Errorf("failed to load retention rules: %w", err)
+	}
+	if saved.Rules == nil {
+		saved.Rules = []RetentionRule{}
+	}
+	return saved.Rules, nil
+}
+
+// HandleGetRetentionRules returns the organization's retention rules
+func HandleGetRetentionRules(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	rules, err := loadRetentionRules()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, retentionRules{Rules: rules})
+}
+
+// HandleUpdateRetentionRules replaces the organization's retention rules, at most one
+// per org unit
+func HandleUpdateRetentionRules(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req retentionRules
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if req.Rules == nil {
+		req.Rules = []RetentionRule{}
+	}
+
+	seen := make(map[string]bool)
+	for i := range req.Rules {
+		rule := &req.Rules[i]
+		rule.OrgUnitPath = strings.TrimSpace(rule.OrgUnitPath)
+		rule.Query = strings.TrimSpace(rule.Query)
+		if err := validOrgUnitPath(rule.OrgUnitPath); err != nil {
+			writeErrorFrom(w, "", err, http.StatusBadRequest)
+			return
+		}
+		if rule.OlderThanDays < 1 {
+			writeError(w, "olderThanDays must be at least 1", http.StatusBadRequest)
+			return
+		}
+		if seen[rule.OrgUnitPath] {
+			writeError(w, "More than one rule for "+rule.OrgUnitPath, http.StatusBadRequest)
+			return
+		}
+		seen[rule.OrgUnitPath] = true
+	}
+
+	if err := Storage.Put(retentionRulesKey, &req); err != nil {
+		writeErrorFrom(w, "Failed to save retention rules", err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, req)
+}
+
+// RetentionResult is what the retention rules did to one domain user's mailbox
+type RetentionResult struct {
+	User        string `json:"user"`
+	OrgUnitPath string `json:"orgUnitPath"`
+	Query       string `json:"query"`
+	Matched     int    `json:"matched"`
+	// Left out of a dry run
+	Trash *TrashResult `json:"trash,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// HandleRunRetentionRules queues a background job applying the retention rules to every
+// active user of the domain and returns it. Protection rules are honored, and each
+// user's trashed messages go on their undo stack. With `dryRun=true` matching messages
+// are only counted.
+func HandleRunRetentionRules(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if config.WorkspaceAdmin == "" {
+		writeError(w, "Retention rules need WORKSPACE_ADMIN to look up the domain's users", http.StatusConflict)
+		return
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	job := Jobs.Enqueue("org-retention", "org", PriorityBackground, func(job *Job) (interface{}, error) {
+		rules, err := loadRetentionRules()
+		if err != nil {
+			return nil, err
+		}
+		users, err := domainUsers(job.Context(), "/")
+		if err != nil {
+			return nil, err
+		}
+
+		results := make([]RetentionResult, 0)
+		for _, user := range users {
+			rule := retentionRuleFor(rules, user.OrgUnitPath)
+			if rule == nil {
+				continue
+			}
+			result := RetentionResult{User: user.Email, OrgUnitPath: user.OrgUnitPath, Query: rule.query()}
+			if err := applyRetention(job, user.Email, &result, dryRun); err != nil {
+				result.Error = redactError(err)
+			}
+			results = append(results, result)
+		}
+		return map[string]interface{}{"dryRun": dryRun, "results": results}, nil
+	})
+	snapshot, _ := Jobs.Get(job.ID)
+	writeJSON(w, snapshot)
+}
+
+// applyRetention trashes the messages of a user matching result's query, unless dryRun
+func applyRetention(job *Job, user string, result *RetentionResult, dryRun bool) error {
+	token, err := DelegatedToken(user)
+	if err != nil {
+		return err
+	}
+	mb, err := newMailbox(token, "me")
+	if err != nil {
+		return err
+	}
+	mb = mb.forJob(job)
+
+	ids, err := listMessageIDs(mb, result.Query)
+	if err != nil {
+		return err
+	}
+	result.Matched = len(ids)
+	if dryRun || len(ids) == 0 {
+		return nil
+	}
+
+	// Without the account what is trashed couldn't be undone, so don't start
+	if _, err := mb.account(); err != nil {
+		return err
+	}
+	result.Trash = trashMessages(mb, ids)
+	recordTrashAudit(mb, newID(), "retention", result.OrgUnitPath+": "+result.Query, affectedIDs(result.Trash, ids), nil)
+	return nil
+}
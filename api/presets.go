@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Preset is a ready-made cleanup: a selection of messages to trash, tuned by a few
+// parameters instead of a search query
+type Preset struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Params      []PresetParam `json:"params"`
+	// Gmail search query the preset runs with its default parameters; empty for presets
+	// that work from the last scan
+	Query string `json:"query,omitempty"`
+	// Whether the preset selects from the mail collected by the last scan, which must
+	// have run first
+	NeedsScan bool `json:"needsScan"`
+}
+
+// PresetParam is a whole-number parameter of a preset
+type PresetParam struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     int    `json:"default"`
+	Min         int    `json:"min"`
+}
+
+// cleanupPreset is a built-in preset and how it selects messages
+type cleanupPreset struct {
+	Preset
+	// query builds the Gmail search the preset trashes the results of
+	query func(params map[string]int) string
+	// resolve selects the messages of presets that work from the last scan instead
+	resolve func(processor *InboxProcessor, params map[string]int) []string
+}
+
+// presetAgeParam is the age parameter of presets selecting old mail
+func presetAgeParam(def int) PresetParam {
+	return PresetParam{"olderThanDays", "Only mail older than this many days", def, 1}
+}
+
+// cleanupPresets are the built-in presets, in the order they are offered
+var cleanupPresets = []cleanupPreset{
+	{
+		Preset: Preset{
+			ID:          "old-promotions",
+			Name:        "Promotions older than a year",
+			Description: "Deals, offers and marketing mail Gmail filed under Promotions",
+			Params:      []PresetParam{presetAgeParam(365)},
+		},
+		query: func(params map[string]int) string {
+			return fmt.Sprintf("category:promotions older_than:%dd", params["olderThanDays"])
+		},
+	},
+	{
+		Preset: Preset{
+			ID:          "social-notifications",
+			Name:        "Social notifications",
+			Description: "Likes, follows and comments from social networks, filed under Social",
+			Params:      []PresetParam{presetAgeParam(30)},
+		},
+		query: func(params map[string]int) string {
+			return fmt.Sprintf("category:social older_than:%dd", params["olderThanDays"])
+		},
+	},
+	{
+		Preset: Preset{
+			ID:          "unopened-no-reply",
+			Name:        "No-reply senders never opened",
+			Description: "Unread mail from addresses that don't take replies, which nobody missed",
+			Params:      []PresetParam{presetAgeParam(90)},
+		},
+		query: func(params map[string]int) string {
+			return fmt.Sprintf("from:(noreply OR no-reply OR donotreply OR do-not-reply) is:unread older_than:%dd", params["olderThanDays"])
+		},
+	},
+	{
+		Preset: Preset{
+			ID:          "large-old-mail",
+			Name:        "Mail over 10 MB older than 2 years",
+			Description: "Large messages, mostly attachments, that take up the most storage",
+			Params: []PresetParam{
+				{"largerThanMB", "Only messages larger than this many megabytes", 10, 1},
+				presetAgeParam(730),
+			},
+		},
+		query: func(params map[string]int) string {
+			return fmt.Sprintf("larger:%dM older_than:%dd", params["largerThanMB"], params["olderThanDays"])
+		},
+	},
+	{
+		Preset: Preset{
+			ID:          "past-calendar-events",
+			Name:        "Invites and notifications of past events",
+			Description: "Calendar invites and event reminders for events that are over",
+			Params:      []PresetParam{{"olderThanDays", "Only events that ended this many days ago", defaultCalendarAgeDays, 0}},
+			NeedsScan:   true,
+		},
+		resolve: func(processor *InboxProcessor, params map[string]int) []string {
+			return processor.CalendarMail(time.Now().UTC().AddDate(0, 0, -params["olderThanDays"])).staleIDs
+		},
+	},
+}
+
+func init() {
+	for i := range cleanupPresets {
+		if preset := &cleanupPresets[i]; preset.query != nil {
+			preset.Query = preset.query(preset.params(nil))
+		}
+	}
+}
+
+// findPreset returns the built-in preset with the given ID, or nil
+func findPreset(id string) *cleanupPreset {
+	for i := range cleanupPresets {
+		if cleanupPresets[i].ID == id {
+			return &cleanupPresets[i]
+		}
+	}
+	return nil
+}
+
+// params fills in the defaults of the parameters missing from given
+func (p *cleanupPreset) params(given map[string]int) map[string]int {
+	params := make(map[string]int, len(p.Params))
+	for _, param := range p.Params {
+		params[param.Name] = param.Default
+		if v, ok := given[param.Name]; ok {
+			params[param.Name] = v
+		}
+	}
+	return params
+}
+
+// validate checks that given only sets known parameters to allowed values
+func (p *cleanupPreset) validate(given map[string]int) error {
+	for name, v := range given {
+		known := false
+		for _, param := range p.Params {
+			if param.Name == name {
+				known = true
+				if v < param.Min {
+					return fmt.Errorf("%s must be at least %d", name, param.Min)
+				}
+			}
+		}
+		if !known {
+			return fmt.Errorf("preset %s has no parameter %s", p.ID, name)
+		}
+	}
+	return nil
+}
+
+// presetRunRequest is the optional body of HandleRunPreset
+type presetRunRequest struct {
+	// Parameters to change from their defaults
+	Params map[string]int `json:"params"`
+}
+
+// HandleListPresets returns the built-in cleanup presets with their parameters
+func HandleListPresets(w http.ResponseWriter, r *http.Request) {
+	presets := make([]Preset, len(cleanupPresets))
+	for i := range cleanupPresets {
+		presets[i] = cleanupPresets[i].Preset
+	}
+
+	writeJSON(w, presets)
+}
+
+// HandleRunPreset previews trashing the messages a built-in preset selects, with the
+// parameters of the body overriding the defaults. The preview is confirmed like any
+// other bulk trash.
+func HandleRunPreset(w http.ResponseWriter, r *http.Request) {
+	preset := findPreset(mux.Vars(r)["id"])
+	if preset == nil {
+		writeError(w, "Preset not found", http.StatusNotFound)
+		return
+	}
+	var req presetRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if err := preset.validate(req.Params); err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+	params := preset.params(req.Params)
+
+	var processor *InboxProcessor
+	if preset.NeedsScan {
+		if processor = processorForRequest(w, r); processor == nil {
+			return
+		}
+	}
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	if preset.query != nil {
+		query := preset.query(params)
+		handleOperationPreview(w, r, mb, "preset-"+preset.ID, query, func() ([]string, error) {
+			return listMessageIDs(mb, query)
+		}, trashOperation)
+		return
+	}
+	target := "preset:" + preset.ID
+	for _, param := range preset.Params {
+		target += fmt.Sprintf(" %s=%d", param.Name, params[param.Name])
+	}
+	handleOperationPreview(w, r, mb, "preset-"+preset.ID, target, func() ([]string, error) {
+		return preset.resolve(processor, params), nil
+	}, trashOperation)
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Google's endpoint describing an access token
+const tokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+
+// AccountProfile describes the connected account and its overall size
+type AccountProfile struct {
+	EmailAddress  string `json:"emailAddress"`
+	MessagesTotal int64  `json:"messagesTotal"`
+	ThreadsTotal  int64  `json:"threadsTotal"`
+	// OAuth scopes the token was granted, if Google could tell
+	Scopes []string `json:"scopes"`
+	// Access the scopes amount to: modify, readonly or metadata
+	Access string `json:"access,omitempty"`
+	// When the access token expires; the frontend refreshes it after that
+	Expiry time.Time `json:"expiry"`
+	// Why the scopes are missing, if they are
+	TokenInfoError string `json:"tokenInfoError,omitempty"`
+}
+
+// tokenInfo asks Google which scopes an access token carries and when it expires
+func tokenInfo(ctx context.Context, accessToken string) ([]string, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenInfoURL+"?access_token="+url.QueryEscape(accessToken), nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("token info request failed: %s", resp.Status)
+	}
+
+	var info struct {
+		Scope     string `json:"scope"`
+		ExpiresIn string `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid token info: %w", err)
+	}
+
+	var expiry time.Time
+	if seconds, err := strconv.Atoi(info.ExpiresIn); err == nil {
+		expiry = time.Now().Add(time.Duration(seconds) * time.Second).Truncate(time.Second)
+	}
+	return strings.Fields(info.Scope), expiry, nil
+}
+
+// HandleGetMe returns the connected Gmail address, its message and thread totals, and
+// the token's scopes and expiry, so the frontend can show which account is connected
+// and how big it is before scanning
+func HandleGetMe(w http.ResponseWriter, r *http.Request) {
+	token, err := ParseToken(r)
+	if err != nil {
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
+		return
+	}
+
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+
+	if err := mb.quota.Wait(r.Context(), costGetProfile); err != nil {
+		writeErrorFrom(w, "Request cancelled", err, http.StatusServiceUnavailable)
+		return
+	}
+	gmailProfile, err := mb.provider.Profile(r.Context(), mb.user)
+	if err != nil {
+		writeErrorFrom(w, "Failed to fetch profile", err, http.StatusInternalServerError)
+		return
+	}
+
+	profile := AccountProfile{
+		EmailAddress:  strings.ToLower(gmailProfile.EmailAddress),
+		MessagesTotal: gmailProfile.MessagesTotal,
+		ThreadsTotal:  gmailProfile.ThreadsTotal,
+		Scopes:        make([]string, 0),
+		Expiry:        token.Expiry,
+	}
+
+	// The token the frontend holds doesn't record its scopes, so ask Google
+	if isIMAPToken(token) || isDemoToken(token) {
+		writeJSON(w, profile)
+		return
+	}
+	scopes, expiry, err := tokenInfo(r.Context(), token.AccessToken)
+	if err != nil {
+		profile.TokenInfoError = redactError(err)
+	} else {
+		profile.Scopes = scopes
+		if !expiry.IsZero() {
+			profile.Expiry = expiry
+		}
+	}
+	profile.Access = accessLevel(withScopes(token, profile.Scopes))
+
+	writeJSON(w, profile)
+}
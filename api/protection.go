@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ProtectionRules lists mail that bulk deletes must never touch
+type ProtectionRules struct {
+	// Exact sender addresses
+	Senders []string `json:"senders"`
+	// Sender domains, matching subdomains too
+	Domains []string `json:"domains"`
+	// Label names, e.g. STARRED, IMPORTANT or a user label
+	Labels []string `json:"labels"`
+}
+
+// Rules new users start with until they save their own
+var defaultProtectionRules = ProtectionRules{
+	Senders: []string{},
+	Domains: []string{},
+	Labels:  []string{"STARRED"},
+}
+
+// protectionKey is the storage key of an account's protection rules
+func protectionKey(account string) string {
+	return "protection/" + account
+}
+
+// loadProtectionRules returns an account's saved rules, or the defaults
+func loadProtectionRules(account string) (*ProtectionRules, error) {
+	rules := defaultProtectionRules
+	if _, err := Storage.Get(protectionKey(account), &rules); err != nil {
+		return nil, fmt.Errorf("failed to load protection rules: %w", err)
+	}
+	return &rules, nil
+}
+
+// IsEmpty reports whether the rules protect nothing
+func (p *ProtectionRules) IsEmpty() bool {
+	return len(p.Senders) == 0 && len(p.Domains) == 0 && len(p.Labels) == 0
+}
+
+// protectsSender reports whether the rules protect mail from an address, by the address
+// or its domain. Labels only apply to mail already received, so they aren't checked.
+func (p *ProtectionRules) protectsSender(sender string) bool {
+	sender = strings.ToLower(sender)
+	domain := sender[strings.LastIndex(sender, "@")+1:]
+	for _, protected := range p.Senders {
+		if strings.EqualFold(protected, sender) {
+			return true
+		}
+	}
+	for _, protected := range p.Domains {
+		protected = strings.TrimPrefix(strings.ToLower(protected), "@")
+		if domain == protected || strings.HasSuffix(domain, "."+protected) {
+			return true
+		}
+	}
+	return false
+}
+
+// protectedSenders returns the senders the mailbox's protection rules cover, whose
+// future mail must not be deleted by filters either
+func protectedSenders(mb *mailbox, senders []string) ([]string, error) {
+	account, err := mb.account()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := loadProtectionRules(account)
+	if err != nil {
+		return nil, err
+	}
+	protected := make([]string, 0)
+	for _, sender := range senders {
+		if rules.protectsSender(sender) {
+			protected = append(protected, sender)
+		}
+	}
+	return protected, nil
+}
+
+// query builds a Gmail search matching every protected message
+func (p *ProtectionRules) query() string {
+	terms := make([]string, 0, len(p.Senders)+len(p.Domains)+len(p.Labels))
+	for _, sender := range p.Senders {
+		terms = append(terms, SenderQuery(sender))
+	}
+	for _, domain := range p.Domains {
+		terms = append(terms, "from:"+domain)
+	}
+	for _, label := range p.Labels {
+		switch strings.ToUpper(label) {
+		case "STARRED", "IMPORTANT", "UNREAD", "SENT", "DRAFT":
+			terms = append(terms, "is:"+strings.ToLower(label))
+		default:
+			// Gmail search spells spaces in label names as dashes
+			terms = append(terms, "label:"+strings.ReplaceAll(strings.ToLower(label), " ", "-"))
+		}
+	}
+	return "{" + strings.Join(terms, " ") + "}"
+}
+
+// protectedIDs returns the IDs of every message in the mailbox covered by the account's
+// protection rules or its protect cleanup rules. Bulk deletes must skip these.
+func protectedIDs(mb *mailbox) (map[string]struct{}, error) {
+	account, err := mb.account()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := loadProtectionRules(account)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := ruleProtectedIDs(mb, account)
+	if err != nil {
+		return nil, err
+	}
+	if rules.IsEmpty() {
+		return protected, nil
+	}
+
+	ids, err := listMessageIDs(mb, rules.query())
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up protected messages: %w", err)
+	}
+	for _, id := range ids {
+		protected[id] = struct{}{}
+	}
+	return protected, nil
+}
+
+// HandleGetProtectionRules returns the user's never-delete list
+func HandleGetProtectionRules(w http.ResponseWriter, r *http.Request) {
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	rules, err := loadProtectionRules(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, rules)
+}
+
+// HandleUpdateProtectionRules replaces the user's never-delete list
+func HandleUpdateProtectionRules(w http.ResponseWriter, r *http.Request) {
+	var rules ProtectionRules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	for _, list := range []*[]string{&rules.Senders, &rules.Domains, &rules.Labels} {
+		if *list == nil {
+			*list = []string{}
+		}
+		for i, v := range *list {
+			(*list)[i] = strings.TrimSpace(v)
+			if (*list)[i] == "" || strings.ContainsAny((*list)[i], "{}") {
+				writeError(w, fmt.Sprintf("Invalid protection entry %q", v), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	if err := Storage.Put(protectionKey(account), &rules); err != nil {
+		writeErrorFrom(w, "Failed to save protection rules", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, rules)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestProtectsSender(t *testing.T) {
+	rules := &ProtectionRules{
+		Senders: []string{"Boss@Work.example"},
+		Domains: []string{"@bank.example", "school.example"},
+	}
+	tests := []struct {
+		sender string
+		want   bool
+	}{
+		{"boss@work.example", true},
+		{"intern@work.example", false},
+		{"alerts@bank.example", true},
+		{"alerts@mail.bank.example", true},
+		{"alerts@notbank.example", false},
+		{"teacher@school.example", true},
+		{"school.example@spam.example", false},
+	}
+	for _, tt := range tests {
+		if got := rules.protectsSender(tt.sender); got != tt.want {
+			t.Errorf("protectsSender(%q) = %v, want %v", tt.sender, got, tt.want)
+		}
+	}
+}
+
+func TestTrashMessagesSkipsProtected(t *testing.T) {
+	messages := append(senderMessages("deal", "deals@shop.example", 2, 0), senderMessages("bank", "alerts@bank.example", 2, 0)...)
+	user := newTestUser(t, messages...)
+	if err := Storage.Put(protectionKey(user.account()), &ProtectionRules{
+		Senders: []string{},
+		Domains: []string{"bank.example"},
+		Labels:  []string{},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	result := trashMessages(user.mailbox(t), []string{"deala", "dealb", "banka", "bankb"})
+	if result.Aborted {
+		t.Fatalf("trash aborted: %s", result.AbortReason)
+	}
+	if len(result.Trashed) != 2 || len(result.Protected) != 2 {
+		t.Errorf("trashed %v and protected %v, want the deals trashed and the bank alerts protected", result.Trashed, result.Protected)
+	}
+	for id, want := range map[string]bool{"deala": true, "dealb": true, "banka": false, "bankb": false} {
+		if got := messageHasLabel(t, user.server, id, "TRASH"); got != want {
+			t.Errorf("message %s in trash = %v, want %v", id, got, want)
+		}
+	}
+}
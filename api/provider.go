@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// MailProvider is the mail backend the processor and handlers act through
+type MailProvider = deepclean.MailProvider
+
+// MessageQuery selects the messages ListMessages returns, one page at a time
+type MessageQuery = deepclean.MessageQuery
+
+// ErrNotSupported is returned for what a mail backend can't do, such as sending mail
+// over IMAP
+var ErrNotSupported = deepclean.ErrNotSupported
+
+// ProviderFactory creates the mail backend of a token's mailboxes
+type ProviderFactory func(token *oauth2.Token) (MailProvider, error)
+
+// NewMailProvider creates the backend every mailbox and scan acts through: IMAP for the
+// tokens of IMAP sessions, the synthetic mailbox in demo mode, Gmail otherwise, unless
+// replaced, e.g. by a fake in tests
+var NewMailProvider ProviderFactory = func(token *oauth2.Token) (MailProvider, error) {
+	if isIMAPToken(token) {
+		return newIMAPProvider(token)
+	}
+	if isDemoToken(token) {
+		return demoProvider(token)
+	}
+	provider, err := NewGmailProvider(token)
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// GmailProvider is the MailProvider backed by the Gmail API
+type GmailProvider = deepclean.GmailProvider
+
+// NewGmailProvider creates a Gmail provider authorized with the given token
+func NewGmailProvider(token *oauth2.Token) (*GmailProvider, error) {
+	client := googleClient(context.Background(), token)
+	service, err := gmail.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gmail service: %w", err)
+	}
+	return deepclean.NewGmailProvider(service), nil
+}
+
+// NewGmailProviderFromService creates a Gmail provider using an existing service, such
+// as one pointed at the fake server of package gmailtest
+func NewGmailProviderFromService(service *gmail.Service) *GmailProvider {
+	return deepclean.NewGmailProvider(service)
+}
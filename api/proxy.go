@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseNetworks parses a comma-separated list of IP addresses and CIDR ranges
+func parseNetworks(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or CIDR range %q", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// isTrustedProxy reports whether addr, an IP address with or without a port, belongs
+// to a trusted proxy
+func isTrustedProxy(addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range config.TrustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustProxies applies the X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto
+// headers of requests coming from a trusted proxy, so the rest of the server sees the
+// client's address and the public host and scheme. The headers of anyone else are
+// ignored, since clients could forge them.
+func TrustProxies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(config.TrustedProxies) == 0 || !isTrustedProxy(r.RemoteAddr) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Each proxy appends the address it received the request from, so the client
+		// is the rightmost address that isn't another trusted proxy
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(strings.Join(forwarded, ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if net.ParseIP(hop) == nil {
+					break
+				}
+				r.RemoteAddr = net.JoinHostPort(hop, "0")
+				if !isTrustedProxy(hop) {
+					break
+				}
+			}
+		}
+		if host := firstForwarded(r.Header.Get("X-Forwarded-Host")); host != "" {
+			r.Host = host
+		}
+		if proto := strings.ToLower(firstForwarded(r.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+			r.URL.Scheme = proto
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// firstForwarded returns the first value of a comma-separated forwarded header, which
+// the proxy closest to the client set
+func firstForwarded(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
+
+// requestBaseURL returns the scheme and host the client used to reach the server, as
+// seen through any trusted proxy
+func requestBaseURL(r *http.Request) string {
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Most threads one prune may cover
+const maxPruneThreads = 5000
+
+// pruneRequest is the body of HandlePruneThreads
+type pruneRequest struct {
+	ThreadIDs []string `json:"threadIds"`
+	// Prune every thread containing mail from these senders
+	Senders []string `json:"senders"`
+}
+
+// HandlePruneThreads previews trashing all but the most recent message of each
+// selected thread. Threads are selected directly or by sender; long automated threads
+// such as build notifications and ticket updates rarely need their full history.
+func HandlePruneThreads(w http.ResponseWriter, r *http.Request) {
+	var req pruneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if len(req.ThreadIDs) == 0 && len(req.Senders) == 0 {
+		writeError(w, "Thread IDs or senders are required", http.StatusBadRequest)
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	target := strings.Join(append(append([]string{}, req.Senders...), req.ThreadIDs...), ",")
+	handleOperationPreview(w, r, mb, "prune-threads", target, func() ([]string, error) {
+		threads := make(map[string]struct{})
+		for _, id := range req.ThreadIDs {
+			threads[id] = struct{}{}
+		}
+		for _, sender := range req.Senders {
+			ids, err := listThreadIDs(mb, SenderQuery(sender))
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				threads[id] = struct{}{}
+			}
+		}
+		if len(threads) > maxPruneThreads {
+			return nil, fmt.Errorf("selection covers %d threads, more than the %d one prune may cover", len(threads), maxPruneThreads)
+		}
+
+		ids := make([]string, 0, len(threads))
+		for id := range threads {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return olderThreadMessages(mb, ids)
+	}, trashOperation)
+}
+
+// listThreadIDs returns the IDs of the threads containing a message matching a Gmail
+// search query
+func listThreadIDs(mb *mailbox, query string) ([]string, error) {
+	seen := make(map[string]struct{})
+	ids := make([]string, 0)
+	pageToken := ""
+
+	for {
+		if err := mb.quota.Wait(context.Background(), costMessagesList); err != nil {
+			return ids, err
+		}
+
+		resp, err := mb.provider.ListMessages(mb.context(), mb.user, MessageQuery{Query: query, PageToken: pageToken, MaxResults: 500})
+		if err != nil {
+			return ids, fmt.Errorf("failed to list messages: %w", err)
+		}
+
+		for _, msg := range resp.Messages {
+			if _, ok := seen[msg.ThreadId]; !ok {
+				seen[msg.ThreadId] = struct{}{}
+				ids = append(ids, msg.ThreadId)
+			}
+		}
+
+		if resp.NextPageToken == "" {
+			return ids, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// olderThreadMessages returns every message of the given threads except the most recent
+// one in each
+func olderThreadMessages(mb *mailbox, threadIDs []string) ([]string, error) {
+	older := make([]string, 0)
+	var mu sync.Mutex
+
+	fetch := func(threadID string) error {
+		thread, err := mb.provider.GetThread(mb.context(), mb.user, threadID, "minimal")
+		if err != nil {
+			return err
+		}
+
+		latest := 0
+		for i, msg := range thread.Messages {
+			if msg.InternalDate > thread.Messages[latest].InternalDate {
+				latest = i
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for i, msg := range thread.Messages {
+			if i != latest {
+				older = append(older, msg.Id)
+			}
+		}
+		return nil
+	}
+
+	var fetchErr error
+	err := runPlanned(context.Background(), mb.quota, threadIDs, costThreadsGet, fetch, func(results map[string]error) bool {
+		for id, err := range results {
+			if err != nil {
+				fetchErr = fmt.Errorf("failed to fetch thread %s: %w", id, err)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return older, nil
+}
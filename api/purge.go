@@ -0,0 +1,299 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DeletionReceipt confirms what the server forgot about a user. It is only returned,
+// never stored, since keeping it would keep the account address.
+type DeletionReceipt struct {
+	ID        string    `json:"id"`
+	Account   string    `json:"account"`
+	DeletedAt time.Time `json:"deletedAt"`
+	// Records deleted from memory and storage, by kind
+	Deleted map[string]int `json:"deleted"`
+	// What couldn't be deleted; purging again retries it
+	Errors []string `json:"errors,omitempty"`
+	// Whether everything was deleted
+	Complete bool `json:"complete"`
+}
+
+// add records count deleted records of a kind, or why deleting them failed
+func (d *DeletionReceipt) add(kind string, count int, err error) {
+	d.Deleted[kind] += count
+	if err != nil {
+		d.Errors = append(d.Errors, kind+": "+redactError(err))
+	}
+}
+
+// deleteKeys deletes storage keys and records those that existed under kind
+func (d *DeletionReceipt) deleteKeys(kind string, keys ...string) {
+	deleted := 0
+	for _, key := range keys {
+		var value json.RawMessage
+		found, err := Storage.Get(key, &value)
+		if err == nil && found {
+			err = Storage.Delete(key)
+		}
+		if err != nil {
+			d.add(kind, deleted, err)
+			return
+		}
+		if found {
+			deleted++
+		}
+	}
+	d.add(kind, deleted, nil)
+}
+
+// deletePrefix deletes every storage key under prefix and records them under kind
+func (d *DeletionReceipt) deletePrefix(kind, prefix string) {
+	keys, err := Storage.List(prefix)
+	if err != nil {
+		d.add(kind, 0, err)
+		return
+	}
+	d.deleteKeys(kind, keys...)
+}
+
+// HandlePurgeMyData makes the server forget the requesting user's mailbox under any of
+// its tokens: its scans, cached metadata, message indexes and checkpoints, jobs, audit
+// log, undo records, feedback, rules, saved searches, webhooks, notifiers, retention
+// policies and their runs, trash emptying settings, imports, backups and usage
+// statistics, and every session or scheduled cleanup holding a token for it, including
+// the one making the request. The mailbox itself is untouched. Purging is refused while
+// a scan or job of the user is running, since it would save its state again when it
+// finishes.
+func HandlePurgeMyData(w http.ResponseWriter, r *http.Request) {
+	token, err := ParseToken(r)
+	if err != nil {
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
+		return
+	}
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	// The account's tokens change over time, and each has its own user ID
+	userIDs := accountUserIDs(account, mb.userID)
+	for _, userID := range userIDs {
+		if processor, ok := Registry.Get(userID); ok && processor.GetProgress()["isProcessing"].(bool) {
+			writeError(w, "A scan of this mailbox is running; purge its data once it has finished", http.StatusConflict)
+			return
+		}
+	}
+	if Jobs.active(account) {
+		writeError(w, "Jobs of this mailbox are running; purge its data once they have finished", http.StatusConflict)
+		return
+	}
+
+	receipt := &DeletionReceipt{
+		ID:        newID(),
+		Account:   account,
+		DeletedAt: time.Now().UTC(),
+		Deleted:   make(map[string]int),
+	}
+
+	// Sessions are matched through the cached addresses, so end them first
+	purgeSessions(receipt, r, token, account)
+
+	scans, indexes := 0, 0
+	for _, userID := range userIDs {
+		if _, ok := Registry.Get(userID); ok {
+			Registry.Remove(userID)
+			scans++
+		}
+		err := os.Remove(messageIndexPath(userID))
+		if err == nil {
+			indexes++
+		} else if !os.IsNotExist(err) {
+			receipt.add("messageIndexes", 0, err)
+		}
+	}
+	receipt.add("scans", scans, nil)
+	receipt.add("messageIndexes", indexes, nil)
+	receipt.deletePrefix("scanCheckpoints", scanCheckpointPrefix(account))
+
+	jobs, err := Jobs.forget(account)
+	receipt.add("jobs", jobs, err)
+	backups, err := Backups.removeOwner(account)
+	receipt.add("backups", backups, err)
+	receipt.deletePrefix("auditEntries", auditKeyPrefix(account))
+	receipt.deletePrefix("trashRecords", trashRecordKey(account, ""))
+	receipt.deleteKeys("feedback", feedbackKey(account))
+	receipt.deleteKeys("protectionRules", protectionKey(account))
+	receipt.deleteKeys("rules", rulesKey(account))
+	receipt.deleteKeys("savedSearches", savedSearchesKey(account))
+	receipt.deleteKeys("webhooks", webhooksKey(account))
+	receipt.deleteKeys("notifiers", notifiersKey(account))
+	receipt.deleteKeys("retentionPolicies", retentionKey(account))
+	receipt.deleteKeys("retentionRuns", retentionRunsKey(account))
+	receipt.deleteKeys("autoEmpty", autoEmptyKey(account))
+	receipt.deleteKeys("scheduleTokens", scheduleTokenKey(account))
+	if token.RefreshToken != "" {
+		receipt.deleteKeys("refreshedTokens", refreshedTokenKey(refreshTokenID(token.RefreshToken)))
+	}
+	purgeImports(receipt, account)
+	purgeCaches(receipt, token, userIDs, account)
+
+	Usage.Flush()
+	days, err := Usage.forget(account)
+	receipt.add("usageDays", days, err)
+
+	receipt.Complete = len(receipt.Errors) == 0
+	log.Printf("Purged the data of a user on request: %d kinds, complete: %v", len(receipt.Deleted), receipt.Complete)
+	writeJSON(w, receipt)
+}
+
+// accountUserIDs returns userID and the other user IDs the account's address is cached
+// under, one for each token its sessions used since the server started
+func accountUserIDs(account, userID string) []string {
+	userIDs := []string{userID}
+	accountCacheMu.Lock()
+	defer accountCacheMu.Unlock()
+	for key, address := range accountCache {
+		if address == account && key != userID {
+			userIDs = append(userIDs, key)
+		}
+	}
+	return userIDs
+}
+
+// purgeSessions ends the request's session and every other one holding a token of the
+// account: those sharing its refresh token and those whose address is cached
+func purgeSessions(receipt *DeletionReceipt, r *http.Request, token *oauth2.Token, account string) {
+	ended := 0
+	if claims, err := verifySessionJWT(sessionJWT(r)); claims != nil && (err == nil || errors.Is(err, errSessionExpired)) {
+		endSession(claims.SessionID)
+		ended++
+	}
+
+	keys, err := Storage.List(sessionPrefix)
+	if err != nil {
+		receipt.add("sessions", ended, err)
+		return
+	}
+	for _, key := range keys {
+		var s session
+		found, err := Storage.Get(key, &s)
+		if err != nil {
+			receipt.add("sessions", ended, err)
+			return
+		}
+		if !found || s.Token == nil {
+			continue
+		}
+		sameToken := token.RefreshToken != "" && s.Token.RefreshToken == token.RefreshToken
+		accountCacheMu.Lock()
+		sameAccount := accountCache[userIDFromToken(s.Token)] == account
+		accountCacheMu.Unlock()
+		if sameToken || sameAccount {
+			endSession(strings.TrimPrefix(key, sessionPrefix))
+			ended++
+		}
+	}
+	receipt.add("sessions", ended, nil)
+}
+
+// purgeImports deletes the account's mbox imports and their uploads
+func purgeImports(receipt *DeletionReceipt, account string) {
+	sessions, err := importSessionsOf(account)
+	if err != nil {
+		receipt.add("imports", 0, err)
+		return
+	}
+	deleted := 0
+	for _, session := range sessions {
+		if err := os.Remove(session.path()); err != nil && !os.IsNotExist(err) {
+			receipt.add("imports", deleted, err)
+			return
+		}
+		if err := Storage.Delete(importKey(session.ID)); err != nil {
+			receipt.add("imports", deleted, err)
+			return
+		}
+		importSessionsMu.Lock()
+		delete(importSessions, session.ID)
+		importSessionsMu.Unlock()
+		deleted++
+	}
+	receipt.add("imports", deleted, nil)
+}
+
+// purgeCaches drops what the server holds in memory only: pending operations, the
+// token's shared token source, contacts, cached addresses, and the IMAP session or demo
+// mailbox of the token
+func purgeCaches(receipt *DeletionReceipt, token *oauth2.Token, userIDs []string, account string) {
+	operations := 0
+	pendingOperationsMu.Lock()
+	for id, op := range pendingOperations {
+		if op.account == account {
+			delete(pendingOperations, id)
+			operations++
+		}
+	}
+	pendingOperationsMu.Unlock()
+	receipt.add("pendingOperations", operations, nil)
+
+	sources := 0
+	if forgetTokenSource(token) {
+		sources++
+	}
+	receipt.add("tokenSources", sources, nil)
+
+	contacts := 0
+	contactDirectoriesMu.Lock()
+	for _, userID := range userIDs {
+		if _, ok := contactDirectories[userID]; ok {
+			delete(contactDirectories, userID)
+			contacts++
+		}
+	}
+	contactDirectoriesMu.Unlock()
+	receipt.add("contacts", contacts, nil)
+
+	addresses := 0
+	accountCacheMu.Lock()
+	for key, address := range accountCache {
+		if address == account {
+			delete(accountCache, key)
+			addresses++
+		}
+	}
+	accountCacheMu.Unlock()
+	receipt.add("cachedAddresses", addresses, nil)
+
+	switch {
+	case isIMAPToken(token):
+		imapSessionsMu.Lock()
+		session := imapSessions[token.AccessToken]
+		delete(imapSessions, token.AccessToken)
+		imapSessionsMu.Unlock()
+		if session != nil {
+			session.close()
+			receipt.add("imapSessions", 1, nil)
+		}
+	case isDemoToken(token):
+		demoMailboxesMu.Lock()
+		_, ok := demoMailboxes[token.AccessToken]
+		delete(demoMailboxes, token.AccessToken)
+		demoMailboxesMu.Unlock()
+		if ok {
+			receipt.add("demoMailboxes", 1, nil)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Gmail allows each user 250 quota units per second (as a moving average, so short
+// bursts are tolerated). Going over it turns large jobs into a storm of 429 retries.
+const quotaUnitsPerSecond = 250
+
+// Quota unit cost of each Gmail API method used by the server
+// (https://developers.google.com/gmail/api/reference/quota)
+const (
+	costMessagesList        = 5
+	costMessagesGet         = 5
+	costMessagesTrash       = 5
+	costMessagesUntrash     = 5
+	costMessagesDelete      = 10
+	costMessagesModify      = 5
+	costMessagesBatchModify = 50
+	costMessagesBatchDelete = 50
+	costMessagesInsert      = 25
+	costMessagesImport      = 25
+	costMessagesSend        = 100
+	costThreadsGet          = 10
+	costThreadsModify       = 10
+	costAttachmentsGet      = 5
+	costFiltersCreate       = 5
+	costLabelsList          = 1
+	costLabelsCreate        = 5
+	costGetProfile          = 1
+	costDelegatesGet        = 1
+)
+
+// Costs of the calls the deepclean package's scanner and cleaner make, by method name
+var methodCosts = map[string]int{
+	"messages.list":        costMessagesList,
+	"messages.get":         costMessagesGet,
+	"messages.trash":       costMessagesTrash,
+	"messages.modify":      costMessagesModify,
+	"messages.batchModify": costMessagesBatchModify,
+	"messages.batchDelete": costMessagesBatchDelete,
+}
+
+// QuotaLimiter paces one user's Gmail calls with a token bucket refilled at the
+// per-user quota rate, slowed down further by its throttle while Gmail rate limits them
+type QuotaLimiter struct {
+	userID   string
+	tokens   float64
+	last     time.Time
+	throttle *Throttle
+	mu       sync.Mutex
+}
+
+// QuotaRegistry holds a limiter per user, so all of a user's concurrent requests and
+// jobs share one budget
+type QuotaRegistry struct {
+	limiters map[string]*QuotaLimiter
+	mu       sync.Mutex
+}
+
+var (
+	// Global registry of per-user quota limiters
+	Quota = &QuotaRegistry{
+		limiters: make(map[string]*QuotaLimiter),
+	}
+)
+
+// For returns the limiter for a user, creating it on first use
+func (r *QuotaRegistry) For(userID string) *QuotaLimiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	limiter, ok := r.limiters[userID]
+	if !ok {
+		limiter = &QuotaLimiter{userID: userID, tokens: quotaUnitsPerSecond, last: time.Now(), throttle: newThrottle()}
+		r.limiters[userID] = limiter
+	}
+	return limiter
+}
+
+// Wait blocks for the throttle's delay and until units quota units are available, and
+// consumes them
+func (l *QuotaLimiter) Wait(ctx context.Context, units int) error {
+	if err := l.throttle.wait(ctx); err != nil {
+		return err
+	}
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * quotaUnitsPerSecond
+		if l.tokens > quotaUnitsPerSecond {
+			l.tokens = quotaUnitsPerSecond
+		}
+		l.last = now
+
+		if l.tokens >= float64(units) {
+			l.tokens -= float64(units)
+			l.mu.Unlock()
+			Usage.addQuota(l.userID, units)
+			return nil
+		}
+		wait := time.Duration((float64(units) - l.tokens) / quotaUnitsPerSecond * float64(time.Second))
+		l.mu.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// quotaBatchSize returns how many calls costing cost units fit into one second of quota
+func quotaBatchSize(cost int) int {
+	return max(1, quotaUnitsPerSecond/cost)
+}
+
+// planBatches splits ids into batches that each use at most one second of quota when
+// every ID costs cost units
+func planBatches(ids []string, cost int) [][]string {
+	size := quotaBatchSize(cost)
+	batches := make([][]string, 0, (len(ids)+size-1)/size)
+	for start := 0; start < len(ids); start += size {
+		batches = append(batches, ids[start:min(start+size, len(ids))])
+	}
+	return batches
+}
+
+// runPlanned calls fn for every ID in quota-sized batches, waiting for each batch's quota
+// before running its calls concurrently, as many at once as the throttle allows.
+// afterBatch is called between batches and can return false to stop early.
+func runPlanned(ctx context.Context, quota *QuotaLimiter, ids []string, cost int, fn func(id string) error, afterBatch func(results map[string]error) bool) error {
+	for _, batch := range planBatches(ids, cost) {
+		if err := quota.Wait(ctx, cost*len(batch)); err != nil {
+			return err
+		}
+
+		results := make(map[string]error, len(batch))
+		slots := make(chan struct{}, quota.throttle.Concurrency())
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, id := range batch {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(id string) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				err := fn(id)
+				quota.throttle.Observe(err)
+				mu.Lock()
+				results[id] = err
+				mu.Unlock()
+			}(id)
+		}
+		wg.Wait()
+
+		if !afterBatch(results) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+)
+
+const (
+	// Most recommendations one request may return
+	maxRecommendations = 200
+	// Sender volume and age at which those signals count fully
+	recommendationFullVolume = 50
+	recommendationFullAge    = 365 * 24 * time.Hour
+)
+
+// How much each signal adds to the deletability score, out of 100
+const (
+	weightVolume       = 20
+	weightUnread       = 25
+	weightNeverReplied = 20
+	weightUnsubscribe  = 15
+	weightAge          = 20
+)
+
+// Categories whose mail is riskier to delete scale the score down
+var categoryScoreFactor = map[string]float64{
+	deepclean.CategoryPersonal:      0.3,
+	deepclean.CategoryTransactional: 0.6,
+}
+
+// Recommendation suggests cleaning up a sender's mail, with how safe that looks
+type Recommendation struct {
+	Sender string `json:"sender"`
+	// Deletability from 0 (keep) to 100 (safe to delete)
+	Score    int    `json:"score"`
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+	Unread   int    `json:"unread"`
+	// Storage a cleanup would reclaim
+	TotalSize int64 `json:"totalSize"`
+	// Count and TotalSize for display
+	Savings        string    `json:"savings"`
+	Newest         time.Time `json:"newest"`
+	HasUnsubscribe bool      `json:"hasUnsubscribe"`
+	Replied        bool      `json:"replied"`
+	// Human-readable signals behind the score
+	Reasons []string `json:"reasons"`
+}
+
+// Recommendations scores every sender by how safe their mail looks to delete and
+// returns those scoring at least minScore, best first. Senders the user marked
+// important are never recommended.
+func (p *InboxProcessor) Recommendations(minScore int) []Recommendation {
+	feedback := p.feedback()
+	now := time.Now()
+
+	p.mu.RLock()
+	// Threads the user replied in, so their senders don't look ignored
+	repliedThreads := make(map[string]struct{})
+	p.emails.each(func(_ int, email *EmailMetadata) bool {
+		if hasLabel(email, "SENT") {
+			repliedThreads[email.ThreadID] = struct{}{}
+		}
+		return true
+	})
+
+	bySender := make(map[string]*Recommendation)
+	categoryCounts := make(map[string]map[string]int)
+	p.emails.each(func(_ int, email *EmailMetadata) bool {
+		// The user's own messages aren't cleanup candidates
+		if hasLabel(email, "SENT") {
+			return true
+		}
+		if feedback.Important[strings.ToLower(email.From)] {
+			return true
+		}
+
+		rec, ok := bySender[email.From]
+		if !ok {
+			rec = &Recommendation{Sender: email.From}
+			bySender[email.From] = rec
+			categoryCounts[email.From] = make(map[string]int)
+		}
+		rec.Count++
+		rec.TotalSize += email.Size()
+		categoryCounts[email.From][email.Category]++
+		if email.HasUnsubscribe {
+			rec.HasUnsubscribe = true
+		}
+		if email.Date.After(rec.Newest) {
+			rec.Newest = email.Date
+		}
+		if hasLabel(email, "UNREAD") {
+			rec.Unread++
+		}
+		if _, ok := repliedThreads[email.ThreadID]; ok {
+			rec.Replied = true
+		}
+		return true
+	})
+	p.mu.RUnlock()
+
+	recommendations := make([]Recommendation, 0, len(bySender))
+	for sender, rec := range bySender {
+		rec.Category = deepclean.MajorityCategory(categoryCounts[sender])
+		rec.score(now)
+		rec.Savings = describeSavings(rec.Count, rec.TotalSize, false)
+		if rec.Score >= minScore {
+			recommendations = append(recommendations, *rec)
+		}
+	}
+
+	sort.Slice(recommendations, func(i, j int) bool {
+		if recommendations[i].Score != recommendations[j].Score {
+			return recommendations[i].Score > recommendations[j].Score
+		}
+		return recommendations[i].TotalSize > recommendations[j].TotalSize
+	})
+	return recommendations
+}
+
+// hasLabel reports whether a message carries a label
+func hasLabel(e *EmailMetadata, label string) bool {
+	for _, l := range e.LabelIDs {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// score computes the deletability score and the reasons behind it
+func (rec *Recommendation) score(now time.Time) {
+	var score float64
+	rec.Reasons = make([]string, 0)
+
+	volume := min(float64(rec.Count)/recommendationFullVolume, 1)
+	score += volume * weightVolume
+	if rec.Count >= recommendationFullVolume/5 {
+		rec.Reasons = append(rec.Reasons, strconv.Itoa(rec.Count)+" messages")
+	}
+
+	unreadRatio := float64(rec.Unread) / float64(rec.Count)
+	score += unreadRatio * weightUnread
+	if rec.Unread == rec.Count {
+		rec.Reasons = append(rec.Reasons, "never read")
+	} else if unreadRatio >= 0.5 {
+		rec.Reasons = append(rec.Reasons, strconv.Itoa(int(unreadRatio*100))+"% unread")
+	}
+
+	if !rec.Replied {
+		score += weightNeverReplied
+		rec.Reasons = append(rec.Reasons, "never replied to")
+	}
+
+	if rec.HasUnsubscribe {
+		score += weightUnsubscribe
+		rec.Reasons = append(rec.Reasons, "has an unsubscribe link")
+	}
+
+	if !rec.Newest.IsZero() {
+		age := now.Sub(rec.Newest)
+		score += min(float64(age)/float64(recommendationFullAge), 1) * weightAge
+		if age >= recommendationFullAge {
+			rec.Reasons = append(rec.Reasons, "nothing new in over a year")
+		}
+	}
+
+	if factor, ok := categoryScoreFactor[rec.Category]; ok {
+		score *= factor
+		rec.Reasons = append(rec.Reasons, "mostly "+rec.Category+" mail")
+	}
+
+	rec.Score = int(score + 0.5)
+}
+
+// HandleGetRecommendations returns senders ranked by how safe their mail is to delete,
+// with the storage each cleanup would reclaim. `limit` caps the list (default 20) and
+// `minScore` drops weaker suggestions.
+func HandleGetRecommendations(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRecommendations {
+			writeError(w, "limit must be between 1 and "+strconv.Itoa(maxRecommendations), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	minScore := 0
+	if v := r.URL.Query().Get("minScore"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 100 {
+			writeError(w, "minScore must be between 0 and 100", http.StatusBadRequest)
+			return
+		}
+		minScore = n
+	}
+
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return
+	}
+
+	recommendations := processor.Recommendations(minScore)
+	var savings int64
+	messages := 0
+	for _, rec := range recommendations {
+		savings += rec.TotalSize
+		messages += rec.Count
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"recommendations": recommendations[:min(limit, len(recommendations))],
+		"total":           len(recommendations),
+		// Reclaimed by cleaning up every recommended sender, not just those returned
+		"totalSavings": savings,
+		"savings":      describeSavings(messages, savings, false),
+	})
+}
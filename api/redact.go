@@ -0,0 +1,58 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+)
+
+// Matches email addresses, including URL-encoded ones inside request URLs that end
+// up in Gmail API error messages
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+(?:@|%40)[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+// redactionDisabled turns redaction off for troubleshooting (DEBUG_UNREDACTED_LOGS=true)
+var redactionDisabled atomic.Bool
+
+// SetRedactionEnabled turns the redaction of addresses, subjects and snippets in logs
+// and persisted errors on or off. It is on by default.
+func SetRedactionEnabled(enabled bool) {
+	redactionDisabled.Store(!enabled)
+}
+
+// RedactionEnabled reports whether log redaction is currently on
+func RedactionEnabled() bool {
+	return !redactionDisabled.Load()
+}
+
+// redactAddress replaces an email address with a short stable hash, so log lines about
+// the same sender can still be correlated without revealing who it is
+func redactAddress(address string) string {
+	if !RedactionEnabled() {
+		return address
+	}
+	sum := sha256.Sum256([]byte(address))
+	return "<addr:" + hex.EncodeToString(sum[:4]) + ">"
+}
+
+// redactText hides free text such as subjects and snippets, keeping only its length
+func redactText(text string) string {
+	if !RedactionEnabled() {
+		return text
+	}
+	return fmt.Sprintf("<redacted %d chars>", len(text))
+}
+
+// redactError returns an error's message with any email addresses replaced. Use it
+// for every error that is logged or persisted, since Gmail errors echo the request
+// URL including search queries like from:someone@example.com.
+func redactError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if !RedactionEnabled() {
+		return err.Error()
+	}
+	return emailPattern.ReplaceAllStringFunc(err.Error(), redactAddress)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// FormatBytes renders a byte count for people, e.g. "12.3 MB"
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// reclaimed estimates the storage freed by affecting count of the operation's messages.
+// The preview may have sized only part of the selection, so its average size is scaled.
+func (op *Operation) reclaimed(count int) int64 {
+	if op.Summary.SizedCount == 0 {
+		return 0
+	}
+	return op.Summary.TotalSize * int64(count) / int64(op.Summary.SizedCount)
+}
+
+// cleanupReport builds the plain-text summary of a finished operation
+func cleanupReport(op *Operation, result interface{}, affected []string, runErr error) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Your %s cleanup", op.Kind)
+	if op.Target != "" {
+		fmt.Fprintf(&b, " of %s", op.Target)
+	}
+	if runErr != nil {
+		fmt.Fprintf(&b, " failed: %s\n\n", redactError(runErr))
+	} else {
+		b.WriteString(" has finished.\n\n")
+	}
+
+	fmt.Fprintf(&b, "Messages selected: %d\n", op.Summary.Count)
+	fmt.Fprintf(&b, "Messages affected: %d\n", len(affected))
+
+	if reclaimed := op.reclaimed(len(affected)); reclaimed > 0 {
+		fmt.Fprintf(&b, "Storage reclaimed: about %s\n", FormatBytes(reclaimed))
+	}
+
+	switch res := result.(type) {
+	case *TrashResult:
+		fmt.Fprintf(&b, "Failed: %d\nSkipped as protected: %d\n", len(res.Failed), len(res.Protected))
+		if res.Aborted {
+			fmt.Fprintf(&b, "Stopped early: %s\n", res.AbortReason)
+		}
+	case *EmptyResult:
+		if res.Error != "" {
+			fmt.Fprintf(&b, "Stopped early: %s\n", res.Error)
+		}
+	}
+
+	if _, ok := result.(trashReporter); ok && len(affected) > 0 {
+		b.WriteString("\nTrashed messages stay in Trash for 30 days. To restore them, undo ")
+		fmt.Fprintf(&b, "operation %s", op.ID)
+		if config.AppURL != "" {
+			fmt.Fprintf(&b, " at %s", config.AppURL)
+		}
+		b.WriteString(".\n")
+	}
+	return b.String()
+}
+
+// sendCleanupReport emails the mailbox a summary of a finished operation. Failing to
+// send it doesn't change the outcome, so failures are only logged.
+func sendCleanupReport(mb *mailbox, op *Operation, result interface{}, affected []string, runErr error) {
+	account, err := mb.account()
+	if err != nil {
+		log.Printf("Failed to send cleanup report for %s: %s", op.ID, redactError(err))
+		return
+	}
+
+	subject := "Cleanup report: " + op.Kind
+	raw := fmt.Sprintf("To: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		account, subject, strings.ReplaceAll(cleanupReport(op, result, affected, runErr), "\n", "\r\n"))
+
+	if err := mb.quota.Wait(context.Background(), costMessagesSend); err != nil {
+		log.Printf("Failed to send cleanup report for %s: %s", op.ID, redactError(err))
+		return
+	}
+	err = mb.provider.SendMessage(mb.context(), mb.user, &gmail.Message{
+		Raw: base64.URLEncoding.EncodeToString([]byte(raw)),
+	})
+	if err != nil {
+		log.Printf("Failed to send cleanup report for %s: %s", op.ID, redactError(err))
+	}
+}
@@ -0,0 +1,547 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// How often a policy is enforced
+	retentionRunInterval = 24 * time.Hour
+	// Most retention policies one account may have
+	maxRetentionPolicies = 20
+	// Run reports kept per policy
+	maxRetentionRuns = 30
+)
+
+// How a retention run was started
+const (
+	retentionTriggerScheduled = "scheduled"
+	retentionTriggerManual    = "manual"
+)
+
+// Serializes read-modify-write updates of retention policies and their run reports
+var retentionMu sync.Mutex
+
+// RetentionPolicy keeps a label's mail for a number of days, after which the scheduler
+// trashes or archives it, e.g. "keep 90 days of CATEGORY_UPDATES"
+type RetentionPolicy struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Label the policy applies to, by ID and name. Gmail's inbox categories are labels
+	// too, such as CATEGORY_UPDATES.
+	LabelID   string `json:"labelId"`
+	LabelName string `json:"labelName"`
+	// Mail with the label is kept this many days
+	KeepDays int `json:"keepDays"`
+	// trash or archive
+	Action string `json:"action"`
+	// Whether the scheduler enforces the policy
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// When the policy was last enforced, by the scheduler or on request
+	LastRunAt *time.Time `json:"lastRunAt,omitempty"`
+}
+
+// retentionRequest is the body of HandleCreateRetentionPolicy and
+// HandleUpdateRetentionPolicy
+type retentionRequest struct {
+	Name string `json:"name"`
+	// Label ID or name, e.g. CATEGORY_UPDATES or CI
+	Label    string `json:"label"`
+	KeepDays int    `json:"keepDays"`
+	// trash (the default) or archive
+	Action string `json:"action"`
+	// Whether the scheduler enforces the policy; true if left out
+	Enabled *bool `json:"enabled"`
+}
+
+// RetentionRun reports what one enforcement of a retention policy did
+type RetentionRun struct {
+	ID       string `json:"id"`
+	PolicyID string `json:"policyId"`
+	// scheduled, or manual for runs started with HandleRunRetentionPolicy
+	Trigger    string    `json:"trigger"`
+	Action     string    `json:"action"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// Mail dated before this was past its retention
+	Cutoff time.Time `json:"cutoff"`
+	// Messages past their retention, and those trashed or archived
+	Matched  int `json:"matched"`
+	Affected int `json:"affected"`
+	// Messages skipped because protection rules cover them
+	Protected int `json:"protected"`
+	Failed    int `json:"failed"`
+	// Undo record of what the run trashed, for HandleUndoOperation
+	OperationID string `json:"operationId,omitempty"`
+	DryRun      bool   `json:"dryRun,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// retentionKey is the storage key of an account's retention policies
+func retentionKey(account string) string {
+	return "retention/" + account
+}
+
+// retentionRunsKey is the storage key of an account's retention run reports
+func retentionRunsKey(account string) string {
+	return "retention-runs/" + account
+}
+
+// loadRetentionPolicies returns an account's retention policies
+func loadRetentionPolicies(account string) ([]RetentionPolicy, error) {
+	policies := make([]RetentionPolicy, 0)
+	if _, err := Storage.Get(retentionKey(account), &policies); err != nil {
+		return nil, fmt.Errorf("failed to load retention policies: %w", err)
+	}
+	return policies, nil
+}
+
+// loadRetentionRuns returns an account's retention run reports, newest first
+func loadRetentionRuns(account string) ([]RetentionRun, error) {
+	runs := make([]RetentionRun, 0)
+	if _, err := Storage.Get(retentionRunsKey(account), &runs); err != nil {
+		return nil, fmt.Errorf("failed to load retention runs: %w", err)
+	}
+	return runs, nil
+}
+
+// findRetentionPolicy returns the index of the policy with the given ID, or -1
+func findRetentionPolicy(policies []RetentionPolicy, id string) int {
+	return slices.IndexFunc(policies, func(p RetentionPolicy) bool { return p.ID == id })
+}
+
+// recordRetentionRun adds a run report, dropping the oldest reports of its policy beyond
+// maxRetentionRuns
+func recordRetentionRun(account string, run *RetentionRun) {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	runs, err := loadRetentionRuns(account)
+	if err != nil {
+		log.Printf("Failed to record retention run: %s", redactError(err))
+		return
+	}
+	runs = append([]RetentionRun{*run}, runs...)
+	kept := 0
+	runs = slices.DeleteFunc(runs, func(r RetentionRun) bool {
+		if r.PolicyID != run.PolicyID {
+			return false
+		}
+		kept++
+		return kept > maxRetentionRuns
+	})
+	if err := Storage.Put(retentionRunsKey(account), runs); err != nil {
+		log.Printf("Failed to record retention run: %s", redactError(err))
+	}
+}
+
+// selection returns the query for the policy's mail dated before cutoff, and the
+// description of it for the audit log
+func (policy *RetentionPolicy) selection(cutoff time.Time) (MessageQuery, string) {
+	before := "before:" + cutoff.Format("2006/01/02")
+	query := MessageQuery{LabelIDs: []string{policy.LabelID}, Query: before}
+	// Archiving only concerns what is still in the inbox
+	if policy.Action == ruleActionArchive && policy.LabelID != "INBOX" {
+		query.LabelIDs = append(query.LabelIDs, "INBOX")
+	}
+	return query, "label:" + policy.LabelName + " " + before
+}
+
+// enforce queues a job trashing or archiving the policy's mail that is past its
+// retention, which records a run report. Nothing is previewed first: setting up the
+// policy agreed to it. Like confirmed operations, what it trashes can be undone, and
+// it is audited and summarized to the account's notifiers.
+func (policy RetentionPolicy) enforce(mb *mailbox, account, trigger string, priority JobPriority) *Job {
+	return Jobs.Enqueue("retention-"+policy.Action, mb.userID, priority, func(job *Job) (interface{}, error) {
+		run := &RetentionRun{
+			ID:        newID(),
+			PolicyID:  policy.ID,
+			Trigger:   trigger,
+			Action:    policy.Action,
+			StartedAt: time.Now().UTC(),
+			DryRun:    mb.dryRun(),
+		}
+		// Gmail's before: searches by day
+		run.Cutoff = run.StartedAt.AddDate(0, 0, -policy.KeepDays).Truncate(24 * time.Hour)
+
+		err := policy.apply(mb, job, account, run)
+		if err != nil {
+			run.Error = redactError(err)
+		}
+		run.FinishedAt = time.Now().UTC()
+		recordRetentionRun(account, run)
+		return run, err
+	})
+}
+
+// apply trashes or archives the policy's mail dated before the run's cutoff, filling in
+// the run's report
+func (policy *RetentionPolicy) apply(mb *mailbox, job *Job, account string, run *RetentionRun) error {
+	query, target := policy.selection(run.Cutoff)
+	ids, err := listQueryMessageIDs(mb.forJob(job), query)
+	if err != nil {
+		notify(account, notification{
+			Title:   "Retention policy failed",
+			Message: fmt.Sprintf("Retention policy %s couldn't look up its mail: %s.", policy.Name, redactError(err)),
+			Failed:  true,
+		})
+		return err
+	}
+	run.Matched = len(ids)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	execute := trashOperation
+	if policy.Action == ruleActionArchive {
+		execute = func(mb *mailbox, job *Job, ids []string) (interface{}, error) {
+			return applyBulkAction(mb, ids, bulkActionArchive)
+		}
+	}
+	op := &Operation{
+		ID:      newID(),
+		Kind:    "retention-" + policy.Action,
+		Target:  target,
+		Summary: OperationSummary{Count: len(ids)},
+		account: account,
+		ids:     ids,
+		execute: execute,
+	}
+	result, err := op.run(mb, account, false, false, true)(job)
+
+	switch res := result.(type) {
+	case *TrashResult:
+		run.Affected, run.Protected, run.Failed = len(res.Trashed), len(res.Protected), len(res.Failed)
+		if res.Aborted && err == nil {
+			run.Error = res.AbortReason
+		}
+		if len(res.Trashed) > 0 && !run.DryRun {
+			run.OperationID = op.ID
+		}
+	case *ArchiveResult:
+		run.Affected, run.Failed = res.Archived, len(res.Failed)
+	}
+	return err
+}
+
+// enforceDueRetention starts the enabled retention policies of an account that weren't
+// enforced within retentionRunInterval of now
+func enforceDueRetention(account string, now time.Time) {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	policies, err := loadRetentionPolicies(account)
+	if err != nil {
+		log.Printf("Not enforcing retention policies: %s", redactError(err))
+		return
+	}
+	due := make([]int, 0)
+	for i, policy := range policies {
+		if policy.Enabled && (policy.LastRunAt == nil || now.Sub(*policy.LastRunAt) >= retentionRunInterval) {
+			due = append(due, i)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	mb, err := scheduledMailbox(account)
+	if err != nil {
+		log.Printf("Not enforcing retention policies: %s", redactError(err))
+		return
+	}
+	// Marked as run when started, so a slow run isn't started again by the next check
+	for _, i := range due {
+		policies[i].LastRunAt = &now
+	}
+	if err := Storage.Put(retentionKey(account), policies); err != nil {
+		log.Printf("Not enforcing retention policies: %s", redactError(err))
+		return
+	}
+	for _, i := range due {
+		policies[i].enforce(mb, account, retentionTriggerScheduled, PriorityBackground)
+	}
+}
+
+// decodeRetentionPolicy reads a policy from the request body, looking its label up in
+// the mailbox. It writes an error response and returns nil if the policy isn't valid.
+func decodeRetentionPolicy(w http.ResponseWriter, mb *mailbox, r *http.Request) *RetentionPolicy {
+	var req retentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return nil
+	}
+	policy := &RetentionPolicy{
+		Name:     strings.TrimSpace(req.Name),
+		KeepDays: req.KeepDays,
+		Action:   req.Action,
+		Enabled:  req.Enabled == nil || *req.Enabled,
+	}
+	if policy.Action == "" {
+		policy.Action = ruleActionTrash
+	}
+
+	switch {
+	case policy.Name == "":
+		writeError(w, "A policy name is required", http.StatusBadRequest)
+		return nil
+	case policy.KeepDays < 1:
+		writeError(w, "keepDays must be at least 1", http.StatusBadRequest)
+		return nil
+	case policy.Action != ruleActionTrash && policy.Action != ruleActionArchive:
+		writeError(w, "action must be trash or archive", http.StatusBadRequest)
+		return nil
+	}
+
+	label := strings.TrimSpace(req.Label)
+	if label == "" {
+		writeError(w, "A label is required", http.StatusBadRequest)
+		return nil
+	}
+	labels, err := listLabels(mb)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return nil
+	}
+	for _, l := range labels {
+		if l.Id == label || (policy.LabelID == "" && strings.EqualFold(l.Name, label)) {
+			policy.LabelID, policy.LabelName = l.Id, l.Name
+		}
+	}
+	switch policy.LabelID {
+	case "":
+		writeError(w, fmt.Sprintf("Label %q not found", label), http.StatusBadRequest)
+		return nil
+	case "TRASH", "SPAM":
+		writeError(w, "Mail in Trash and Spam is cleaned up by emptying them", http.StatusBadRequest)
+		return nil
+	}
+	return policy
+}
+
+// requestRetentionAccount returns the mailbox and account of a request that changes
+// retention policies, writing an error response and returning nil if that fails
+func requestRetentionAccount(w http.ResponseWriter, r *http.Request) (*mailbox, string) {
+	if !requireFeature(w, Features().ScheduledCleanup, "scheduled cleanup") {
+		return nil, ""
+	}
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return nil, ""
+	}
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return nil, ""
+	}
+	return mb, account
+}
+
+// HandleListRetentionPolicies returns the user's retention policies
+func HandleListRetentionPolicies(w http.ResponseWriter, r *http.Request) {
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	policies, err := loadRetentionPolicies(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, policies)
+}
+
+// HandleCreateRetentionPolicy adds a retention policy, which the scheduler enforces
+// daily from then on with the sign-in of the request
+func HandleCreateRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	mb, account := requestRetentionAccount(w, r)
+	if mb == nil {
+		return
+	}
+	policy := decodeRetentionPolicy(w, mb, r)
+	if policy == nil {
+		return
+	}
+	policy.ID = newID()
+	policy.CreatedAt = time.Now()
+	policy.UpdatedAt = policy.CreatedAt
+
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	policies, err := loadRetentionPolicies(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	if len(policies) >= maxRetentionPolicies {
+		writeError(w, fmt.Sprintf("An account may have at most %d retention policies", maxRetentionPolicies), http.StatusBadRequest)
+		return
+	}
+	if err := saveScheduleToken(mb, account); err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	policies = append(policies, *policy)
+	if err := Storage.Put(retentionKey(account), policies); err != nil {
+		writeErrorFrom(w, "Failed to save retention policy", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, policy)
+}
+
+// HandleUpdateRetentionPolicy replaces a retention policy, keeping when it last ran
+func HandleUpdateRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	mb, account := requestRetentionAccount(w, r)
+	if mb == nil {
+		return
+	}
+	policy := decodeRetentionPolicy(w, mb, r)
+	if policy == nil {
+		return
+	}
+
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	policies, err := loadRetentionPolicies(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	i := findRetentionPolicy(policies, id)
+	if i < 0 {
+		writeError(w, "Retention policy not found", http.StatusNotFound)
+		return
+	}
+	policy.ID = id
+	policy.CreatedAt = policies[i].CreatedAt
+	policy.UpdatedAt = time.Now()
+	policy.LastRunAt = policies[i].LastRunAt
+	policies[i] = *policy
+
+	if err := saveScheduleToken(mb, account); err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	if err := Storage.Put(retentionKey(account), policies); err != nil {
+		writeErrorFrom(w, "Failed to save retention policy", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, policy)
+}
+
+// HandleDeleteRetentionPolicy removes a retention policy and its run reports. Once the
+// last one is gone, the server forgets the sign-in it kept for them.
+func HandleDeleteRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	policies, err := loadRetentionPolicies(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	i := findRetentionPolicy(policies, id)
+	if i < 0 {
+		writeError(w, "Retention policy not found", http.StatusNotFound)
+		return
+	}
+	policies = slices.Delete(policies, i, i+1)
+	if err := Storage.Put(retentionKey(account), policies); err != nil {
+		writeErrorFrom(w, "Failed to delete retention policy", err, http.StatusInternalServerError)
+		return
+	}
+
+	if runs, err := loadRetentionRuns(account); err == nil {
+		runs = slices.DeleteFunc(runs, func(run RetentionRun) bool { return run.PolicyID == id })
+		if err := Storage.Put(retentionRunsKey(account), runs); err != nil {
+			log.Printf("Failed to delete retention runs: %s", redactError(err))
+		}
+	}
+	releaseScheduleToken(account)
+
+	writeJSON(w, policies)
+}
+
+// HandleRunRetentionPolicy enforces a retention policy right away, whether or not it is
+// enabled, and returns the job doing it. Its result is the run report.
+func HandleRunRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	mb, account := requestRetentionAccount(w, r)
+	if mb == nil {
+		return
+	}
+
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	policies, err := loadRetentionPolicies(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	i := findRetentionPolicy(policies, id)
+	if i < 0 {
+		writeError(w, "Retention policy not found", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	policies[i].LastRunAt = &now
+	if err := Storage.Put(retentionKey(account), policies); err != nil {
+		writeErrorFrom(w, "Failed to save retention policy", err, http.StatusInternalServerError)
+		return
+	}
+
+	job := policies[i].enforce(mb, account, retentionTriggerManual, PriorityUser)
+	snapshot, _ := Jobs.Get(job.ID)
+	writeJSON(w, snapshot)
+}
+
+// HandleGetRetentionRuns returns the reports of a retention policy's latest runs,
+// newest first
+func HandleGetRetentionRuns(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	policies, err := loadRetentionPolicies(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	if findRetentionPolicy(policies, id) < 0 {
+		writeError(w, "Retention policy not found", http.StatusNotFound)
+		return
+	}
+	runs, err := loadRetentionRuns(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, slices.DeleteFunc(runs, func(run RetentionRun) bool { return run.PolicyID != id }))
+}
@@ -0,0 +1,400 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+	"github.com/gorilla/mux"
+)
+
+// What a cleanup rule does with the messages it matches
+const (
+	ruleActionTrash   = "trash"
+	ruleActionArchive = "archive"
+	ruleActionLabel   = "label"
+	// Keeps matching messages safe from every other rule and bulk trash
+	ruleActionProtect = "protect"
+)
+
+// Serializes read-modify-write updates of rules
+var rulesMu sync.Mutex
+
+// RuleMatch selects messages by their collected metadata
+type RuleMatch = deepclean.RuleMatch
+
+// Rule is a saved cleanup policy: the messages it matches and what to do with them
+type Rule struct {
+	ID     string    `json:"id"`
+	Name   string    `json:"name"`
+	Match  RuleMatch `json:"match"`
+	Action string    `json:"action"`
+	// Label name applied by the label action; created if it doesn't exist
+	Label     string    `json:"label,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// RuleSimulation reports what a rule would do if run now against the collected metadata
+type RuleSimulation struct {
+	RuleID    string          `json:"ruleId"`
+	Name      string          `json:"name"`
+	Action    string          `json:"action"`
+	Count     int             `json:"count"`
+	TotalSize int64           `json:"totalSize"`
+	Savings   string          `json:"savings"`
+	Sample    []MessageSample `json:"sample"`
+}
+
+// rulesKey is the storage key of an account's rules
+func rulesKey(account string) string {
+	return "rules/" + account
+}
+
+// loadRules returns an account's saved rules
+func loadRules(account string) ([]Rule, error) {
+	rules := make([]Rule, 0)
+	if _, err := Storage.Get(rulesKey(account), &rules); err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+	return rules, nil
+}
+
+// validate normalizes a rule and checks it can be run
+func (rule *Rule) validate() error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	rule.Label = strings.TrimSpace(rule.Label)
+	m := &rule.Match
+	m.Sender = strings.ToLower(strings.TrimSpace(m.Sender))
+	m.Domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(m.Domain), "@"))
+	m.Label = strings.TrimSpace(m.Label)
+
+	if rule.Name == "" {
+		return fmt.Errorf("a rule name is required")
+	}
+	if err := validateCategory(m.Category); err != nil {
+		return err
+	}
+	if m.OlderThanDays < 0 || m.LargerThan < 0 {
+		return fmt.Errorf("olderThanDays and largerThan can't be negative")
+	}
+	if *m == (RuleMatch{}) {
+		return fmt.Errorf("a rule must set at least one match condition")
+	}
+
+	switch rule.Action {
+	case ruleActionTrash, ruleActionArchive, ruleActionProtect:
+	case ruleActionLabel:
+		if rule.Label == "" {
+			return fmt.Errorf("a label is required for the label action")
+		}
+	default:
+		return fmt.Errorf("action must be trash, archive, label or protect")
+	}
+	return nil
+}
+
+// RuleMatches returns the collected messages a rule matches. Messages matched by any of
+// the protect rules are left out of every other rule.
+func (p *InboxProcessor) RuleMatches(rule *Rule, rules []Rule) []EmailMetadata {
+	now := time.Now()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	matched := make([]EmailMetadata, 0)
+	p.emails.each(func(_ int, email *EmailMetadata) bool {
+		if !rule.Match.Matches(email, now) {
+			return true
+		}
+		if rule.Action != ruleActionProtect && protectedByRules(email, rules, now) {
+			return true
+		}
+		matched = append(matched, *email)
+		return true
+	})
+	return matched
+}
+
+// protectedByRules reports whether any protect rule matches a message
+func protectedByRules(e *EmailMetadata, rules []Rule, now time.Time) bool {
+	for i := range rules {
+		if rules[i].Action == ruleActionProtect && rules[i].Match.Matches(e, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Protect rules match the scanned messages, so without a scan nothing can be deleted
+// safely while the account has any
+var errProtectRulesUnscanned = errors.New("protect rules can only be checked against a scan of the mailbox; scan it before deleting messages")
+
+// ruleProtectedIDs returns the collected messages covered by the account's protect rules.
+// It fails if the account has protect rules but no scan to evaluate them against.
+func ruleProtectedIDs(mb *mailbox, account string) (map[string]struct{}, error) {
+	rules, err := loadRules(account)
+	if err != nil {
+		return nil, err
+	}
+	protected := make(map[string]struct{})
+	var processor *InboxProcessor
+	for i := range rules {
+		if rules[i].Action != ruleActionProtect {
+			continue
+		}
+		if processor == nil {
+			var exists bool
+			if processor, exists = Registry.Get(mb.userID); !exists {
+				return nil, errProtectRulesUnscanned
+			}
+		}
+		for _, email := range processor.RuleMatches(&rules[i], rules) {
+			protected[email.ID] = struct{}{}
+		}
+	}
+	return protected, nil
+}
+
+// simulate describes what a rule would do to the collected messages
+func (p *InboxProcessor) simulate(rule *Rule, rules []Rule) RuleSimulation {
+	matched := p.RuleMatches(rule, rules)
+	simulation := RuleSimulation{
+		RuleID: rule.ID,
+		Name:   rule.Name,
+		Action: rule.Action,
+		Count:  len(matched),
+		Sample: make([]MessageSample, 0, operationSampleSize),
+	}
+	for i := range matched {
+		simulation.TotalSize += matched[i].Size()
+		if len(simulation.Sample) < operationSampleSize {
+			simulation.Sample = append(simulation.Sample, MessageSample{
+				ID:      matched[i].ID,
+				From:    matched[i].From,
+				Subject: matched[i].Subject,
+				Date:    matched[i].Date.Format(time.RFC1123Z),
+				Size:    matched[i].Size(),
+			})
+		}
+	}
+	simulation.Savings = describeSavings(simulation.Count, simulation.TotalSize, false)
+	return simulation
+}
+
+// requestRules loads the rules of the request's account together with its mailbox
+func requestRules(w http.ResponseWriter, r *http.Request) (*mailbox, string, []Rule) {
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return nil, "", nil
+	}
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return nil, "", nil
+	}
+	rules, err := loadRules(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return nil, "", nil
+	}
+	return mb, account, rules
+}
+
+// findRule returns the index of the rule with the given ID, or -1
+func findRule(rules []Rule, id string) int {
+	for i := range rules {
+		if rules[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// updateRules applies change to the request's account's rules and saves them. change
+// writes its own error response and returns false to abort.
+func updateRules(w http.ResponseWriter, r *http.Request, change func(rules []Rule) ([]Rule, bool)) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	_, account, rules := requestRules(w, r)
+	if rules == nil {
+		return
+	}
+	rules, ok := change(rules)
+	if !ok {
+		return
+	}
+	if err := Storage.Put(rulesKey(account), rules); err != nil {
+		writeErrorFrom(w, "Failed to save rules", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, rules)
+}
+
+// decodeRule reads and validates a rule from the request body
+func decodeRule(w http.ResponseWriter, r *http.Request) (*Rule, bool) {
+	var rule Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return nil, false
+	}
+	if err := rule.validate(); err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return nil, false
+	}
+	return &rule, true
+}
+
+// HandleListRules returns the user's cleanup rules
+func HandleListRules(w http.ResponseWriter, r *http.Request) {
+	_, _, rules := requestRules(w, r)
+	if rules == nil {
+		return
+	}
+	writeJSON(w, rules)
+}
+
+// HandleCreateRule adds a cleanup rule
+func HandleCreateRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := decodeRule(w, r)
+	if !ok {
+		return
+	}
+	rule.ID = newID()
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = rule.CreatedAt
+
+	updateRules(w, r, func(rules []Rule) ([]Rule, bool) {
+		return append(rules, *rule), true
+	})
+}
+
+// HandleUpdateRule replaces a cleanup rule
+func HandleUpdateRule(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	rule, ok := decodeRule(w, r)
+	if !ok {
+		return
+	}
+
+	updateRules(w, r, func(rules []Rule) ([]Rule, bool) {
+		i := findRule(rules, id)
+		if i < 0 {
+			writeError(w, "Rule not found", http.StatusNotFound)
+			return nil, false
+		}
+		rule.ID = id
+		rule.CreatedAt = rules[i].CreatedAt
+		rule.UpdatedAt = time.Now()
+		rules[i] = *rule
+		return rules, true
+	})
+}
+
+// HandleDeleteRule removes a cleanup rule
+func HandleDeleteRule(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	updateRules(w, r, func(rules []Rule) ([]Rule, bool) {
+		i := findRule(rules, id)
+		if i < 0 {
+			writeError(w, "Rule not found", http.StatusNotFound)
+			return nil, false
+		}
+		return append(rules[:i], rules[i+1:]...), true
+	})
+}
+
+// HandleSimulateRules reports what each of the user's rules, or only the one given by
+// `id`, would do to the mail collected by the last scan, without changing anything
+func HandleSimulateRules(w http.ResponseWriter, r *http.Request) {
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return
+	}
+	_, _, rules := requestRules(w, r)
+	if rules == nil {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	simulations := make([]RuleSimulation, 0, len(rules))
+	for i := range rules {
+		if id == "" || rules[i].ID == id {
+			simulations = append(simulations, processor.simulate(&rules[i], rules))
+		}
+	}
+	if id != "" && len(simulations) == 0 {
+		writeError(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, simulations)
+}
+
+// HandleRunRule executes a rule against the mail collected by the last scan. Trash
+// rules return an operation preview to confirm like other bulk trashes; archive and
+// label rules are queued right away and the job is returned.
+func HandleRunRule(w http.ResponseWriter, r *http.Request) {
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return
+	}
+	mb, _, rules := requestRules(w, r)
+	if rules == nil || !requireModifyAccess(w, mb.token) {
+		return
+	}
+
+	i := findRule(rules, mux.Vars(r)["id"])
+	if i < 0 {
+		writeError(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+	rule := rules[i]
+
+	if rule.Action == ruleActionProtect {
+		writeError(w, "Protect rules apply automatically and can't be run", http.StatusBadRequest)
+		return
+	}
+
+	matchedIDs := func() []string {
+		matched := processor.RuleMatches(&rule, rules)
+		ids := make([]string, len(matched))
+		for i := range matched {
+			ids[i] = matched[i].ID
+		}
+		return ids
+	}
+
+	if rule.Action == ruleActionTrash {
+		handleOperationPreview(w, r, mb, "rule-trash", "rule:"+rule.Name, func() ([]string, error) {
+			return matchedIDs(), nil
+		}, trashOperation)
+		return
+	}
+
+	job := Jobs.Enqueue("rule-"+rule.Action, mb.userID, PriorityUser, func(job *Job) (interface{}, error) {
+		ids := matchedIDs()
+		switch rule.Action {
+		case ruleActionArchive:
+			return applyBulkAction(mb, ids, bulkActionArchive)
+		default:
+			labelID, err := findOrCreateLabel(mb, rule.Label)
+			if err != nil {
+				return nil, err
+			}
+			if err := batchModify(mb, ids, []string{labelID}, nil); err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"labeled": len(ids), "label": rule.Label}, nil
+		}
+	})
+	snapshot, _ := Jobs.Get(job.ID)
+	writeJSON(w, snapshot)
+}
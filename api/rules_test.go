@@ -0,0 +1,31 @@
+package api
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTrashMessagesAbortsOnUnscannedProtectRules(t *testing.T) {
+	user := newTestUser(t, senderMessages("deal", "deals@shop.example", 2, 0)...)
+	rules := []Rule{{
+		ID:     "keep-shop",
+		Name:   "Keep the shop",
+		Match:  RuleMatch{Domain: "shop.example"},
+		Action: ruleActionProtect,
+	}}
+	if err := Storage.Put(rulesKey(user.account()), rules); err != nil {
+		t.Fatal(err)
+	}
+
+	mb := user.mailbox(t)
+	if _, err := ruleProtectedIDs(mb, user.account()); !errors.Is(err, errProtectRulesUnscanned) {
+		t.Fatalf("ruleProtectedIDs error = %v, want %v", err, errProtectRulesUnscanned)
+	}
+	result := trashMessages(mb, []string{"deala", "dealb"})
+	if !result.Aborted || len(result.Trashed) != 0 {
+		t.Errorf("result = %+v, want an aborted trash", result)
+	}
+	if messageHasLabel(t, user.server, "deala", "TRASH") {
+		t.Error("message trashed although the protect rules couldn't be checked")
+	}
+}
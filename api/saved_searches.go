@@ -0,0 +1,247 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Where a saved search runs
+const (
+	// Against the metadata collected by the scan, like HandleSearchEmails
+	savedSearchLocal = "local"
+	// As a Gmail search, like HandleGetEmails
+	savedSearchGmail = "gmail"
+)
+
+// Query parameters a local saved search may store
+var localSearchParams = map[string]bool{
+	"sender": true, "domain": true, "subject": true, "label": true, "minSize": true,
+	"maxSize": true, "after": true, "before": true, "sort": true, "order": true,
+}
+
+// Serializes read-modify-write updates of saved searches
+var savedSearchesMu sync.Mutex
+
+// SavedSearch is a named search a user can re-run in one click, such as "big old
+// attachments"
+type SavedSearch struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// local or gmail
+	Type string `json:"type"`
+	// Filters of a local search, by query parameter name
+	Params map[string]string `json:"params,omitempty"`
+	// Gmail search query of a gmail search
+	Query string `json:"query,omitempty"`
+	// Label IDs a gmail search is limited to
+	LabelIDs  []string  `json:"labelIds,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// savedSearchesKey is the storage key of an account's saved searches
+func savedSearchesKey(account string) string {
+	return "saved-searches/" + account
+}
+
+// loadSavedSearches returns an account's saved searches
+func loadSavedSearches(account string) ([]SavedSearch, error) {
+	searches := make([]SavedSearch, 0)
+	if _, err := Storage.Get(savedSearchesKey(account), &searches); err != nil {
+		return nil, fmt.Errorf("failed to load saved searches: %w", err)
+	}
+	return searches, nil
+}
+
+// validate normalizes a saved search and checks it can be run
+func (s *SavedSearch) validate() error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return fmt.Errorf("a name is required")
+	}
+
+	switch s.Type {
+	case savedSearchLocal:
+		if len(s.Params) == 0 {
+			return fmt.Errorf("a local search needs at least one filter")
+		}
+		values := url.Values{}
+		for key, value := range s.Params {
+			if !localSearchParams[key] {
+				return fmt.Errorf("unknown local search filter %q", key)
+			}
+			values.Set(key, value)
+		}
+		// Reuse the search endpoint's own validation
+		if _, err := parseMetadataSearch(&http.Request{URL: &url.URL{RawQuery: values.Encode()}}); err != nil {
+			return err
+		}
+		s.Query, s.LabelIDs = "", nil
+	case savedSearchGmail:
+		s.Query = strings.TrimSpace(s.Query)
+		if s.Query == "" && len(s.LabelIDs) == 0 {
+			return fmt.Errorf("a gmail search needs a query or labels")
+		}
+		s.Params = nil
+	default:
+		return fmt.Errorf("type must be local or gmail")
+	}
+	return nil
+}
+
+// accountForRequest resolves the account of the request's mailbox, writing an error
+// response and returning "" if it can't be determined
+func accountForRequest(w http.ResponseWriter, r *http.Request) string {
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return ""
+	}
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return ""
+	}
+	return account
+}
+
+// HandleListSavedSearches returns the user's saved searches
+func HandleListSavedSearches(w http.ResponseWriter, r *http.Request) {
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	searches, err := loadSavedSearches(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, searches)
+}
+
+// HandleCreateSavedSearch saves a named search
+func HandleCreateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	var search SavedSearch
+	if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if err := search.validate(); err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+	search.ID = newID()
+	search.CreatedAt = time.Now()
+
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	savedSearchesMu.Lock()
+	defer savedSearchesMu.Unlock()
+
+	searches, err := loadSavedSearches(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	searches = append(searches, search)
+	if err := Storage.Put(savedSearchesKey(account), searches); err != nil {
+		writeErrorFrom(w, "Failed to save search", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, search)
+}
+
+// HandleDeleteSavedSearch removes a saved search
+func HandleDeleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	savedSearchesMu.Lock()
+	defer savedSearchesMu.Unlock()
+
+	searches, err := loadSavedSearches(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	kept := make([]SavedSearch, 0, len(searches))
+	for _, search := range searches {
+		if search.ID != id {
+			kept = append(kept, search)
+		}
+	}
+	if len(kept) == len(searches) {
+		writeError(w, "Saved search not found", http.StatusNotFound)
+		return
+	}
+	if err := Storage.Put(savedSearchesKey(account), kept); err != nil {
+		writeErrorFrom(w, "Failed to delete search", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, kept)
+}
+
+// HandleRunSavedSearch runs a saved search and writes its results in the same shape as
+// HandleSearchEmails for local searches or HandleGetEmails for gmail searches. Paging
+// parameters (offset and limit, or maxResults and pageToken) are taken from the request.
+func HandleRunSavedSearch(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	searches, err := loadSavedSearches(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	var search *SavedSearch
+	for i := range searches {
+		if searches[i].ID == id {
+			search = &searches[i]
+		}
+	}
+	if search == nil {
+		writeError(w, "Saved search not found", http.StatusNotFound)
+		return
+	}
+
+	// Run the search through its endpoint with the saved filters in place of the
+	// request's own
+	query := url.Values{}
+	for _, key := range []string{"offset", "limit", "maxResults", "pageToken"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			query.Set(key, v)
+		}
+	}
+	switch search.Type {
+	case savedSearchLocal:
+		for key, value := range search.Params {
+			query.Set(key, value)
+		}
+		r.URL.RawQuery = query.Encode()
+		HandleSearchEmails(w, r)
+	case savedSearchGmail:
+		query.Set("q", search.Query)
+		for _, label := range search.LabelIDs {
+			query.Add("labelIds", label)
+		}
+		r.URL.RawQuery = query.Encode()
+		HandleGetEmails(w, r)
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+)
+
+// scanCheckpoint is the saved state of an unfinished scan. The messages collected so far
+// are saved separately in chunks, each holding those collected since the previous
+// checkpoint, so a checkpoint never rewrites what is already on disk.
+type scanCheckpoint struct {
+	User      string      `json:"user"`
+	Scope     ScanScope   `json:"scope"`
+	PageToken string      `json:"pageToken"`
+	Stats     *EmailStats `json:"stats"`
+	// Senders of each mailing list, which EmailStats doesn't serialize
+	ListSenders map[string][]string `json:"listSenders"`
+	// Number of message chunks saved, and the messages they hold
+	Chunks   int       `json:"chunks"`
+	Messages int       `json:"messages"`
+	SavedAt  time.Time `json:"savedAt"`
+}
+
+// scanCheckpointPrefix is the storage prefix of an account's scan checkpoint and its
+// messages. Keyed by account, a scan resumes after the user's access token changes.
+func scanCheckpointPrefix(account string) string {
+	return "scan-checkpoints/" + account + "/"
+}
+
+// scanCheckpointKey is the storage key of an account's scan checkpoint
+func scanCheckpointKey(account string) string {
+	return scanCheckpointPrefix(account) + "checkpoint"
+}
+
+// scanChunkKey is the storage key of one chunk of a checkpointed scan's messages
+func scanChunkKey(account string, chunk int) string {
+	return scanCheckpointPrefix(account) + "emails/" + strconv.Itoa(chunk)
+}
+
+// checkpoint saves the scan's progress after a finished page so it can be resumed
+// after a restart from pageToken, the next page to list
+func (p *InboxProcessor) checkpoint(pageToken string) error {
+	p.mu.RLock()
+	chunk := make([]EmailMetadata, 0, p.emails.len()-p.checkpointed)
+	p.emails.each(func(i int, email *EmailMetadata) bool {
+		if i >= p.checkpointed {
+			chunk = append(chunk, *email)
+		}
+		return true
+	})
+	p.mu.RUnlock()
+
+	cp := scanCheckpoint{
+		User:        p.user,
+		Scope:       p.scope,
+		PageToken:   pageToken,
+		Stats:       p.stats,
+		ListSenders: make(map[string][]string),
+		Chunks:      p.checkpointChunks,
+		Messages:    p.checkpointed,
+		SavedAt:     time.Now(),
+	}
+	if len(chunk) > 0 {
+		if err := Storage.Put(scanChunkKey(p.account, cp.Chunks), chunk); err != nil {
+			return fmt.Errorf("failed to save scanned messages: %w", err)
+		}
+		cp.Chunks++
+		cp.Messages += len(chunk)
+	}
+
+	// Hold the stats lock while they are serialized
+	cp.ListSenders = p.stats.ListSenders()
+	p.stats.RLock()
+	defer p.stats.RUnlock()
+	if err := Storage.Put(scanCheckpointKey(p.account), cp); err != nil {
+		return fmt.Errorf("failed to save scan checkpoint: %w", err)
+	}
+
+	p.checkpointChunks, p.checkpointed = cp.Chunks, cp.Messages
+	return nil
+}
+
+// resume restores the progress of an unfinished scan of the same mailbox and scope,
+// reporting whether there was one. A checkpoint for a different scope is discarded,
+// since its results wouldn't match what was asked for.
+func (p *InboxProcessor) resume() (bool, error) {
+	// Decode into fresh stats so every map exists even if the checkpoint lacks it
+	cp := scanCheckpoint{Stats: deepclean.NewStats()}
+	found, err := Storage.Get(scanCheckpointKey(p.account), &cp)
+	if err != nil || !found {
+		return false, err
+	}
+	if cp.User != p.user || !cp.Scope.equal(p.scope) {
+		p.checkpointChunks = cp.Chunks
+		p.clearCheckpoint()
+		return false, nil
+	}
+
+	stats := cp.Stats
+	stats.SetListSenders(cp.ListSenders)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := 0; i < cp.Chunks; i++ {
+		var chunk []EmailMetadata
+		if _, err := Storage.Get(scanChunkKey(p.account, i), &chunk); err != nil {
+			return false, fmt.Errorf("failed to load scanned messages: %w", err)
+		}
+		for _, email := range chunk {
+			if err := p.emails.append(email); err != nil {
+				return false, err
+			}
+		}
+	}
+	p.stats = stats
+	p.pageToken = cp.PageToken
+	p.checkpointChunks, p.checkpointed = cp.Chunks, p.emails.len()
+	p.resumed = true
+
+	log.Printf("Resuming scan from checkpoint with %d messages", p.checkpointed)
+	return true, nil
+}
+
+// clearCheckpoint deletes the saved progress of the scan
+func (p *InboxProcessor) clearCheckpoint() {
+	for i := 0; i < p.checkpointChunks; i++ {
+		if err := Storage.Delete(scanChunkKey(p.account, i)); err != nil {
+			log.Printf("Failed to delete scan checkpoint: %s", redactError(err))
+		}
+	}
+	if err := Storage.Delete(scanCheckpointKey(p.account)); err != nil {
+		log.Printf("Failed to delete scan checkpoint: %s", redactError(err))
+	}
+	p.checkpointChunks, p.checkpointed = 0, 0
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ScanScope narrows a scan to part of the mailbox. The zero value scans everything.
+type ScanScope struct {
+	// Only messages carrying all of these label IDs, e.g. CATEGORY_PROMOTIONS
+	LabelIDs []string `json:"labelIds,omitempty"`
+	// Gmail search query the messages must match
+	Query string `json:"query,omitempty"`
+	// Only messages received on or after this day (YYYY-MM-DD)
+	After string `json:"after,omitempty"`
+	// Only messages received before this day (YYYY-MM-DD)
+	Before string `json:"before,omitempty"`
+	// Also scan Spam and Trash. Their messages are only counted in the junk stats.
+	IncludeSpamTrash bool `json:"includeSpamTrash,omitempty"`
+}
+
+// equal reports whether two scopes cover the same messages
+func (s ScanScope) equal(o ScanScope) bool {
+	return slices.Equal(s.LabelIDs, o.LabelIDs) && s.Query == o.Query && s.After == o.After &&
+		s.Before == o.Before && s.IncludeSpamTrash == o.IncludeSpamTrash
+}
+
+// parseScanScope reads the optional `labelIds` (comma-separated or repeated), `q`,
+// `after`, `before` and `includeSpamTrash` query parameters of a scan request
+func parseScanScope(r *http.Request) (ScanScope, error) {
+	query := r.URL.Query()
+	scope := ScanScope{
+		Query:            strings.TrimSpace(query.Get("q")),
+		After:            query.Get("after"),
+		Before:           query.Get("before"),
+		IncludeSpamTrash: query.Get("includeSpamTrash") == "true",
+	}
+	for _, v := range query["labelIds"] {
+		for _, label := range strings.Split(v, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				scope.LabelIDs = append(scope.LabelIDs, label)
+			}
+		}
+	}
+	return scope, scope.validate()
+}
+
+// validate checks the date range of a scope
+func (s *ScanScope) validate() error {
+	var after, before time.Time
+	var err error
+	if s.After != "" {
+		if after, err = time.Parse("2006-01-02", s.After); err != nil {
+			return fmt.Errorf("after must be a date in YYYY-MM-DD format")
+		}
+	}
+	if s.Before != "" {
+		if before, err = time.Parse("2006-01-02", s.Before); err != nil {
+			return fmt.Errorf("before must be a date in YYYY-MM-DD format")
+		}
+	}
+	if !after.IsZero() && !before.IsZero() && !after.Before(before) {
+		return fmt.Errorf("after must be earlier than before")
+	}
+	return nil
+}
+
+// searchQuery combines the query and date range into a Gmail search
+func (s *ScanScope) searchQuery() string {
+	terms := make([]string, 0, 3)
+	if s.Query != "" {
+		terms = append(terms, "("+s.Query+")")
+	}
+	// Gmail search dates are written YYYY/MM/DD
+	if s.After != "" {
+		terms = append(terms, "after:"+strings.ReplaceAll(s.After, "-", "/"))
+	}
+	if s.Before != "" {
+		terms = append(terms, "before:"+strings.ReplaceAll(s.Before, "-", "/"))
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Cleanups a user sets up to run on their own, retention policies and automatic trash
+// emptying, are run by the scheduler with a copy of the user's OAuth token kept for the
+// account. The token is saved whenever the user sets one up, and forgotten once the
+// account has none left.
+
+// Storage prefix of the tokens scheduled cleanups run with
+const scheduleTokenPrefix = "schedule-tokens/"
+
+// scheduleToken is the OAuth token the scheduler acts on an account's mailbox with
+type scheduleToken struct {
+	Token *oauth2.Token `json:"token,omitempty"`
+	// Workspace user a delegated token acts as. Delegated tokens can't be refreshed, so
+	// a new one is issued for every run instead of saving the token.
+	Subject string `json:"subject,omitempty"`
+	// Scopes Google granted the token, which don't survive encoding it
+	Scopes []string `json:"scopes,omitempty"`
+	// Mailbox the token acts on, "me" unless it is delegated
+	User    string    `json:"user"`
+	SavedAt time.Time `json:"savedAt"`
+}
+
+// scheduleTokenKey is the storage key of the token an account's scheduled cleanups run
+// with
+func scheduleTokenKey(account string) string {
+	return scheduleTokenPrefix + account
+}
+
+// saveScheduleToken keeps the mailbox's token for the scheduled cleanups of its account,
+// replacing the one saved before, so the latest sign-in is used
+func saveScheduleToken(mb *mailbox, account string) error {
+	saved := &scheduleToken{Scopes: tokenScopes(mb.token), User: mb.user, SavedAt: time.Now()}
+	if subject := delegatedSubject(mb.token); subject != "" {
+		saved.Subject = subject
+	} else {
+		saved.Token = mb.token
+	}
+	if err := Storage.Put(scheduleTokenKey(account), saved); err != nil {
+		return fmt.Errorf("failed to save sign-in for scheduled cleanups: %w", err)
+	}
+	return nil
+}
+
+// releaseScheduleToken forgets an account's saved token if it has no scheduled cleanups
+// left
+func releaseScheduleToken(account string) {
+	policies, err := loadRetentionPolicies(account)
+	if err != nil || len(policies) > 0 {
+		return
+	}
+	autoEmpty, err := loadAutoEmpty(account)
+	if err != nil || autoEmpty.Enabled {
+		return
+	}
+	if err := Storage.Delete(scheduleTokenKey(account)); err != nil {
+		log.Printf("Failed to forget sign-in for scheduled cleanups: %s", redactError(err))
+	}
+}
+
+// scheduledMailbox returns the mailbox the scheduler acts on for an account
+func scheduledMailbox(account string) (*mailbox, error) {
+	var saved scheduleToken
+	found, err := Storage.Get(scheduleTokenKey(account), &saved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sign-in for scheduled cleanups: %w", err)
+	}
+	if !found || (saved.Token == nil && saved.Subject == "") {
+		return nil, fmt.Errorf("no sign-in saved for scheduled cleanups")
+	}
+	if saved.Subject != "" {
+		token, err := DelegatedToken(saved.Subject)
+		if err != nil {
+			return nil, err
+		}
+		return newMailbox(token, saved.User)
+	}
+	return newMailbox(withScopes(saved.Token, saved.Scopes), saved.User)
+}
+
+// runScheduler starts the scheduled cleanups that are due every interval
+func runScheduler(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		runScheduledCleanups(now)
+	}
+}
+
+// runScheduledCleanups starts the scheduled cleanups of every account that are due at
+// now, as background jobs
+func runScheduledCleanups(now time.Time) {
+	if !Features().ScheduledCleanup {
+		return
+	}
+	keys, err := Storage.List(scheduleTokenPrefix)
+	if err != nil {
+		log.Printf("Failed to list accounts with scheduled cleanups: %s", redactError(err))
+		return
+	}
+	for _, key := range keys {
+		account := strings.TrimPrefix(key, scheduleTokenPrefix)
+		enforceDueRetention(account, now)
+		emptyDueTrash(account, now)
+	}
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestScheduleTokenOfDelegatedSession(t *testing.T) {
+	token := (&oauth2.Token{AccessToken: "ya29.c.delegated", TokenType: "Bearer"}).WithExtra(map[string]interface{}{delegatedSubjectKey: "worker@corp.example"})
+	mb := &mailbox{token: token, user: "me"}
+	if err := saveScheduleToken(mb, "worker@corp.example"); err != nil {
+		t.Fatal(err)
+	}
+
+	var saved scheduleToken
+	if _, err := Storage.Get(scheduleTokenKey("worker@corp.example"), &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Subject != "worker@corp.example" || saved.Token != nil {
+		t.Errorf("saved %+v, want the subject instead of the short-lived token", saved)
+	}
+
+	// Without a service account there is no way to act as the user any more
+	if _, err := scheduledMailbox("worker@corp.example"); err == nil || !strings.Contains(err.Error(), "delegation is not configured") {
+		t.Errorf("scheduledMailbox error = %v, want delegation to be needed", err)
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Most results one page of a local search may return
+const maxSearchPageSize = 500
+
+// MetadataSearch filters collected messages. Every field that is set must match.
+type MetadataSearch struct {
+	// Exact sender address
+	Sender string
+	// Sender domain, matching subdomains too
+	Domain string
+	// Case-insensitive substring of the subject
+	Subject string
+	// Label ID the message must carry
+	Label   string
+	MinSize int64
+	// Zero means no upper bound
+	MaxSize int64
+	// Messages on or after this time
+	After time.Time
+	// Messages before this time; zero means no bound
+	Before time.Time
+}
+
+// matches reports whether a message meets every condition of the search
+func (s *MetadataSearch) matches(e *EmailMetadata) bool {
+	from := strings.ToLower(e.From)
+	switch {
+	case s.Sender != "" && from != s.Sender:
+	case s.Domain != "" && !strings.HasSuffix(from, "@"+s.Domain) && !strings.HasSuffix(from, "."+s.Domain):
+	case s.Subject != "" && !strings.Contains(strings.ToLower(e.Subject), s.Subject):
+	case s.Label != "" && !hasLabel(e, s.Label):
+	case e.Size() < s.MinSize:
+	case s.MaxSize > 0 && e.Size() > s.MaxSize:
+	case !s.After.IsZero() && e.Date.Before(s.After):
+	case !s.Before.IsZero() && !e.Date.Before(s.Before):
+	default:
+		return true
+	}
+	return false
+}
+
+// Search returns copies of the collected messages matching the search
+func (p *InboxProcessor) Search(search *MetadataSearch) []EmailMetadata {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	results := make([]EmailMetadata, 0)
+	p.emails.each(func(_ int, email *EmailMetadata) bool {
+		if search.matches(email) {
+			results = append(results, *email)
+		}
+		return true
+	})
+	return results
+}
+
+// parseMetadataSearch reads the search filters from the query parameters
+func parseMetadataSearch(r *http.Request) (*MetadataSearch, error) {
+	query := r.URL.Query()
+	search := &MetadataSearch{
+		Sender:  strings.ToLower(strings.TrimSpace(query.Get("sender"))),
+		Domain:  strings.ToLower(strings.TrimPrefix(strings.TrimSpace(query.Get("domain")), "@")),
+		Subject: strings.ToLower(query.Get("subject")),
+		Label:   query.Get("label"),
+	}
+
+	var err error
+	if v := query.Get("minSize"); v != "" {
+		if search.MinSize, err = strconv.ParseInt(v, 10, 64); err != nil || search.MinSize < 0 {
+			return nil, fmt.Errorf("minSize must be a number of bytes")
+		}
+	}
+	if v := query.Get("maxSize"); v != "" {
+		if search.MaxSize, err = strconv.ParseInt(v, 10, 64); err != nil || search.MaxSize < 1 {
+			return nil, fmt.Errorf("maxSize must be a positive number of bytes")
+		}
+	}
+	if v := query.Get("after"); v != "" {
+		if search.After, err = time.Parse("2006-01-02", v); err != nil {
+			return nil, fmt.Errorf("after must be a date in YYYY-MM-DD format")
+		}
+	}
+	if v := query.Get("before"); v != "" {
+		if search.Before, err = time.Parse("2006-01-02", v); err != nil {
+			return nil, fmt.Errorf("before must be a date in YYYY-MM-DD format")
+		}
+	}
+	return search, nil
+}
+
+// HandleSearchEmails searches the metadata collected by the scan without calling Gmail.
+// It filters by `sender`, `domain`, `subject` (substring), `label`, `minSize`/`maxSize`
+// (bytes) and `after`/`before` (YYYY-MM-DD), sorts by `sort` (date, size or from) in
+// `order` (asc or desc, default desc) and pages with `offset` and `limit`.
+func HandleSearchEmails(w http.ResponseWriter, r *http.Request) {
+	search, err := parseMetadataSearch(r)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	offset, limit := 0, 50
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			writeError(w, "offset must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxSearchPageSize {
+			writeError(w, "limit must be between 1 and "+strconv.Itoa(maxSearchPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var less func(a, b *EmailMetadata) bool
+	switch query.Get("sort") {
+	case "", "date":
+		less = func(a, b *EmailMetadata) bool { return a.Date.Before(b.Date) }
+	case "size":
+		less = func(a, b *EmailMetadata) bool { return a.Size() < b.Size() }
+	case "from":
+		less = func(a, b *EmailMetadata) bool { return a.From < b.From }
+	default:
+		writeError(w, "sort must be date, size or from", http.StatusBadRequest)
+		return
+	}
+	desc := true
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		desc = false
+	default:
+		writeError(w, "order must be asc or desc", http.StatusBadRequest)
+		return
+	}
+
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return
+	}
+
+	results := processor.Search(search)
+	sort.SliceStable(results, func(i, j int) bool {
+		if desc {
+			return less(&results[j], &results[i])
+		}
+		return less(&results[i], &results[j])
+	})
+
+	page := results[min(offset, len(results)):min(offset+limit, len(results))]
+	writeJSON(w, map[string]interface{}{
+		"emails": page,
+		"total":  len(results),
+		"offset": offset,
+		"limit":  limit,
+	})
+}
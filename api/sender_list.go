@@ -0,0 +1,164 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// More ways a full sender listing may be sorted, besides count and size
+const (
+	sendersByUnread    = "unread"
+	sendersByFirstSeen = "firstSeen"
+	sendersByLastSeen  = "lastSeen"
+	sendersByEmail     = "email"
+)
+
+// SenderSummary is everything collected about one sender's mail
+type SenderSummary struct {
+	Email  string `json:"email"`
+	Count  int    `json:"count"`
+	Size   int64  `json:"size"`
+	Unread int    `json:"unread"`
+	// Dates of the sender's earliest and latest messages; zero if none had a date
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Category  string    `json:"category,omitempty"`
+}
+
+// Senders summarizes every sender in the collected messages, optionally only those
+// whose mail mostly falls into category
+func (p *InboxProcessor) Senders(category string) []SenderSummary {
+	p.mu.RLock()
+	bySender := make(map[string]*SenderSummary)
+	p.emails.each(func(_ int, email *EmailMetadata) bool {
+		sender, ok := bySender[email.From]
+		if !ok {
+			sender = &SenderSummary{Email: email.From}
+			bySender[email.From] = sender
+		}
+		sender.Count++
+		sender.Size += email.Size()
+		if hasLabel(email, "UNREAD") {
+			sender.Unread++
+		}
+		return true
+	})
+	p.mu.RUnlock()
+
+	p.stats.RLock()
+	defer p.stats.RUnlock()
+	senders := make([]SenderSummary, 0, len(bySender))
+	for _, sender := range bySender {
+		sender.Category = p.stats.FromCategory[sender.Email]
+		sender.FirstSeen = p.stats.FromFirstSeen[sender.Email]
+		sender.LastSeen = p.stats.FromLastSeen[sender.Email]
+		if category != "" && sender.Category != category {
+			continue
+		}
+		senders = append(senders, *sender)
+	}
+	return senders
+}
+
+// HandleListSenders returns every sender with their message count, size, unread count
+// and first and most recent message, so the long tail beyond the top senders can be
+// worked through. It pages with `offset` and `limit`, sorts by `sortBy` (count, size,
+// unread, firstSeen, lastSeen or email) in `order` (asc or desc, default desc) and
+// accepts a `category`. `lastSeenBefore` and `firstSeenAfter` (YYYY-MM-DD) keep only
+// senders gone quiet since a date, or new since one.
+func HandleListSenders(w http.ResponseWriter, r *http.Request) {
+	category, err := parseCategoryFilter(r)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+	lastSeenBefore, err := parseSenderDate(r, "lastSeenBefore")
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+	firstSeenAfter, err := parseSenderDate(r, "firstSeenAfter")
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+	offset, limit, sortBy, err := parseSenderPage(r, sendersByCount, sendersBySize, sendersByUnread, sendersByFirstSeen, sendersByLastSeen, sendersByEmail)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+	desc := true
+	switch r.URL.Query().Get("order") {
+	case "", "desc":
+	case "asc":
+		desc = false
+	default:
+		writeError(w, "order must be asc or desc", http.StatusBadRequest)
+		return
+	}
+
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return
+	}
+
+	senders := processor.Senders(category)
+	if !lastSeenBefore.IsZero() || !firstSeenAfter.IsZero() {
+		senders = slices.DeleteFunc(senders, func(sender SenderSummary) bool {
+			return (!lastSeenBefore.IsZero() && !sender.LastSeen.Before(lastSeenBefore)) ||
+				(!firstSeenAfter.IsZero() && !sender.FirstSeen.After(firstSeenAfter))
+		})
+	}
+	var less func(a, b *SenderSummary) bool
+	switch sortBy {
+	case sendersByCount:
+		less = func(a, b *SenderSummary) bool { return a.Count < b.Count }
+	case sendersBySize:
+		less = func(a, b *SenderSummary) bool { return a.Size < b.Size }
+	case sendersByUnread:
+		less = func(a, b *SenderSummary) bool { return a.Unread < b.Unread }
+	case sendersByFirstSeen:
+		less = func(a, b *SenderSummary) bool { return a.FirstSeen.Before(b.FirstSeen) }
+	case sendersByLastSeen:
+		less = func(a, b *SenderSummary) bool { return a.LastSeen.Before(b.LastSeen) }
+	case sendersByEmail:
+		less = func(a, b *SenderSummary) bool { return a.Email < b.Email }
+	}
+	// Ties always go by address so pages are stable
+	sort.Slice(senders, func(i, j int) bool {
+		a, b := &senders[i], &senders[j]
+		if desc {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return senders[i].Email < senders[j].Email
+	})
+
+	writeJSON(w, map[string]interface{}{
+		"senders": senders[min(offset, len(senders)):min(offset+limit, len(senders))],
+		"total":   len(senders),
+		"offset":  offset,
+		"limit":   limit,
+	})
+}
+
+// parseSenderDate reads an optional date filter (YYYY-MM-DD) of sender listings
+func parseSenderDate(r *http.Request, param string) (time.Time, error) {
+	v := r.URL.Query().Get(param)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date in YYYY-MM-DD format", param)
+	}
+	return t, nil
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SenderMonth is a sender's mail in one calendar month (UTC)
+type SenderMonth struct {
+	// Month as YYYY-MM
+	Month string `json:"month"`
+	Count int    `json:"count"`
+	Size  int64  `json:"size"`
+}
+
+// SenderTimeline is a sender's collected mail month by month
+type SenderTimeline struct {
+	Sender string `json:"sender"`
+	Count  int    `json:"count"`
+	Size   int64  `json:"size"`
+	// Every month from the sender's first message to their last, oldest first, including
+	// the empty ones in between so the months can be charted as they are
+	Months []SenderMonth `json:"months"`
+	// Messages without a parseable date, which no month includes
+	Undated int `json:"undated"`
+}
+
+// SenderTimeline counts a sender's collected messages and their size per month
+func (p *InboxProcessor) SenderTimeline(sender string) *SenderTimeline {
+	timeline := &SenderTimeline{Sender: sender, Months: make([]SenderMonth, 0)}
+	byMonth := make(map[time.Time]*SenderMonth)
+	var first, last time.Time
+
+	p.mu.RLock()
+	p.emails.each(func(_ int, email *EmailMetadata) bool {
+		if !strings.EqualFold(email.From, sender) {
+			return true
+		}
+		timeline.Count++
+		timeline.Size += email.Size()
+		if email.Date.IsZero() {
+			timeline.Undated++
+			return true
+		}
+
+		date := email.Date.UTC()
+		month := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		counts, ok := byMonth[month]
+		if !ok {
+			counts = &SenderMonth{Month: month.Format("2006-01")}
+			byMonth[month] = counts
+		}
+		counts.Count++
+		counts.Size += email.Size()
+		if first.IsZero() || month.Before(first) {
+			first = month
+		}
+		if month.After(last) {
+			last = month
+		}
+		return true
+	})
+	p.mu.RUnlock()
+
+	if first.IsZero() {
+		return timeline
+	}
+	for month := first; !month.After(last); month = month.AddDate(0, 1, 0) {
+		if counts, ok := byMonth[month]; ok {
+			timeline.Months = append(timeline.Months, *counts)
+		} else {
+			timeline.Months = append(timeline.Months, SenderMonth{Month: month.Format("2006-01")})
+		}
+	}
+	return timeline
+}
+
+// HandleGetSenderTimeline returns how many messages a sender sent and how big they were
+// in each month, from the mail collected by the last scan, to see when they started
+// flooding the mailbox before cleaning them up
+func HandleGetSenderTimeline(w http.ResponseWriter, r *http.Request) {
+	processor := processorForRequest(w, r)
+	if processor == nil {
+		return
+	}
+
+	writeJSON(w, processor.SenderTimeline(mux.Vars(r)["email"]))
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// The browser holds a short-lived JWT naming a session instead of the OAuth token
+// itself, so refresh tokens never travel with API requests. Sessions keep the token on
+// the server until they expire or the user signs out.
+
+// Storage prefix of sessions
+const sessionPrefix = "sessions/"
+
+// Header of every session JWT; only HS256 is issued or accepted
+var sessionJWTHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Errors of session JWTs that aren't valid
+var (
+	errSessionInvalid = errors.New("invalid session token")
+	errSessionExpired = errors.New("session token has expired")
+	errSessionEnded   = errors.New("session has ended, sign in again")
+)
+
+// session is a signed-in user's OAuth token held by the server
+type session struct {
+	Token *oauth2.Token `json:"token"`
+	// Scopes Google granted the token, which don't survive encoding it
+	Scopes    []string  `json:"scopes,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// sessionClaims is the payload of a session JWT
+type sessionClaims struct {
+	SessionID string `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// SessionToken is what the browser gets instead of an OAuth token
+type SessionToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Access the user granted when signing in: modify, readonly or metadata
+	Access string `json:"access,omitempty"`
+}
+
+var (
+	// Sessions loaded from storage, by ID
+	sessionCache   = make(map[string]*session)
+	sessionCacheMu sync.Mutex
+)
+
+// sessionSecret returns the key session JWTs are signed with
+func sessionSecret() []byte {
+	return []byte(config.SessionSecret)
+}
+
+// newSession stores token as a new session and issues its first JWT
+func newSession(token *oauth2.Token) (*SessionToken, error) {
+	now := time.Now()
+	id := newID()
+	s := &session{
+		Token:     token,
+		Scopes:    tokenScopes(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(config.SessionMaxDays) * 24 * time.Hour),
+	}
+	if err := Storage.Put(sessionPrefix+id, s); err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+	sessionCacheMu.Lock()
+	sessionCache[id] = s
+	sessionCacheMu.Unlock()
+
+	pruneExpiredSessions()
+	return issueSessionJWT(id, s)
+}
+
+// issueSessionJWT signs a JWT for a session, valid for the configured minutes but never
+// past the session's end
+func issueSessionJWT(id string, s *session) (*SessionToken, error) {
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(config.SessionMinutes) * time.Minute)
+	if expiresAt.After(s.ExpiresAt) {
+		expiresAt = s.ExpiresAt
+	}
+
+	payload, err := json.Marshal(sessionClaims{SessionID: id, IssuedAt: now.Unix(), ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	signed := sessionJWTHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, sessionSecret())
+	mac.Write([]byte(signed))
+	return &SessionToken{
+		Token:     signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)),
+		ExpiresAt: expiresAt.Truncate(time.Second),
+		Access:    accessLevel(withScopes(s.Token, s.Scopes)),
+	}, nil
+}
+
+// verifySessionJWT checks a session JWT's signature and expiry and returns its claims,
+// which come along with errSessionExpired too
+func verifySessionJWT(jwt string) (*sessionClaims, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 || parts[0] != sessionJWTHeader {
+		return nil, errSessionInvalid
+	}
+
+	mac := hmac.New(sha256.New, sessionSecret())
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	expected := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return nil, errSessionInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errSessionInvalid
+	}
+	var claims sessionClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.SessionID == "" {
+		return nil, errSessionInvalid
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return &claims, errSessionExpired
+	}
+	return &claims, nil
+}
+
+// loadSession returns a session by ID, or errSessionEnded if it is gone or expired
+func loadSession(id string) (*session, error) {
+	sessionCacheMu.Lock()
+	s, ok := sessionCache[id]
+	sessionCacheMu.Unlock()
+
+	if !ok {
+		s = &session{}
+		found, err := Storage.Get(sessionPrefix+id, s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load session: %w", err)
+		}
+		if !found {
+			return nil, errSessionEnded
+		}
+		sessionCacheMu.Lock()
+		sessionCache[id] = s
+		sessionCacheMu.Unlock()
+	}
+
+	if time.Now().After(s.ExpiresAt) {
+		endSession(id)
+		return nil, errSessionEnded
+	}
+	return s, nil
+}
+
+// endSession forgets a session and its token
+func endSession(id string) {
+	sessionCacheMu.Lock()
+	delete(sessionCache, id)
+	sessionCacheMu.Unlock()
+	if err := Storage.Delete(sessionPrefix + id); err != nil {
+		log.Printf("Failed to delete session: %s", redactError(err))
+	}
+}
+
+// pruneExpiredSessions deletes the stored sessions that have expired
+func pruneExpiredSessions() {
+	keys, err := Storage.List(sessionPrefix)
+	if err != nil {
+		log.Printf("Failed to list sessions: %s", redactError(err))
+		return
+	}
+	now := time.Now()
+	for _, key := range keys {
+		var s session
+		if found, err := Storage.Get(key, &s); err == nil && found && now.After(s.ExpiresAt) {
+			endSession(strings.TrimPrefix(key, sessionPrefix))
+		}
+	}
+}
+
+// sessionJWT returns the session JWT in a request's Authorization header, or "" if it
+// carries something else, such as an OAuth token as JSON
+func sessionJWT(r *http.Request) string {
+	value := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if strings.Count(value, ".") != 2 || strings.HasPrefix(value, "{") {
+		return ""
+	}
+	return value
+}
+
+// Context key of the OAuth token of a request's session
+type sessionTokenKey struct{}
+
+// withSession returns the request with the OAuth token of its session JWT in its
+// context, for ParseToken to use. Requests without a session JWT are returned as is.
+func withSession(r *http.Request) (*http.Request, error) {
+	jwt := sessionJWT(r)
+	if jwt == "" {
+		return r, nil
+	}
+	claims, err := verifySessionJWT(jwt)
+	if err != nil {
+		return nil, err
+	}
+	s, err := loadSession(claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	token := withScopes(s.Token, s.Scopes)
+	return r.WithContext(context.WithValue(r.Context(), sessionTokenKey{}, token)), nil
+}
+
+// sessionToken returns the OAuth token withSession put in ctx
+func sessionToken(ctx context.Context) *oauth2.Token {
+	token, _ := ctx.Value(sessionTokenKey{}).(*oauth2.Token)
+	return token
+}
+
+// Sessions validates the session JWTs requests carry and loads the OAuth tokens they
+// stand for. Requests with an OAuth token as JSON, as API clients send, pass through.
+func Sessions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		withToken, err := withSession(r)
+		if errors.Is(err, errSessionExpired) || errors.Is(err, errSessionEnded) {
+			writeErrorCode(w, http.StatusUnauthorized, ErrCodeTokenExpired, err.Error())
+			return
+		}
+		if err != nil {
+			writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, withToken)
+	})
+}
+
+// HandleRefreshSession issues a new JWT for the session of a still valid one, which the
+// frontend does before the old one expires
+func HandleRefreshSession(w http.ResponseWriter, r *http.Request) {
+	claims, err := verifySessionJWT(sessionJWT(r))
+	if err != nil {
+		writeErrorCode(w, http.StatusUnauthorized, ErrCodeTokenExpired, err.Error())
+		return
+	}
+	s, err := loadSession(claims.SessionID)
+	if err != nil {
+		writeErrorCode(w, http.StatusUnauthorized, ErrCodeTokenExpired, err.Error())
+		return
+	}
+
+	token, err := issueSessionJWT(claims.SessionID, s)
+	if err != nil {
+		writeErrorFrom(w, "Failed to refresh session", err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, token)
+}
+
+// HandleEndSession signs out, forgetting the session's OAuth token. Expired JWTs may
+// still end their session.
+func HandleEndSession(w http.ResponseWriter, r *http.Request) {
+	claims, err := verifySessionJWT(sessionJWT(r))
+	if err != nil && !errors.Is(err, errSessionExpired) {
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
+		return
+	}
+	endSession(claims.SessionID)
+	w.WriteHeader(http.StatusNoContent)
+}
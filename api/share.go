@@ -0,0 +1,196 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultShareTTL = 24 * time.Hour
+	maxShareTTL     = 30 * 24 * time.Hour
+)
+
+// shareClaims is the signed payload of a share token
+type shareClaims struct {
+	UserID    string `json:"u"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// SharedDashboard is the redacted, read-only view exposed through a share link. It holds
+// aggregate numbers only: no addresses, subjects or message content.
+type SharedDashboard struct {
+	TotalEmails   int            `json:"totalEmails"`
+	TotalSize     int64          `json:"totalSize"`
+	SenderCount   int            `json:"senderCount"`
+	ListCount     int            `json:"listCount"`
+	CategoryCount map[string]int `json:"categoryCount"`
+	MonthlyCount  map[string]int `json:"monthlyCount"`
+	IsProcessing  bool           `json:"isProcessing"`
+	ExpiresAt     time.Time      `json:"expiresAt"`
+}
+
+// shareSecret returns the key share tokens are signed with
+func shareSecret() []byte {
+	return []byte(config.ShareSecret)
+}
+
+// randomSecret generates a signing key for when none is configured
+func randomSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// signShareToken encodes and signs share claims as "<payload>.<signature>"
+func signShareToken(claims shareClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, shareSecret())
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyShareToken checks a share token's signature and expiry and returns its claims
+func verifyShareToken(token string) (*shareClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, fmt.Errorf("malformed share token")
+	}
+
+	mac := hmac.New(sha256.New, shareSecret())
+	mac.Write([]byte(encoded))
+	expected := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, fmt.Errorf("invalid share token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed share token")
+	}
+	var claims shareClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed share token")
+	}
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, fmt.Errorf("share link has expired")
+	}
+	return &claims, nil
+}
+
+// shareRequest is the optional body of HandleCreateShareLink
+type shareRequest struct {
+	// Lifetime of the link in hours, defaultShareTTL if omitted
+	TTLHours *int `json:"ttlHours"`
+}
+
+// HandleCreateShareLink issues a signed, time-limited link to a read-only dashboard of the
+// user's stats. The optional body {"ttlHours": n} sets its lifetime (default 24h).
+func HandleCreateShareLink(w http.ResponseWriter, r *http.Request) {
+	// Parse token from Authorization header
+	token, err := ParseToken(r)
+	if err != nil {
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
+		return
+	}
+
+	var req shareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	ttl := defaultShareTTL
+	if req.TTLHours != nil {
+		// Checked before converting, since a large number of hours overflows a Duration
+		maxHours := int(maxShareTTL / time.Hour)
+		if *req.TTLHours <= 0 || *req.TTLHours > maxHours {
+			writeError(w, fmt.Sprintf("ttlHours must be between 1 and %d", maxHours), http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(*req.TTLHours) * time.Hour
+	}
+
+	userID, err := requestUserID(r, token)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+	if _, exists := Registry.Get(userID); !exists {
+		writeError(w, "No processing found for this user", http.StatusNotFound)
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	shareToken, err := signShareToken(shareClaims{UserID: userID, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		writeErrorFrom(w, "Failed to create share link", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"token":     shareToken,
+		"url":       apiPath(r, "/shared/"+shareToken),
+		"expiresAt": expiresAt,
+	})
+}
+
+// HandleGetSharedDashboard serves the redacted dashboard behind a share link. It needs no
+// authorization beyond the signed token and offers no actions.
+func HandleGetSharedDashboard(w http.ResponseWriter, r *http.Request) {
+	claims, err := verifyShareToken(mux.Vars(r)["token"])
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusForbidden)
+		return
+	}
+
+	processor, exists := Registry.Get(claims.UserID)
+	if !exists {
+		writeError(w, "The shared dashboard is no longer available", http.StatusGone)
+		return
+	}
+
+	dashboard := processor.SharedDashboard()
+	dashboard.ExpiresAt = time.Unix(claims.ExpiresAt, 0)
+	writeJSON(w, dashboard)
+}
+
+// SharedDashboard builds the redacted aggregate view of the processor's stats
+func (p *InboxProcessor) SharedDashboard() *SharedDashboard {
+	dashboard := &SharedDashboard{
+		CategoryCount: make(map[string]int),
+		MonthlyCount:  make(map[string]int),
+		IsProcessing:  p.GetProgress()["isProcessing"].(bool),
+	}
+
+	p.stats.RLock()
+	dashboard.TotalEmails = p.stats.TotalEmails
+	dashboard.SenderCount = len(p.stats.FromCount)
+	dashboard.ListCount = len(p.stats.ListCount)
+	for _, size := range p.stats.FromSize {
+		dashboard.TotalSize += size
+	}
+	for category, count := range p.stats.CategoryCount {
+		dashboard.CategoryCount[category] = count
+	}
+	for date, count := range p.stats.DateCount {
+		// Dates are YYYY-MM-DD, aggregate them by month
+		dashboard.MonthlyCount[date[:7]] += count
+	}
+	p.stats.RUnlock()
+
+	return dashboard
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCreateShareLinkTTL(t *testing.T) {
+	user := newTestUser(t)
+	tests := []struct {
+		body   interface{}
+		status int
+	}{
+		{map[string]int{"ttlHours": 0}, http.StatusBadRequest},
+		{map[string]int{"ttlHours": -1}, http.StatusBadRequest},
+		{map[string]int{"ttlHours": 721}, http.StatusBadRequest},
+		// Would wrap around to a short negative duration if multiplied first
+		{map[string]int64{"ttlHours": 1 << 62}, http.StatusBadRequest},
+		// Valid lifetimes get as far as looking for the user's scan
+		{map[string]int{"ttlHours": 720}, http.StatusNotFound},
+		{nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		r := user.request(t, http.MethodPost, "/api/v1/share", tt.body, nil)
+		if w := serve(HandleCreateShareLink, r); w.Code != tt.status {
+			t.Errorf("ttl %v: status = %d, want %d; body: %s", tt.body, w.Code, tt.status, w.Body)
+		}
+	}
+}
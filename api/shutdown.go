@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+var (
+	// Closed by Shutdown to make running scans checkpoint and stop
+	shuttingDown     = make(chan struct{})
+	shuttingDownOnce sync.Once
+	// Scans currently running, which Shutdown waits for
+	runningScans sync.WaitGroup
+)
+
+// stopping reports whether the server is shutting down
+func stopping() bool {
+	select {
+	case <-shuttingDown:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown stops running scans at their next page, checkpointing them so they resume
+// after the restart, then saves collected usage. It gives up waiting for scans when ctx
+// ends. The HTTP server should be shut down first so no new scans start.
+func Shutdown(ctx context.Context) {
+	shuttingDownOnce.Do(func() { close(shuttingDown) })
+
+	done := make(chan struct{})
+	go func() {
+		runningScans.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Printf("All scans checkpointed")
+	case <-ctx.Done():
+		log.Printf("Gave up waiting for scans to checkpoint: %v", ctx.Err())
+	}
+
+	Usage.Flush()
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultSizeAuditTop = 50
+	maxSizeAuditTop     = 1000
+	// Parallel raw fetches during an audit
+	sizeAuditWorkers = 8
+)
+
+// SizeAuditEntry compares Gmail's size estimate of a message with its exact raw size
+type SizeAuditEntry struct {
+	ID           string `json:"id"`
+	From         string `json:"from"`
+	Subject      string `json:"subject"`
+	SizeEstimate int64  `json:"sizeEstimate"`
+	RawSize      int64  `json:"rawSize"`
+}
+
+// SizeAudit summarizes a size reconciliation pass
+type SizeAudit struct {
+	Audited        int              `json:"audited"`
+	Failed         int              `json:"failed"`
+	EstimatedTotal int64            `json:"estimatedTotal"`
+	RawTotal       int64            `json:"rawTotal"`
+	Difference     int64            `json:"difference"`
+	Messages       []SizeAuditEntry `json:"messages"`
+}
+
+// AuditSizes fetches the exact raw size of the k largest collected messages and records
+// it on their metadata, so storage-savings figures match what Gmail actually reclaims
+func (p *InboxProcessor) AuditSizes(k int) (*SizeAudit, error) {
+	// Find the indexes of the k largest messages by estimate
+	p.mu.RLock()
+	estimates := make([]int64, p.emails.len())
+	p.emails.each(func(i int, email *EmailMetadata) bool {
+		estimates[i] = email.SizeEstimate
+		return true
+	})
+	indexes := make([]int, len(estimates))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.Slice(indexes, func(a, b int) bool {
+		return estimates[indexes[a]] > estimates[indexes[b]]
+	})
+	if k < len(indexes) {
+		indexes = indexes[:k]
+	}
+	targets := make([]EmailMetadata, 0, len(indexes))
+	for _, idx := range indexes {
+		email, err := p.emails.get(idx)
+		if err != nil {
+			p.mu.RUnlock()
+			return nil, err
+		}
+		targets = append(targets, email)
+	}
+	p.mu.RUnlock()
+
+	audit := &SizeAudit{Messages: make([]SizeAuditEntry, 0, len(targets))}
+	var auditMu sync.Mutex
+
+	// Fetch raw messages with a bounded number of workers
+	user := p.user
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < sizeAuditWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				rawSize, err := p.fetchRawSize(user, targets[i].ID)
+				if err != nil {
+					log.Printf("Failed to audit size of message %s: %s", targets[i].ID, redactError(err))
+					auditMu.Lock()
+					audit.Failed++
+					auditMu.Unlock()
+					continue
+				}
+				p.recordRawSize(indexes[i], rawSize)
+
+				auditMu.Lock()
+				audit.Audited++
+				audit.EstimatedTotal += targets[i].SizeEstimate
+				audit.RawTotal += rawSize
+				audit.Messages = append(audit.Messages, SizeAuditEntry{
+					ID:           targets[i].ID,
+					From:         targets[i].From,
+					Subject:      targets[i].Subject,
+					SizeEstimate: targets[i].SizeEstimate,
+					RawSize:      rawSize,
+				})
+				auditMu.Unlock()
+			}
+		}()
+	}
+	for i := range targets {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	if audit.Audited == 0 && audit.Failed > 0 {
+		return nil, fmt.Errorf("failed to fetch any of the %d messages", audit.Failed)
+	}
+
+	audit.Difference = audit.RawTotal - audit.EstimatedTotal
+	sort.Slice(audit.Messages, func(a, b int) bool {
+		return audit.Messages[a].RawSize > audit.Messages[b].RawSize
+	})
+	return audit, nil
+}
+
+// fetchRawSize downloads a message in raw format and returns its exact size in bytes
+func (p *InboxProcessor) fetchRawSize(user, messageID string) (int64, error) {
+	p.quota.Wait(context.Background(), costMessagesGet)
+	msg, err := p.provider.GetMessage(context.Background(), user, messageID, "raw")
+	if err != nil {
+		return 0, err
+	}
+	raw, err := decodeBase64URL(msg.Raw)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(raw)), nil
+}
+
+// recordRawSize stores an audited size and corrects the sender's size total to match
+func (p *InboxProcessor) recordRawSize(index int, rawSize int64) {
+	var delta int64
+	var from string
+	p.mu.Lock()
+	err := p.emails.update(index, func(email *EmailMetadata) {
+		delta = rawSize - email.Size()
+		email.RawSize = rawSize
+		from = email.From
+	})
+	p.mu.Unlock()
+	if err != nil {
+		log.Printf("Failed to record audited size: %s", redactError(err))
+		return
+	}
+
+	p.stats.Lock()
+	p.stats.FromSize[from] += delta
+	p.stats.Unlock()
+}
+
+// HandleSizeAudit reconciles Gmail's size estimates against exact raw sizes for the
+// largest collected messages (`top`, default 50)
+func HandleSizeAudit(w http.ResponseWriter, r *http.Request) {
+	// Parse token from Authorization header
+	token, err := ParseToken(r)
+	if err != nil {
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
+		return
+	}
+	if !requireContentAccess(w, token) {
+		return
+	}
+
+	userID, err := requestUserID(r, token)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+
+	// Get processor
+	processor, exists := Registry.Get(userID)
+	if !exists {
+		writeError(w, "No processing found for this user", http.StatusNotFound)
+		return
+	}
+
+	top := defaultSizeAuditTop
+	if v := r.URL.Query().Get("top"); v != "" {
+		top, err = strconv.Atoi(v)
+		if err != nil || top <= 0 || top > maxSizeAuditTop {
+			writeError(w, fmt.Sprintf("top must be between 1 and %d", maxSizeAuditTop), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// A preview the user is waiting on, so it jumps ahead of scans and cleanups
+	audit, err := Jobs.Run(r.Context(), "size-audit", userID, PriorityInteractive, func(job *Job) (interface{}, error) {
+		return processor.AuditSizes(top)
+	})
+	if err != nil {
+		writeErrorFrom(w, "Failed to audit sizes", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, audit)
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// SpamResult reports the outcome of reporting messages as spam
+type SpamResult struct {
+	Reported []string `json:"reported"`
+	// Maps the ID of each message that failed to its error
+	Failed map[string]string `json:"failed"`
+	// Messages skipped because the user's protection rules cover them
+	Protected []string `json:"protected"`
+	// Set when some of the failed messages are worth trying again
+	Retry *RetryGuidance `json:"retry,omitempty"`
+}
+
+// reportSpam moves messages to Spam, which also teaches Gmail's spam filter about them.
+// Messages covered by the user's protection rules are skipped, and those that fail
+// are reported without failing the rest.
+func reportSpam(mb *mailbox, ids []string) (*SpamResult, error) {
+	result := &SpamResult{
+		Reported:  make([]string, 0, len(ids)),
+		Failed:    make(map[string]string),
+		Protected: make([]string, 0),
+	}
+
+	protected, err := protectedIDs(mb)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := protected[id]; ok {
+			result.Protected = append(result.Protected, id)
+		} else {
+			allowed = append(allowed, id)
+		}
+	}
+
+	failed := mb.cleaner().ModifyEach(mb.context(), allowed, []string{"SPAM"}, []string{"INBOX"})
+	for _, id := range allowed {
+		if err, ok := failed[id]; ok {
+			result.Failed[id] = redactError(err)
+		} else {
+			result.Reported = append(result.Reported, id)
+		}
+	}
+	result.Retry = retryGuidance(failed)
+
+	recordAudit(mb, "report-spam", "", result.Reported, nil)
+	return result, nil
+}
+
+// HandleReportSpam reports a single message as spam
+func HandleReportSpam(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	if err := mb.quota.Wait(context.Background(), costMessagesModify); err != nil {
+		writeErrorFrom(w, "Request cancelled", err, http.StatusServiceUnavailable)
+		return
+	}
+	err := mb.provider.ModifyMessage(mb.context(), mb.user, messageID, []string{"SPAM"}, []string{"INBOX"})
+	recordAudit(mb, "report-spam", messageID, []string{messageID}, err)
+	if err != nil {
+		writeErrorFrom(w, "Failed to report spam", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]string{"status": "success", "message": "Email reported as spam"})
+}
+
+// HandleBatchReportSpam reports a list of messages as spam, optionally only those the
+// classifier put in `category`
+func HandleBatchReportSpam(w http.ResponseWriter, r *http.Request) {
+	handleBatchAction(w, r, "batch-report-spam", bulkActionReportSpam)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store is the server's persistent key-value storage. Keys are slash-separated paths
+// such as "protection/someone@example.com"; values are stored as JSON.
+type Store interface {
+	// Get loads the value at key into v, reporting whether it existed
+	Get(key string, v interface{}) (bool, error)
+	// Put stores v at key, replacing any previous value
+	Put(key string, v interface{}) error
+	// Delete removes key; deleting a missing key is not an error
+	Delete(key string) error
+	// List returns the keys under prefix, sorted
+	List(prefix string) ([]string, error)
+}
+
+var (
+	// Global storage backend, set up by Init
+	Storage Store
+)
+
+// FileStore is a Store keeping one JSON file per key under a directory
+type FileStore struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+// NewFileStore creates a FileStore rooted at dir
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path maps a key to its file, escaping each segment so keys can't leave the directory
+func (s *FileStore) path(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+		if segments[i] == "." || segments[i] == ".." {
+			segments[i] = "%2E" + segments[i][1:]
+		}
+	}
+	return filepath.Join(s.dir, filepath.Join(segments...)) + ".json"
+}
+
+// Get loads the value at key into v
+func (s *FileStore) Get(key string, v interface{}) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// Put stores v at key, writing to a temporary file first so a crash never leaves a
+// half-written value behind
+func (s *FileStore) Put(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Delete removes key
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List returns the keys under prefix
+func (s *FileStore) List(prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0)
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return err
+		}
+
+		rel, err := filepath.Rel(s.dir, strings.TrimSuffix(path, ".json"))
+		if err != nil {
+			return err
+		}
+		segments := strings.Split(filepath.ToSlash(rel), "/")
+		for i, segment := range segments {
+			if segments[i], err = url.PathUnescape(segment); err != nil {
+				return err
+			}
+		}
+
+		key := strings.Join(segments, "/")
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
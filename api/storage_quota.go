@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// StorageQuota is the Google account storage shared by Gmail, Drive and Photos
+type StorageQuota struct {
+	// Zero when the account has unlimited storage
+	Limit        int64 `json:"limit"`
+	Usage        int64 `json:"usage"`
+	UsageInGmail int64 `json:"usageInGmail"`
+	UsageInDrive int64 `json:"usageInDrive"`
+	Unlimited    bool  `json:"unlimited"`
+}
+
+// StorageProjection shows what a cleanup would do to the account's storage
+type StorageProjection struct {
+	Limit int64 `json:"limit"`
+	Usage int64 `json:"usage"`
+	// Usage once the affected messages are gone for good, i.e. after trash is emptied
+	UsageAfter int64 `json:"usageAfter"`
+	Freed      int64 `json:"freed"`
+}
+
+// fetchStorageQuota reads the storage quota of the token's account from Drive
+func fetchStorageQuota(ctx context.Context, token *oauth2.Token) (*StorageQuota, error) {
+	client := googleClient(ctx, token)
+	service, err := drive.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Drive service: %w", err)
+	}
+
+	about, err := service.About.Get().Fields("storageQuota").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch storage quota: %w", err)
+	}
+	quota := about.StorageQuota
+	if quota == nil {
+		return nil, fmt.Errorf("Drive returned no storage quota")
+	}
+
+	return &StorageQuota{
+		Limit:        quota.Limit,
+		Usage:        quota.Usage,
+		UsageInGmail: quota.Usage - quota.UsageInDrive,
+		UsageInDrive: quota.UsageInDrive,
+		Unlimited:    quota.Limit == 0,
+	}, nil
+}
+
+// projectStorage estimates the account's storage after freeing freed bytes. It returns
+// nil when quota lookups are disabled or unavailable, since a preview is still useful
+// without it. Only the signed-in user's own storage is known, not a delegated mailbox's.
+func projectStorage(mb *mailbox, freed int64) *StorageProjection {
+	if !config.StorageQuotaEnabled || mb.user != "me" {
+		return nil
+	}
+	quota, err := fetchStorageQuota(context.Background(), mb.token)
+	if err != nil {
+		return nil
+	}
+	return &StorageProjection{
+		Limit:      quota.Limit,
+		Usage:      quota.Usage,
+		UsageAfter: max(quota.Usage-freed, 0),
+		Freed:      freed,
+	}
+}
+
+// HandleGetStorageQuota returns the total, used and Gmail-attributed storage of the
+// signed-in user's Google account
+func HandleGetStorageQuota(w http.ResponseWriter, r *http.Request) {
+	if !config.StorageQuotaEnabled {
+		writeError(w, "Storage quota lookups are not enabled on this server", http.StatusNotImplemented)
+		return
+	}
+
+	token, err := ParseToken(r)
+	if err != nil {
+		writeErrorFrom(w, "Unauthorized", err, http.StatusUnauthorized)
+		return
+	}
+
+	quota, err := fetchStorageQuota(r.Context(), token)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, quota)
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Bounds and pace of the adaptive throttle
+const (
+	// Most calls of one user that run at once, and where the throttle starts
+	maxCallConcurrency = 50
+	// Shortest delay worth waiting between calls; shorter ones drop to none
+	minThrottleDelay = 25 * time.Millisecond
+	maxThrottleDelay = 10 * time.Second
+	// Rate limit errors within this long of a backoff count as the same burst, since
+	// the calls already in flight fail together
+	throttleBackoffCooldown = time.Second
+	// Successful calls, at least throttleRampInterval after the last backoff, before
+	// each step back up
+	throttleRampCalls    = 25
+	throttleRampInterval = 5 * time.Second
+)
+
+// Throttle adapts how many of a user's calls run at once and how long each waits first
+// to the rate limit errors Gmail returns, on top of the quota pacing: each burst of them
+// halves the concurrency and doubles the delay, and a run of successful calls steps
+// back up one call and half the delay at a time.
+type Throttle struct {
+	concurrency int
+	delay       time.Duration
+	// Successful calls since the last step
+	successes   int
+	lastBackoff time.Time
+	mu          sync.Mutex
+}
+
+// ThrottleState is the current pace of a user's calls
+type ThrottleState struct {
+	Concurrency int   `json:"concurrency"`
+	DelayMillis int64 `json:"delayMillis"`
+}
+
+// newThrottle creates a throttle that starts at full speed
+func newThrottle() *Throttle {
+	return &Throttle{concurrency: maxCallConcurrency}
+}
+
+// Concurrency returns how many calls may run at once
+func (t *Throttle) Concurrency() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.concurrency
+}
+
+// State returns the current concurrency and delay
+func (t *Throttle) State() ThrottleState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return ThrottleState{Concurrency: t.concurrency, DelayMillis: t.delay.Milliseconds()}
+}
+
+// Observe adapts the pace to the outcome of a call. Errors other than rate limits
+// neither slow it down nor count towards speeding it up.
+func (t *Throttle) Observe(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if isRateLimitError(err) {
+		t.successes = 0
+		if now.Sub(t.lastBackoff) < throttleBackoffCooldown {
+			return
+		}
+		t.lastBackoff = now
+		t.concurrency = max(1, t.concurrency/2)
+		t.delay = min(maxThrottleDelay, max(minThrottleDelay, t.delay*2))
+		log.Printf("Rate limited, slowing down to %d concurrent calls %v apart", t.concurrency, t.delay)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	t.successes++
+	if t.successes < throttleRampCalls || now.Sub(t.lastBackoff) < throttleRampInterval {
+		return
+	}
+	t.successes = 0
+	t.concurrency = min(maxCallConcurrency, t.concurrency+1)
+	if t.delay /= 2; t.delay < minThrottleDelay {
+		t.delay = 0
+	}
+}
+
+// wait sleeps for the current delay
+func (t *Throttle) wait(ctx context.Context) error {
+	t.mu.Lock()
+	delay := t.delay
+	t.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isRateLimitError reports whether Gmail refused a call for exceeding a rate limit
+func isRateLimitError(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && (gerr.Code == http.StatusTooManyRequests || isRateLimitReason(gerr))
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+)
+
+// StatsBetween recomputes the aggregates from the cached metadata of messages dated
+// within [since, until), showing what the mailbox looked like in a past era. A zero
+// bound leaves that side open; messages without a parseable date are left out.
+func (p *InboxProcessor) StatsBetween(since, until time.Time) *EmailStats {
+	stats := deepclean.NewStats()
+	fromCategories := make(map[string]map[string]int)
+
+	p.mu.RLock()
+	p.emails.each(func(_ int, email *EmailMetadata) bool {
+		if email.Date.IsZero() || email.Date.Before(since) || (!until.IsZero() && !email.Date.Before(until)) {
+			return true
+		}
+
+		stats.TotalEmails++
+		stats.Add(&deepclean.Message{EmailMetadata: *email})
+		if fromCategories[email.From] == nil {
+			fromCategories[email.From] = make(map[string]int)
+		}
+		fromCategories[email.From][email.Category]++
+		return true
+	})
+	p.mu.RUnlock()
+
+	for sender, counts := range fromCategories {
+		stats.FromCategory[sender] = deepclean.MajorityCategory(counts)
+	}
+
+	// Names and unsubscribe targets don't change over time, so reuse the current ones
+	p.stats.RLock()
+	for id := range stats.ListCount {
+		if name, ok := p.stats.ListNames[id]; ok {
+			stats.ListNames[id] = name
+		}
+	}
+	for sender := range stats.FromCount {
+		if info, ok := p.stats.Unsubscribe[sender]; ok {
+			stats.Unsubscribe[sender] = info
+		}
+	}
+	p.stats.RUnlock()
+
+	return stats
+}
+
+// parseTimeTravel reads the optional `since` and `asOf` query parameters (YYYY-MM-DD).
+// asOf includes the whole of its day.
+func parseTimeTravel(r *http.Request) (since, until time.Time, err error) {
+	if v := r.URL.Query().Get("since"); v != "" {
+		if since, err = time.Parse("2006-01-02", v); err != nil {
+			return since, until, fmt.Errorf("since must be a date in YYYY-MM-DD format")
+		}
+	}
+	if v := r.URL.Query().Get("asOf"); v != "" {
+		asOf, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return since, until, fmt.Errorf("asOf must be a date in YYYY-MM-DD format")
+		}
+		until = asOf.AddDate(0, 0, 1)
+	}
+	if !until.IsZero() && !since.Before(until) {
+		return since, until, fmt.Errorf("since must be earlier than asOf")
+	}
+	return since, until, nil
+}
+
+// statsView returns the processor itself, or when the request asks for a past era
+// with `since`/`asOf`, a read-only view whose stats cover only that era. Only the
+// stats-based accessors may be called on the view.
+func statsView(w http.ResponseWriter, r *http.Request, processor *InboxProcessor) *InboxProcessor {
+	since, until, err := parseTimeTravel(r)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return nil
+	}
+	if since.IsZero() && until.IsZero() {
+		return processor
+	}
+	return &InboxProcessor{stats: processor.StatsBetween(since, until)}
+}
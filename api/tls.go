@@ -0,0 +1,60 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Issues certificates from Let's Encrypt when autocert is configured
+var certManager *autocert.Manager
+
+// ServerTLS returns the TLS configuration to serve HTTPS with, or nil to serve plain
+// HTTP. Either the configured certificate and key files are used, or certificates for
+// the autocert hosts are requested from Let's Encrypt and cached in the data directory.
+func ServerTLS() (*tls.Config, error) {
+	switch {
+	case config.TLSCertFile != "":
+		cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+
+	case len(config.AutocertHosts) > 0:
+		certManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.AutocertHosts...),
+			Cache:      autocert.DirCache(filepath.Join(config.DataDir, "autocert")),
+			Email:      config.AutocertEmail,
+		}
+		tlsConfig := certManager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, nil
+	}
+	return nil, nil
+}
+
+// RedirectToHTTPS handles the plain HTTP listener of a server serving HTTPS on
+// httpsPort: it answers Let's Encrypt's challenges when autocert is on and redirects
+// everything else to HTTPS
+func RedirectToHTTPS(httpsPort string) http.Handler {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	if certManager != nil {
+		return certManager.HTTPHandler(redirect)
+	}
+	return redirect
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+)
+
+// Every request, job and scheduled cleanup acting for a user builds its own API clients
+// from the same OAuth token. Once the access token expires they would each refresh it,
+// so clients get their tokens from one source per refresh token instead, which
+// refreshes once for all of them and keeps the result for the next ones.
+
+// Storage prefix of the access tokens refreshed from a refresh token, so a restart
+// doesn't refresh again
+const refreshedTokenPrefix = "refreshed-tokens/"
+
+var (
+	// Token sources by refreshTokenID
+	sharedTokens   = make(map[string]*sharedTokenSource)
+	sharedTokensMu sync.Mutex
+	// Collapses concurrent calls for a refresh token's token into one
+	tokenRefreshes singleflight.Group
+)
+
+// sharedTokenSource hands out the latest access token of a refresh token to every
+// client of its user
+type sharedTokenSource struct {
+	id string
+	// Reuses the token until it expires, then refreshes it
+	source oauth2.TokenSource
+	// Last token handed out, which is saved whenever the source refreshes it
+	last *oauth2.Token
+}
+
+// refreshTokenID identifies a refresh token without keeping the secret in map or
+// storage keys
+func refreshTokenID(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// Token returns the current access token, refreshing it if it expired. Callers that ask
+// while a refresh is under way wait for it and get its token.
+func (s *sharedTokenSource) Token() (*oauth2.Token, error) {
+	token, err, _ := tokenRefreshes.Do(s.id, func() (interface{}, error) {
+		token, err := s.source.Token()
+		if err != nil {
+			return nil, err
+		}
+		if token != s.last {
+			s.last = token
+			saveRefreshedToken(s.id, token)
+		}
+		return token, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token.(*oauth2.Token), nil
+}
+
+// refreshedTokenKey is the storage key of the access token refreshed last from the
+// refresh token with the given ID
+func refreshedTokenKey(id string) string {
+	return refreshedTokenPrefix + id
+}
+
+// saveRefreshedToken stores a refreshed access token. The refresh token is left out:
+// sessions and scheduled cleanups keep it, and the access token expires on its own.
+func saveRefreshedToken(id string, token *oauth2.Token) {
+	saved := &oauth2.Token{AccessToken: token.AccessToken, TokenType: token.TokenType, Expiry: token.Expiry}
+	if err := Storage.Put(refreshedTokenKey(id), saved); err != nil {
+		log.Printf("Failed to save refreshed token: %s", redactError(err))
+	}
+}
+
+// tokenSource returns the source API clients authorized with token get their access
+// tokens from, shared by every client of the same refresh token. It starts from the
+// token refreshed last, if that is still valid and newer than token.
+func tokenSource(token *oauth2.Token) oauth2.TokenSource {
+	if token.RefreshToken == "" {
+		return oauthConfig.TokenSource(context.Background(), token)
+	}
+	id := refreshTokenID(token.RefreshToken)
+
+	sharedTokensMu.Lock()
+	defer sharedTokensMu.Unlock()
+	if source, ok := sharedTokens[id]; ok {
+		return source
+	}
+
+	start := token
+	var saved oauth2.Token
+	if found, err := Storage.Get(refreshedTokenKey(id), &saved); err == nil && found && saved.Valid() && saved.Expiry.After(token.Expiry) {
+		saved.RefreshToken = token.RefreshToken
+		start = &saved
+	}
+	// The config's source is a ReuseTokenSource, which refreshes only once start expires
+	source := &sharedTokenSource{id: id, source: oauthConfig.TokenSource(context.Background(), start), last: start}
+	sharedTokens[id] = source
+	return source
+}
+
+// forgetTokenSource drops the shared source of a token's refresh token, reporting
+// whether there was one
+func forgetTokenSource(token *oauth2.Token) bool {
+	id := refreshTokenID(token.RefreshToken)
+
+	sharedTokensMu.Lock()
+	defer sharedTokensMu.Unlock()
+	_, ok := sharedTokens[id]
+	delete(sharedTokens, id)
+	return ok
+}
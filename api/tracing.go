@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+)
+
+// Spans are created with the global provider, which is a no-op until InitTracing
+// installs an exporting one
+var tracer = otel.Tracer("github.com/dustinmichels/gmail-deepclean/api")
+
+// InitTracing exports traces over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set. The exporter reads the rest of the standard
+// OTEL_* variables itself, such as headers and sampling. The returned function flushes
+// buffered spans and must be called before exiting.
+func InitTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the default name
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName("gmail-deepclean")),
+		resource.Environment(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// TraceRequests wraps the router so every request gets a span, named after the route
+// that handled it
+func TraceRequests(router *mux.Router) http.Handler {
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Route templates keep message IDs and addresses out of span names
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					trace.SpanFromContext(r.Context()).SetName(r.Method + " " + template)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	return otelhttp.NewHandler(router, "http")
+}
+
+// googleClient returns an HTTP client for Google APIs authorized with token, or with the
+// service account's tokens for the user a delegated token acts as. Clients of the same
+// user share the refreshes of their token. Every call is traced as a child of the span
+// in the request's context.
+func googleClient(ctx context.Context, token *oauth2.Token) *http.Client {
+	var client *http.Client
+	if subject := delegatedSubject(token); subject != "" && delegation != nil {
+		client = oauth2.NewClient(ctx, delegation.tokenSource(subject))
+	} else {
+		client = oauth2.NewClient(ctx, tokenSource(token))
+	}
+	client.Transport = otelhttp.NewTransport(client.Transport, otelhttp.WithSpanNameFormatter(
+		func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Host
+		},
+	))
+	return client
+}
+
+// endSpan records err on span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, redactError(err))
+	}
+	span.End()
+}
+
+// jobAttributes describe a job on its span
+func jobAttributes(job *Job) trace.SpanStartOption {
+	return trace.WithAttributes(
+		attribute.String("job.id", job.ID),
+		attribute.String("job.kind", job.Kind),
+		attribute.Int("job.priority", int(job.Priority)),
+	)
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Gmail permanently deletes trashed messages after this long, so older trashes can't be undone
+const trashRetention = 30 * 24 * time.Hour
+
+var (
+	errOperationUndone = errors.New("operation has already been undone")
+	errTrashPurged     = errors.New("trashed messages have been permanently deleted by Gmail")
+)
+
+// Serializes checking and marking trash records undone
+var trashRecordsMu sync.Mutex
+
+// TrashRecord is an entry in a user's undo stack: the messages one operation trashed
+type TrashRecord struct {
+	// ID of the operation that trashed the messages
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Count      int        `json:"count"`
+	MessageIDs []string   `json:"messageIds,omitempty"`
+	TrashedAt  time.Time  `json:"trashedAt"`
+	UndoneAt   *time.Time `json:"undoneAt,omitempty"`
+}
+
+// trashReporter is implemented by operation results that moved messages to trash
+type trashReporter interface {
+	trashedIDs() []string
+}
+
+// trashedIDs returns the messages the bulk trash moved to trash
+func (r *TrashResult) trashedIDs() []string {
+	return r.Trashed
+}
+
+// trashRecordKey is the storage key of a trash record
+func trashRecordKey(account, id string) string {
+	return "trash-log/" + account + "/" + id
+}
+
+// recordTrash adds messages an action trashed to the account's undo stack, as the
+// operation with the given ID. Dry runs trashed nothing, so they aren't recorded.
+func recordTrash(mb *mailbox, id, kind string, ids []string) {
+	if len(ids) == 0 || mb.dryRun() {
+		return
+	}
+	account, err := mb.account()
+	if err != nil {
+		log.Printf("Failed to record trash operation %s: %s", id, redactError(err))
+		return
+	}
+
+	record := &TrashRecord{
+		ID:         id,
+		Kind:       kind,
+		Count:      len(ids),
+		MessageIDs: ids,
+		TrashedAt:  time.Now(),
+	}
+	if err := Storage.Put(trashRecordKey(account, id), record); err != nil {
+		log.Printf("Failed to record trash operation %s: %s", id, redactError(err))
+	}
+}
+
+// loadTrashRecords returns an account's undo stack, most recent first
+func loadTrashRecords(account string) ([]TrashRecord, error) {
+	keys, err := Storage.List(trashRecordKey(account, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]TrashRecord, 0, len(keys))
+	for _, key := range keys {
+		var record TrashRecord
+		if _, err := Storage.Get(key, &record); err != nil {
+			return nil, fmt.Errorf("failed to load operation: %w", err)
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].TrashedAt.After(records[j].TrashedAt)
+	})
+	return records, nil
+}
+
+// HandleListTrashRecords returns the user's undo stack, most recent first, without the
+// individual message IDs
+func HandleListTrashRecords(w http.ResponseWriter, r *http.Request) {
+	mb := mailboxForRequest(w, r)
+	if mb == nil {
+		return
+	}
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	records, err := loadTrashRecords(account)
+	if err != nil {
+		writeErrorFrom(w, "Failed to list operations", err, http.StatusInternalServerError)
+		return
+	}
+	for i := range records {
+		records[i].MessageIDs = nil
+	}
+
+	writeJSON(w, records)
+}
+
+// HandleUndoOperation moves every message an operation trashed back out of trash
+func HandleUndoOperation(w http.ResponseWriter, r *http.Request) {
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+	account, err := mb.account()
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+
+	record, err := claimUndo(account, mux.Vars(r)["id"])
+	switch {
+	case errors.Is(err, errOperationNotFound):
+		writeError(w, "Operation not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errOperationUndone):
+		writeError(w, "Operation has already been undone", http.StatusConflict)
+		return
+	case errors.Is(err, errTrashPurged):
+		writeError(w, "Trashed messages have been permanently deleted by Gmail", http.StatusGone)
+		return
+	case err != nil:
+		writeErrorFrom(w, "Failed to load operation", err, http.StatusInternalServerError)
+		return
+	}
+
+	result, err := Jobs.Run(r.Context(), "undo", mb.userID, PriorityUser, func(job *Job) (interface{}, error) {
+		mb := mb.forJob(job)
+		result := untrashMessages(mb, record.MessageIDs)
+		recordAudit(mb, "undo", record.ID, result.Succeeded, nil)
+		return result, nil
+	})
+	if err != nil {
+		writeErrorFrom(w, "Failed to undo operation", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, result)
+}
+
+// claimUndo marks an operation of the account undone and returns its record, so that of
+// concurrent undos only one restores its messages. It fails with errOperationNotFound,
+// errOperationUndone or errTrashPurged if the operation can't be undone.
+func claimUndo(account, id string) (*TrashRecord, error) {
+	trashRecordsMu.Lock()
+	defer trashRecordsMu.Unlock()
+
+	key := trashRecordKey(account, id)
+	var record TrashRecord
+	found, err := Storage.Get(key, &record)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errOperationNotFound
+	}
+	if record.UndoneAt != nil {
+		return nil, errOperationUndone
+	}
+	if time.Since(record.TrashedAt) > trashRetention {
+		return nil, errTrashPurged
+	}
+
+	now := time.Now()
+	record.UndoneAt = &now
+	if err := Storage.Put(key, &record); err != nil {
+		return nil, fmt.Errorf("failed to mark operation undone: %w", err)
+	}
+	return &record, nil
+}
+
+// batchUntrashRequest is the body of HandleBatchUntrash, which gives either IDs or the
+// operation that trashed them
+type batchUntrashRequest struct {
+	IDs []string `json:"ids"`
+	// Operation whose trashed messages to restore
+	OperationID string `json:"operationId"`
+}
+
+// HandleBatchUntrash moves a list of messages, or every message an operation trashed,
+// back out of trash. Unlike HandleUndoOperation it can restore any message and any
+// operation any number of times, and leaves the undo stack as it is.
+func HandleBatchUntrash(w http.ResponseWriter, r *http.Request) {
+	var req batchUntrashRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if (len(req.IDs) == 0) == (req.OperationID == "") {
+		writeError(w, "Provide either message IDs or an operation ID", http.StatusBadRequest)
+		return
+	}
+
+	mb := mailboxForChange(w, r)
+	if mb == nil {
+		return
+	}
+
+	ids, target := req.IDs, ""
+	if req.OperationID != "" {
+		account, err := mb.account()
+		if err != nil {
+			writeErrorFrom(w, "", err, http.StatusInternalServerError)
+			return
+		}
+		var record TrashRecord
+		found, err := Storage.Get(trashRecordKey(account, req.OperationID), &record)
+		if err != nil {
+			writeErrorFrom(w, "Failed to load operation", err, http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			writeError(w, "Operation not found", http.StatusNotFound)
+			return
+		}
+		if time.Since(record.TrashedAt) > trashRetention {
+			writeError(w, "Trashed messages have been permanently deleted by Gmail", http.StatusGone)
+			return
+		}
+		ids, target = record.MessageIDs, "operation:"+record.ID
+	}
+
+	result, err := Jobs.Run(r.Context(), "batch-untrash", mb.userID, PriorityUser, func(job *Job) (interface{}, error) {
+		mb := mb.forJob(job)
+		result := untrashMessages(mb, ids)
+		recordAudit(mb, "batch-untrash", target, result.Succeeded, nil)
+		return result, nil
+	})
+	if err != nil {
+		writeErrorFrom(w, "Failed to restore messages", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, result)
+}
+
+// UntrashResult reports the outcome of moving messages out of trash
+type UntrashResult struct {
+	Restored int `json:"restored"`
+	*BatchResult
+	// Set when restoring stopped before every message was tried, which are then failed
+	Error string `json:"error,omitempty"`
+}
+
+// untrashMessages moves messages out of trash in quota-sized batches
+func untrashMessages(mb *mailbox, ids []string) *UntrashResult {
+	failed := make(map[string]error)
+	tried := make(map[string]bool, len(ids))
+
+	untrash := func(id string) error {
+		return mb.provider.Untrash(mb.context(), mb.user, id)
+	}
+	err := runPlanned(context.Background(), mb.quota, ids, costMessagesUntrash, untrash, func(results map[string]error) bool {
+		for id, err := range results {
+			tried[id] = true
+			if err != nil {
+				failed[id] = err
+			}
+		}
+		return true
+	})
+
+	result := &UntrashResult{}
+	if err != nil {
+		for _, id := range ids {
+			if !tried[id] {
+				failed[id] = err
+			}
+		}
+		result.Error = fmt.Sprintf("stopped early: %s", redactError(err))
+	}
+	result.BatchResult = newBatchResult(ids, failed)
+	result.Restored = len(result.Succeeded)
+	return result
+}
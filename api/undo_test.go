@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestUndoSingleTrash(t *testing.T) {
+	user := newTestUser(t, senderMessages("deal", "deals@shop.example", 1, 0)...)
+
+	r := user.request(t, http.MethodDelete, "/api/v1/emails/deala", nil, map[string]string{"id": "deala"})
+	decodeResponse(t, serve(HandleDeleteEmail, r), http.StatusOK, nil)
+	if !messageHasLabel(t, user.server, "deala", "TRASH") {
+		t.Fatal("message not trashed")
+	}
+
+	records, err := loadTrashRecords(user.account())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || len(records[0].MessageIDs) != 1 || records[0].MessageIDs[0] != "deala" {
+		t.Fatalf("undo stack = %+v, want the trashed message", records)
+	}
+
+	id := records[0].ID
+	r = user.request(t, http.MethodPost, "/api/v1/operations/"+id+"/undo", nil, map[string]string{"id": id})
+	var result UntrashResult
+	decodeResponse(t, serve(HandleUndoOperation, r), http.StatusOK, &result)
+	if result.Restored != 1 || len(result.Succeeded) != 1 || result.Succeeded[0] != "deala" || len(result.Failed) != 0 {
+		t.Errorf("undo result = %+v, want deala restored", result)
+	}
+	if messageHasLabel(t, user.server, "deala", "TRASH") {
+		t.Error("message still in trash after undo")
+	}
+
+	r = user.request(t, http.MethodPost, "/api/v1/operations/"+id+"/undo", nil, map[string]string{"id": id})
+	decodeResponse(t, serve(HandleUndoOperation, r), http.StatusConflict, nil)
+}
+
+func TestUndoOperationOfOtherAccount(t *testing.T) {
+	owner := newTestUser(t, senderMessages("deal", "deals@shop.example", 1, 0)...)
+	other := newTestUser(t)
+
+	r := owner.request(t, http.MethodDelete, "/api/v1/emails/deala", nil, map[string]string{"id": "deala"})
+	decodeResponse(t, serve(HandleDeleteEmail, r), http.StatusOK, nil)
+	records, err := loadTrashRecords(owner.account())
+	if err != nil || len(records) != 1 {
+		t.Fatalf("undo stack = %+v, %v; want one record", records, err)
+	}
+
+	id := records[0].ID
+	r = other.request(t, http.MethodPost, "/api/v1/operations/"+id+"/undo", nil, map[string]string{"id": id})
+	decodeResponse(t, serve(HandleUndoOperation, r), http.StatusNotFound, nil)
+	if !messageHasLabel(t, owner.server, "deala", "TRASH") {
+		t.Error("another account's undo restored the owner's message")
+	}
+}
+
+func TestClaimUndoOnce(t *testing.T) {
+	user := newTestUser(t)
+	recordTrash(user.mailbox(t), "op1", "trash", []string{"a", "b"})
+
+	var wg sync.WaitGroup
+	var claimed, undone int
+	var mu sync.Mutex
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := claimUndo(user.account(), "op1")
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				claimed++
+			case errors.Is(err, errOperationUndone):
+				undone++
+			default:
+				t.Errorf("claimUndo: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if claimed != 1 || undone != 7 {
+		t.Errorf("%d claims succeeded and %d found the operation undone, want 1 and 7", claimed, undone)
+	}
+
+	if _, err := claimUndo(user.account(), "missing"); !errors.Is(err, errOperationNotFound) {
+		t.Errorf("claimUndo of a missing operation = %v, want %v", err, errOperationNotFound)
+	}
+}
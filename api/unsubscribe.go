@@ -0,0 +1,8 @@
+package api
+
+import (
+	"github.com/dustinmichels/gmail-deepclean/pkg/deepclean"
+)
+
+// UnsubscribeInfo holds the unsubscribe targets a sender advertises
+type UnsubscribeInfo = deepclean.UnsubscribeInfo
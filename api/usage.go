@@ -0,0 +1,328 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Longest history the usage dashboard returns
+const maxUsageDays = 365
+
+// DailyUsage is the server-wide activity of one day, for operators planning Google
+// quota requests and capacity
+type DailyUsage struct {
+	Date string `json:"date"`
+	// Gmail API quota units consumed across all users, and by each usage ID
+	QuotaUnits int64            `json:"quotaUnits"`
+	UserQuota  map[string]int64 `json:"userQuota,omitempty"`
+	// Jobs finished, by kind
+	Jobs       map[string]int `json:"jobs"`
+	FailedJobs int            `json:"failedJobs"`
+	// Distinct users who called the Gmail API or ran a job
+	ActiveUsers int `json:"activeUsers"`
+	// Calls dry runs skipped instead of changing a mailbox, counted per message or other
+	// object they would have acted on, by Gmail method
+	DryRun map[string]int `json:"dryRun,omitempty"`
+	// Usage IDs of the active users, kept so restarts don't count them twice
+	Users []string `json:"users,omitempty"`
+}
+
+// UsageTracker accumulates daily usage in memory and periodically saves it to storage
+type UsageTracker struct {
+	days  map[string]*DailyUsage
+	users map[string]map[string]struct{}
+	mu    sync.Mutex
+}
+
+var (
+	// Global usage tracker
+	Usage = &UsageTracker{
+		days:  make(map[string]*DailyUsage),
+		users: make(map[string]map[string]struct{}),
+	}
+)
+
+// usageID returns the ID usage counts an account under: a hash of its address, so a
+// user counts once however often their token changes, without usage naming them
+func usageID(account string) string {
+	if account == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(account))
+	return hex.EncodeToString(sum[:8])
+}
+
+// usageUserID returns the usage ID of a user ID's account, or "" if it isn't known yet.
+// What such users consume still counts toward the totals.
+func usageUserID(userID string) string {
+	return usageID(knownAccount(userID))
+}
+
+// usageKey is the storage key of a day's usage
+func usageKey(date string) string {
+	return "usage/" + date
+}
+
+// day returns the usage of today, loading what an earlier run saved on first use, and
+// counts the user with the given usage ID as active. Callers must hold t.mu.
+func (t *UsageTracker) day(id string) *DailyUsage {
+	date := time.Now().UTC().Format("2006-01-02")
+	usage, ok := t.days[date]
+	if !ok {
+		usage = &DailyUsage{Date: date, Jobs: make(map[string]int)}
+		if Storage != nil {
+			if _, err := Storage.Get(usageKey(date), usage); err != nil {
+				log.Printf("Failed to load usage of %s: %s", date, redactError(err))
+			}
+		}
+		t.days[date] = usage
+		t.users[date] = make(map[string]struct{})
+		for _, id := range usage.Users {
+			t.users[date][id] = struct{}{}
+		}
+	}
+
+	if _, seen := t.users[date][id]; !seen && id != "" {
+		t.users[date][id] = struct{}{}
+		usage.Users = append(usage.Users, id)
+		usage.ActiveUsers = len(usage.Users)
+	}
+	return usage
+}
+
+// addQuota records quota units a user consumed
+func (t *UsageTracker) addQuota(userID string, units int) {
+	id := usageUserID(userID)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := t.day(id)
+	usage.QuotaUnits += int64(units)
+	if id == "" {
+		return
+	}
+	if usage.UserQuota == nil {
+		usage.UserQuota = make(map[string]int64)
+	}
+	usage.UserQuota[id] += int64(units)
+}
+
+// quotaToday returns the quota units a user consumed today
+func (t *UsageTracker) quotaToday(userID string) int64 {
+	id := usageUserID(userID)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.day("").UserQuota[id]
+}
+
+// addJob records a finished job
+func (t *UsageTracker) addJob(userID, kind string, failed bool) {
+	id := usageUserID(userID)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := t.day(id)
+	usage.Jobs[kind]++
+	if failed {
+		usage.FailedJobs++
+	}
+}
+
+// addDryRun records a call a dry run skipped, which would have acted on count objects
+func (t *UsageTracker) addDryRun(userID, method string, count int) {
+	id := usageUserID(userID)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := t.day(id)
+	if usage.DryRun == nil {
+		usage.DryRun = make(map[string]int)
+	}
+	usage.DryRun[method] += count
+}
+
+// Flush saves the usage collected in memory and forgets all days but today
+func (t *UsageTracker) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	today := time.Now().UTC().Format("2006-01-02")
+	for date, usage := range t.days {
+		if err := Storage.Put(usageKey(date), usage); err != nil {
+			log.Printf("Failed to save usage of %s: %s", date, redactError(err))
+			continue
+		}
+		if date != today {
+			delete(t.days, date)
+			delete(t.users, date)
+		}
+	}
+}
+
+// UserUsage is what one user consumed on one day
+type UserUsage struct {
+	Date       string `json:"date"`
+	QuotaUnits int64  `json:"quotaUnits"`
+}
+
+// userHistory returns the quota an account consumed on each day usage recorded it,
+// oldest first
+func (t *UsageTracker) userHistory(account string) ([]UserUsage, error) {
+	id := usageID(account)
+	t.Flush()
+	keys, err := Storage.List(usageKey(""))
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]UserUsage, 0)
+	for _, key := range keys {
+		var usage DailyUsage
+		if _, err := Storage.Get(key, &usage); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(usage.Users, id) {
+			continue
+		}
+		history = append(history, UserUsage{Date: usage.Date, QuotaUnits: usage.UserQuota[id]})
+	}
+	return history, nil
+}
+
+// forget removes an account's usage ID from the usage of every day, in memory and
+// storage, and returns how many days mentioned it. The days' totals stay as they are.
+func (t *UsageTracker) forget(account string) (int, error) {
+	id := usageID(account)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dates := make(map[string]struct{})
+	for date, usage := range t.days {
+		if forgetUsageUser(usage, id) {
+			dates[date] = struct{}{}
+		}
+		delete(t.users[date], id)
+	}
+
+	keys, err := Storage.List(usageKey(""))
+	if err != nil {
+		return len(dates), err
+	}
+	for _, key := range keys {
+		var usage DailyUsage
+		if _, err := Storage.Get(key, &usage); err != nil {
+			return len(dates), err
+		}
+		if !forgetUsageUser(&usage, id) {
+			continue
+		}
+		if err := Storage.Put(key, &usage); err != nil {
+			return len(dates), err
+		}
+		dates[usage.Date] = struct{}{}
+	}
+	return len(dates), nil
+}
+
+// forgetUsageUser removes a usage ID from a day's usage, reporting whether it was there
+func forgetUsageUser(usage *DailyUsage, id string) bool {
+	_, found := usage.UserQuota[id]
+	delete(usage.UserQuota, id)
+	if i := slices.Index(usage.Users, id); i >= 0 {
+		usage.Users = slices.Delete(usage.Users, i, i+1)
+		found = true
+	}
+	return found
+}
+
+// flushEvery saves collected usage at a fixed interval
+func (t *UsageTracker) flushEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		t.Flush()
+	}
+}
+
+// dataDirSize returns the bytes stored under the data directory: persisted state,
+// backups and exports
+func dataDirSize() int64 {
+	var size int64
+	filepath.WalkDir(config.DataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// HandleAdminMetrics reports per-day quota consumption, jobs and active users for the
+// last `days` days (default 30), plus the storage and memory the server holds now
+func HandleAdminMetrics(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxUsageDays {
+			writeError(w, "days must be between 1 and "+strconv.Itoa(maxUsageDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	Usage.Flush()
+	since := time.Now().UTC().AddDate(0, 0, 1-days).Format("2006-01-02")
+
+	keys, err := Storage.List(usageKey(""))
+	if err != nil {
+		writeErrorFrom(w, "Failed to read usage", err, http.StatusInternalServerError)
+		return
+	}
+
+	history := make([]DailyUsage, 0, days)
+	for _, key := range keys {
+		var usage DailyUsage
+		if _, err := Storage.Get(key, &usage); err != nil {
+			writeErrorFrom(w, "Failed to read usage", err, http.StatusInternalServerError)
+			return
+		}
+		if usage.Date < since {
+			continue
+		}
+		usage.Users = nil
+		history = append(history, usage)
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Date < history[j].Date
+	})
+
+	var memory int64
+	cached := 0
+	processors := Registry.All()
+	for _, processor := range processors {
+		usage := processor.Usage()
+		memory += usage.MemoryBytes
+		cached += usage.CachedMessages
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"days": history,
+		"current": map[string]interface{}{
+			"accounts":       len(processors),
+			"cachedMessages": cached,
+			"memoryBytes":    memory,
+			"diskBytes":      dataDirSize(),
+		},
+	})
+}
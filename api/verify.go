@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Verification reports an independent re-check that an operation left messages in the
+// state it claimed
+type Verification struct {
+	Checked   int `json:"checked"`
+	Confirmed int `json:"confirmed"`
+	// Why each unconfirmed message doesn't match, by message ID
+	Discrepancies map[string]string `json:"discrepancies"`
+	Error         string            `json:"error,omitempty"`
+}
+
+// verifyMessages re-fetches messages and checks each still carries label, or with an
+// empty label, that it no longer exists
+func verifyMessages(mb *mailbox, ids []string, label string) *Verification {
+	verification := &Verification{Checked: len(ids), Discrepancies: make(map[string]string)}
+
+	check := func(id string) error {
+		msg, err := mb.provider.GetMessage(mb.context(), mb.user, id, "minimal")
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			if label == "" {
+				return nil
+			}
+			return errors.New("message no longer exists")
+		}
+		if err != nil {
+			return err
+		}
+		if label == "" {
+			return errors.New("message still exists")
+		}
+		for _, l := range msg.LabelIds {
+			if l == label {
+				return nil
+			}
+		}
+		return errors.New("message is not labeled " + label)
+	}
+	err := runPlanned(context.Background(), mb.quota, ids, costMessagesGet, check, func(results map[string]error) bool {
+		for id, err := range results {
+			if err != nil {
+				verification.Discrepancies[id] = redactError(err)
+			} else {
+				verification.Confirmed++
+			}
+		}
+		return true
+	})
+	if err != nil {
+		verification.Error = "stopped early: " + redactError(err)
+	}
+	return verification
+}
+
+// verifyOperation rescans the messages a confirmed operation reports acting on and
+// attaches the outcome to its result. ids are the messages the operation was given.
+func verifyOperation(mb *mailbox, kind string, result interface{}, ids []string) {
+	var verification *Verification
+	switch res := result.(type) {
+	case *TrashResult:
+		verification = verifyMessages(mb, res.Trashed, "TRASH")
+		res.Verification = verification
+	case *CampaignResult:
+		verification = verifyMessages(mb, res.trashedIDs(), "TRASH")
+		res.Verification = verification
+	case *BlockResult:
+		verification = verifyMessages(mb, res.trashedIDs(), "TRASH")
+		res.Verification = verification
+	case *EmptyResult:
+		// Deletion goes in order, so the deleted messages are the first ones
+		verification = verifyMessages(mb, ids[:res.Deleted], "")
+		res.Verification = verification
+	default:
+		return
+	}
+
+	if len(verification.Discrepancies) > 0 {
+		log.Printf("Verification of %s found %d of %d messages not as reported", kind, len(verification.Discrepancies), verification.Checked)
+	}
+}
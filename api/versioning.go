@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Version the unversioned /api paths of before versioning map to
+const legacyAPIVersion = 1
+
+type apiVersionKey struct{}
+
+// Version tags the requests a versioned subrouter handles with its API version, so
+// handlers shared between versions can tell which behavior the client expects
+func Version(version int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+		})
+	}
+}
+
+// requestAPIVersion returns the API version a request was made against
+func requestAPIVersion(r *http.Request) int {
+	if version, ok := r.Context().Value(apiVersionKey{}).(int); ok {
+		return version
+	}
+	return legacyAPIVersion
+}
+
+// apiPath returns p, such as "/shared/abc", under the API version of the request
+func apiPath(r *http.Request, p string) string {
+	return "/api/v" + strconv.Itoa(requestAPIVersion(r)) + p
+}
+
+var versionedPath = regexp.MustCompile(`^/api/v[0-9]+(/|$)`)
+
+// LegacyPaths serves requests to the unversioned /api paths as requests to the same path
+// under /api/v1, so frontends and scripts written before versioning keep working.
+// Responses are marked deprecated and link the versioned path.
+func LegacyPaths(router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A versioned path nothing matched doesn't exist in that version
+		if versionedPath.MatchString(r.URL.Path) {
+			writeError(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		rest := strings.TrimPrefix(r.URL.Path, "/api")
+		versioned := "/api/v" + strconv.Itoa(legacyAPIVersion) + rest
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+versioned+`>; rel="successor-version"`)
+
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = versioned
+		r2.URL.RawPath = ""
+		router.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,414 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Events a webhook can subscribe to
+const (
+	eventScanCompleted = "scan.completed"
+	eventScanFailed    = "scan.failed"
+	eventJobSucceeded  = "job.succeeded"
+	eventJobFailed     = "job.failed"
+	// Sent by HandleTestWebhook only
+	eventPing = "ping"
+)
+
+var webhookEvents = []string{eventScanCompleted, eventScanFailed, eventJobSucceeded, eventJobFailed}
+
+const (
+	// Most webhooks one account may register
+	maxWebhooks = 10
+	// Deliveries are attempted this many times while the receiver is unreachable or
+	// answers with a server error
+	webhookAttempts = 3
+)
+
+// Client for webhook deliveries. It connects directly, never through a proxy, so its
+// dialer sees the receiver's address.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: addressDialControl(errWebhookAddress)}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+var errWebhookAddress = errors.New("webhooks can only be delivered to public addresses")
+
+// Pause before each retry of a delivery
+var webhookRetryDelays = []time.Duration{5 * time.Second, 30 * time.Second}
+
+// Serializes read-modify-write updates of webhooks
+var webhooksMu sync.Mutex
+
+// Webhook is a URL that receives a signed POST when one of its events happens
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events to send; all of them if empty
+	Events []string `json:"events"`
+	// Key of the HMAC-SHA256 signature of deliveries. It is only returned when the
+	// webhook is created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookEvent is the body of a webhook delivery
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	Account   string      `json:"account"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// webhookRequest is the body of HandleCreateWebhook
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// webhooksKey is the storage key of an account's webhooks
+func webhooksKey(account string) string {
+	return "webhooks/" + account
+}
+
+// loadWebhooks returns an account's webhooks, with their secrets
+func loadWebhooks(account string) ([]Webhook, error) {
+	hooks := make([]Webhook, 0)
+	if _, err := Storage.Get(webhooksKey(account), &hooks); err != nil {
+		return nil, fmt.Errorf("failed to load webhooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// withoutSecrets returns copies of webhooks with their secrets cleared
+func withoutSecrets(hooks []Webhook) []Webhook {
+	cleared := slices.Clone(hooks)
+	for i := range cleared {
+		cleared[i].Secret = ""
+	}
+	return cleared
+}
+
+// wants reports whether a webhook subscribed to an event
+func (h *Webhook) wants(event string) bool {
+	return event == eventPing || len(h.Events) == 0 || slices.Contains(h.Events, event)
+}
+
+// webhookAddressAllowed reports whether deliveries may connect to an address: any
+// public one, and the others in the networks the operator allowed
+func webhookAddressAllowed(ip net.IP) bool {
+	for _, ipNet := range config.WebhookAllowedNetworks {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// addressDialControl returns a dialer Control refusing connections to addresses
+// webhookAddressAllowed rejects with errAddress. It checks the address actually dialed,
+// so a receiver's name that resolved to a public address when it was registered can't
+// later point deliveries inside.
+func addressDialControl(errAddress error) func(network, address string, c syscall.RawConn) error {
+	return func(_, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !webhookAddressAllowed(ip) {
+			return fmt.Errorf("%w, not %s", errAddress, host)
+		}
+		return nil
+	}
+}
+
+// disallowedAddress returns an address of host, an IP or a name, that deliveries may
+// not connect to, or nil if there is none. A name that doesn't resolve yet is left to
+// the delivery to check.
+func disallowedAddress(host string) net.IP {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ips, _ = net.LookupIP(host)
+	}
+	for _, ip := range ips {
+		if !webhookAddressAllowed(ip) {
+			return ip
+		}
+	}
+	return nil
+}
+
+// validate checks a webhook request's URL and events. A URL whose host is or resolves
+// to an address deliveries may not connect to is refused up front.
+func (req *webhookRequest) validate() error {
+	req.URL = strings.TrimSpace(req.URL)
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL")
+	}
+	if u.User != nil {
+		return fmt.Errorf("url must not contain credentials")
+	}
+	if ip := disallowedAddress(u.Hostname()); ip != nil {
+		return fmt.Errorf("%w, not %s", errWebhookAddress, ip)
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("unknown event %q; events are %s", event, strings.Join(webhookEvents, ", "))
+		}
+	}
+	return nil
+}
+
+// signWebhook returns the signature of a delivery: the hex HMAC-SHA256 of the
+// timestamp, a dot and the body, keyed by the webhook's secret
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook POSTs an event to a webhook once and returns the receiver's status
+func deliverWebhook(hook *Webhook, event *WebhookEvent, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gmail-deepclean-webhook")
+	req.Header.Set("X-Deepclean-Event", event.Event)
+	req.Header.Set("X-Deepclean-Delivery", event.ID)
+	req.Header.Set("X-Deepclean-Timestamp", timestamp)
+	req.Header.Set("X-Deepclean-Signature", signWebhook(hook.Secret, timestamp, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// sendWebhook delivers an event, retrying while the receiver is unreachable, rate
+// limits or fails
+func sendWebhook(hook Webhook, event *WebhookEvent, body []byte) {
+	for attempt := 0; ; attempt++ {
+		status, err := deliverWebhook(&hook, event, body)
+		if err == nil {
+			return
+		}
+		retryable := status == 0 || status == http.StatusTooManyRequests || status >= 500
+		if !retryable || attempt+1 >= webhookAttempts {
+			log.Printf("Webhook delivery %s of %s failed: %s", event.ID, event.Event, redactError(err))
+			return
+		}
+		time.Sleep(webhookRetryDelays[min(attempt, len(webhookRetryDelays)-1)])
+	}
+}
+
+// publishEvent sends an event to every webhook of the account that subscribed to it,
+// in the background
+func publishEvent(account, name string, data interface{}) {
+	if !Features().Webhooks || account == "" {
+		return
+	}
+	hooks, err := loadWebhooks(account)
+	if err != nil {
+		log.Printf("Not sending %s: %s", name, redactError(err))
+		return
+	}
+
+	event := &WebhookEvent{ID: newID(), Event: name, Account: account, CreatedAt: time.Now().UTC(), Data: data}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Not sending %s: %s", name, redactError(err))
+		return
+	}
+	for _, hook := range hooks {
+		if hook.wants(name) {
+			go sendWebhook(hook, event, body)
+		}
+	}
+}
+
+// publishJobEvent sends the event of a finished job: scan.completed or scan.failed for
+// scans, job.succeeded or job.failed for other jobs. Interactive jobs, which a request
+// is waiting on, are left out, and so are jobs of no known account.
+func publishJobEvent(job Job) {
+	var name string
+	switch {
+	case job.Kind == "scan":
+		name = eventScanCompleted
+		if progress, ok := job.Result.(map[string]interface{}); job.State == JobFailed || ok && progress["aborted"] == true {
+			name = eventScanFailed
+		}
+	case job.Priority == PriorityInteractive:
+		return
+	case job.State == JobFailed:
+		name = eventJobFailed
+	default:
+		name = eventJobSucceeded
+	}
+
+	publishEvent(job.Account, name, job)
+}
+
+// HandleListWebhooks returns the user's webhooks, without their secrets
+func HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	hooks, err := loadWebhooks(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, withoutSecrets(hooks))
+}
+
+// HandleCreateWebhook registers a webhook for some or all events and returns it with
+// its signing secret, which is never shown again
+func HandleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireFeature(w, Features().Webhooks, "webhooks") {
+		return
+	}
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorFrom(w, "Invalid request body", err, http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeErrorFrom(w, "", err, http.StatusBadRequest)
+		return
+	}
+
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	hook := Webhook{
+		ID:        newID(),
+		URL:       req.URL,
+		Events:    req.Events,
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: time.Now(),
+	}
+	if hook.Events == nil {
+		hook.Events = make([]string, 0)
+	}
+
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+
+	hooks, err := loadWebhooks(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	if len(hooks) >= maxWebhooks {
+		writeError(w, fmt.Sprintf("An account may have at most %d webhooks", maxWebhooks), http.StatusBadRequest)
+		return
+	}
+	hooks = append(hooks, hook)
+	if err := Storage.Put(webhooksKey(account), hooks); err != nil {
+		writeErrorFrom(w, "Failed to save webhook", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, hook)
+}
+
+// HandleDeleteWebhook removes a webhook
+func HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+
+	hooks, err := loadWebhooks(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	kept := slices.DeleteFunc(slices.Clone(hooks), func(hook Webhook) bool { return hook.ID == id })
+	if len(kept) == len(hooks) {
+		writeError(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err := Storage.Put(webhooksKey(account), kept); err != nil {
+		writeErrorFrom(w, "Failed to delete webhook", err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, withoutSecrets(kept))
+}
+
+// HandleTestWebhook sends a ping event to a webhook right away, once, and reports how
+// the receiver answered
+func HandleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireFeature(w, Features().Webhooks, "webhooks") {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	account := accountForRequest(w, r)
+	if account == "" {
+		return
+	}
+
+	hooks, err := loadWebhooks(account)
+	if err != nil {
+		writeErrorFrom(w, "", err, http.StatusInternalServerError)
+		return
+	}
+	i := slices.IndexFunc(hooks, func(hook Webhook) bool { return hook.ID == id })
+	if i < 0 {
+		writeError(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	event := &WebhookEvent{ID: newID(), Event: eventPing, Account: account, CreatedAt: time.Now().UTC(), Data: map[string]string{"webhookId": id}}
+	body, _ := json.Marshal(event)
+	status, err := deliverWebhook(&hooks[i], event, body)
+	result := map[string]interface{}{"delivered": err == nil, "status": status}
+	if err != nil {
+		result["error"] = redactError(err)
+	}
+	writeJSON(w, result)
+}
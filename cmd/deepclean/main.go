@@ -0,0 +1,132 @@
+// Command deepclean cleans a Gmail inbox from the terminal. It reuses the server's
+// scanning and cleanup code but talks to Gmail directly, with a token cached locally
+// by `deepclean login`.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+	"golang.org/x/oauth2"
+
+	"github.com/dustinmichels/gmail-deepclean/api"
+)
+
+// Flags shared by every command
+var (
+	configFile  string
+	tokenFile   string
+	impersonate string
+	verbose     bool
+)
+
+func main() {
+	// Stop scans and cleanups on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	root := &cobra.Command{
+		Use:           "deepclean",
+		Short:         "Find and clean out what's filling up a Gmail inbox",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return initAPI()
+		},
+	}
+	root.PersistentFlags().StringVar(&configFile, "config", "", "YAML config file ($CONFIG_FILE)")
+	root.PersistentFlags().StringVar(&tokenFile, "token", defaultTokenFile(), "File the signed-in token is cached in")
+	root.PersistentFlags().StringVar(&impersonate, "impersonate", "", "Act as this user of a Workspace domain through the service account instead (needs SERVICE_ACCOUNT_FILE)")
+	root.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Log what the scan and cleanups are doing")
+
+	root.AddCommand(loginCommand(), scanCommand(), topSendersCommand(), trashCommand())
+
+	if err := root.ExecuteContext(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// initAPI loads the same configuration as the server, from the config file and the
+// environment, and initializes the api package with it
+func initAPI() error {
+	if !verbose {
+		log.SetOutput(io.Discard)
+	}
+	godotenv.Load()
+
+	var args []string
+	if configFile != "" {
+		args = []string{"--config", configFile}
+	}
+	cfg, err := api.LoadConfig(args)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return fmt.Errorf("GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET must be set")
+	}
+	api.Init(cfg)
+	return nil
+}
+
+// defaultTokenFile returns where the token is cached unless --token says otherwise:
+// ~/.config/deepclean/token.json, or under $XDG_CONFIG_HOME if that is set
+func defaultTokenFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "deepclean-token.json"
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "deepclean", "token.json")
+}
+
+// loadToken reads the cached token of the signed-in user, or with --impersonate gets a
+// token acting as that user through domain-wide delegation
+func loadToken() (*oauth2.Token, error) {
+	if impersonate != "" {
+		return api.DelegatedToken(impersonate)
+	}
+	return api.LoadToken(tokenFile)
+}
+
+// loginCommand signs in with Google and caches the token
+func loginCommand() *cobra.Command {
+	var device bool
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Sign in with Google and cache the token for the other commands",
+		Long: "Sign in with Google and cache the token for the other commands.\n\n" +
+			"By default Google redirects the browser back to a port on this machine. On a\n" +
+			"machine without a browser, --device prints a code to enter on another device.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			login := api.LoginLocal
+			if device {
+				login = api.LoginDevice
+			}
+			token, err := login(cmd.Context(), cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			if err := api.SaveToken(tokenFile, token); err != nil {
+				return fmt.Errorf("failed to cache token: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Signed in. Token cached in %s\n", tokenFile)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&device, "device", false, "Sign in with a code entered on another device")
+	return cmd
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dustinmichels/gmail-deepclean/api"
+)
+
+// How often a running scan reports its progress
+const progressInterval = 2 * time.Second
+
+// scanCommand scans the mailbox and prints an overview of it
+func scanCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "scan",
+		Short: "Scan the mailbox and summarize what's in it",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			processor, err := scanMailbox(cmd.Context(), cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+
+			stats := processor.GetStats()
+			var size int64
+			for _, s := range stats.FromSize {
+				size += s
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "%d messages, %s, from %d senders\n", stats.TotalEmails, api.FormatBytes(size), len(stats.FromCount))
+
+			// Categories, largest first
+			categories := make([]string, 0, len(stats.CategoryCount))
+			for category := range stats.CategoryCount {
+				categories = append(categories, category)
+			}
+			sort.Slice(categories, func(i, j int) bool {
+				return stats.CategoryCount[categories[i]] > stats.CategoryCount[categories[j]]
+			})
+			for _, category := range categories {
+				fmt.Fprintf(out, "  %-14s %d\n", category, stats.CategoryCount[category])
+			}
+			return nil
+		},
+	}
+}
+
+// topSendersCommand scans the mailbox and lists the senders taking up the most of it
+func topSendersCommand() *cobra.Command {
+	var (
+		limit int
+		by    string
+	)
+	cmd := &cobra.Command{
+		Use:   "top-senders",
+		Short: "Scan the mailbox and list the senders with the most mail",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if by != "count" && by != "size" {
+				return fmt.Errorf(`--by must be "count" or "size", got %q`, by)
+			}
+			processor, err := scanMailbox(cmd.Context(), cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+
+			table := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "SENDER\tMESSAGES\tSIZE\tCATEGORY")
+			for _, sender := range processor.GetTopSenders(0, limit, by, "") {
+				category, _ := sender["category"].(string)
+				fmt.Fprintf(table, "%s\t%d\t%s\t%s\n", sender["email"], sender["count"], api.FormatBytes(sender["size"].(int64)), category)
+			}
+			return table.Flush()
+		},
+	}
+	cmd.Flags().IntVarP(&limit, "limit", "n", 20, "Number of senders to list")
+	cmd.Flags().StringVar(&by, "by", "count", `Rank senders by "count" or "size"`)
+	return cmd
+}
+
+// scanMailbox scans the signed-in user's mailbox, reporting progress to out. Messages
+// earlier scans fetched aren't downloaded again.
+func scanMailbox(ctx context.Context, out io.Writer) (*api.InboxProcessor, error) {
+	token, err := loadToken()
+	if err != nil {
+		return nil, err
+	}
+	processor, err := api.NewInboxProcessor(token, "me", api.ScanScope{})
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintf(out, "\rScanned %d messages...", processor.EmailCount())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err = processor.Scan(ctx)
+	close(done)
+	fmt.Fprintf(out, "\rScanned %d messages.   \n", processor.EmailCount())
+	return processor, err
+}
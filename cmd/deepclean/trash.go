@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dustinmichels/gmail-deepclean/api"
+)
+
+// Ages Gmail's older_than operator accepts, e.g. 30d, 6m or 2y
+var agePattern = regexp.MustCompile(`^[0-9]+[dmy]$`)
+
+// trashCommand moves the messages matching the given criteria to trash
+func trashCommand() *cobra.Command {
+	var (
+		from      string
+		olderThan string
+		query     string
+		dryRun    bool
+		yes       bool
+	)
+	cmd := &cobra.Command{
+		Use:   "trash",
+		Short: "Move messages matching the given criteria to trash",
+		Example: "  deepclean trash --from x@y.com --older-than 2y --dry-run\n" +
+			"  deepclean trash --query \"category:promotions larger:5M\" --yes",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Build the Gmail search; an empty one would match the whole mailbox
+			var terms []string
+			if from != "" {
+				terms = append(terms, api.SenderQuery(from))
+			}
+			if olderThan != "" {
+				if !agePattern.MatchString(olderThan) {
+					return fmt.Errorf("--older-than must be a number of days, months or years such as 30d, 6m or 2y, got %q", olderThan)
+				}
+				terms = append(terms, "older_than:"+olderThan)
+			}
+			if query != "" {
+				terms = append(terms, "("+query+")")
+			}
+			if len(terms) == 0 {
+				return fmt.Errorf("give at least one of --from, --older-than or --query")
+			}
+
+			token, err := loadToken()
+			if err != nil {
+				return err
+			}
+			plan, err := api.PlanTrash(cmd.Context(), token, strings.Join(terms, " "))
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Matching %s: %s\n", plan.Query, plan.Summary.Savings)
+			for _, sample := range plan.Summary.Sample {
+				fmt.Fprintf(out, "  %s  %s  %s\n", sample.Date, sample.From, sample.Subject)
+			}
+			if dryRun || plan.Summary.Count == 0 {
+				return nil
+			}
+
+			if !yes {
+				fmt.Fprintf(out, "Move %d messages to trash? [y/N] ", plan.Summary.Count)
+				answer, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+				if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+					fmt.Fprintln(out, "Nothing trashed.")
+					return nil
+				}
+			}
+
+			result, err := plan.Execute()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Trashed %d messages", len(result.Trashed))
+			if len(result.Protected) > 0 {
+				fmt.Fprintf(out, ", skipped %d covered by protection rules", len(result.Protected))
+			}
+			if len(result.Failed) > 0 {
+				fmt.Fprintf(out, ", %d failed", len(result.Failed))
+			}
+			fmt.Fprintln(out, ".")
+			if result.Aborted {
+				return fmt.Errorf("cleanup stopped early: %s", result.AbortReason)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "Only messages from this sender")
+	cmd.Flags().StringVar(&olderThan, "older-than", "", "Only messages older than this, e.g. 30d, 6m or 2y")
+	cmd.Flags().StringVar(&query, "query", "", "Only messages matching this Gmail search")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only show what would be trashed")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Don't ask for confirmation")
+	return cmd
+}
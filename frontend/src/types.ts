@@ -1,12 +1,11 @@
 // src/types.ts
 
-export interface OAuthToken {
-  access_token: string
-  token_type: string
-  refresh_token?: string
-  expiry?: string
-  expires_in?: number
-  scope?: string
+// Short-lived token standing for the OAuth token the server holds
+export interface Session {
+  token: string
+  expiresAt: string
+  // Access granted when signing in: modify, readonly or metadata
+  access?: string
 }
 
 export interface Email {
@@ -0,0 +1,11 @@
+//go:build !embed
+
+package main
+
+import "io/fs"
+
+// embeddedFrontend returns nil: without the embed build tag the frontend is served from
+// disk
+func embeddedFrontend() fs.FS {
+	return nil
+}
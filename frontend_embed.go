@@ -0,0 +1,23 @@
+//go:build embed
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+)
+
+// The built frontend, embedded by building with -tags embed after building the frontend
+//
+//go:embed all:frontend/dist
+var frontendDist embed.FS
+
+// embeddedFrontend returns the frontend files embedded in the binary
+func embeddedFrontend() fs.FS {
+	files, err := fs.Sub(frontendDist, "frontend/dist")
+	if err != nil {
+		log.Fatalf("Failed to load embedded frontend: %v", err)
+	}
+	return files
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/dustinmichels/gmail-deepclean/api"
 )
@@ -19,35 +28,340 @@ func init() {
 	}
 }
 
+// How long shutdown waits for requests and scans to wind down
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	router := mux.NewRouter()
 
+	// Read the config file, environment and flags
+	cfg, err := api.LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Initialize API
-	api.Init()
+	api.Init(cfg)
+
+	// Export traces if an OTLP endpoint is configured
+	shutdownTracing, err := api.InitTracing(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
 
-	// API Routes
+	// Sign-in stays unversioned, since its callback URL is registered with Google
 	router.HandleFunc("/auth/gmail", api.HandleGmailAuth).Methods("GET")
 	router.HandleFunc("/auth/gmail/callback", api.HandleGmailCallback).Methods("GET")
-	router.HandleFunc("/api/emails", api.HandleGetEmails).Methods("GET")
-	router.HandleFunc("/api/emails/{id}", api.HandleDeleteEmail).Methods("DELETE")
+	router.HandleFunc("/auth/imap", api.HandleIMAPConnect).Methods("POST")
+	router.HandleFunc("/auth/imap", api.HandleIMAPDisconnect).Methods("DELETE")
+	router.HandleFunc("/auth/session/refresh", api.HandleRefreshSession).Methods("POST")
+	router.HandleFunc("/auth/session", api.HandleEndSession).Methods("DELETE")
+	router.HandleFunc("/auth/csrf", api.HandleGetCSRFToken).Methods("GET")
 
-	// Inbox processing routes
-	router.HandleFunc("/api/inbox/process", api.HandleStartProcessingInbox).Methods("POST")
-	router.HandleFunc("/api/inbox/status", api.HandleGetInboxStatus).Methods("GET")
-	router.HandleFunc("/api/inbox/top-senders", api.HandleGetTopSenders).Methods("GET")
-	router.HandleFunc("/api/inbox/stats", api.HandleGetEmailStats).Methods("GET")
+	// Versioned API. A breaking change goes into a new version whose register function
+	// adds the changed routes and then calls the previous version's for the rest, since
+	// the first matching route wins.
+	v1 := router.PathPrefix("/api/v1").Subrouter()
+	v1.Use(api.Version(1), api.Sessions)
+	registerV1(v1)
+
+	// OpenAPI document of the routes above, and Swagger UI for it
+	api.RegisterDocs(router, v1)
+
+	// The gRPC API as JSON over HTTP
+	gateway, err := api.GatewayHandler(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to set up the gRPC gateway: %v", err)
+	}
+	router.PathPrefix("/gateway/").Handler(api.Sessions(gateway))
+
+	// The unversioned paths of before versioning
+	router.PathPrefix("/api/").Handler(api.LegacyPaths(router))
+
+	// Turn handler panics into 500 responses
+	router.Use(api.RecoverPanics)
 
-	// Serve Svelte frontend from dist directory
-	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./frontend/dist")))
+	// Serve the frontend, embedded in the binary when built with -tags embed
+	router.PathPrefix("/").Handler(api.FrontendHandler(embeddedFrontend()))
+
+	// Serve HTTPS if a certificate or Let's Encrypt hostnames are configured
+	tlsConfig, err := api.ServerTLS()
+	if err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
+	}
 
 	// Start server
-	port := os.Getenv("PORT")
+	port := cfg.Port
 	if port == "" {
 		port = "8080"
+		if tlsConfig != nil {
+			port = "443"
+		}
+	}
+
+	server := &http.Server{
+		Addr:      ":" + port,
+		Handler:   api.TrustProxies(api.CORS(api.CSRF(api.Compress(api.TraceRequests(router))))),
+		TLSConfig: tlsConfig,
+	}
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		var err error
+		if tlsConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Plain HTTP redirects to HTTPS and answers Let's Encrypt's challenges
+	var redirectServer *http.Server
+	if redirectPort := cfg.HTTPRedirectPort; tlsConfig != nil && redirectPort != "off" {
+		redirectServer = &http.Server{Addr: ":" + redirectPort, Handler: api.RedirectToHTTPS(port)}
+		go func() {
+			log.Printf("Redirecting HTTP on port %s to HTTPS", redirectPort)
+			if err := redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("HTTP redirect server: %v", err)
+			}
+		}()
 	}
 
-	log.Printf("Server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, router); err != nil {
-		log.Fatal(err)
+	// Serve the gRPC API on its own port, over TLS like the HTTP API
+	var grpcServer *grpc.Server
+	if grpcPort := cfg.GRPCPort; grpcPort != "" {
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcServer = api.NewGRPCServer(opts...)
+		listener, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatalf("Failed to listen for gRPC: %v", err)
+		}
+		go func() {
+			log.Printf("gRPC server starting on port %s", grpcPort)
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Printf("gRPC server: %v", err)
+			}
+		}()
 	}
+
+	// Wait for Ctrl-C or a redeploy
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Printf("Shutting down")
+
+	// Stop accepting requests and let in-flight ones finish, then checkpoint scans
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+	if redirectServer != nil {
+		redirectServer.Shutdown(shutdownCtx)
+	}
+	if grpcServer != nil {
+		stopGRPC(shutdownCtx, grpcServer)
+	}
+	api.Shutdown(shutdownCtx)
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
+}
+
+// stopGRPC lets in-flight gRPC calls finish, cutting off those still running when ctx
+// is done, such as progress streams of long scans
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
+
+// registerV1 registers the routes of version 1 of the API
+func registerV1(r *mux.Router) {
+	r.HandleFunc("/me", api.HandleGetMe).Methods("GET")
+	r.HandleFunc("/me/data", api.HandlePurgeMyData).Methods("DELETE")
+	r.HandleFunc("/me/export", api.HandleExportMyData).Methods("GET")
+	r.HandleFunc("/storage", api.HandleGetStorageQuota).Methods("GET")
+	r.HandleFunc("/emails", api.HandleGetEmails).Methods("GET")
+	r.HandleFunc("/emails/{id}", api.HandleGetEmail).Methods("GET")
+	r.HandleFunc("/emails/{id}", api.HandleDeleteEmail).Methods("DELETE")
+	r.HandleFunc("/emails/batch-trash", api.HandleBatchTrash).Methods("POST")
+	r.HandleFunc("/emails/batch-archive", api.HandleBatchArchive).Methods("POST")
+	r.HandleFunc("/emails/batch-untrash", api.HandleBatchUntrash).Methods("POST")
+	r.HandleFunc("/emails/delete-by-query", api.HandleDeleteByQuery).Methods("POST")
+	r.HandleFunc("/emails/archive-by-query", api.HandleArchiveByQuery).Methods("POST")
+	r.HandleFunc("/emails/batch-mark-read", api.HandleBatchMarkRead).Methods("POST")
+	r.HandleFunc("/emails/mark-read-by-query", api.HandleMarkReadByQuery).Methods("POST")
+	r.HandleFunc("/emails/batch-spam", api.HandleBatchReportSpam).Methods("POST")
+	r.HandleFunc("/emails/{id}/spam", api.HandleReportSpam).Methods("POST")
+	r.HandleFunc("/emails/{id}/strip-attachments", api.HandleStripAttachments).Methods("POST")
+	r.HandleFunc("/emails/{id}/attachments/drive", api.HandleSaveAttachmentsToDrive).Methods("POST")
+	r.HandleFunc("/threads/{id}/mute", api.HandleMuteThread).Methods("POST")
+	r.HandleFunc("/threads/prune", api.HandlePruneThreads).Methods("POST")
+
+	// Sender actions
+	r.HandleFunc("/senders/{email}/timeline", api.HandleGetSenderTimeline).Methods("GET")
+	r.HandleFunc("/senders/{email}/mute", api.HandleMuteSender).Methods("POST")
+	r.HandleFunc("/senders/{email}/filter", api.HandleCreateSenderFilter).Methods("POST")
+	r.HandleFunc("/senders/{email}/block", api.HandleBlockSender).Methods("POST")
+	r.HandleFunc("/senders/block", api.HandleBatchBlockSenders).Methods("POST")
+	r.HandleFunc("/senders/{email}/trash", api.HandleTrashSender).Methods("POST")
+	r.HandleFunc("/senders/{email}/archive", api.HandleArchiveSender).Methods("POST")
+	r.HandleFunc("/senders/{email}/mark-read", api.HandleMarkSenderRead).Methods("POST")
+
+	// Unsubscribe campaigns
+	r.HandleFunc("/campaigns/unsubscribe", api.HandleUnsubscribeCampaign).Methods("POST")
+
+	// Mailing list actions
+	r.HandleFunc("/lists/{listId}/trash", api.HandleTrashMailingList).Methods("POST")
+	r.HandleFunc("/lists/{listId}/archive", api.HandleArchiveMailingList).Methods("POST")
+
+	// Subject cluster actions
+	r.HandleFunc("/clusters/{id}/trash", api.HandleTrashSubjectCluster).Methods("POST")
+	r.HandleFunc("/clusters/{id}/archive", api.HandleArchiveSubjectCluster).Methods("POST")
+
+	// Label actions
+	r.HandleFunc("/labels/{labelId}/trash", api.HandleTrashLabel).Methods("POST")
+	r.HandleFunc("/labels/{labelId}/archive", api.HandleArchiveLabel).Methods("POST")
+
+	// Calendar cleanup
+	r.HandleFunc("/calendar/trash", api.HandleTrashStaleCalendarMail).Methods("POST")
+
+	// Cleanup presets
+	r.HandleFunc("/presets", api.HandleListPresets).Methods("GET")
+	r.HandleFunc("/presets/{id}/run", api.HandleRunPreset).Methods("POST")
+
+	// Inbox processing routes
+	r.HandleFunc("/inbox/process", api.HandleStartProcessingInbox).Methods("POST")
+	r.HandleFunc("/inbox/status", api.HandleGetInboxStatus).Methods("GET")
+	r.HandleFunc("/inbox/top-senders", api.HandleGetTopSenders).Methods("GET")
+	r.HandleFunc("/inbox/senders", api.HandleListSenders).Methods("GET")
+	r.HandleFunc("/inbox/senders/dormant", api.HandleGetDormantSenders).Methods("GET")
+	r.HandleFunc("/inbox/recommendations", api.HandleGetRecommendations).Methods("GET")
+	r.HandleFunc("/inbox/search", api.HandleSearchEmails).Methods("GET")
+	r.HandleFunc("/inbox/stats", api.HandleGetEmailStats).Methods("GET")
+	r.HandleFunc("/inbox/lists", api.HandleGetMailingLists).Methods("GET")
+	r.HandleFunc("/inbox/labels", api.HandleGetLabels).Methods("GET")
+	r.HandleFunc("/inbox/clusters", api.HandleGetSubjectClusters).Methods("GET")
+	r.HandleFunc("/inbox/calendar", api.HandleGetCalendarMail).Methods("GET")
+	r.HandleFunc("/inbox/size-audit", api.HandleSizeAudit).Methods("POST")
+	r.HandleFunc("/inbox/export.jsonl", api.HandleExportEmails).Methods("GET")
+	r.HandleFunc("/inbox/export", api.HandleExportEmails).Methods("GET")
+
+	// Destructive operation confirmation and undo
+	r.HandleFunc("/operations", api.HandleListTrashRecords).Methods("GET")
+	r.HandleFunc("/operations/{id}/confirm", api.HandleConfirmOperation).Methods("POST")
+	r.HandleFunc("/operations/{id}/undo", api.HandleUndoOperation).Methods("POST")
+
+	// Audit log
+	r.HandleFunc("/audit", api.HandleGetAuditLog).Methods("GET")
+
+	// Mbox imports
+	r.HandleFunc("/imports", api.HandleCreateImport).Methods("POST")
+	r.HandleFunc("/imports/{id}", api.HandleGetImport).Methods("GET")
+	r.HandleFunc("/imports/{id}", api.HandleDeleteImport).Methods("DELETE")
+	r.HandleFunc("/imports/{id}/chunks", api.HandleUploadImportChunk).Methods("PUT")
+	r.HandleFunc("/imports/{id}/complete", api.HandleCompleteImport).Methods("POST")
+
+	// Classification feedback
+	r.HandleFunc("/feedback", api.HandleGetFeedback).Methods("GET")
+	r.HandleFunc("/feedback/senders/{email}", api.HandleSenderFeedback).Methods("PUT")
+	r.HandleFunc("/feedback/messages/{id}", api.HandleMessageFeedback).Methods("PUT")
+
+	// Protection rules
+	r.HandleFunc("/protection", api.HandleGetProtectionRules).Methods("GET")
+	r.HandleFunc("/protection", api.HandleUpdateProtectionRules).Methods("PUT")
+
+	// Saved searches
+	r.HandleFunc("/searches", api.HandleListSavedSearches).Methods("GET")
+	r.HandleFunc("/searches", api.HandleCreateSavedSearch).Methods("POST")
+	r.HandleFunc("/searches/{id}", api.HandleDeleteSavedSearch).Methods("DELETE")
+	r.HandleFunc("/searches/{id}/run", api.HandleRunSavedSearch).Methods("GET")
+
+	// Webhooks
+	r.HandleFunc("/webhooks", api.HandleListWebhooks).Methods("GET")
+	r.HandleFunc("/webhooks", api.HandleCreateWebhook).Methods("POST")
+	r.HandleFunc("/webhooks/{id}", api.HandleDeleteWebhook).Methods("DELETE")
+	r.HandleFunc("/webhooks/{id}/test", api.HandleTestWebhook).Methods("POST")
+
+	// Notifiers
+	r.HandleFunc("/notifiers", api.HandleListNotifiers).Methods("GET")
+	r.HandleFunc("/notifiers", api.HandleCreateNotifier).Methods("POST")
+	r.HandleFunc("/notifiers/{id}", api.HandleDeleteNotifier).Methods("DELETE")
+	r.HandleFunc("/notifiers/{id}/test", api.HandleTestNotifier).Methods("POST")
+
+	// Cleanup rules
+	r.HandleFunc("/rules", api.HandleListRules).Methods("GET")
+	r.HandleFunc("/rules", api.HandleCreateRule).Methods("POST")
+	r.HandleFunc("/rules/simulate", api.HandleSimulateRules).Methods("GET")
+	r.HandleFunc("/rules/{id}", api.HandleUpdateRule).Methods("PUT")
+	r.HandleFunc("/rules/{id}", api.HandleDeleteRule).Methods("DELETE")
+	r.HandleFunc("/rules/{id}/run", api.HandleRunRule).Methods("POST")
+
+	// Retention policies
+	r.HandleFunc("/retention", api.HandleListRetentionPolicies).Methods("GET")
+	r.HandleFunc("/retention", api.HandleCreateRetentionPolicy).Methods("POST")
+	r.HandleFunc("/retention/{id}", api.HandleUpdateRetentionPolicy).Methods("PUT")
+	r.HandleFunc("/retention/{id}", api.HandleDeleteRetentionPolicy).Methods("DELETE")
+	r.HandleFunc("/retention/{id}/run", api.HandleRunRetentionPolicy).Methods("POST")
+	r.HandleFunc("/retention/{id}/runs", api.HandleGetRetentionRuns).Methods("GET")
+
+	// Trash and spam
+	r.HandleFunc("/trash/empty", api.HandleEmptyTrash).Methods("POST")
+	r.HandleFunc("/spam/empty", api.HandleEmptySpam).Methods("POST")
+	r.HandleFunc("/trash/auto-empty", api.HandleGetAutoEmpty).Methods("GET")
+	r.HandleFunc("/trash/auto-empty", api.HandleUpdateAutoEmpty).Methods("PUT")
+	r.HandleFunc("/trash/auto-empty/run", api.HandleRunAutoEmpty).Methods("POST")
+
+	// Drafts
+	r.HandleFunc("/drafts/report", api.HandleGetDraftsReport).Methods("GET")
+	r.HandleFunc("/drafts/discard", api.HandleDiscardDrafts).Methods("POST")
+
+	// Share links
+	r.HandleFunc("/share", api.HandleCreateShareLink).Methods("POST")
+	r.HandleFunc("/shared/{token}", api.HandleGetSharedDashboard).Methods("GET")
+
+	// Jobs
+	r.HandleFunc("/jobs", api.HandleListJobs).Methods("GET")
+	r.HandleFunc("/jobs/{id}", api.HandleGetJob).Methods("GET")
+
+	// Backups
+	r.HandleFunc("/backups/{id}", api.HandleDownloadBackup).Methods("GET")
+
+	// Feature flags
+	r.HandleFunc("/features", api.HandleGetFeatures).Methods("GET")
+
+	// Admin routes
+	r.HandleFunc("/admin/features", api.HandleUpdateFeatures).Methods("PUT")
+	r.HandleFunc("/admin/usage", api.HandleAdminUsage).Methods("GET")
+	r.HandleFunc("/admin/metrics", api.HandleAdminMetrics).Methods("GET")
+	r.HandleFunc("/admin/delegation", api.HandleGetDelegation).Methods("GET")
+
+	// Workspace admin routes, acting on the domain's users through delegation
+	r.HandleFunc("/admin/org/scans", api.HandleStartOrgScans).Methods("POST")
+	r.HandleFunc("/admin/org/stats", api.HandleGetOrgStats).Methods("GET")
+	r.HandleFunc("/admin/org/retention", api.HandleGetRetentionRules).Methods("GET")
+	r.HandleFunc("/admin/org/retention", api.HandleUpdateRetentionRules).Methods("PUT")
+	r.HandleFunc("/admin/org/retention/run", api.HandleRunRetentionRules).Methods("POST")
 }
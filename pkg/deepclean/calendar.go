@@ -0,0 +1,122 @@
+package deepclean
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Formats of iCalendar DATE-TIME and DATE values (RFC 5545)
+const (
+	icalDateTime = "20060102T150405"
+	icalDate     = "20060102"
+)
+
+// parseCalendarParts reports whether a message payload has a calendar invite, a
+// text/calendar or .ics part, and when the invite's event ends if the calendar data
+// came inline. The end is zero for events that recur without end.
+func parseCalendarParts(part *gmail.MessagePart) (invite bool, end time.Time) {
+	if part == nil {
+		return false, time.Time{}
+	}
+	mimeType := strings.ToLower(part.MimeType)
+	if mimeType == "text/calendar" || mimeType == "application/ics" || strings.HasSuffix(strings.ToLower(part.Filename), ".ics") {
+		invite = true
+		if part.Body != nil && part.Body.Data != "" {
+			end = parseEventEnd(decodePartData(part.Body.Data))
+		}
+	}
+	for _, child := range part.Parts {
+		childInvite, childEnd := parseCalendarParts(child)
+		invite = invite || childInvite
+		if end.IsZero() {
+			end = childEnd
+		}
+	}
+	return invite, end
+}
+
+// decodePartData decodes the base64url body data of a message part, padded or not
+func decodePartData(data string) string {
+	decoded, err := base64.URLEncoding.DecodeString(data)
+	if err != nil {
+		decoded, _ = base64.RawURLEncoding.DecodeString(data)
+	}
+	return string(decoded)
+}
+
+// parseEventEnd returns when the first event of iCalendar data ends: its DTEND, or its
+// DTSTART if it has none, or the UNTIL of its recurrence. Events recurring without end
+// and data that can't be parsed give zero.
+func parseEventEnd(data string) time.Time {
+	// Long lines are folded onto lines starting with a space or tab
+	data = strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(data)
+
+	var start, end, until time.Time
+	var inEvent, recurs bool
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, params, _ := strings.Cut(name, ";")
+		name = strings.ToUpper(name)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			inEvent = true
+		case !inEvent:
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			if recurs {
+				return until
+			}
+			if !end.IsZero() {
+				return end
+			}
+			return start
+		case name == "DTSTART":
+			// An all-day event without an end lasts the day
+			var allDay bool
+			if start, allDay = parseICalTime(value, params); allDay {
+				start = start.AddDate(0, 0, 1)
+			}
+		case name == "DTEND":
+			end, _ = parseICalTime(value, params)
+		case name == "RRULE":
+			recurs = true
+			for _, rule := range strings.Split(value, ";") {
+				if key, v, _ := strings.Cut(rule, "="); strings.EqualFold(key, "UNTIL") {
+					until, _ = parseICalTime(v, "")
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// parseICalTime parses an iCalendar DATE-TIME value, or a DATE value as the start of
+// that day, in the time zone its TZID parameter names, UTC if it can't be loaded
+func parseICalTime(value, params string) (t time.Time, date bool) {
+	loc := time.UTC
+	for _, param := range strings.Split(params, ";") {
+		if key, tzid, _ := strings.Cut(param, "="); strings.EqualFold(key, "TZID") {
+			if l, err := time.LoadLocation(strings.Trim(tzid, `"`)); err == nil {
+				loc = l
+			}
+		}
+	}
+
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(icalDateTime+"Z", value); err == nil {
+		return t, false
+	}
+	if t, err := time.ParseInLocation(icalDateTime, value, loc); err == nil {
+		return t, false
+	}
+	if t, err := time.ParseInLocation(icalDate, value, loc); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
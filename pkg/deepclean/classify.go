@@ -0,0 +1,77 @@
+package deepclean
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Message categories assigned by the classifier
+const (
+	CategoryNewsletter    = "newsletter"
+	CategoryTransactional = "transactional"
+	CategoryPersonal      = "personal"
+	CategoryAutomated     = "automated"
+)
+
+// Categories lists every category the classifier assigns
+var Categories = []string{CategoryNewsletter, CategoryTransactional, CategoryPersonal, CategoryAutomated}
+
+var (
+	// Sender local parts that never expect a reply
+	noReplyPattern = regexp.MustCompile(`(?i)^(no[-_.]?reply|do[-_.]?not[-_.]?reply|notifications?|alerts?|mailer-daemon|postmaster|bounce[s]?)[^@]*@`)
+	// Subjects typical of receipts, orders and account mail
+	transactionalPattern = regexp.MustCompile(`(?i)\b(receipt|invoice|order|payment|shipped|shipping|delivered|confirm(ation|ed)?|verify|verification|password|security code|sign[- ]in|statement|booking|reservation|itinerary)\b`)
+)
+
+// Share of a sender's mail left unread above which their non-list mail counts as automated
+const automatedUnreadRatio = 0.9
+
+// IsBulkPrecedence reports whether a Precedence header marks mass mail
+func IsBulkPrecedence(precedence string) bool {
+	switch strings.ToLower(strings.TrimSpace(precedence)) {
+	case "bulk", "list", "junk":
+		return true
+	}
+	return false
+}
+
+// Classify tags a message as newsletter, transactional, personal or automated from its
+// headers, Gmail's own category labels and how often the sender's mail goes unread.
+// Pass a negative unreadRatio when the sender's history isn't known yet.
+func Classify(e *EmailMetadata, unreadRatio float64, senderCount int) string {
+	hasLabel := func(label string) bool {
+		for _, l := range e.LabelIDs {
+			if l == label {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case transactionalPattern.MatchString(e.Subject) && !hasLabel("CATEGORY_PROMOTIONS"):
+		return CategoryTransactional
+	case e.HasUnsubscribe || e.ListID != "" || IsBulkPrecedence(e.Precedence) || hasLabel("CATEGORY_PROMOTIONS"):
+		return CategoryNewsletter
+	case e.AutoGenerated || noReplyPattern.MatchString(e.From) ||
+		hasLabel("CATEGORY_UPDATES") || hasLabel("CATEGORY_SOCIAL") || hasLabel("CATEGORY_FORUMS"):
+		return CategoryAutomated
+	case unreadRatio >= automatedUnreadRatio && senderCount >= 5:
+		// Real people rarely send five messages in a row that all go unread
+		return CategoryAutomated
+	default:
+		return CategoryPersonal
+	}
+}
+
+// MajorityCategory returns the category most of a sender's mail falls into, which is
+// the sender's category
+func MajorityCategory(counts map[string]int) string {
+	best := ""
+	for _, category := range Categories {
+		if counts[category] > counts[best] {
+			best = category
+		}
+	}
+	return best
+}
@@ -0,0 +1,177 @@
+package deepclean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Cleaner acts on messages in bulk: listing those a search matches, trashing,
+// archiving, marking read, relabeling and deleting them, in as few calls as the
+// provider allows
+type Cleaner struct {
+	Provider MailProvider
+	// Mailbox to clean, "me" (the default) for the signed-in user's own
+	User string
+	// BeforeCall is called before each API call with its Gmail method name, such as
+	// messages.batchModify, e.g. to pace calls against a quota. An error fails the call.
+	BeforeCall func(ctx context.Context, method string) error
+	// AfterCall is called after each API call with its method name and error, e.g. to
+	// slow down when rate limited
+	AfterCall func(method string, err error)
+}
+
+func (c *Cleaner) user() string {
+	if c.User == "" {
+		return "me"
+	}
+	return c.User
+}
+
+func (c *Cleaner) beforeCall(ctx context.Context, method string) error {
+	if c.BeforeCall == nil {
+		return nil
+	}
+	return c.BeforeCall(ctx, method)
+}
+
+// afterCall reports the outcome of a call to AfterCall and returns its error
+func (c *Cleaner) afterCall(method string, err error) error {
+	if c.AfterCall != nil {
+		c.AfterCall(method, err)
+	}
+	return err
+}
+
+// List returns the IDs of every message matching a Gmail search query. On failure it
+// returns the IDs listed so far along with the error.
+func (c *Cleaner) List(ctx context.Context, query string) ([]string, error) {
+	ids := make([]string, 0)
+	pageToken := ""
+
+	for {
+		if err := c.beforeCall(ctx, "messages.list"); err != nil {
+			return ids, err
+		}
+
+		resp, err := c.Provider.ListMessages(ctx, c.user(), MessageQuery{Query: query, PageToken: pageToken, MaxResults: 500})
+		if c.afterCall("messages.list", err) != nil {
+			return ids, fmt.Errorf("failed to list messages: %w", err)
+		}
+
+		for _, msg := range resp.Messages {
+			ids = append(ids, msg.Id)
+		}
+
+		if resp.NextPageToken == "" {
+			return ids, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// Modify adds and removes labels on the given messages, splitting the IDs into as many
+// BatchModify calls as needed
+func (c *Cleaner) Modify(ctx context.Context, ids, addLabelIDs, removeLabelIDs []string) error {
+	for start := 0; start < len(ids); start += BatchLimit {
+		end := min(start+BatchLimit, len(ids))
+
+		if err := c.beforeCall(ctx, "messages.batchModify"); err != nil {
+			return err
+		}
+
+		err := c.afterCall("messages.batchModify", c.Provider.BatchModify(ctx, c.user(), ids[start:end], addLabelIDs, removeLabelIDs))
+		if err != nil {
+			return fmt.Errorf("failed to modify messages %d-%d: %w", start, end-1, err)
+		}
+	}
+	return nil
+}
+
+// ModifyEach is Modify going on past failures: when a BatchModify call is refused
+// because of a message in it, such as one deleted in the meantime, its messages are
+// modified one by one to find which. It returns the error of each message that failed.
+func (c *Cleaner) ModifyEach(ctx context.Context, ids, addLabelIDs, removeLabelIDs []string) map[string]error {
+	failed := make(map[string]error)
+	for start := 0; start < len(ids); start += BatchLimit {
+		chunk := ids[start:min(start+BatchLimit, len(ids))]
+
+		err := c.beforeCall(ctx, "messages.batchModify")
+		if err == nil {
+			err = c.afterCall("messages.batchModify", c.Provider.BatchModify(ctx, c.user(), chunk, addLabelIDs, removeLabelIDs))
+		}
+		if err == nil {
+			continue
+		}
+		if !isMessageError(err) {
+			// Trying each message would only fail the same way
+			for _, id := range chunk {
+				failed[id] = err
+			}
+			continue
+		}
+
+		for _, id := range chunk {
+			if err := c.beforeCall(ctx, "messages.modify"); err != nil {
+				failed[id] = err
+				continue
+			}
+			if err := c.afterCall("messages.modify", c.Provider.ModifyMessage(ctx, c.user(), id, addLabelIDs, removeLabelIDs)); err != nil {
+				failed[id] = err
+			}
+		}
+	}
+	return failed
+}
+
+// isMessageError reports whether Gmail refused a call because of the messages it
+// named, which it does with 400 for malformed IDs and 404 for missing ones
+func isMessageError(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && (gerr.Code == http.StatusBadRequest || gerr.Code == http.StatusNotFound)
+}
+
+// Archive removes messages from the inbox
+func (c *Cleaner) Archive(ctx context.Context, ids []string) error {
+	return c.Modify(ctx, ids, nil, []string{"INBOX"})
+}
+
+// MarkRead marks messages as read
+func (c *Cleaner) MarkRead(ctx context.Context, ids []string) error {
+	return c.Modify(ctx, ids, nil, []string{"UNREAD"})
+}
+
+// Trash moves messages to the trash one by one, going on past failures, and returns
+// the error of each message that failed
+func (c *Cleaner) Trash(ctx context.Context, ids []string) map[string]error {
+	failed := make(map[string]error)
+	for _, id := range ids {
+		if err := c.beforeCall(ctx, "messages.trash"); err != nil {
+			failed[id] = err
+			continue
+		}
+		if err := c.afterCall("messages.trash", c.Provider.Trash(ctx, c.user(), id)); err != nil {
+			failed[id] = err
+		}
+	}
+	return failed
+}
+
+// Delete deletes messages for good, skipping the trash
+func (c *Cleaner) Delete(ctx context.Context, ids []string) error {
+	for start := 0; start < len(ids); start += BatchLimit {
+		end := min(start+BatchLimit, len(ids))
+
+		if err := c.beforeCall(ctx, "messages.batchDelete"); err != nil {
+			return err
+		}
+
+		if err := c.afterCall("messages.batchDelete", c.Provider.BatchDelete(ctx, c.user(), ids[start:end])); err != nil {
+			return fmt.Errorf("failed to delete messages %d-%d: %w", start, end-1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+// Package deepclean is the analysis engine of Gmail DeepClean without its HTTP layer:
+// the MailProvider mailboxes are read and changed through, a Scanner that fetches a
+// mailbox's messages, the Stats aggregated from them and the classifier that sorts them
+// into categories, rule matching, and a Cleaner that acts on messages in bulk.
+//
+// A minimal scan of the senders of old mail:
+//
+//	service, _ := gmail.NewService(ctx, option.WithHTTPClient(oauthClient))
+//	provider := deepclean.NewGmailProvider(service)
+//	stats, _, err := deepclean.Scan(ctx, provider, "me", deepclean.MessageQuery{Query: "older_than:1y"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	for sender, count := range stats.FromCount {
+//		fmt.Println(sender, count)
+//	}
+//
+// and archiving what one of them sent:
+//
+//	cleaner := &deepclean.Cleaner{Provider: provider}
+//	ids, err := cleaner.List(ctx, "from:news@example.com")
+//	if err == nil {
+//		err = cleaner.Archive(ctx, ids)
+//	}
+package deepclean
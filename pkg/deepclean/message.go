@@ -0,0 +1,218 @@
+package deepclean
+
+import (
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// EmailMetadata stores information about emails
+type EmailMetadata struct {
+	ID           string    `json:"id"`
+	ThreadID     string    `json:"threadId"`
+	From         string    `json:"from"`
+	To           []string  `json:"to"`
+	Subject      string    `json:"subject"`
+	Date         time.Time `json:"date"`
+	Snippet      string    `json:"snippet"`
+	LabelIDs     []string  `json:"labelIds"`
+	SizeEstimate int64     `json:"sizeEstimate"`
+	// Exact raw size, filled in by a size audit (SizeEstimate can be off)
+	RawSize int64 `json:"rawSize,omitempty"`
+	// Whether the message carried a usable List-Unsubscribe header
+	HasUnsubscribe bool `json:"hasUnsubscribe"`
+	// Mailing list identifier from the List-Id header
+	ListID string `json:"listId,omitempty"`
+	// Precedence header value (bulk, list, junk...)
+	Precedence string `json:"precedence,omitempty"`
+	// Whether an Auto-Submitted header marked the message as machine-generated
+	AutoGenerated bool `json:"autoGenerated"`
+	// Classifier verdict: newsletter, transactional, personal or automated
+	Category string `json:"category"`
+	// Whether the message carries a calendar invite
+	CalendarInvite bool `json:"calendarInvite,omitempty"`
+	// When the invite's event ends. Nil if the scan didn't fetch its calendar data or the
+	// event recurs without end.
+	EventEnd *time.Time `json:"eventEnd,omitempty"`
+}
+
+// Size returns the best known size of the email: the audited raw size if available,
+// otherwise Gmail's estimate
+func (e *EmailMetadata) Size() int64 {
+	if e.RawSize > 0 {
+		return e.RawSize
+	}
+	return e.SizeEstimate
+}
+
+// Message is what a scan keeps of a fetched message: its metadata, and the unsubscribe
+// targets and list name its headers gave, which the stats keep per sender and list
+type Message struct {
+	EmailMetadata
+	Unsubscribe *UnsubscribeInfo `json:"unsubscribe,omitempty"`
+	ListName    string           `json:"listName,omitempty"`
+}
+
+// Formats Date headers come in besides RFC 1123 with a numeric zone
+var dateFormats = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 -0700 (MST)",
+}
+
+// ParseMessage extracts the metadata of a message fetched in the metadata or full
+// format. Its category is left for the classifier.
+func ParseMessage(msg *gmail.Message) *Message {
+	metadata := EmailMetadata{
+		ID:           msg.Id,
+		ThreadID:     msg.ThreadId,
+		LabelIDs:     msg.LabelIds,
+		Snippet:      msg.Snippet,
+		SizeEstimate: msg.SizeEstimate,
+		To:           make([]string, 0),
+	}
+
+	var listUnsubscribe, listUnsubscribePost, listName string
+	var headers []*gmail.MessagePartHeader
+	if msg.Payload != nil {
+		headers = msg.Payload.Headers
+	}
+	for _, header := range headers {
+		switch header.Name {
+		case "From":
+			metadata.From = ExtractEmailAddress(header.Value)
+		case "To":
+			// Note: To might contain multiple addresses, this is a simplified version
+			metadata.To = append(metadata.To, ExtractEmailAddress(header.Value))
+		case "Subject":
+			metadata.Subject = header.Value
+		case "List-Unsubscribe":
+			listUnsubscribe = header.Value
+		case "List-Unsubscribe-Post":
+			listUnsubscribePost = header.Value
+		case "List-Id":
+			metadata.ListID, listName = ParseListID(header.Value)
+		case "Precedence":
+			metadata.Precedence = header.Value
+		case "Auto-Submitted":
+			metadata.AutoGenerated = !strings.EqualFold(strings.TrimSpace(header.Value), "no")
+		case "Date":
+			for _, format := range dateFormats {
+				if t, err := time.Parse(format, header.Value); err == nil {
+					metadata.Date = t
+					break
+				}
+			}
+		}
+	}
+
+	if invite, end := parseCalendarParts(msg.Payload); invite {
+		metadata.CalendarInvite = true
+		if !end.IsZero() {
+			metadata.EventEnd = &end
+		}
+	}
+
+	parsed := &Message{
+		EmailMetadata: metadata,
+		Unsubscribe:   ParseListUnsubscribe(listUnsubscribe, listUnsubscribePost),
+		ListName:      listName,
+	}
+	parsed.HasUnsubscribe = parsed.Unsubscribe != nil
+	return parsed
+}
+
+// ExtractEmailAddress extracts the email address from the value field of a header,
+// e.g. "John Doe <john@example.com>" -> "john@example.com"
+func ExtractEmailAddress(header string) string {
+	start := 0
+	end := len(header)
+
+	// Find the start of the email (after '<' if present)
+	for i := 0; i < len(header); i++ {
+		if header[i] == '<' {
+			start = i + 1
+			break
+		}
+	}
+
+	// Find the end of the email (before '>' if present)
+	for i := len(header) - 1; i >= 0; i-- {
+		if header[i] == '>' {
+			end = i
+			break
+		}
+	}
+
+	if start < end {
+		return header[start:end]
+	}
+
+	return header
+}
+
+// ParseListID splits a List-Id header such as `"GitHub" <notifications.github.com>`
+// into the list identifier and its optional display name (RFC 2919)
+func ParseListID(value string) (id, name string) {
+	value = strings.TrimSpace(value)
+	start := strings.LastIndex(value, "<")
+	end := strings.LastIndex(value, ">")
+	if start < 0 || end < start {
+		return strings.ToLower(value), ""
+	}
+
+	id = strings.ToLower(strings.TrimSpace(value[start+1 : end]))
+	name = strings.Trim(strings.TrimSpace(value[:start]), `"`)
+	return id, name
+}
+
+// UnsubscribeInfo holds the unsubscribe targets a sender advertises via the
+// List-Unsubscribe and List-Unsubscribe-Post headers (RFC 2369 and RFC 8058)
+type UnsubscribeInfo struct {
+	// HTTP(S) unsubscribe links
+	URLs []string `json:"urls,omitempty"`
+	// mailto: unsubscribe addresses
+	Mailto []string `json:"mailto,omitempty"`
+	// Whether the sender supports one-click unsubscribe by POSTing to the URL
+	OneClick bool `json:"oneClick"`
+}
+
+// ParseListUnsubscribe extracts the targets from a List-Unsubscribe header value such as
+// "<mailto:unsub@example.com?subject=unsubscribe>, <https://example.com/u/123>", or
+// returns nil if it has none
+func ParseListUnsubscribe(value, postValue string) *UnsubscribeInfo {
+	info := &UnsubscribeInfo{}
+	for _, entry := range strings.Split(value, ",") {
+		target := strings.TrimSpace(entry)
+		target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+
+		lower := strings.ToLower(target)
+		switch {
+		case strings.HasPrefix(lower, "mailto:"):
+			info.Mailto = append(info.Mailto, target)
+		case strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "http://"):
+			info.URLs = append(info.URLs, target)
+		}
+	}
+
+	if len(info.URLs) == 0 && len(info.Mailto) == 0 {
+		return nil
+	}
+
+	// One-click only applies to HTTPS targets
+	info.OneClick = len(info.URLs) > 0 &&
+		strings.EqualFold(strings.TrimSpace(postValue), "List-Unsubscribe=One-Click")
+	return info
+}
+
+// JunkLabel returns SPAM or TRASH if the labels include one, or ""
+func JunkLabel(labelIDs []string) string {
+	for _, label := range labelIDs {
+		if label == "SPAM" || label == "TRASH" {
+			return label
+		}
+	}
+	return ""
+}
@@ -0,0 +1,198 @@
+package deepclean
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// Most message IDs a single BatchModify or BatchDelete call accepts
+const BatchLimit = 1000
+
+// MailProvider is the mail backend scans and cleanups act through. Messages, threads
+// and labels are described with Gmail's types, which other backends fill in as far as
+// they can. user is the mailbox acted on, "me" for the signed-in user's own.
+type MailProvider interface {
+	Profile(ctx context.Context, user string) (*gmail.Profile, error)
+
+	ListMessages(ctx context.Context, user string, query MessageQuery) (*gmail.ListMessagesResponse, error)
+	// GetMessage fetches a message in a Gmail format: minimal, metadata (with only the
+	// given headers, or all), full or raw
+	GetMessage(ctx context.Context, user, id, format string, headers ...string) (*gmail.Message, error)
+	GetAttachment(ctx context.Context, user, messageID, attachmentID string) (*gmail.MessagePartBody, error)
+	// InsertMessage adds a raw message to the mailbox as is, dated by its Date header,
+	// like IMAP APPEND
+	InsertMessage(ctx context.Context, user string, msg *gmail.Message) (*gmail.Message, error)
+	// ImportMessage adds an RFC 822 message as if it had been received, dated by its
+	// Date header and never marked as spam
+	ImportMessage(ctx context.Context, user string, raw io.Reader) (*gmail.Message, error)
+	SendMessage(ctx context.Context, user string, msg *gmail.Message) error
+	ModifyMessage(ctx context.Context, user, id string, addLabelIDs, removeLabelIDs []string) error
+	// BatchModify changes the labels of up to BatchLimit messages at once
+	BatchModify(ctx context.Context, user string, ids, addLabelIDs, removeLabelIDs []string) error
+	Trash(ctx context.Context, user, id string) error
+	Untrash(ctx context.Context, user, id string) error
+	// BatchDelete deletes up to BatchLimit messages for good
+	BatchDelete(ctx context.Context, user string, ids []string) error
+
+	GetThread(ctx context.Context, user, id, format string, headers ...string) (*gmail.Thread, error)
+	ModifyThread(ctx context.Context, user, id string, addLabelIDs, removeLabelIDs []string) error
+
+	Labels(ctx context.Context, user string) ([]*gmail.Label, error)
+	CreateLabel(ctx context.Context, user string, label *gmail.Label) (*gmail.Label, error)
+	CreateFilter(ctx context.Context, user string, filter *gmail.Filter) (*gmail.Filter, error)
+	ListDrafts(ctx context.Context, user, pageToken string, maxResults int64) (*gmail.ListDraftsResponse, error)
+}
+
+// MessageQuery selects the messages ListMessages returns, one page at a time
+type MessageQuery struct {
+	// Gmail search query, e.g. "from:foo older_than:2y"
+	Query string
+	// Only messages carrying all of these label IDs
+	LabelIDs         []string
+	IncludeSpamTrash bool
+	PageToken        string
+	MaxResults       int64
+}
+
+// ErrNotSupported is returned for what a mail backend can't do, such as sending mail
+// over IMAP
+var ErrNotSupported = errors.New("not supported by this mail provider")
+
+// GmailProvider is the MailProvider backed by the Gmail API
+type GmailProvider struct {
+	service *gmail.Service
+}
+
+// NewGmailProvider creates a Gmail provider using a Gmail service, which carries the
+// credentials and endpoint to use
+func NewGmailProvider(service *gmail.Service) *GmailProvider {
+	return &GmailProvider{service: service}
+}
+
+func (g *GmailProvider) Profile(ctx context.Context, user string) (*gmail.Profile, error) {
+	return g.service.Users.GetProfile(user).Context(ctx).Do()
+}
+
+func (g *GmailProvider) ListMessages(ctx context.Context, user string, query MessageQuery) (*gmail.ListMessagesResponse, error) {
+	req := g.service.Users.Messages.List(user)
+	if query.Query != "" {
+		req = req.Q(query.Query)
+	}
+	if len(query.LabelIDs) > 0 {
+		req = req.LabelIds(query.LabelIDs...)
+	}
+	if query.IncludeSpamTrash {
+		req = req.IncludeSpamTrash(true)
+	}
+	if query.PageToken != "" {
+		req = req.PageToken(query.PageToken)
+	}
+	if query.MaxResults > 0 {
+		req = req.MaxResults(query.MaxResults)
+	}
+	return req.Context(ctx).Do()
+}
+
+func (g *GmailProvider) GetMessage(ctx context.Context, user, id, format string, headers ...string) (*gmail.Message, error) {
+	req := g.service.Users.Messages.Get(user, id).Format(format)
+	if len(headers) > 0 {
+		req = req.MetadataHeaders(headers...)
+	}
+	return req.Context(ctx).Do()
+}
+
+func (g *GmailProvider) GetAttachment(ctx context.Context, user, messageID, attachmentID string) (*gmail.MessagePartBody, error) {
+	return g.service.Users.Messages.Attachments.Get(user, messageID, attachmentID).Context(ctx).Do()
+}
+
+func (g *GmailProvider) InsertMessage(ctx context.Context, user string, msg *gmail.Message) (*gmail.Message, error) {
+	return g.service.Users.Messages.Insert(user, msg).InternalDateSource("dateHeader").Context(ctx).Do()
+}
+
+func (g *GmailProvider) ImportMessage(ctx context.Context, user string, raw io.Reader) (*gmail.Message, error) {
+	return g.service.Users.Messages.Import(user, &gmail.Message{}).
+		InternalDateSource("dateHeader").
+		NeverMarkSpam(true).
+		Media(raw, googleapi.ContentType("message/rfc822")).
+		Context(ctx).
+		Do()
+}
+
+func (g *GmailProvider) SendMessage(ctx context.Context, user string, msg *gmail.Message) error {
+	_, err := g.service.Users.Messages.Send(user, msg).Context(ctx).Do()
+	return err
+}
+
+func (g *GmailProvider) ModifyMessage(ctx context.Context, user, id string, addLabelIDs, removeLabelIDs []string) error {
+	_, err := g.service.Users.Messages.Modify(user, id, &gmail.ModifyMessageRequest{
+		AddLabelIds:    addLabelIDs,
+		RemoveLabelIds: removeLabelIDs,
+	}).Context(ctx).Do()
+	return err
+}
+
+func (g *GmailProvider) BatchModify(ctx context.Context, user string, ids, addLabelIDs, removeLabelIDs []string) error {
+	return g.service.Users.Messages.BatchModify(user, &gmail.BatchModifyMessagesRequest{
+		Ids:            ids,
+		AddLabelIds:    addLabelIDs,
+		RemoveLabelIds: removeLabelIDs,
+	}).Context(ctx).Do()
+}
+
+func (g *GmailProvider) Trash(ctx context.Context, user, id string) error {
+	_, err := g.service.Users.Messages.Trash(user, id).Context(ctx).Do()
+	return err
+}
+
+func (g *GmailProvider) Untrash(ctx context.Context, user, id string) error {
+	_, err := g.service.Users.Messages.Untrash(user, id).Context(ctx).Do()
+	return err
+}
+
+func (g *GmailProvider) BatchDelete(ctx context.Context, user string, ids []string) error {
+	return g.service.Users.Messages.BatchDelete(user, &gmail.BatchDeleteMessagesRequest{Ids: ids}).Context(ctx).Do()
+}
+
+func (g *GmailProvider) GetThread(ctx context.Context, user, id, format string, headers ...string) (*gmail.Thread, error) {
+	req := g.service.Users.Threads.Get(user, id).Format(format)
+	if len(headers) > 0 {
+		req = req.MetadataHeaders(headers...)
+	}
+	return req.Context(ctx).Do()
+}
+
+func (g *GmailProvider) ModifyThread(ctx context.Context, user, id string, addLabelIDs, removeLabelIDs []string) error {
+	_, err := g.service.Users.Threads.Modify(user, id, &gmail.ModifyThreadRequest{
+		AddLabelIds:    addLabelIDs,
+		RemoveLabelIds: removeLabelIDs,
+	}).Context(ctx).Do()
+	return err
+}
+
+func (g *GmailProvider) Labels(ctx context.Context, user string) ([]*gmail.Label, error) {
+	resp, err := g.service.Users.Labels.List(user).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Labels, nil
+}
+
+func (g *GmailProvider) CreateLabel(ctx context.Context, user string, label *gmail.Label) (*gmail.Label, error) {
+	return g.service.Users.Labels.Create(user, label).Context(ctx).Do()
+}
+
+func (g *GmailProvider) CreateFilter(ctx context.Context, user string, filter *gmail.Filter) (*gmail.Filter, error) {
+	return g.service.Users.Settings.Filters.Create(user, filter).Context(ctx).Do()
+}
+
+func (g *GmailProvider) ListDrafts(ctx context.Context, user, pageToken string, maxResults int64) (*gmail.ListDraftsResponse, error) {
+	req := g.service.Users.Drafts.List(user).MaxResults(maxResults)
+	if pageToken != "" {
+		req = req.PageToken(pageToken)
+	}
+	return req.Context(ctx).Do()
+}
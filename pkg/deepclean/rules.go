@@ -0,0 +1,56 @@
+package deepclean
+
+import (
+	"strings"
+	"time"
+)
+
+// RuleMatch selects messages by their collected metadata. Every condition that is set
+// must hold.
+type RuleMatch struct {
+	// Exact sender address
+	Sender string `json:"sender,omitempty"`
+	// Sender domain, matching subdomains too
+	Domain string `json:"domain,omitempty"`
+	// Label ID, e.g. CATEGORY_PROMOTIONS or Label_12
+	Label string `json:"label,omitempty"`
+	// Classifier category
+	Category string `json:"category,omitempty"`
+	// Only messages older than this many days
+	OlderThanDays int `json:"olderThanDays,omitempty"`
+	// Only messages larger than this many bytes
+	LargerThan int64 `json:"largerThan,omitempty"`
+}
+
+// Matches reports whether a message meets all of the conditions at the time now
+func (m *RuleMatch) Matches(e *EmailMetadata, now time.Time) bool {
+	from := strings.ToLower(e.From)
+	if m.Sender != "" && from != m.Sender {
+		return false
+	}
+	if m.Domain != "" && !strings.HasSuffix(from, "@"+m.Domain) && !strings.HasSuffix(from, "."+m.Domain) {
+		return false
+	}
+	if m.Label != "" {
+		found := false
+		for _, label := range e.LabelIDs {
+			if strings.EqualFold(label, m.Label) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if m.Category != "" && e.Category != m.Category {
+		return false
+	}
+	if m.OlderThanDays > 0 && (e.Date.IsZero() || now.Sub(e.Date) < time.Duration(m.OlderThanDays)*24*time.Hour) {
+		return false
+	}
+	if m.LargerThan > 0 && e.Size() <= m.LargerThan {
+		return false
+	}
+	return true
+}
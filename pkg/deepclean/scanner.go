@@ -0,0 +1,229 @@
+package deepclean
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/dustinmichels/gmail-deepclean/pkg/deepclean")
+
+// Scanner lists the messages of a mailbox page by page and fetches each one. Only
+// Provider is required; the hooks let callers pace calls, skip messages they already
+// have, and keep or count what is fetched.
+type Scanner struct {
+	Provider MailProvider
+	// Mailbox to scan, "me" (the default) for the signed-in user's own
+	User string
+	// Messages to scan. MaxResults is the page size (default 100), and a PageToken
+	// resumes an earlier scan from that page.
+	Query MessageQuery
+	// Format messages are fetched in, "metadata" (the default) or "full"
+	Format string
+
+	// BeforeCall is called before each API call with its Gmail method name,
+	// messages.list or messages.get, e.g. to pace calls against a quota. An error
+	// fails the call.
+	BeforeCall func(ctx context.Context, method string) error
+	// AfterCall is called after each API call with its method name and error, e.g. to
+	// slow down when rate limited
+	AfterCall func(method string, err error)
+	// Concurrency returns how many messages of a page may be fetched at once, asked
+	// again for each page so it can follow the rate limits met. Without it, all of a
+	// page's messages are fetched at once.
+	Concurrency func() int
+	// Lookup returns a message fetched before, which is used instead of fetching it
+	Lookup func(id string) (*Message, bool)
+	// OnMessage is called with each message, concurrently with the others of its page;
+	// fetched tells whether it was fetched rather than looked up. An error counts the
+	// message as failed. Without it, messages are only counted.
+	OnMessage func(msg *Message, fetched bool) error
+	// OnPage is called after each page. An error stops the scan, and Scan returns it.
+	OnPage func(ctx context.Context, page Page) error
+}
+
+// Page is the outcome of one page of a scan
+type Page struct {
+	// Number of the page in this run of the scan, from 0
+	Number int
+	// Messages listed on the page
+	Messages int
+	// Errors of the messages that failed to be fetched or handled
+	Errors []error
+	// Token of the next page to resume the scan from, "" after the last page
+	NextPageToken string
+}
+
+// Scan runs the scan until every page is done, the context is canceled, listing a page
+// fails or OnPage stops it
+func (s *Scanner) Scan(ctx context.Context) error {
+	user := s.User
+	if user == "" {
+		user = "me"
+	}
+	format := s.Format
+	if format == "" {
+		format = "metadata"
+	}
+	query := s.Query
+	if query.MaxResults <= 0 {
+		query.MaxResults = 100
+	}
+
+	for number := 0; ; number++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pageCtx, span := tracer.Start(ctx, "scan.page", trace.WithAttributes(attribute.Int("scan.page", number)))
+
+		if err := s.beforeCall(pageCtx, "messages.list"); err != nil {
+			endSpan(span, err)
+			return err
+		}
+		resp, err := s.Provider.ListMessages(pageCtx, user, query)
+		s.afterCall("messages.list", err)
+		if err != nil {
+			endSpan(span, err)
+			return fmt.Errorf("failed to list messages: %w", err)
+		}
+		span.SetAttributes(attribute.Int("scan.messages", len(resp.Messages)))
+
+		page := Page{Number: number, Messages: len(resp.Messages), NextPageToken: resp.NextPageToken}
+		slots := make(chan struct{}, s.concurrency(len(resp.Messages)))
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for _, msg := range resp.Messages {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(id string) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				if err := s.scanMessage(pageCtx, user, id, format); err != nil {
+					mu.Lock()
+					page.Errors = append(page.Errors, err)
+					mu.Unlock()
+				}
+			}(msg.Id)
+		}
+		wg.Wait()
+		span.End()
+
+		if s.OnPage != nil {
+			if err := s.OnPage(ctx, page); err != nil {
+				return err
+			}
+		}
+		if resp.NextPageToken == "" {
+			return nil
+		}
+		query.PageToken = resp.NextPageToken
+	}
+}
+
+// scanMessage looks up or fetches one message and hands it to OnMessage
+func (s *Scanner) scanMessage(ctx context.Context, user, id, format string) (err error) {
+	ctx, span := tracer.Start(ctx, "scan.message")
+	defer func() { endSpan(span, err) }()
+
+	if s.Lookup != nil {
+		if known, ok := s.Lookup(id); ok {
+			span.SetAttributes(attribute.Bool("scan.cached", true))
+			return s.onMessage(known, false)
+		}
+	}
+
+	if err := s.beforeCall(ctx, "messages.get"); err != nil {
+		return err
+	}
+	msg, err := s.Provider.GetMessage(ctx, user, id, format)
+	s.afterCall("messages.get", err)
+	if err != nil {
+		return fmt.Errorf("failed to fetch message %s: %w", id, err)
+	}
+	return s.onMessage(ParseMessage(msg), true)
+}
+
+func (s *Scanner) beforeCall(ctx context.Context, method string) error {
+	if s.BeforeCall == nil {
+		return nil
+	}
+	return s.BeforeCall(ctx, method)
+}
+
+func (s *Scanner) afterCall(method string, err error) {
+	if s.AfterCall != nil {
+		s.AfterCall(method, err)
+	}
+}
+
+// concurrency returns how many of a page's messages may be fetched at once
+func (s *Scanner) concurrency(messages int) int {
+	if s.Concurrency == nil {
+		return max(1, messages)
+	}
+	return max(1, s.Concurrency())
+}
+
+func (s *Scanner) onMessage(msg *Message, fetched bool) error {
+	if s.OnMessage == nil {
+		return nil
+	}
+	return s.OnMessage(msg, fetched)
+}
+
+// endSpan ends a span, marking it failed if err is set. The status only names the
+// failure, since errors can contain addresses and subjects.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed")
+	}
+	span.End()
+}
+
+// Scan collects the statistics of the messages matching query in a mailbox, with each
+// message classified, for callers that don't need the hooks of a Scanner
+func Scan(ctx context.Context, provider MailProvider, user string, query MessageQuery) (*Stats, []EmailMetadata, error) {
+	stats := NewStats()
+	var messages []EmailMetadata
+	var mu sync.Mutex
+
+	scanner := &Scanner{
+		Provider: provider,
+		User:     user,
+		Query:    query,
+		OnMessage: func(msg *Message, fetched bool) error {
+			msg.Category = Classify(&msg.EmailMetadata, -1, 0)
+			stats.Add(msg)
+			if JunkLabel(msg.LabelIDs) == "" {
+				mu.Lock()
+				messages = append(messages, msg.EmailMetadata)
+				mu.Unlock()
+			}
+			return nil
+		},
+		OnPage: func(ctx context.Context, page Page) error {
+			stats.Lock()
+			stats.TotalEmails += page.Messages
+			stats.Unlock()
+			return nil
+		},
+	}
+	if err := scanner.Scan(ctx); err != nil {
+		return stats, messages, err
+	}
+
+	stats.Reclassify(func(yield func(*EmailMetadata) bool) {
+		for i := range messages {
+			if !yield(&messages[i]) {
+				return
+			}
+		}
+	}, nil)
+	return stats, messages, nil
+}
@@ -0,0 +1,202 @@
+package deepclean
+
+import (
+	"iter"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats tracks statistics about email communications. It is safe for concurrent use;
+// hold RLock while reading its maps as messages are being added.
+type Stats struct {
+	// Maps sender email to number of emails received
+	FromCount map[string]int `json:"fromCount"`
+	// Maps recipient email to number of emails sent
+	ToCount map[string]int `json:"toCount"`
+	// Maps sender to total size of emails received
+	FromSize map[string]int64 `json:"fromSize"`
+	// Maps sender to number of their emails that are still unread
+	FromUnread map[string]int `json:"fromUnread"`
+	// Maps sender to the category most of their emails fall into
+	FromCategory map[string]string `json:"fromCategory"`
+	// Maps sender to the dates of their earliest and latest emails, for those with a
+	// parseable date
+	FromFirstSeen map[string]time.Time `json:"fromFirstSeen"`
+	FromLastSeen  map[string]time.Time `json:"fromLastSeen"`
+	// Maps category to number of emails
+	CategoryCount map[string]int `json:"categoryCount"`
+	// Maps date to number of emails
+	DateCount map[string]int `json:"dateCount"`
+	// Maps sender to the unsubscribe targets from their most recent list mail
+	Unsubscribe map[string]*UnsubscribeInfo `json:"unsubscribe"`
+	// Maps mailing list ID to number of emails, total size and display name
+	ListCount map[string]int    `json:"listCount"`
+	ListSize  map[string]int64  `json:"listSize"`
+	ListNames map[string]string `json:"listNames"`
+	// Maps mailing list ID to the set of addresses it has been sent from
+	listSenders map[string]map[string]struct{}
+	// Maps SPAM and TRASH to the number and total size of messages in them. Only
+	// filled by scans that include Spam and Trash, whose messages count nowhere else.
+	JunkCount map[string]int   `json:"junkCount"`
+	JunkSize  map[string]int64 `json:"junkSize"`
+	// Total emails processed
+	TotalEmails int `json:"totalEmails"`
+	// Lock for concurrent map access
+	mu sync.RWMutex
+}
+
+// NewStats creates empty statistics
+func NewStats() *Stats {
+	return &Stats{
+		FromCount: make(map[string]int),
+		ToCount:   make(map[string]int),
+		FromSize:  make(map[string]int64),
+		DateCount: make(map[string]int),
+
+		FromUnread:    make(map[string]int),
+		FromCategory:  make(map[string]string),
+		CategoryCount: make(map[string]int),
+		FromFirstSeen: make(map[string]time.Time),
+		FromLastSeen:  make(map[string]time.Time),
+
+		Unsubscribe: make(map[string]*UnsubscribeInfo),
+		ListCount:   make(map[string]int),
+		ListSize:    make(map[string]int64),
+		ListNames:   make(map[string]string),
+		listSenders: make(map[string]map[string]struct{}),
+
+		JunkCount: make(map[string]int),
+		JunkSize:  make(map[string]int64),
+	}
+}
+
+// Lock, Unlock, RLock and RUnlock guard the maps and totals
+func (s *Stats) Lock()    { s.mu.Lock() }
+func (s *Stats) Unlock()  { s.mu.Unlock() }
+func (s *Stats) RLock()   { s.mu.RLock() }
+func (s *Stats) RUnlock() { s.mu.RUnlock() }
+
+// Add counts a message into the aggregates under its current category. Messages in
+// Spam or Trash only count towards JunkCount and JunkSize, so they never show up as
+// something to clean up again. TotalEmails is left to the caller, which may count
+// messages that failed to fetch too.
+func (s *Stats) Add(msg *Message) {
+	metadata := &msg.EmailMetadata
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if label := JunkLabel(metadata.LabelIDs); label != "" {
+		s.JunkCount[label]++
+		s.JunkSize[label] += metadata.SizeEstimate
+		return
+	}
+
+	s.FromCount[metadata.From]++
+	s.FromSize[metadata.From] += metadata.SizeEstimate
+	for _, label := range metadata.LabelIDs {
+		if label == "UNREAD" {
+			s.FromUnread[metadata.From]++
+			break
+		}
+	}
+	s.CategoryCount[metadata.Category]++
+	for _, to := range metadata.To {
+		s.ToCount[to]++
+	}
+
+	// Remember how to unsubscribe from the sender
+	if msg.Unsubscribe != nil {
+		s.Unsubscribe[metadata.From] = msg.Unsubscribe
+	}
+
+	// Mailing list aggregates group lists that rotate From addresses
+	if metadata.ListID != "" {
+		s.ListCount[metadata.ListID]++
+		s.ListSize[metadata.ListID] += metadata.SizeEstimate
+		if msg.ListName != "" {
+			s.ListNames[metadata.ListID] = msg.ListName
+		}
+		if s.listSenders[metadata.ListID] == nil {
+			s.listSenders[metadata.ListID] = make(map[string]struct{})
+		}
+		s.listSenders[metadata.ListID][metadata.From] = struct{}{}
+	}
+
+	if !metadata.Date.IsZero() {
+		s.DateCount[metadata.Date.Format("2006-01-02")]++
+		if first, ok := s.FromFirstSeen[metadata.From]; !ok || metadata.Date.Before(first) {
+			s.FromFirstSeen[metadata.From] = metadata.Date
+		}
+		if metadata.Date.After(s.FromLastSeen[metadata.From]) {
+			s.FromLastSeen[metadata.From] = metadata.Date
+		}
+	}
+}
+
+// ListSenders returns the addresses each mailing list has been sent from, sorted
+func (s *Stats) ListSenders() map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lists := make(map[string][]string, len(s.listSenders))
+	for id, senders := range s.listSenders {
+		for sender := range senders {
+			lists[id] = append(lists[id], sender)
+		}
+		sort.Strings(lists[id])
+	}
+	return lists
+}
+
+// SetListSenders replaces the senders of mailing lists, as ListSenders returned them
+func (s *Stats) SetListSenders(lists map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listSenders = make(map[string]map[string]struct{}, len(lists))
+	for id, senders := range lists {
+		s.listSenders[id] = make(map[string]struct{}, len(senders))
+		for _, sender := range senders {
+			s.listSenders[id][sender] = struct{}{}
+		}
+	}
+}
+
+// Reclassify re-runs the classifier over the messages now that each sender's unread
+// ratio is known, updating their categories, and rebuilds the category aggregates. If
+// override is given, it has the final say on each message's category, e.g. to apply
+// the user's corrections.
+func (s *Stats) Reclassify(messages iter.Seq[*EmailMetadata], override func(e *EmailMetadata, category string) string) {
+	s.mu.RLock()
+	unreadRatio := make(map[string]float64, len(s.FromCount))
+	senderCount := make(map[string]int, len(s.FromCount))
+	for sender, count := range s.FromCount {
+		unreadRatio[sender] = float64(s.FromUnread[sender]) / float64(count)
+		senderCount[sender] = count
+	}
+	s.mu.RUnlock()
+
+	categoryCount := make(map[string]int)
+	fromCategories := make(map[string]map[string]int)
+	for email := range messages {
+		email.Category = Classify(email, unreadRatio[email.From], senderCount[email.From])
+		if override != nil {
+			email.Category = override(email, email.Category)
+		}
+
+		categoryCount[email.Category]++
+		if fromCategories[email.From] == nil {
+			fromCategories[email.From] = make(map[string]int)
+		}
+		fromCategories[email.From][email.Category]++
+	}
+
+	fromCategory := make(map[string]string, len(fromCategories))
+	for sender, counts := range fromCategories {
+		fromCategory[sender] = MajorityCategory(counts)
+	}
+
+	s.mu.Lock()
+	s.CategoryCount = categoryCount
+	s.FromCategory = fromCategory
+	s.mu.Unlock()
+}
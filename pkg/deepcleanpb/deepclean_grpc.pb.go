@@ -0,0 +1,457 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: deepclean/v1/deepclean.proto
+
+// gRPC API of Gmail DeepClean. Calls authenticate with the same token as the HTTP API,
+// sent as `authorization` metadata, and act on a delegated mailbox when `x-mailbox`
+// metadata names one. Every call is also served as JSON over HTTP by the gateway, under
+// the paths in the http options.
+
+package deepcleanpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	DeepClean_StartScan_FullMethodName        = "/deepclean.v1.DeepClean/StartScan"
+	DeepClean_GetScanProgress_FullMethodName  = "/deepclean.v1.DeepClean/GetScanProgress"
+	DeepClean_WatchScan_FullMethodName        = "/deepclean.v1.DeepClean/WatchScan"
+	DeepClean_GetStats_FullMethodName         = "/deepclean.v1.DeepClean/GetStats"
+	DeepClean_ListSenders_FullMethodName      = "/deepclean.v1.DeepClean/ListSenders"
+	DeepClean_ArchiveMessages_FullMethodName  = "/deepclean.v1.DeepClean/ArchiveMessages"
+	DeepClean_MarkRead_FullMethodName         = "/deepclean.v1.DeepClean/MarkRead"
+	DeepClean_PreviewTrash_FullMethodName     = "/deepclean.v1.DeepClean/PreviewTrash"
+	DeepClean_ConfirmOperation_FullMethodName = "/deepclean.v1.DeepClean/ConfirmOperation"
+)
+
+// DeepCleanClient is the client API for DeepClean service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DeepCleanClient interface {
+	// Starts scanning the mailbox, or returns the scan already registered for it
+	StartScan(ctx context.Context, in *StartScanRequest, opts ...grpc.CallOption) (*ScanProgress, error)
+	// Returns the progress of the mailbox's scan
+	GetScanProgress(ctx context.Context, in *GetScanProgressRequest, opts ...grpc.CallOption) (*ScanProgress, error)
+	// Streams the progress of the mailbox's scan until it finishes
+	WatchScan(ctx context.Context, in *WatchScanRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ScanProgress], error)
+	// Returns the totals of the scanned messages
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*Stats, error)
+	// Streams the senders of the scanned messages, most mail first
+	ListSenders(ctx context.Context, in *ListSendersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Sender], error)
+	// Removes messages from the inbox without deleting them
+	ArchiveMessages(ctx context.Context, in *BulkRequest, opts ...grpc.CallOption) (*BulkResult, error)
+	// Marks messages read
+	MarkRead(ctx context.Context, in *BulkRequest, opts ...grpc.CallOption) (*BulkResult, error)
+	// Previews moving messages to the trash. Nothing is trashed until the returned
+	// operation is confirmed with ConfirmOperation.
+	PreviewTrash(ctx context.Context, in *BulkRequest, opts ...grpc.CallOption) (*Operation, error)
+	// Executes a previewed operation
+	ConfirmOperation(ctx context.Context, in *ConfirmOperationRequest, opts ...grpc.CallOption) (*TrashResult, error)
+}
+
+type deepCleanClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDeepCleanClient(cc grpc.ClientConnInterface) DeepCleanClient {
+	return &deepCleanClient{cc}
+}
+
+func (c *deepCleanClient) StartScan(ctx context.Context, in *StartScanRequest, opts ...grpc.CallOption) (*ScanProgress, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ScanProgress)
+	err := c.cc.Invoke(ctx, DeepClean_StartScan_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deepCleanClient) GetScanProgress(ctx context.Context, in *GetScanProgressRequest, opts ...grpc.CallOption) (*ScanProgress, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ScanProgress)
+	err := c.cc.Invoke(ctx, DeepClean_GetScanProgress_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deepCleanClient) WatchScan(ctx context.Context, in *WatchScanRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ScanProgress], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &DeepClean_ServiceDesc.Streams[0], DeepClean_WatchScan_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchScanRequest, ScanProgress]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DeepClean_WatchScanClient = grpc.ServerStreamingClient[ScanProgress]
+
+func (c *deepCleanClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*Stats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, DeepClean_GetStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deepCleanClient) ListSenders(ctx context.Context, in *ListSendersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Sender], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &DeepClean_ServiceDesc.Streams[1], DeepClean_ListSenders_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ListSendersRequest, Sender]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DeepClean_ListSendersClient = grpc.ServerStreamingClient[Sender]
+
+func (c *deepCleanClient) ArchiveMessages(ctx context.Context, in *BulkRequest, opts ...grpc.CallOption) (*BulkResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BulkResult)
+	err := c.cc.Invoke(ctx, DeepClean_ArchiveMessages_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deepCleanClient) MarkRead(ctx context.Context, in *BulkRequest, opts ...grpc.CallOption) (*BulkResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BulkResult)
+	err := c.cc.Invoke(ctx, DeepClean_MarkRead_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deepCleanClient) PreviewTrash(ctx context.Context, in *BulkRequest, opts ...grpc.CallOption) (*Operation, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Operation)
+	err := c.cc.Invoke(ctx, DeepClean_PreviewTrash_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deepCleanClient) ConfirmOperation(ctx context.Context, in *ConfirmOperationRequest, opts ...grpc.CallOption) (*TrashResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TrashResult)
+	err := c.cc.Invoke(ctx, DeepClean_ConfirmOperation_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeepCleanServer is the server API for DeepClean service.
+// All implementations must embed UnimplementedDeepCleanServer
+// for forward compatibility.
+type DeepCleanServer interface {
+	// Starts scanning the mailbox, or returns the scan already registered for it
+	StartScan(context.Context, *StartScanRequest) (*ScanProgress, error)
+	// Returns the progress of the mailbox's scan
+	GetScanProgress(context.Context, *GetScanProgressRequest) (*ScanProgress, error)
+	// Streams the progress of the mailbox's scan until it finishes
+	WatchScan(*WatchScanRequest, grpc.ServerStreamingServer[ScanProgress]) error
+	// Returns the totals of the scanned messages
+	GetStats(context.Context, *GetStatsRequest) (*Stats, error)
+	// Streams the senders of the scanned messages, most mail first
+	ListSenders(*ListSendersRequest, grpc.ServerStreamingServer[Sender]) error
+	// Removes messages from the inbox without deleting them
+	ArchiveMessages(context.Context, *BulkRequest) (*BulkResult, error)
+	// Marks messages read
+	MarkRead(context.Context, *BulkRequest) (*BulkResult, error)
+	// Previews moving messages to the trash. Nothing is trashed until the returned
+	// operation is confirmed with ConfirmOperation.
+	PreviewTrash(context.Context, *BulkRequest) (*Operation, error)
+	// Executes a previewed operation
+	ConfirmOperation(context.Context, *ConfirmOperationRequest) (*TrashResult, error)
+	mustEmbedUnimplementedDeepCleanServer()
+}
+
+// UnimplementedDeepCleanServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedDeepCleanServer struct{}
+
+func (UnimplementedDeepCleanServer) StartScan(context.Context, *StartScanRequest) (*ScanProgress, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartScan not implemented")
+}
+func (UnimplementedDeepCleanServer) GetScanProgress(context.Context, *GetScanProgressRequest) (*ScanProgress, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetScanProgress not implemented")
+}
+func (UnimplementedDeepCleanServer) WatchScan(*WatchScanRequest, grpc.ServerStreamingServer[ScanProgress]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchScan not implemented")
+}
+func (UnimplementedDeepCleanServer) GetStats(context.Context, *GetStatsRequest) (*Stats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedDeepCleanServer) ListSenders(*ListSendersRequest, grpc.ServerStreamingServer[Sender]) error {
+	return status.Errorf(codes.Unimplemented, "method ListSenders not implemented")
+}
+func (UnimplementedDeepCleanServer) ArchiveMessages(context.Context, *BulkRequest) (*BulkResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ArchiveMessages not implemented")
+}
+func (UnimplementedDeepCleanServer) MarkRead(context.Context, *BulkRequest) (*BulkResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MarkRead not implemented")
+}
+func (UnimplementedDeepCleanServer) PreviewTrash(context.Context, *BulkRequest) (*Operation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PreviewTrash not implemented")
+}
+func (UnimplementedDeepCleanServer) ConfirmOperation(context.Context, *ConfirmOperationRequest) (*TrashResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmOperation not implemented")
+}
+func (UnimplementedDeepCleanServer) mustEmbedUnimplementedDeepCleanServer() {}
+func (UnimplementedDeepCleanServer) testEmbeddedByValue()                   {}
+
+// UnsafeDeepCleanServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DeepCleanServer will
+// result in compilation errors.
+type UnsafeDeepCleanServer interface {
+	mustEmbedUnimplementedDeepCleanServer()
+}
+
+func RegisterDeepCleanServer(s grpc.ServiceRegistrar, srv DeepCleanServer) {
+	// If the following call pancis, it indicates UnimplementedDeepCleanServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&DeepClean_ServiceDesc, srv)
+}
+
+func _DeepClean_StartScan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeepCleanServer).StartScan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeepClean_StartScan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeepCleanServer).StartScan(ctx, req.(*StartScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeepClean_GetScanProgress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetScanProgressRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeepCleanServer).GetScanProgress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeepClean_GetScanProgress_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeepCleanServer).GetScanProgress(ctx, req.(*GetScanProgressRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeepClean_WatchScan_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchScanRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DeepCleanServer).WatchScan(m, &grpc.GenericServerStream[WatchScanRequest, ScanProgress]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DeepClean_WatchScanServer = grpc.ServerStreamingServer[ScanProgress]
+
+func _DeepClean_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeepCleanServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeepClean_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeepCleanServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeepClean_ListSenders_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListSendersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DeepCleanServer).ListSenders(m, &grpc.GenericServerStream[ListSendersRequest, Sender]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DeepClean_ListSendersServer = grpc.ServerStreamingServer[Sender]
+
+func _DeepClean_ArchiveMessages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BulkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeepCleanServer).ArchiveMessages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeepClean_ArchiveMessages_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeepCleanServer).ArchiveMessages(ctx, req.(*BulkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeepClean_MarkRead_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BulkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeepCleanServer).MarkRead(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeepClean_MarkRead_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeepCleanServer).MarkRead(ctx, req.(*BulkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeepClean_PreviewTrash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BulkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeepCleanServer).PreviewTrash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeepClean_PreviewTrash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeepCleanServer).PreviewTrash(ctx, req.(*BulkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeepClean_ConfirmOperation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfirmOperationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeepCleanServer).ConfirmOperation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeepClean_ConfirmOperation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeepCleanServer).ConfirmOperation(ctx, req.(*ConfirmOperationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// DeepClean_ServiceDesc is the grpc.ServiceDesc for DeepClean service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DeepClean_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "deepclean.v1.DeepClean",
+	HandlerType: (*DeepCleanServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartScan",
+			Handler:    _DeepClean_StartScan_Handler,
+		},
+		{
+			MethodName: "GetScanProgress",
+			Handler:    _DeepClean_GetScanProgress_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _DeepClean_GetStats_Handler,
+		},
+		{
+			MethodName: "ArchiveMessages",
+			Handler:    _DeepClean_ArchiveMessages_Handler,
+		},
+		{
+			MethodName: "MarkRead",
+			Handler:    _DeepClean_MarkRead_Handler,
+		},
+		{
+			MethodName: "PreviewTrash",
+			Handler:    _DeepClean_PreviewTrash_Handler,
+		},
+		{
+			MethodName: "ConfirmOperation",
+			Handler:    _DeepClean_ConfirmOperation_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchScan",
+			Handler:       _DeepClean_WatchScan_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListSenders",
+			Handler:       _DeepClean_ListSenders_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "deepclean/v1/deepclean.proto",
+}